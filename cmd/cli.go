@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+)
+
+const cliUsage = `Usage: gpt-home [command] [flags]
+
+Commands:
+  serve          Run the GPT-Home server (the default)
+  chat           Chat with Luna interactively, or send one message
+  devices        List devices, show one or run an action: list, get, action
+  conversations  List, show or delete conversations: list, show, delete
+  export         Export conversations from a running server to JSON or Markdown
+  import         Import a JSON export into a running server
+
+Run "gpt-home <command> -h" for a command's flags.
+
+When the server has user accounts, sign in with -user (or GPT_HOME_USER) and
+set the password in GPT_HOME_PASSWORD.
+`
+
+// cliClient talks to a running GPT-Home server
+type cliClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newCLIClient(server string) *cliClient {
+	// The jar keeps the session cookie from login
+	jar, _ := cookiejar.New(nil)
+	return &cliClient{
+		baseURL:    strings.TrimRight(server, "/"),
+		httpClient: &http.Client{Timeout: 60 * time.Second, Jar: jar},
+	}
+}
+
+// login signs in as username. It does nothing without a username, for servers
+// without user accounts.
+func (c *cliClient) login(username, password string) error {
+	if username == "" {
+		return nil
+	}
+	if password == "" {
+		return fmt.Errorf("set GPT_HOME_PASSWORD to sign in as %s", username)
+	}
+
+	body, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
+	}
+	resp, err := c.httpClient.Post(c.baseURL+"/api/v1/auth/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to sign in: %w", responseError(resp))
+	}
+	return nil
+}
+
+// connect creates a client for server and signs in as user, if set
+func connect(server, user string) (*cliClient, error) {
+	client := newCLIClient(server)
+	if err := client.login(user, os.Getenv("GPT_HOME_PASSWORD")); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// do sends body, if set, as JSON and decodes the JSON response into out, if set
+func (c *cliClient) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	return nil
+}
+
+func serverFlag(flags *flag.FlagSet) *string {
+	return flags.String("server", defaultServerURL(), "GPT-Home server URL (or set GPT_HOME_URL)")
+}
+
+func userFlag(flags *flag.FlagSet) *string {
+	return flags.String("user", os.Getenv("GPT_HOME_USER"), "user to sign in as, with the password in GPT_HOME_PASSWORD (or set GPT_HOME_USER)")
+}
+
+// formatFlag is the output format of commands that print what the server has
+func formatFlag(flags *flag.FlagSet, text string) *string {
+	return flags.String("format", text, "output format: "+text+" or json")
+}
+
+func checkFormat(format, text string) error {
+	if format != text && format != "json" {
+		return fmt.Errorf("unknown format %q, expected %s or json", format, text)
+	}
+	return nil
+}
+
+// writeJSON prints v indented, for piping into jq and the like
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// runCommand runs a CLI command. It returns false if args don't name one, in
+// which case the server should start.
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "serve":
+		return false, nil
+	case "chat":
+		return true, ignoreHelp(runChat(args[1:], stdin, stdout, stderr))
+	case "devices":
+		return true, ignoreHelp(runDevices(args[1:], stdout, stderr))
+	case "conversations":
+		return true, ignoreHelp(runConversations(args[1:], stdout, stderr))
+	case "export":
+		return true, ignoreHelp(runExport(args[1:], stdout, stderr))
+	case "import":
+		return true, ignoreHelp(runImport(args[1:], stdin, stdout, stderr))
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return true, nil
+	default:
+		fmt.Fprint(stderr, cliUsage)
+		return true, fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// ignoreHelp treats -h as success; the flag set has already printed its usage
+func ignoreHelp(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+func defaultServerURL() string {
+	if server := os.Getenv("GPT_HOME_URL"); server != "" {
+		return server
+	}
+	port := os.Getenv("SERVER_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://localhost:" + port
+}
+
+func runExport(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	server := serverFlag(flags)
+	id := flags.String("id", "", "conversation ID to export (default: all conversations)")
+	format := flags.String("format", "json", "export format: json or markdown")
+	output := flags.String("o", "", "file to write (default: stdout)")
+	user := userFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/api/v1/conversations/export"
+	if *id != "" {
+		path = "/api/v1/conversations/" + url.PathEscape(*id) + "/export"
+	}
+
+	client, err := connect(*server, *user)
+	if err != nil {
+		return err
+	}
+	resp, err := client.httpClient.Get(client.baseURL + path + "?format=" + url.QueryEscape(*format))
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	out := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+func runImport(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	server := serverFlag(flags)
+	onConflict := flags.String("on-conflict", "error", "when a conversation exists: error, skip, replace or new")
+	user := userFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: gpt-home import [flags] <file.json|->")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("import needs exactly one file, or - for stdin")
+	}
+
+	if _, err := conversation.ParseConflictMode(*onConflict); err != nil {
+		return err
+	}
+
+	input := stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open import file: %w", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	client, err := connect(*server, *user)
+	if err != nil {
+		return err
+	}
+	resp, err := client.httpClient.Post(client.baseURL+"/api/v1/conversations/import?on_conflict="+url.QueryEscape(*onConflict), "application/json", input)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var result conversation.ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read import result: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Imported conversations: %d created, %d replaced, %d skipped, %d renamed\n",
+		len(result.Created), len(result.Replaced), len(result.Skipped), len(result.Renamed))
+	for original, renamed := range result.Renamed {
+		fmt.Fprintf(stdout, "  %s -> %s\n", original, renamed)
+	}
+	return nil
+}
+
+// responseError turns an API error response into an error
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error     string   `json:"error"`
+		Conflicts []string `json:"conflicts"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(data, &body); err != nil || body.Error == "" {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	if len(body.Conflicts) > 0 {
+		return fmt.Errorf("server returned %s: %s (existing: %s; retry with -on-conflict skip, replace or new)",
+			resp.Status, body.Error, strings.Join(body.Conflicts, ", "))
+	}
+	return fmt.Errorf("server returned %s: %s", resp.Status, body.Error)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// chatTimeout is how long a reply may take, queueing for the model included
+const chatTimeout = 5 * time.Minute
+
+const chatHelp = `Commands:
+  /new      start a new conversation
+  /id       print the current conversation's ID
+  /history  show what you typed recently
+  /help     show this help
+  /quit     leave (as does Ctrl-D)
+`
+
+// chatSession is a conversation with Luna from the command line
+type chatSession struct {
+	client         *cliClient
+	conversationID uuid.UUID
+	stream         bool
+	format         string
+	stdout         io.Writer
+	stderr         io.Writer
+}
+
+func runChat(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("chat", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	server := serverFlag(flags)
+	user := userFlag(flags)
+	conversationID := flags.String("c", "", "conversation ID to continue")
+	resume := flags.Bool("resume", false, "continue the most recent conversation")
+	history := flags.String("history", defaultHistoryPath(), "file to keep what you type in, empty to keep nothing (or set GPT_HOME_HISTORY)")
+	noStream := flags.Bool("no-stream", false, "wait for the whole reply without showing the queue")
+	format := formatFlag(flags, "text")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: gpt-home chat [flags] [message]")
+		fmt.Fprintln(stderr, "\nWith a message, sends it, prints the reply and exits; otherwise starts a chat.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, "text"); err != nil {
+		return err
+	}
+
+	client, err := connect(*server, *user)
+	if err != nil {
+		return err
+	}
+	client.httpClient.Timeout = chatTimeout
+
+	session := &chatSession{client: client, stream: !*noStream, format: *format, stdout: stdout, stderr: stderr}
+	switch {
+	case *conversationID != "":
+		if session.conversationID, err = uuid.Parse(*conversationID); err != nil {
+			return fmt.Errorf("invalid conversation ID: %s", *conversationID)
+		}
+	case *resume:
+		if session.conversationID, err = client.latestConversation(); err != nil {
+			return err
+		}
+	}
+
+	if flags.NArg() > 0 {
+		return session.send(strings.Join(flags.Args(), " "))
+	}
+	return session.repl(stdin, *history)
+}
+
+// repl chats until stdin ends or the user quits. A failed message is reported
+// and the chat carries on.
+func (s *chatSession) repl(stdin io.Reader, historyPath string) error {
+	if s.conversationID != uuid.Nil {
+		fmt.Fprintf(s.stdout, "Continuing conversation %s. Type /help for commands.\n", s.conversationID)
+	} else {
+		fmt.Fprintln(s.stdout, "Chatting with Luna. Type /help for commands.")
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	for {
+		fmt.Fprint(s.stdout, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(s.stdout)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if historyPath != "" {
+			if err := appendHistory(historyPath, line); err != nil {
+				fmt.Fprintln(s.stderr, "Warning:", err)
+			}
+		}
+
+		switch line {
+		case "/quit", "/exit":
+			return nil
+		case "/help":
+			fmt.Fprint(s.stdout, chatHelp)
+		case "/new":
+			s.conversationID = uuid.Nil
+			fmt.Fprintln(s.stdout, "Started a new conversation.")
+		case "/id":
+			if s.conversationID == uuid.Nil {
+				fmt.Fprintln(s.stdout, "No conversation yet; your next message starts one.")
+			} else {
+				fmt.Fprintln(s.stdout, s.conversationID)
+			}
+		case "/history":
+			if err := printHistory(s.stdout, historyPath, 20); err != nil {
+				fmt.Fprintln(s.stderr, "Error:", err)
+			}
+		default:
+			if strings.HasPrefix(line, "/") {
+				fmt.Fprintf(s.stderr, "Unknown command %s. Type /help for commands.\n", line)
+				continue
+			}
+			if err := s.send(line); err != nil {
+				fmt.Fprintln(s.stderr, "Error:", err)
+			}
+		}
+	}
+}
+
+// send sends one message in the session's conversation and prints the reply
+func (s *chatSession) send(message string) error {
+	req := models.ChatRequest{Message: message, ConversationID: s.conversationID}
+
+	var response *models.ChatResponse
+	var err error
+	if s.stream {
+		response, err = s.streamChat(req)
+	} else {
+		response = &models.ChatResponse{}
+		err = s.client.do(http.MethodPost, "/api/v1/chat", req, response)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.conversationID = response.ConversationID
+	if s.format == "json" {
+		return writeJSON(s.stdout, response)
+	}
+
+	fmt.Fprintln(s.stdout, response.Response)
+	for _, action := range response.ActionsPerformed {
+		fmt.Fprintln(s.stdout, "  *", describeAction(action))
+	}
+	return nil
+}
+
+// streamChat chats with server-sent events, showing the message's place in
+// line while the model is busy with others
+func (s *chatSession) streamChat(req models.ChatRequest) (*models.ChatResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, s.client.baseURL+"/api/v1/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	resp, err := s.client.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var event string
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		case line == "":
+			response, done, err := s.handleEvent(event, strings.Join(data, "\n"))
+			if done || err != nil {
+				return response, err
+			}
+			event, data = "", nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reply: %w", err)
+	}
+	return nil, fmt.Errorf("server closed the connection without replying")
+}
+
+// handleEvent acts on one server-sent event, reporting whether it ended the reply
+func (s *chatSession) handleEvent(event, data string) (*models.ChatResponse, bool, error) {
+	switch event {
+	case "queued":
+		var queued struct {
+			Position int `json:"position"`
+		}
+		if json.Unmarshal([]byte(data), &queued) == nil {
+			fmt.Fprintf(s.stderr, "(waiting for Luna, position %d in line)\n", queued.Position)
+		}
+		return nil, false, nil
+	case "response":
+		var response models.ChatResponse
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
+			return nil, true, fmt.Errorf("failed to read reply: %w", err)
+		}
+		return &response, true, nil
+	case "error":
+		var failed struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal([]byte(data), &failed)
+		return nil, true, fmt.Errorf("server failed to reply: %s", failed.Error)
+	default:
+		return nil, false, nil
+	}
+}
+
+// latestConversation is the ID of the conversation with the most recent message
+func (c *cliClient) latestConversation() (uuid.UUID, error) {
+	var page struct {
+		Conversations []models.ConversationSummary `json:"conversations"`
+	}
+	if err := c.do(http.MethodGet, "/api/v1/conversations?limit=1", nil, &page); err != nil {
+		return uuid.Nil, err
+	}
+	if len(page.Conversations) == 0 {
+		return uuid.Nil, fmt.Errorf("there is no conversation to resume")
+	}
+	return page.Conversations[0].ID, nil
+}
+
+// describeAction is a device action as one line, e.g. "set_brightness brightness=50"
+func describeAction(action models.DeviceAction) string {
+	keys := make([]string, 0, len(action.Parameters))
+	for key := range action.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{action.Action}
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", key, action.Parameters[key]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func defaultHistoryPath() string {
+	if path := os.Getenv("GPT_HOME_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gpt_home_history")
+}
+
+// appendHistory adds a line to the history file, which only its owner can read
+func appendHistory(path, line string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	return nil
+}
+
+// printHistory prints the last n lines of the history file
+func printHistory(w io.Writer, path string, n int) error {
+	if path == "" {
+		return fmt.Errorf("history is turned off")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, "  "+line)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/config"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/llm"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+// setupChatServer serves the API with a fake Ollama that answers with replies
+// in turn, then "Hi"
+func setupChatServer(t *testing.T, replies ...string) (*httptest.Server, *conversation.Manager) {
+	t.Helper()
+
+	var mu sync.Mutex
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[]}`))
+		case "/api/generate":
+			var req struct {
+				Prompt string `json:"prompt"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+
+			reply := "Hi"
+			mu.Lock()
+			if strings.Contains(req.Prompt, "Human:") && len(replies) > 0 {
+				reply, replies = replies[0], replies[1:]
+			}
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]any{"response": reply, "done": true})
+		}
+	}))
+	t.Cleanup(ollama.Close)
+
+	llmService := llm.NewService(ollama.URL, "test")
+	require.NoError(t, llmService.LoadModel())
+
+	conversationManager := conversation.NewManager()
+	router := setupTestRouter(
+		&config.Config{Server: config.ServerConfig{Mode: "test"}},
+		device.NewManager(mocks.NewMockHomeAssistantClient()),
+		llmService,
+		conversationManager,
+	)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, conversationManager
+}
+
+func TestChatCommand_OneMessage(t *testing.T) {
+	server, conversationManager := setupChatServer(t,
+		`{"response": "The living room light is on.", "actions": [{"action": "turn_on", "targets": ["light.living_room"]}]}`)
+
+	for _, stream := range []string{"-no-stream=false", "-no-stream"} {
+		var stdout, stderr bytes.Buffer
+		_, err := runCommand([]string{"chat", "-server", server.URL, "-history", "", stream, "turn", "on", "the", "light"}, nil, &stdout, &stderr)
+		require.NoError(t, err, stderr.String())
+		if stream == "-no-stream" {
+			assert.Equal(t, "Hi\n", stdout.String())
+			continue
+		}
+		assert.Contains(t, stdout.String(), "The living room light is on.")
+		assert.Contains(t, stdout.String(), "* turn_on")
+	}
+	assert.Len(t, conversationManager.GetAllConversations(), 2)
+
+	var stdout bytes.Buffer
+	_, err := runCommand([]string{"chat", "-server", server.URL, "-history", "", "-format", "json", "hello"}, nil, &stdout, &bytes.Buffer{})
+	require.NoError(t, err)
+	var response models.ChatResponse
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &response))
+	assert.Equal(t, "Hi", response.Response)
+}
+
+func TestChatCommand_REPL(t *testing.T) {
+	server, conversationManager := setupChatServer(t, `{"response": "Hello there.", "actions": []}`)
+	history := filepath.Join(t.TempDir(), "history")
+
+	input := strings.Join([]string{"hello", "/id", "and again", "/history", "/new", "/bogus", "/quit", "never sent"}, "\n")
+	var stdout, stderr bytes.Buffer
+	_, err := runCommand([]string{"chat", "-server", server.URL, "-history", history}, strings.NewReader(input), &stdout, &stderr)
+	require.NoError(t, err)
+
+	out := stdout.String()
+	assert.Contains(t, out, "Hello there.")
+	assert.Contains(t, out, "Started a new conversation.")
+	assert.Contains(t, stderr.String(), "Unknown command /bogus")
+
+	// Both messages went to the same conversation, whose ID /id printed
+	convs := conversationManager.GetAllConversations()
+	require.Len(t, convs, 1)
+	assert.Len(t, convs[0].Messages, 4)
+	assert.Contains(t, out, convs[0].ID.String())
+
+	data, err := os.ReadFile(history)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n/id\nand again\n/history\n/new\n/bogus\n/quit\n", string(data))
+	assert.Contains(t, out, "  and again")
+}
+
+func TestChatCommand_Resume(t *testing.T) {
+	server, conversationManager := setupChatServer(t)
+	conv := conversationManager.CreateConversation()
+	require.NoError(t, conversationManager.AddMessage(conv.ID, models.Message{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Earlier"}))
+
+	var stdout, stderr bytes.Buffer
+	_, err := runCommand([]string{"chat", "-server", server.URL, "-history", "", "-resume", "hi"}, nil, &stdout, &stderr)
+	require.NoError(t, err, stderr.String())
+	stored, err := conversationManager.GetConversation(conv.ID)
+	require.NoError(t, err)
+	assert.Len(t, stored.Messages, 3)
+
+	_, err = runCommand([]string{"chat", "-server", server.URL, "-history", "", "-c", conv.ID.String(), "hi"}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Len(t, conversationManager.GetAllConversations(), 1)
+
+	_, err = runCommand([]string{"chat", "-server", server.URL, "-c", "nope", "hi"}, nil, &stdout, &stderr)
+	assert.ErrorContains(t, err, "invalid conversation ID")
+}
+
+func TestChatCommand_ResumeWithoutConversations(t *testing.T) {
+	server, _ := setupChatServer(t)
+
+	_, err := runCommand([]string{"chat", "-server", server.URL, "-history", "", "-resume", "hi"}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "no conversation to resume")
+}
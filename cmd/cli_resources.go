@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+const devicesUsage = `Usage: gpt-home devices <command> [flags]
+
+Commands:
+  list                             List devices, filtered by flags
+  get <id>                         Show a device and its attributes
+  action <id> <action> [k=v ...]   Run an action, e.g. action light.kitchen set_brightness brightness=50
+`
+
+const conversationsUsage = `Usage: gpt-home conversations <command> [flags]
+
+Commands:
+  list         List conversations, most recent first
+  show <id>    Show a conversation's messages
+  delete <id>  Delete a conversation
+`
+
+// resourceCommand is a devices or conversations subcommand after its flags are
+// parsed
+type resourceCommand struct {
+	client *cliClient
+	format string
+	args   []string
+	stdout io.Writer
+}
+
+// parseResourceCommand parses a subcommand's flags, requiring want arguments
+// after them, at least when atLeast is set
+func parseResourceCommand(flags *flag.FlagSet, args []string, text string, want int, atLeast bool, stdout, stderr io.Writer) (*resourceCommand, error) {
+	flags.SetOutput(stderr)
+	server := serverFlag(flags)
+	user := userFlag(flags)
+	format := formatFlag(flags, text)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() < want || (!atLeast && flags.NArg() > want) {
+		flags.Usage()
+		return nil, fmt.Errorf("%s needs %d argument(s)", flags.Name(), want)
+	}
+	if err := checkFormat(*format, text); err != nil {
+		return nil, err
+	}
+
+	client, err := connect(*server, *user)
+	if err != nil {
+		return nil, err
+	}
+	return &resourceCommand{client: client, format: *format, args: flags.Args(), stdout: stdout}, nil
+}
+
+func usageFor(flags *flag.FlagSet, stderr io.Writer, usage string) {
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: gpt-home "+usage)
+		flags.PrintDefaults()
+	}
+}
+
+func runDevices(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, devicesUsage)
+		return fmt.Errorf("devices needs a command")
+	}
+
+	switch args[0] {
+	case "list":
+		return runDevicesList(args[1:], stdout, stderr)
+	case "get":
+		return runDevicesGet(args[1:], stdout, stderr)
+	case "action":
+		return runDevicesAction(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, devicesUsage)
+		return nil
+	default:
+		fmt.Fprint(stderr, devicesUsage)
+		return fmt.Errorf("unknown devices command: %s", args[0])
+	}
+}
+
+func runDevicesList(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("devices list", flag.ContinueOnError)
+	usageFor(flags, stderr, "devices list [flags]")
+	deviceType := flags.String("type", "", "only devices of this type, e.g. light")
+	area := flags.String("area", "", "only devices in this area")
+	state := flags.String("state", "", "only devices in this state, e.g. on")
+	search := flags.String("q", "", "only devices whose name or ID contains this")
+	cmd, err := parseResourceCommand(flags, args, "table", 0, false, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	for key, value := range map[string]string{"type": *deviceType, "area": *area, "state": *state, "q": *search} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	// Follow the pages so scripts get every device
+	devices := []models.Device{}
+	for {
+		var page struct {
+			Devices    []models.Device `json:"devices"`
+			NextCursor string          `json:"next_cursor"`
+		}
+		if err := cmd.client.do(http.MethodGet, "/api/v1/devices?"+query.Encode(), nil, &page); err != nil {
+			return err
+		}
+		devices = append(devices, page.Devices...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+
+	if cmd.format == "json" {
+		return writeJSON(stdout, devices)
+	}
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tNAME\tTYPE\tSTATE\tAREA")
+	for _, d := range devices {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", d.ID, d.Name, d.Type, d.State, d.Area)
+	}
+	return table.Flush()
+}
+
+func runDevicesGet(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("devices get", flag.ContinueOnError)
+	usageFor(flags, stderr, "devices get [flags] <id>")
+	cmd, err := parseResourceCommand(flags, args, "table", 1, false, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	var d models.Device
+	if err := cmd.client.do(http.MethodGet, "/api/v1/devices/"+url.PathEscape(cmd.args[0]), nil, &d); err != nil {
+		return err
+	}
+
+	if cmd.format == "json" {
+		return writeJSON(stdout, d)
+	}
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "ID\t%s\n", d.ID)
+	fmt.Fprintf(table, "Name\t%s\n", d.Name)
+	fmt.Fprintf(table, "Type\t%s\n", d.Type)
+	fmt.Fprintf(table, "State\t%s\n", d.State)
+	if d.Area != "" {
+		fmt.Fprintf(table, "Area\t%s\n", d.Area)
+	}
+	fmt.Fprintf(table, "Last changed\t%s\n", d.LastChanged.Local().Format(time.DateTime))
+
+	keys := make([]string, 0, len(d.Attributes))
+	for key := range d.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(table, "  %s\t%v\n", key, d.Attributes[key])
+	}
+	return table.Flush()
+}
+
+func runDevicesAction(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("devices action", flag.ContinueOnError)
+	usageFor(flags, stderr, "devices action [flags] <id> <action> [key=value ...]")
+	cmd, err := parseResourceCommand(flags, args, "table", 2, true, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	action := models.DeviceAction{Action: cmd.args[1]}
+	if len(cmd.args) > 2 {
+		if action.Parameters, err = parseParameters(cmd.args[2:]); err != nil {
+			return err
+		}
+	}
+
+	var result map[string]any
+	if err := cmd.client.do(http.MethodPost, "/api/v1/devices/"+url.PathEscape(cmd.args[0])+"/action", action, &result); err != nil {
+		return err
+	}
+
+	if cmd.format == "json" {
+		return writeJSON(stdout, result)
+	}
+	fmt.Fprintf(stdout, "Done: %s on %s\n", describeAction(action), cmd.args[0])
+	return nil
+}
+
+// parseParameters reads key=value action parameters. Values that are JSON,
+// such as 50, true or [255,0,0], are sent as such, anything else as a string.
+func parseParameters(args []string) (map[string]any, error) {
+	parameters := make(map[string]any, len(args))
+	for _, arg := range args {
+		key, raw, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid parameter %q, expected key=value", arg)
+		}
+		var value any
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+		parameters[key] = value
+	}
+	return parameters, nil
+}
+
+func runConversations(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, conversationsUsage)
+		return fmt.Errorf("conversations needs a command")
+	}
+
+	switch args[0] {
+	case "list":
+		return runConversationsList(args[1:], stdout, stderr)
+	case "show":
+		return runConversationsShow(args[1:], stdout, stderr)
+	case "delete":
+		return runConversationsDelete(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, conversationsUsage)
+		return nil
+	default:
+		fmt.Fprint(stderr, conversationsUsage)
+		return fmt.Errorf("unknown conversations command: %s", args[0])
+	}
+}
+
+func runConversationsList(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("conversations list", flag.ContinueOnError)
+	usageFor(flags, stderr, "conversations list [flags]")
+	search := flags.String("q", "", "only conversations whose messages contain these words")
+	limit := flags.Int("limit", 20, "most conversations to list")
+	cmd, err := parseResourceCommand(flags, args, "table", 0, false, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"limit": {strconv.Itoa(*limit)}}
+	if *search != "" {
+		query.Set("q", *search)
+	}
+	var page struct {
+		Conversations []models.ConversationSummary `json:"conversations"`
+	}
+	if err := cmd.client.do(http.MethodGet, "/api/v1/conversations?"+query.Encode(), nil, &page); err != nil {
+		return err
+	}
+
+	if cmd.format == "json" {
+		return writeJSON(stdout, page.Conversations)
+	}
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tLAST MESSAGE\tMESSAGES\tTITLE")
+	for _, summary := range page.Conversations {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\n", summary.ID, summary.LastMessageAt.Local().Format("2006-01-02 15:04"), summary.MessageCount, summary.Title)
+	}
+	return table.Flush()
+}
+
+func runConversationsShow(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("conversations show", flag.ContinueOnError)
+	usageFor(flags, stderr, "conversations show [flags] <id>")
+	cmd, err := parseResourceCommand(flags, args, "text", 1, false, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	var conv models.Conversation
+	if err := cmd.client.do(http.MethodGet, "/api/v1/conversations/"+url.PathEscape(cmd.args[0]), nil, &conv); err != nil {
+		return err
+	}
+
+	if cmd.format == "json" {
+		return writeJSON(stdout, conv)
+	}
+	fmt.Fprintf(stdout, "Conversation %s, started %s\n\n", conv.ID, conv.CreatedAt.Local().Format("2006-01-02 15:04"))
+	for _, message := range conv.Messages {
+		speaker := "You"
+		if message.Role == models.MessageRoleAssistant {
+			speaker = "Luna"
+		}
+		fmt.Fprintf(stdout, "[%s] %s: %s\n", message.Timestamp.Local().Format("15:04"), speaker, message.Content)
+	}
+	return nil
+}
+
+func runConversationsDelete(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("conversations delete", flag.ContinueOnError)
+	usageFor(flags, stderr, "conversations delete [flags] <id>")
+	cmd, err := parseResourceCommand(flags, args, "text", 1, false, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	var result map[string]any
+	if err := cmd.client.do(http.MethodDelete, "/api/v1/conversations/"+url.PathEscape(cmd.args[0]), nil, &result); err != nil {
+		return err
+	}
+
+	if cmd.format == "json" {
+		return writeJSON(stdout, result)
+	}
+	fmt.Fprintf(stdout, "Deleted conversation %s\n", cmd.args[0])
+	return nil
+}
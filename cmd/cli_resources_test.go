@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func TestDevicesCommands(t *testing.T) {
+	server, _ := setupChatServer(t)
+	var stdout, stderr bytes.Buffer
+
+	_, err := runCommand([]string{"devices", "list", "-server", server.URL, "-type", "light"}, nil, &stdout, &stderr)
+	require.NoError(t, err, stderr.String())
+	assert.Contains(t, stdout.String(), "ID")
+	assert.Contains(t, stdout.String(), "light.living_room")
+	assert.NotContains(t, stdout.String(), "climate.")
+
+	stdout.Reset()
+	_, err = runCommand([]string{"devices", "list", "-server", server.URL, "-format", "json"}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	var devices []models.Device
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &devices))
+	assert.NotEmpty(t, devices)
+
+	stdout.Reset()
+	_, err = runCommand([]string{"devices", "get", "-server", server.URL, "light.bedroom"}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Contains(t, stdout.String(), "Bedroom Light")
+	assert.Contains(t, stdout.String(), "brightness")
+
+	stdout.Reset()
+	_, err = runCommand([]string{"devices", "action", "-server", server.URL, "light.bedroom", "set_brightness", "brightness=50"}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Equal(t, "Done: set_brightness brightness=50 on light.bedroom\n", stdout.String())
+
+	_, err = runCommand([]string{"devices", "get", "-server", server.URL}, nil, &stdout, &stderr)
+	assert.Error(t, err)
+	_, err = runCommand([]string{"devices", "list", "-server", server.URL, "-format", "yaml"}, nil, &stdout, &stderr)
+	assert.Error(t, err)
+	_, err = runCommand([]string{"devices", "action", "-server", server.URL, "light.bedroom", "turn_on", "oops"}, nil, &stdout, &stderr)
+	assert.ErrorContains(t, err, "key=value")
+	_, err = runCommand([]string{"devices", "dance"}, nil, &stdout, &stderr)
+	assert.Error(t, err)
+}
+
+func TestParseParameters(t *testing.T) {
+	parameters, err := parseParameters([]string{"brightness=50", "rgb_color=[255,0,0]", "effect=rainbow", "on=true"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"brightness": float64(50),
+		"rgb_color":  []any{float64(255), float64(0), float64(0)},
+		"effect":     "rainbow",
+		"on":         true,
+	}, parameters)
+}
+
+func TestConversationsCommands(t *testing.T) {
+	server, conversationManager := setupChatServer(t)
+	conv := conversationManager.CreateConversation()
+	require.NoError(t, conversationManager.AddMessage(conv.ID, models.Message{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Is the door locked?"}))
+	require.NoError(t, conversationManager.AddMessage(conv.ID, models.Message{ID: uuid.New(), Role: models.MessageRoleAssistant, Content: "Yes."}))
+	var stdout, stderr bytes.Buffer
+
+	_, err := runCommand([]string{"conversations", "list", "-server", server.URL}, nil, &stdout, &stderr)
+	require.NoError(t, err, stderr.String())
+	assert.Contains(t, stdout.String(), conv.ID.String())
+	assert.Contains(t, stdout.String(), "Is the door locked?")
+
+	stdout.Reset()
+	_, err = runCommand([]string{"conversations", "show", "-server", server.URL, conv.ID.String()}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Contains(t, stdout.String(), "You: Is the door locked?")
+	assert.Contains(t, stdout.String(), "Luna: Yes.")
+
+	stdout.Reset()
+	_, err = runCommand([]string{"conversations", "show", "-server", server.URL, "-format", "json", conv.ID.String()}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	var shown models.Conversation
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &shown))
+	assert.Len(t, shown.Messages, 2)
+
+	stdout.Reset()
+	_, err = runCommand([]string{"conversations", "delete", "-server", server.URL, conv.ID.String()}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Contains(t, stdout.String(), "Deleted conversation")
+	assert.Empty(t, conversationManager.GetAllConversations())
+
+	_, err = runCommand([]string{"conversations", "show", "-server", server.URL, conv.ID.String()}, nil, &stdout, &stderr)
+	assert.ErrorContains(t, err, "Conversation not found")
+}
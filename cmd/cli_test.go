@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/api"
+	"github.com/tienpdinh/gpt-home/internal/auth"
+	"github.com/tienpdinh/gpt-home/internal/config"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/llm"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func setupTestServer(t *testing.T) (*httptest.Server, *conversation.Manager) {
+	t.Helper()
+
+	conversationManager := conversation.NewManager()
+	router := setupTestRouter(
+		&config.Config{Server: config.ServerConfig{Mode: "test"}},
+		device.NewManager(&mockHomeAssistantClient{}),
+		llm.NewService("http://localhost:11434", "test"),
+		conversationManager,
+	)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, conversationManager
+}
+
+func TestRunCommand_NotACommand(t *testing.T) {
+	for _, args := range [][]string{nil, {"serve"}} {
+		handled, err := runCommand(args, nil, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.False(t, handled)
+		assert.NoError(t, err)
+	}
+}
+
+func TestRunCommand_Help(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	handled, err := runCommand([]string{"help"}, nil, &stdout, &stderr)
+	assert.True(t, handled)
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), "export")
+
+	handled, err = runCommand([]string{"export", "-h"}, nil, &stdout, &stderr)
+	assert.True(t, handled)
+	assert.NoError(t, err)
+	assert.Contains(t, stderr.String(), "-format")
+
+	handled, err = runCommand([]string{"frobnicate"}, nil, &stdout, &stderr)
+	assert.True(t, handled)
+	assert.Error(t, err)
+}
+
+func TestExportImportCommands(t *testing.T) {
+	server, conversationManager := setupTestServer(t)
+
+	conv := conversationManager.CreateConversation()
+	conv.Messages = append(conv.Messages, models.Message{
+		ID:        uuid.New(),
+		Role:      models.MessageRoleUser,
+		Content:   "Turn on the porch light",
+		Timestamp: time.Now(),
+	})
+	require.NoError(t, conversationManager.UpdateConversation(conv))
+
+	exportFile := filepath.Join(t.TempDir(), "export.json")
+	var stdout, stderr bytes.Buffer
+	handled, err := runCommand([]string{"export", "-server", server.URL, "-o", exportFile}, nil, &stdout, &stderr)
+	require.True(t, handled)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(exportFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), conv.ID.String())
+
+	// Markdown exports of one conversation go to stdout by default
+	stdout.Reset()
+	_, err = runCommand([]string{"export", "-server", server.URL, "-id", conv.ID.String(), "-format", "markdown"}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stdout.String(), "# Turn on the porch light"))
+
+	// Re-importing conflicts with the conversation that's still there
+	_, err = runCommand([]string{"import", "-server", server.URL, exportFile}, nil, &stdout, &stderr)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), conv.ID.String())
+
+	stdout.Reset()
+	_, err = runCommand([]string{"import", "-server", server.URL, "-on-conflict", "new", "-"}, bytes.NewReader(data), &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Contains(t, stdout.String(), "1 renamed")
+	assert.Len(t, conversationManager.GetAllConversations(), 2)
+}
+
+func TestExportCommand_SignsIn(t *testing.T) {
+	users := auth.NewManager(0)
+	alice, err := users.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+
+	conversationManager := conversation.NewManager()
+	conv := conversationManager.CreateConversationForUser(alice.ID, nil)
+	handler := api.NewHandlerWithOptions(device.NewManager(&mockHomeAssistantClient{}), llm.NewService("http://localhost:11434", "test"), conversationManager, api.HandlerOptions{Users: users})
+
+	router := gin.New()
+	router.POST("/api/v1/auth/login", handler.Login)
+	router.GET("/api/v1/conversations/export", handler.RequireUser(), handler.ExportConversations)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	_, err = runCommand([]string{"export", "-server", server.URL}, nil, &stdout, &stderr)
+	assert.ErrorContains(t, err, "Sign in required")
+
+	t.Setenv("GPT_HOME_PASSWORD", "wrong password")
+	_, err = runCommand([]string{"export", "-server", server.URL, "-user", "alice"}, nil, &stdout, &stderr)
+	assert.ErrorContains(t, err, "failed to sign in")
+
+	t.Setenv("GPT_HOME_PASSWORD", "correct horse")
+	_, err = runCommand([]string{"export", "-server", server.URL, "-user", "alice"}, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Contains(t, stdout.String(), conv.ID.String())
+}
+
+func TestExportCommand_Errors(t *testing.T) {
+	server, _ := setupTestServer(t)
+	var stdout, stderr bytes.Buffer
+
+	_, err := runCommand([]string{"export", "-server", server.URL, "-id", uuid.New().String()}, nil, &stdout, &stderr)
+	assert.ErrorContains(t, err, "Conversation not found")
+
+	_, err = runCommand([]string{"import", "-server", server.URL}, nil, &stdout, &stderr)
+	assert.Error(t, err)
+
+	_, err = runCommand([]string{"import", "-server", server.URL, "-on-conflict", "merge", "-"}, strings.NewReader("{}"), &stdout, &stderr)
+	assert.ErrorIs(t, err, conversation.ErrInvalidImport)
+}
@@ -0,0 +1,80 @@
+// Command fake-ha runs a pretend HomeAssistant, so GPT-Home can be developed
+// and tried out without a real one. Point HA_URL at it:
+//
+//	go run ./cmd/fake-ha -house test/houses/demo.yaml
+//	HA_URL=http://localhost:8123 HA_TOKEN=dev go run ./cmd
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/test/mocks"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("fake-ha", flag.ContinueOnError)
+	addr := flags.String("addr", ":8123", "address to listen on")
+	housePath := flags.String("house", "", "YAML house to serve; a small built-in one when empty")
+	token := flags.String("token", os.Getenv("FAKE_HA_TOKEN"), "access token clients must send, any when empty (or set FAKE_HA_TOKEN)")
+	var faults mocks.Faults
+	flags.DurationVar(&faults.Latency, "latency", 0, "delay added to every request")
+	flags.DurationVar(&faults.Jitter, "jitter", 0, "up to this much more delay, at random")
+	flags.Float64Var(&faults.ErrorRate, "error-rate", 0, "share of requests to fail, from 0 to 1")
+	flags.IntVar(&faults.ErrorStatus, "error-status", 500, "status of failed requests")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if faults.ErrorRate < 0 || faults.ErrorRate > 1 {
+		return fmt.Errorf("-error-rate must be between 0 and 1")
+	}
+
+	var fake *mocks.MockHomeAssistantServer
+	if *housePath != "" {
+		house, err := mocks.LoadHouse(*housePath)
+		if err != nil {
+			return err
+		}
+		fake = mocks.NewMockHomeAssistantServerForHouse(house, *token)
+		logrus.Infof("Serving %d entities from %s", len(house.Entities), *housePath)
+	} else {
+		fake = mocks.NewMockHomeAssistantServer(mocks.NewMockHomeAssistantClient(), *token)
+		logrus.Info("Serving the built-in house")
+	}
+	fake.SetFaults(faults)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: *addr, Handler: fake}
+	errs := make(chan error, 1)
+	go func() {
+		logrus.Infof("Fake HomeAssistant listening on %s", *addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
@@ -4,17 +4,25 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/tienpdinh/gpt-home/internal/api"
+	"github.com/tienpdinh/gpt-home/internal/auth"
 	"github.com/tienpdinh/gpt-home/internal/config"
 	"github.com/tienpdinh/gpt-home/internal/conversation"
 	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/events"
 	"github.com/tienpdinh/gpt-home/internal/llm"
+	"github.com/tienpdinh/gpt-home/internal/mqtt"
+	"github.com/tienpdinh/gpt-home/internal/speech"
+	"github.com/tienpdinh/gpt-home/internal/wyoming"
 	"github.com/tienpdinh/gpt-home/pkg/homeassistant"
 
 	"github.com/gin-gonic/gin"
@@ -22,6 +30,15 @@ import (
 )
 
 func main() {
+	// Subcommands like export and import talk to a running server instead of starting one
+	if handled, err := runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); handled {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -34,23 +51,83 @@ func main() {
 	logrus.Info("Starting GPT-Home...")
 
 	// Initialize components
-	haClient := homeassistant.NewClient(cfg.HomeAssistant.URL, cfg.HomeAssistant.Token)
-	deviceManager := device.NewManager(haClient)
+	hub := events.NewHub()
+	acl, err := loadACL(cfg.Auth)
+	if err != nil {
+		logrus.Fatalf("Failed to load device access rules: %v", err)
+	}
+	deviceManager, err := newDeviceManager(cfg.HomeAssistant, hub, acl)
+	if err != nil {
+		logrus.Fatalf("Failed to set up Home Assistant instances: %v", err)
+	}
 	llmService := llm.NewServiceWithConfig(cfg.LLM.OllamaURL, cfg.LLM.Model, cfg.LLM)
-	conversationManager := conversation.NewManager()
+	conversationManager, err := newConversationManager(cfg.Storage)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize conversation storage: %v", err)
+	}
+	defer conversationManager.Close()
+
+	users, err := newUserManager(cfg)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize user accounts: %v", err)
+	}
+	if users != nil {
+		defer users.Close()
+	}
 
 	// Initialize and load LLM
 	if err := llmService.LoadModel(); err != nil {
 		logrus.Fatalf("Failed to load LLM: %v", err)
 	}
 
-	// Setup HTTP server
-	router := setupRouter(cfg, deviceManager, llmService, conversationManager)
+	// Poll device states so changes made outside GPT-Home reach WebSocket clients
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	if cfg.HomeAssistant.PollInterval > 0 {
+		go deviceManager.WatchStates(watchCtx, time.Duration(cfg.HomeAssistant.PollInterval)*time.Second)
+	}
+
+	transcriber, err := newTranscriber(cfg.Speech)
+	if err != nil {
+		logrus.Fatalf("Failed to set up speech recognition: %v", err)
+	}
+	synthesizer := newSynthesizer(cfg.Speech)
+
+	// Setup HTTP server. Requests' contexts end at shutdown, so in-flight calls to
+	// HomeAssistant and Ollama stop instead of running to their timeouts.
+	apiHandler := api.NewHandlerWithOptions(deviceManager, llmService, conversationManager, api.HandlerOptions{
+		Events:      hub,
+		Users:       users,
+		Transcriber: transcriber,
+		Synthesizer: synthesizer,
+	})
+	router := setupRouter(cfg, apiHandler)
+	serverCtx, stopRequests := context.WithCancel(context.Background())
+	defer stopRequests()
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
 		Handler:      router,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
+		BaseContext:  func(net.Listener) context.Context { return serverCtx },
+	}
+
+	// MQTT clients such as buttons talk to Luna without HTTP
+	if cfg.MQTT.Enabled {
+		bridge := mqtt.NewBridge(mqttOptions(cfg.MQTT), apiHandler, deviceManager, hub)
+		if err := bridge.Start(serverCtx); err != nil {
+			logrus.Fatalf("Failed to start MQTT: %v", err)
+		}
+		defer bridge.Stop()
+	}
+
+	// Wyoming satellites hand Luna their transcripts directly
+	if cfg.Wyoming.Enabled {
+		satellites := wyoming.NewServer(wyomingOptions(cfg.Wyoming), apiHandler)
+		if err := satellites.Start(serverCtx); err != nil {
+			logrus.Fatalf("Failed to start Wyoming server: %v", err)
+		}
+		defer satellites.Stop()
 	}
 
 	// Start server in goroutine
@@ -67,6 +144,8 @@ func main() {
 	<-quit
 
 	logrus.Info("Shutting down server...")
+	stopRequests()
+	stopWatching()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -79,6 +158,135 @@ func main() {
 	logrus.Info("Server exited")
 }
 
+// newDeviceManager connects to the configured Home Assistant server, or to each
+// named instance when there are several
+func newDeviceManager(cfg config.HomeAssistantConfig, hub *events.Hub, acl *device.ACL) (*device.Manager, error) {
+	if len(cfg.Instances) == 0 {
+		haClient := homeassistant.NewClientWithOptions(cfg.URL, cfg.Token, haClientOptions(cfg))
+		return device.NewManagerWithOptions(haClient, device.ManagerOptions{Events: hub, ACL: acl}), nil
+	}
+
+	instances := make([]device.Instance, 0, len(cfg.Instances))
+	for _, instance := range cfg.Instances {
+		instances = append(instances, device.Instance{
+			Name:   instance.Name,
+			Client: homeassistant.NewClientWithOptions(instance.URL, instance.Token, haClientOptions(cfg)),
+		})
+	}
+	return device.NewManagerWithInstances(instances, device.ManagerOptions{Events: hub, ACL: acl})
+}
+
+// haClientOptions sets up the HomeAssistant client's timeout, retries and breaker
+func haClientOptions(cfg config.HomeAssistantConfig) homeassistant.Options {
+	opts := homeassistant.DefaultOptions()
+	opts.Timeout = time.Duration(cfg.Timeout) * time.Second
+	opts.Retry.Attempts = cfg.Retries
+	opts.RetryServiceCalls = cfg.RetryServiceCalls
+	opts.BreakerThreshold = cfg.BreakerThreshold
+	opts.BreakerCooldown = time.Duration(cfg.BreakerCooldown) * time.Second
+	return opts
+}
+
+// mqttOptions sets up the MQTT bridge's connection and topics. Certificates are
+// only loaded when one is configured; ssl:// brokers otherwise use the system's.
+func mqttOptions(cfg config.MQTTConfig) mqtt.Options {
+	opts := mqtt.Options{
+		Broker:        cfg.Broker,
+		ClientID:      cfg.ClientID,
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		CommandTopic:  cfg.CommandTopic,
+		ResponseTopic: cfg.ResponseTopic,
+		ActionTopic:   cfg.ActionTopic,
+		EventTopic:    cfg.EventTopic,
+		QoS:           byte(cfg.QoS),
+	}
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		opts.TLS = &mqtt.TLSFiles{CAFile: cfg.CAFile, CertFile: cfg.CertFile, KeyFile: cfg.KeyFile}
+	}
+	return opts
+}
+
+// wyomingOptions sets up the address and languages the Wyoming server offers
+func wyomingOptions(cfg config.WyomingConfig) wyoming.Options {
+	var languages []string
+	for _, language := range strings.Split(cfg.Languages, ",") {
+		if language = strings.TrimSpace(language); language != "" {
+			languages = append(languages, language)
+		}
+	}
+	return wyoming.Options{
+		Addr:      fmt.Sprintf(":%d", cfg.Port),
+		Languages: languages,
+		Version:   "1.0.0",
+	}
+}
+
+// newTranscriber connects to the STT server, or returns nil when none is
+// configured so voice input is turned off
+func newTranscriber(cfg config.SpeechConfig) (speech.Transcriber, error) {
+	if cfg.STTURL == "" {
+		return nil, nil
+	}
+	client, err := speech.NewWhisperClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Voice input enabled with STT server %s", cfg.STTURL)
+	return client, nil
+}
+
+// newSynthesizer connects to the TTS server, or returns nil when none is
+// configured so replies aren't spoken
+func newSynthesizer(cfg config.SpeechConfig) speech.Synthesizer {
+	if cfg.TTSURL == "" {
+		return nil
+	}
+	logrus.Infof("Spoken replies enabled with TTS server %s", cfg.TTSURL)
+	return speech.NewPiperClient(cfg)
+}
+
+// newConversationManager keeps conversations in memory, or in SQLite under
+// cfg.Path when the storage type is "sqlite"
+func newConversationManager(cfg config.StorageConfig) (*conversation.Manager, error) {
+	if cfg.Type != "sqlite" {
+		return conversation.NewManager(), nil
+	}
+
+	if err := os.MkdirAll(cfg.Path, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return conversation.NewManagerWithDB(filepath.Join(cfg.Path, "conversations.db"))
+}
+
+// newUserManager keeps user accounts in STORAGE_PATH/users.db, whatever the
+// conversation storage, so sign-ins survive restarts. It returns nil when
+// accounts are disabled.
+func newUserManager(cfg *config.Config) (*auth.Manager, error) {
+	if !cfg.Auth.Enabled {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(cfg.Storage.Path, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return auth.NewManagerWithDB(filepath.Join(cfg.Storage.Path, "users.db"), time.Duration(cfg.Auth.SessionTTL)*time.Hour)
+}
+
+// loadACL reads the device access rules, or returns nil to give everyone full
+// access when none are configured
+func loadACL(cfg config.AuthConfig) (*device.ACL, error) {
+	if cfg.ACLFile == "" {
+		return nil, nil
+	}
+	acl, err := device.LoadACL(cfg.ACLFile)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Loaded device access rules for %d principals from %s", len(acl.Principals), cfg.ACLFile)
+	return acl, nil
+}
+
 func setupLogging(level string) {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 
@@ -96,7 +304,7 @@ func setupLogging(level string) {
 	}
 }
 
-func setupRouter(cfg *config.Config, deviceManager *device.Manager, llmService *llm.Service, conversationManager *conversation.Manager) *gin.Engine {
+func setupRouter(cfg *config.Config, apiHandler *api.Handler) *gin.Engine {
 	if cfg.Server.Mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -107,27 +315,59 @@ func setupRouter(cfg *config.Config, deviceManager *device.Manager, llmService *
 	router.Use(gin.Recovery())
 	router.Use(gin.Logger())
 
-	// Initialize API handlers
-	apiHandler := api.NewHandler(deviceManager, llmService, conversationManager)
-
 	// API routes
 	v1 := router.Group("/api/v1")
 	{
-		v1.POST("/chat", apiHandler.HandleChat)
-		v1.GET("/devices", apiHandler.GetDevices)
-		v1.GET("/devices/:id", apiHandler.GetDevice)
-		v1.POST("/devices/:id/action", apiHandler.ControlDevice)
-		v1.GET("/conversations/:id", apiHandler.GetConversation)
-		v1.DELETE("/conversations/:id", apiHandler.DeleteConversation)
 		v1.GET("/health", apiHandler.HealthCheck)
+		v1.GET("/metrics", apiHandler.Metrics)
+		v1.POST("/auth/login", apiHandler.Login)
+		v1.POST("/auth/setup", apiHandler.Setup)
+		v1.POST("/auth/logout", apiHandler.Logout)
+	}
+
+	// Chat, Assist and OpenAI clients share one allowance per client
+	chatLimit := api.RateLimit(api.NewRateLimiter(cfg.Server.ChatRateLimit, cfg.Server.ChatRateBurst))
+
+	// Everything else needs a signed-in user when accounts are enabled
+	protected := v1.Group("", apiHandler.RequireUser())
+	{
+		protected.POST("/chat", chatLimit, apiHandler.HandleChat)
+		protected.POST("/chat/audio", chatLimit, apiHandler.HandleAudioChat)
+		protected.POST("/conversation/process", chatLimit, apiHandler.ProcessConversation)
+		protected.GET("/messages/:id/audio", apiHandler.GetMessageAudio)
+		protected.GET("/devices", apiHandler.GetDevices)
+		protected.GET("/devices/:id", apiHandler.GetDevice)
+		protected.GET("/devices/:id/history", apiHandler.GetDeviceHistory)
+		protected.POST("/devices/:id/action", apiHandler.ControlDevice)
+		protected.POST("/actions", apiHandler.ExecuteActions)
+		protected.GET("/conversations", apiHandler.ListConversations)
+		protected.GET("/conversations/export", apiHandler.ExportConversations)
+		protected.POST("/conversations/import", apiHandler.ImportConversations)
+		protected.GET("/conversations/:id", apiHandler.GetConversation)
+		protected.GET("/conversations/:id/export", apiHandler.ExportConversation)
+		protected.DELETE("/conversations/:id", apiHandler.DeleteConversation)
+		protected.GET("/auth/me", apiHandler.GetCurrentUser)
+		protected.PUT("/auth/me/preferences", apiHandler.UpdatePreferences)
+		protected.GET("/users", apiHandler.ListUsers)
+		protected.POST("/users", apiHandler.CreateUser)
+		protected.GET("/ws", apiHandler.HandleWebSocket)
+	}
+
+	// OpenAI-compatible API for tools built for OpenAI models
+	openai := router.Group("/v1", apiHandler.RequireUser())
+	{
+		openai.GET("/models", apiHandler.ListModels)
+		openai.POST("/chat/completions", chatLimit, apiHandler.ChatCompletions)
 	}
 
 	// Static files for web interface
 	router.Static("/static", "./web/static")
 	router.LoadHTMLGlob("web/templates/*")
-	router.GET("/", func(c *gin.Context) {
+	router.GET("/login", apiHandler.LoginPage)
+	router.GET("/", apiHandler.RequireLogin(), func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", gin.H{
 			"title": "Luna - GPT-Home",
+			"user":  api.CurrentUser(c),
 		})
 	})
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -21,22 +22,34 @@ import (
 // mockHomeAssistantClient for testing
 type mockHomeAssistantClient struct{}
 
-func (m *mockHomeAssistantClient) GetEntities() ([]models.Device, error) {
+func (m *mockHomeAssistantClient) GetEntities(ctx context.Context) ([]models.Device, error) {
 	return []models.Device{}, nil
 }
 
-func (m *mockHomeAssistantClient) GetEntity(entityID string) (*models.Device, error) {
+func (m *mockHomeAssistantClient) GetEntity(ctx context.Context, entityID string) (*models.Device, error) {
 	return &models.Device{ID: entityID, Name: "Test Device"}, nil
 }
 
-func (m *mockHomeAssistantClient) CallService(domain, service, entityID string, serviceData map[string]interface{}) error {
+func (m *mockHomeAssistantClient) CallService(ctx context.Context, domain, service, entityID string, serviceData map[string]interface{}) error {
 	return nil
 }
 
-func (m *mockHomeAssistantClient) TestConnection() error {
+func (m *mockHomeAssistantClient) CallServiceForEntities(ctx context.Context, domain, service string, entityIDs []string, serviceData map[string]interface{}) error {
 	return nil
 }
 
+func (m *mockHomeAssistantClient) TestConnection(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockHomeAssistantClient) GetHistory(ctx context.Context, entityIDs []string, start, end time.Time) (map[string][]models.StateChange, error) {
+	return map[string][]models.StateChange{}, nil
+}
+
+func (m *mockHomeAssistantClient) GetLogbook(ctx context.Context, entityID string, start, end time.Time) ([]models.LogbookEntry, error) {
+	return []models.LogbookEntry{}, nil
+}
+
 // Test version of setupRouter that doesn't load templates
 func setupTestRouter(cfg *config.Config, deviceManager *device.Manager, llmService *llm.Service, conversationManager *conversation.Manager) *gin.Engine {
 	if cfg.Server.Mode == "release" {
@@ -55,13 +68,42 @@ func setupTestRouter(cfg *config.Config, deviceManager *device.Manager, llmServi
 	// API routes
 	v1 := router.Group("/api/v1")
 	{
-		v1.POST("/chat", apiHandler.HandleChat)
-		v1.GET("/devices", apiHandler.GetDevices)
-		v1.GET("/devices/:id", apiHandler.GetDevice)
-		v1.POST("/devices/:id/action", apiHandler.ControlDevice)
-		v1.GET("/conversations/:id", apiHandler.GetConversation)
-		v1.DELETE("/conversations/:id", apiHandler.DeleteConversation)
 		v1.GET("/health", apiHandler.HealthCheck)
+		v1.POST("/auth/login", apiHandler.Login)
+		v1.POST("/auth/setup", apiHandler.Setup)
+		v1.POST("/auth/logout", apiHandler.Logout)
+	}
+
+	chatLimit := api.RateLimit(api.NewRateLimiter(cfg.Server.ChatRateLimit, cfg.Server.ChatRateBurst))
+	protected := v1.Group("", apiHandler.RequireUser())
+	{
+		protected.POST("/chat", chatLimit, apiHandler.HandleChat)
+		protected.POST("/chat/audio", chatLimit, apiHandler.HandleAudioChat)
+		protected.POST("/conversation/process", chatLimit, apiHandler.ProcessConversation)
+		protected.GET("/messages/:id/audio", apiHandler.GetMessageAudio)
+		protected.GET("/devices", apiHandler.GetDevices)
+		protected.GET("/devices/:id", apiHandler.GetDevice)
+		protected.GET("/devices/:id/history", apiHandler.GetDeviceHistory)
+		protected.POST("/devices/:id/action", apiHandler.ControlDevice)
+		protected.POST("/actions", apiHandler.ExecuteActions)
+		protected.GET("/conversations", apiHandler.ListConversations)
+		protected.GET("/conversations/export", apiHandler.ExportConversations)
+		protected.POST("/conversations/import", apiHandler.ImportConversations)
+		protected.GET("/conversations/:id", apiHandler.GetConversation)
+		protected.GET("/conversations/:id/export", apiHandler.ExportConversation)
+		protected.DELETE("/conversations/:id", apiHandler.DeleteConversation)
+		protected.GET("/auth/me", apiHandler.GetCurrentUser)
+		protected.PUT("/auth/me/preferences", apiHandler.UpdatePreferences)
+		protected.GET("/users", apiHandler.ListUsers)
+		protected.POST("/users", apiHandler.CreateUser)
+		protected.GET("/ws", apiHandler.HandleWebSocket)
+	}
+
+	// OpenAI-compatible API for tools built for OpenAI models
+	openai := router.Group("/v1", apiHandler.RequireUser())
+	{
+		openai.GET("/models", apiHandler.ListModels)
+		openai.POST("/chat/completions", chatLimit, apiHandler.ChatCompletions)
 	}
 
 	// Simple home route for testing (without template loading)
@@ -186,12 +228,17 @@ func TestSetupRouter_APIRoutes(t *testing.T) {
 		path   string
 	}{
 		{"POST", "/api/v1/chat"},
+		{"POST", "/api/v1/chat/audio"},
+		{"POST", "/api/v1/conversation/process"},
+		{"GET", "/api/v1/messages/550e8400-e29b-41d4-a716-446655440000/audio"},
 		{"GET", "/api/v1/devices"},
 		{"GET", "/api/v1/devices/test"},
 		{"POST", "/api/v1/devices/test/action"},
 		{"GET", "/api/v1/conversations/550e8400-e29b-41d4-a716-446655440000"},    // May return 404 due to business logic
 		{"DELETE", "/api/v1/conversations/550e8400-e29b-41d4-a716-446655440000"}, // May return 500 due to business logic
 		{"GET", "/api/v1/health"},
+		{"GET", "/v1/models"},
+		{"POST", "/v1/chat/completions"},
 	}
 
 	for _, route := range apiRoutes {
@@ -321,6 +368,12 @@ func TestMainComponents_Environment(t *testing.T) {
 	})
 }
 
+func TestWyomingOptions(t *testing.T) {
+	opts := wyomingOptions(config.WyomingConfig{Port: 10500, Languages: "en, de,,fr "})
+	assert.Equal(t, ":10500", opts.Addr)
+	assert.Equal(t, []string{"en", "de", "fr"}, opts.Languages)
+}
+
 // Test that the router handles middleware correctly
 func TestSetupRouter_Middleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Response types of Home Assistant's conversation API
+const (
+	assistActionDone  = "action_done"
+	assistQueryAnswer = "query_answer"
+	assistError       = "error"
+)
+
+// assistRequest is what Home Assistant sends a conversation agent to process
+type assistRequest struct {
+	Text           string `json:"text" binding:"required"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	Language       string `json:"language,omitempty"`
+	AgentID        string `json:"agent_id,omitempty"`
+}
+
+// assistResult is what a conversation agent answers Home Assistant with
+type assistResult struct {
+	Response             assistResponse `json:"response"`
+	ConversationID       string         `json:"conversation_id"`
+	ContinueConversation bool           `json:"continue_conversation"`
+}
+
+// assistResponse is Home Assistant's intent response
+type assistResponse struct {
+	ResponseType string       `json:"response_type"`
+	Language     string       `json:"language"`
+	Speech       assistSpeech `json:"speech"`
+	Data         assistData   `json:"data"`
+}
+
+type assistSpeech struct {
+	Plain assistPlainSpeech `json:"plain"`
+}
+
+type assistPlainSpeech struct {
+	Speech    string `json:"speech"`
+	ExtraData any    `json:"extra_data"`
+}
+
+// assistData lists the entities an intent acted on. Errors carry a code instead.
+type assistData struct {
+	Targets []assistTarget `json:"targets,omitempty"`
+	Success []assistTarget `json:"success,omitempty"`
+	Failed  []assistTarget `json:"failed,omitempty"`
+	Code    string         `json:"code,omitempty"`
+}
+
+type assistTarget struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// ProcessConversation answers in the shape of Home Assistant's conversation
+// processing API, so Assist can use Luna as its conversation agent. Messages go
+// through the same chat as the web UI, keeping history and device actions.
+// Failures are answered as error intent responses so Assist speaks them.
+func (h *Handler) ProcessConversation(c *gin.Context) {
+	var req assistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	language := req.Language
+	if language == "" {
+		language = "en"
+	}
+
+	// Assist makes up its own IDs for new conversations; only ours continue one
+	chatReq := models.ChatRequest{Message: req.Text}
+	if id, err := uuid.Parse(req.ConversationID); err == nil {
+		if _, err := h.getOwnConversation(c, id); err == nil {
+			chatReq.ConversationID = id
+		}
+	}
+
+	response, chatErr := h.chat(c, chatReq, nil)
+	if chatErr != nil {
+		c.JSON(http.StatusOK, assistResult{
+			Response: assistResponse{
+				ResponseType: assistError,
+				Language:     language,
+				Speech:       plainSpeech(chatErr.message),
+				Data:         assistData{Code: "unknown"},
+			},
+			ConversationID: req.ConversationID,
+		})
+		return
+	}
+
+	result := assistResult{
+		Response: assistResponse{
+			ResponseType: assistQueryAnswer,
+			Language:     language,
+			Speech:       plainSpeech(response.Response),
+		},
+		ConversationID: response.ConversationID.String(),
+		// Assist keeps listening when Luna asks something back
+		ContinueConversation: strings.HasSuffix(strings.TrimSpace(response.Response), "?"),
+	}
+	if len(response.Metadata.ActionsPerformed) > 0 || len(response.Metadata.DevicesFailed) > 0 {
+		result.Response.ResponseType = assistActionDone
+		result.Response.Data.Success = h.assistTargets(c.Request.Context(), performedDevices(response.Metadata.ActionsPerformed))
+		result.Response.Data.Failed = h.assistTargets(c.Request.Context(), response.Metadata.DevicesFailed)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func plainSpeech(text string) assistSpeech {
+	return assistSpeech{Plain: assistPlainSpeech{Speech: text}}
+}
+
+// performedDevices picks the devices out of "action device_id" entries
+func performedDevices(performed []string) []string {
+	var ids []string
+	for _, entry := range performed {
+		id := entry[strings.LastIndex(entry, " ")+1:]
+		ids = mergeDeviceIDs(ids, []string{id})
+	}
+	return ids
+}
+
+// assistTargets describes devices as the entities of an intent response
+func (h *Handler) assistTargets(ctx context.Context, deviceIDs []string) []assistTarget {
+	targets := make([]assistTarget, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		name := id
+		if d, err := h.deviceManager.GetDevice(ctx, id); err == nil {
+			name = d.Name
+		}
+		targets = append(targets, assistTarget{Name: name, Type: "entity", ID: id})
+	}
+	return targets
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+)
+
+func processConversation(t *testing.T, router *gin.Engine, body any) assistResult {
+	t.Helper()
+
+	payload, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/conversation/process", bytes.NewBuffer(payload))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var result assistResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	return result
+}
+
+func TestProcessConversation(t *testing.T) {
+	llmService := newFakeOllama(t,
+		`{"response": "The test light is on.", "actions": [{"action": "turn_on", "targets": ["light.1"]}]}`,
+		`{"response": "Should I turn it off again?", "actions": []}`,
+	)
+	handler := NewHandler(device.NewManager(&mockHAClient{}), llmService, conversation.NewManager())
+	router := setupTestRouter(handler)
+
+	// Assist's own IDs start a new conversation
+	first := processConversation(t, router, assistRequest{
+		Text:           "Switch on the test light",
+		ConversationID: "01HZXJ4T6K8Q2C5V9M3N7P1R0S",
+		Language:       "en",
+	})
+	assert.Equal(t, assistActionDone, first.Response.ResponseType)
+	assert.Equal(t, "en", first.Response.Language)
+	assert.Equal(t, "The test light is on.", first.Response.Speech.Plain.Speech)
+	assert.Equal(t, []assistTarget{{Name: "Test Light", Type: "entity", ID: "light.1"}}, first.Response.Data.Success)
+	assert.False(t, first.ContinueConversation)
+
+	id, err := uuid.Parse(first.ConversationID)
+	require.NoError(t, err)
+
+	// Passing our ID back continues the conversation
+	second := processConversation(t, router, assistRequest{Text: "Thanks", ConversationID: first.ConversationID})
+	assert.Equal(t, first.ConversationID, second.ConversationID)
+	assert.Equal(t, assistQueryAnswer, second.Response.ResponseType)
+	assert.Equal(t, "en", second.Response.Language)
+	assert.Empty(t, second.Response.Data.Success)
+	assert.True(t, second.ContinueConversation)
+
+	conv, err := handler.conversationManager.GetConversation(id)
+	require.NoError(t, err)
+	assert.Len(t, conv.Messages, 4)
+}
+
+// brokenSwitchClient is a HomeAssistant whose test switch fails every service call
+type brokenSwitchClient struct {
+	mockHAClient
+}
+
+func (c *brokenSwitchClient) CallService(ctx context.Context, domain, service, entityID string, serviceData map[string]interface{}) error {
+	return c.CallServiceForEntities(ctx, domain, service, []string{entityID}, serviceData)
+}
+
+func (c *brokenSwitchClient) CallServiceForEntities(ctx context.Context, domain, service string, entityIDs []string, serviceData map[string]interface{}) error {
+	for _, id := range entityIDs {
+		if id == "switch.1" {
+			return errors.New("HomeAssistant API error 500: Internal Server Error")
+		}
+	}
+	return nil
+}
+
+func TestProcessConversation_PartialFailure(t *testing.T) {
+	llmService := newFakeOllama(t,
+		`{"response": "Done.", "actions": [{"action": "turn_off", "targets": ["light.1", "switch.1"]}]}`,
+	)
+	handler := NewHandler(device.NewManager(&brokenSwitchClient{}), llmService, conversation.NewManager())
+	router := setupTestRouter(handler)
+
+	result := processConversation(t, router, assistRequest{Text: "Turn off the test light and the test switch"})
+	assert.Equal(t, assistActionDone, result.Response.ResponseType)
+	assert.Equal(t, []assistTarget{{Name: "Test Light", Type: "entity", ID: "light.1"}}, result.Response.Data.Success)
+	assert.Equal(t, []assistTarget{{Name: "Test Switch", Type: "entity", ID: "switch.1"}}, result.Response.Data.Failed)
+}
+
+func TestProcessConversation_StateQuery(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	result := processConversation(t, router, map[string]string{"text": "Is the test light on?", "language": "de"})
+	assert.Equal(t, assistQueryAnswer, result.Response.ResponseType)
+	assert.Equal(t, "de", result.Response.Language)
+	assert.Contains(t, result.Response.Speech.Plain.Speech, "Test Light (light.1) is on")
+	assert.NotEmpty(t, result.ConversationID)
+}
+
+func TestProcessConversation_InvalidRequest(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/conversation/process", bytes.NewBufferString(`{"language": "en"}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tienpdinh/gpt-home/internal/speech"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAudioBytes caps uploaded recordings, as OpenAI's transcription API does
+const maxAudioBytes = 25 << 20
+
+// HandleAudioChat transcribes a spoken message and answers it like HandleChat,
+// adding the transcript to the response. The recording is sent either as the
+// multipart form file "audio", with optional "conversation_id" and "speak"
+// fields, or as the whole body with its audio content type and those in the
+// query.
+func (h *Handler) HandleAudioChat(c *gin.Context) {
+	if h.transcriber == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Voice input is not configured"})
+		return
+	}
+
+	audio, req, err := readAudio(c)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Audio must be at most %d MB", maxAudioBytes>>20)})
+		case errors.Is(err, speech.ErrUnsupportedFormat):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Audio must be WAV, Ogg or WebM"})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if req.Speak && h.synthesizer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Spoken replies are not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	transcript, err := h.transcriber.Transcribe(ctx, audio)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request cancelled while transcribing"})
+			return
+		}
+		logrus.WithError(err).Error("Failed to transcribe audio")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Speech recognition failed"})
+		return
+	}
+	if transcript.Text == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No speech was recognized"})
+		return
+	}
+
+	req.Message = transcript.Text
+	response, chatErr := h.chat(c, req, nil)
+	if chatErr != nil {
+		respondChatError(c, chatErr)
+		return
+	}
+	if req.Speak {
+		h.speakReply(ctx, response)
+	}
+	c.JSON(http.StatusOK, models.AudioChatResponse{ChatResponse: *response, Transcript: transcript.Text})
+}
+
+// readAudio reads the recording of an audio chat request and the chat options
+// sent with it
+func readAudio(c *gin.Context) (speech.Audio, models.ChatRequest, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAudioBytes)
+
+	var audio speech.Audio
+	var req models.ChatRequest
+	var id, speak string
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, header, err := c.Request.FormFile("audio")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return audio, req, err
+			}
+			return audio, req, fmt.Errorf("missing audio file: %w", err)
+		}
+		defer file.Close()
+
+		audio.ContentType = header.Header.Get("Content-Type")
+		audio.Filename = header.Filename
+		if audio.Data, err = io.ReadAll(file); err != nil {
+			return audio, req, err
+		}
+		id = c.Request.FormValue("conversation_id")
+		speak = c.Request.FormValue("speak")
+	} else {
+		var err error
+		audio.ContentType = c.GetHeader("Content-Type")
+		if audio.Data, err = io.ReadAll(c.Request.Body); err != nil {
+			return audio, req, err
+		}
+		id = c.Query("conversation_id")
+		speak = c.Query("speak")
+	}
+
+	if _, err := speech.AudioFormat(audio.ContentType, audio.Filename); err != nil {
+		return audio, req, err
+	}
+	if len(audio.Data) == 0 {
+		return audio, req, fmt.Errorf("audio is empty")
+	}
+
+	if id != "" {
+		var err error
+		if req.ConversationID, err = uuid.Parse(id); err != nil {
+			return audio, req, fmt.Errorf("invalid conversation_id")
+		}
+	}
+	if speak != "" {
+		var err error
+		if req.Speak, err = strconv.ParseBool(speak); err != nil {
+			return audio, req, fmt.Errorf("invalid speak")
+		}
+	}
+	return audio, req, nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/speech"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+type fakeTranscriber struct {
+	text  string
+	err   error
+	heard []speech.Audio
+}
+
+func (f *fakeTranscriber) Transcribe(ctx context.Context, audio speech.Audio) (*speech.Transcript, error) {
+	f.heard = append(f.heard, audio)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &speech.Transcript{Text: f.text}, nil
+}
+
+func audioForm(t *testing.T, filename, contentType string, data []byte, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := make(map[string][]string)
+	header["Content-Disposition"] = []string{`form-data; name="audio"; filename="` + filename + `"`}
+	header["Content-Type"] = []string{contentType}
+	part, err := form.CreatePart(header)
+	require.NoError(t, err)
+	part.Write(data)
+	for name, value := range fields {
+		require.NoError(t, form.WriteField(name, value))
+	}
+	require.NoError(t, form.Close())
+	return &body, form.FormDataContentType()
+}
+
+func TestHandleAudioChat(t *testing.T) {
+	llmService := newFakeOllama(t,
+		`{"response": "The test light is on.", "actions": [{"action": "turn_on", "targets": ["light.1"]}]}`,
+		`{"response": "You're welcome.", "actions": []}`,
+	)
+	transcriber := &fakeTranscriber{text: "Switch on the test light"}
+	handler := NewHandlerWithOptions(device.NewManager(&mockHAClient{}), llmService, conversation.NewManager(), HandlerOptions{Transcriber: transcriber})
+	router := setupTestRouter(handler)
+
+	body, contentType := audioForm(t, "clip.webm", "audio/webm;codecs=opus", []byte("webm"), nil)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/chat/audio", body)
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var first models.AudioChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Equal(t, "Switch on the test light", first.Transcript)
+	assert.Equal(t, "The test light is on.", first.Response)
+	assert.Len(t, first.ActionsPerformed, 1)
+	require.Len(t, transcriber.heard, 1)
+	assert.Equal(t, []byte("webm"), transcriber.heard[0].Data)
+	assert.Equal(t, "clip.webm", transcriber.heard[0].Filename)
+
+	// A raw body continues the conversation named in the query
+	transcriber.text = "Thanks"
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/chat/audio?conversation_id="+first.ConversationID.String(), bytes.NewReader([]byte("RIFF")))
+	req.Header.Set("Content-Type", "audio/wav")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var second models.AudioChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Equal(t, first.ConversationID, second.ConversationID)
+	assert.Equal(t, "Thanks", second.Transcript)
+	assert.Equal(t, "You're welcome.", second.Response)
+}
+
+func TestHandleAudioChat_Errors(t *testing.T) {
+	newRouter := func(transcriber speech.Transcriber) http.Handler {
+		handler := NewHandlerWithOptions(device.NewManager(&mockHAClient{}), newFakeOllama(t), conversation.NewManager(), HandlerOptions{Transcriber: transcriber})
+		return setupTestRouter(handler)
+	}
+	post := func(router http.Handler, contentType string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/chat/audio", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		w := post(newRouter(nil), "audio/wav", []byte("RIFF"))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("speaking not configured", func(t *testing.T) {
+		body, contentType := audioForm(t, "clip.wav", "audio/wav", []byte("RIFF"), map[string]string{"speak": "true"})
+		w := post(newRouter(&fakeTranscriber{text: "hi"}), contentType, body.Bytes())
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		w := post(newRouter(&fakeTranscriber{text: "hi"}), "audio/mpeg", []byte("ID3"))
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("conversation_id", "")
+		form.Close()
+		w := post(newRouter(&fakeTranscriber{text: "hi"}), form.FormDataContentType(), body.Bytes())
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid conversation", func(t *testing.T) {
+		body, contentType := audioForm(t, "clip.ogg", "audio/ogg", []byte("OggS"), map[string]string{"conversation_id": "nope"})
+		w := post(newRouter(&fakeTranscriber{text: "hi"}), contentType, body.Bytes())
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		w := post(newRouter(&fakeTranscriber{text: "hi"}), "audio/wav", make([]byte, maxAudioBytes+1))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("transcription failed", func(t *testing.T) {
+		w := post(newRouter(&fakeTranscriber{err: errors.New("boom")}), "audio/wav", []byte("RIFF"))
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("nothing heard", func(t *testing.T) {
+		w := post(newRouter(&fakeTranscriber{}), "audio/wav", []byte("RIFF"))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
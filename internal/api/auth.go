@@ -0,0 +1,343 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/internal/auth"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// sessionCookie holds the session token of a signed-in user
+	sessionCookie = "luna_session"
+	// userKey is where the signed-in user is kept in the request context
+	userKey = "user"
+)
+
+// credentials are sent to sign in or to create the first account
+type credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// newUserRequest is sent by an admin to add a user
+type newUserRequest struct {
+	credentials
+	Admin bool `json:"admin"`
+}
+
+// RequireUser rejects API requests without a valid session. With user accounts
+// disabled every request is let through.
+func (h *Handler) RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.users == nil {
+			c.Next()
+			return
+		}
+
+		user, err := h.sessionUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Sign in required"})
+			return
+		}
+		c.Set(userKey, user)
+		c.Next()
+	}
+}
+
+// RequireLogin sends visitors without a valid session to the login page
+func (h *Handler) RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.users == nil {
+			c.Next()
+			return
+		}
+
+		user, err := h.sessionUser(c)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/login")
+			c.Abort()
+			return
+		}
+		c.Set(userKey, user)
+		c.Next()
+	}
+}
+
+// sessionUser is the user whose session token came with the request, in the
+// session cookie or, for API clients that can't keep cookies, as a bearer token
+func (h *Handler) sessionUser(c *gin.Context) (*models.User, error) {
+	token, err := c.Cookie(sessionCookie)
+	if err != nil {
+		bearer, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || bearer == "" {
+			return nil, auth.ErrNoSession
+		}
+		token = bearer
+	}
+	return h.users.UserForSession(token)
+}
+
+// CurrentUser returns the signed-in user, or nil when user accounts are disabled
+func CurrentUser(c *gin.Context) *models.User {
+	if value, exists := c.Get(userKey); exists {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// currentUserID is the signed-in user's ID, or uuid.Nil when user accounts are disabled
+func currentUserID(c *gin.Context) uuid.UUID {
+	return userID(CurrentUser(c))
+}
+
+// currentPrincipal is who device access is checked for: the signed-in user's
+// name, or "" when user accounts are disabled
+func currentPrincipal(c *gin.Context) string {
+	return principalOf(CurrentUser(c))
+}
+
+func userID(user *models.User) uuid.UUID {
+	if user != nil {
+		return user.ID
+	}
+	return uuid.Nil
+}
+
+func principalOf(user *models.User) string {
+	if user != nil {
+		return user.Username
+	}
+	return ""
+}
+
+// accountsEnabled responds with 404 and returns false when user accounts are disabled
+func (h *Handler) accountsEnabled(c *gin.Context) bool {
+	if h.users == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User accounts are disabled"})
+		return false
+	}
+	return true
+}
+
+// LoginPage renders the sign-in form, or the form to create the first account
+// when there are no users yet
+func (h *Handler) LoginPage(c *gin.Context) {
+	if h.users == nil {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	if _, err := h.sessionUser(c); err == nil {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"title": "Sign in - Luna",
+		"setup": !h.users.HasUsers(),
+	})
+}
+
+// Login checks a username and password and starts a session
+func (h *Handler) Login(c *gin.Context) {
+	if !h.accountsEnabled(c) {
+		return
+	}
+
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.Authenticate(req.Username, req.Password)
+	if err != nil {
+		logrus.Infof("Failed sign-in for %q from %s", req.Username, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	h.startSession(c, http.StatusOK, user)
+}
+
+// Setup creates the first account, an admin, and signs it in. It only works
+// while there are no users.
+func (h *Handler) Setup(c *gin.Context) {
+	if !h.accountsEnabled(c) {
+		return
+	}
+
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.CreateFirstUser(req.Username, req.Password)
+	if err != nil {
+		h.respondAccountError(c, err)
+		return
+	}
+
+	logrus.Infof("Created first user %s", user.Username)
+	h.startSession(c, http.StatusCreated, user)
+}
+
+func (h *Handler) startSession(c *gin.Context, status int, user *models.User) {
+	token, expires, err := h.users.CreateSession(user.ID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to create session")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	setSessionCookie(c, token, expires)
+	c.JSON(status, gin.H{"user": user, "expires_at": expires})
+}
+
+// Logout ends the caller's session
+func (h *Handler) Logout(c *gin.Context) {
+	if !h.accountsEnabled(c) {
+		return
+	}
+
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		h.users.DeleteSession(token)
+	}
+	setSessionCookie(c, "", time.Time{})
+	c.JSON(http.StatusOK, gin.H{"status": "signed out"})
+}
+
+// GetCurrentUser returns the signed-in user
+func (h *Handler) GetCurrentUser(c *gin.Context) {
+	if !h.accountsEnabled(c) {
+		return
+	}
+	c.JSON(http.StatusOK, CurrentUser(c))
+}
+
+// UpdatePreferences replaces the signed-in user's preferences. They apply to
+// all of the user's conversations from their next message.
+func (h *Handler) UpdatePreferences(c *gin.Context) {
+	if !h.accountsEnabled(c) {
+		return
+	}
+
+	var preferences map[string]string
+	if err := c.ShouldBindJSON(&preferences); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.SetPreferences(currentUserID(c), preferences)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to update preferences")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ListUsers returns every user. Only admins may list users.
+func (h *Handler) ListUsers(c *gin.Context) {
+	if !h.accountsEnabled(c) || !requireAdmin(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": h.users.ListUsers()})
+}
+
+// CreateUser adds a user. Only admins may add users.
+func (h *Handler) CreateUser(c *gin.Context) {
+	if !h.accountsEnabled(c) || !requireAdmin(c) {
+		return
+	}
+
+	var req newUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.CreateUser(req.Username, req.Password, req.Admin)
+	if err != nil {
+		h.respondAccountError(c, err)
+		return
+	}
+
+	logrus.Infof("User %s added user %s", CurrentUser(c).Username, user.Username)
+	c.JSON(http.StatusCreated, user)
+}
+
+func requireAdmin(c *gin.Context) bool {
+	if user := CurrentUser(c); user == nil || !user.Admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can manage users"})
+		return false
+	}
+	return true
+}
+
+func (h *Handler) respondAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidAccount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrUserExists), errors.Is(err, auth.ErrSetupComplete):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.WithError(err).Error("Failed to create user")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+	}
+}
+
+// setSessionCookie stores the session token, or clears it when token is empty
+func setSessionCookie(c *gin.Context, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(c.Request),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expires
+		cookie.MaxAge = int(time.Until(expires).Seconds())
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+// isHTTPS reports whether the client reached us over HTTPS, directly or through
+// a proxy, so the cookie is only marked secure when it can be
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// getOwnConversation returns a conversation the caller may see. Other users'
+// conversations are reported as not found so their IDs can't be probed.
+func (h *Handler) getOwnConversation(c *gin.Context, id uuid.UUID) (*models.Conversation, error) {
+	return h.ownConversation(currentUserID(c), id)
+}
+
+// ownConversation gets a conversation that belongs to owner
+func (h *Handler) ownConversation(owner, id uuid.UUID) (*models.Conversation, error) {
+	conv, err := h.conversationManager.GetConversation(id)
+	if err != nil {
+		return nil, err
+	}
+	if h.users != nil && conv.UserID != owner {
+		return nil, fmt.Errorf("conversation not found: %s", id)
+	}
+	return conv, nil
+}
@@ -0,0 +1,389 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/auth"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/events"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func setupAuthServer(t *testing.T, handler *Handler) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	router.GET("/health", handler.HealthCheck)
+	router.POST("/auth/login", handler.Login)
+	router.POST("/auth/setup", handler.Setup)
+	router.POST("/auth/logout", handler.Logout)
+
+	protected := router.Group("", handler.RequireUser())
+	protected.POST("/chat", handler.HandleChat)
+	protected.GET("/devices", handler.GetDevices)
+	protected.GET("/devices/:id", handler.GetDevice)
+	protected.POST("/devices/:id/control", handler.ControlDevice)
+	protected.POST("/actions", handler.ExecuteActions)
+	protected.GET("/conversations", handler.ListConversations)
+	protected.GET("/conversations/export", handler.ExportConversations)
+	protected.POST("/conversations/import", handler.ImportConversations)
+	protected.GET("/conversations/:id", handler.GetConversation)
+	protected.DELETE("/conversations/:id", handler.DeleteConversation)
+	protected.GET("/auth/me", handler.GetCurrentUser)
+	protected.PUT("/auth/me/preferences", handler.UpdatePreferences)
+	protected.GET("/users", handler.ListUsers)
+	protected.POST("/users", handler.CreateUser)
+	protected.GET("/ws", handler.HandleWebSocket)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// authClient is one browser: it keeps its own session cookie
+type authClient struct {
+	t      *testing.T
+	server *httptest.Server
+	http   *http.Client
+}
+
+func newAuthClient(t *testing.T, server *httptest.Server) *authClient {
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	return &authClient{t: t, server: server, http: &http.Client{Jar: jar}}
+}
+
+func (a *authClient) do(method, path string, body any) (int, []byte) {
+	a.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(a.t, err)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, a.server.URL+path, reader)
+	require.NoError(a.t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.http.Do(req)
+	require.NoError(a.t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(a.t, err)
+	return resp.StatusCode, data
+}
+
+func (a *authClient) login(username, password string) {
+	a.t.Helper()
+	status, body := a.do("POST", "/auth/login", credentials{Username: username, Password: password})
+	require.Equal(a.t, http.StatusOK, status, string(body))
+}
+
+func newAuthHandler(t *testing.T, replies ...string) (*Handler, *auth.Manager) {
+	users := auth.NewManager(0)
+	handler := NewHandlerWithOptions(device.NewManager(&mockHAClient{}), newFakeOllama(t, replies...), conversation.NewManager(), HandlerOptions{Users: users})
+	return handler, users
+}
+
+func TestAuth_RequiresSession(t *testing.T) {
+	handler, users := newAuthHandler(t)
+	_, err := users.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+	server := setupAuthServer(t, handler)
+	client := newAuthClient(t, server)
+
+	status, _ := client.do("GET", "/health", nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	for _, path := range []string{"/devices", "/conversations", "/auth/me"} {
+		status, _ := client.do("GET", path, nil)
+		assert.Equal(t, http.StatusUnauthorized, status, path)
+	}
+
+	status, _ = client.do("POST", "/auth/login", credentials{Username: "alice", Password: "wrong password"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	client.login("alice", "correct horse")
+	status, body := client.do("GET", "/auth/me", nil)
+	require.Equal(t, http.StatusOK, status)
+	var me models.User
+	require.NoError(t, json.Unmarshal(body, &me))
+	assert.Equal(t, "alice", me.Username)
+
+	status, _ = client.do("POST", "/auth/logout", nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = client.do("GET", "/auth/me", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestAuth_SetupCreatesFirstAdmin(t *testing.T) {
+	handler, _ := newAuthHandler(t)
+	server := setupAuthServer(t, handler)
+	client := newAuthClient(t, server)
+
+	status, _ := client.do("POST", "/auth/setup", credentials{Username: "alice", Password: "short"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body := client.do("POST", "/auth/setup", credentials{Username: "alice", Password: "correct horse"})
+	require.Equal(t, http.StatusCreated, status, string(body))
+
+	// Setup signs the new admin in
+	status, body = client.do("GET", "/auth/me", nil)
+	require.Equal(t, http.StatusOK, status)
+	var me models.User
+	require.NoError(t, json.Unmarshal(body, &me))
+	assert.True(t, me.Admin)
+
+	// Nobody else can claim the house once it has an account
+	status, _ = newAuthClient(t, server).do("POST", "/auth/setup", credentials{Username: "mallory", Password: "correct horse"})
+	assert.Equal(t, http.StatusConflict, status)
+}
+
+func TestAuth_OnlyAdminsManageUsers(t *testing.T) {
+	handler, users := newAuthHandler(t)
+	_, err := users.CreateFirstUser("alice", "correct horse")
+	require.NoError(t, err)
+	server := setupAuthServer(t, handler)
+
+	admin := newAuthClient(t, server)
+	admin.login("alice", "correct horse")
+	status, body := admin.do("POST", "/users", newUserRequest{credentials: credentials{Username: "bob", Password: "battery staple"}})
+	require.Equal(t, http.StatusCreated, status, string(body))
+
+	status, _ = admin.do("POST", "/users", newUserRequest{credentials: credentials{Username: "BOB", Password: "battery staple"}})
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, body = admin.do("GET", "/users", nil)
+	require.Equal(t, http.StatusOK, status)
+	var list struct {
+		Users []models.User `json:"users"`
+	}
+	require.NoError(t, json.Unmarshal(body, &list))
+	assert.Len(t, list.Users, 2)
+
+	bob := newAuthClient(t, server)
+	bob.login("bob", "battery staple")
+	status, _ = bob.do("POST", "/users", newUserRequest{credentials: credentials{Username: "carol", Password: "battery staple"}})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = bob.do("GET", "/users", nil)
+	assert.Equal(t, http.StatusForbidden, status)
+}
+
+func TestAuth_ConversationsArePrivate(t *testing.T) {
+	handler, users := newAuthHandler(t,
+		`{"response": "Hi Alice.", "actions": []}`,
+		`{"response": "Hi Bob.", "actions": []}`,
+	)
+	for _, name := range []string{"alice", "bob"} {
+		_, err := users.CreateUser(name, "correct horse", false)
+		require.NoError(t, err)
+	}
+	server := setupAuthServer(t, handler)
+
+	alice := newAuthClient(t, server)
+	alice.login("alice", "correct horse")
+	bob := newAuthClient(t, server)
+	bob.login("bob", "correct horse")
+
+	// The body can't claim to be someone else; the session decides the owner
+	status, body := alice.do("POST", "/chat", map[string]any{"message": "hello there", "user_id": uuid.New()})
+	require.Equal(t, http.StatusOK, status, string(body))
+	var reply models.ChatResponse
+	require.NoError(t, json.Unmarshal(body, &reply))
+	aliceConv := reply.ConversationID
+
+	status, body = bob.do("POST", "/chat", map[string]any{"message": "hello there"})
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	// Bob can't read, continue or delete Alice's conversation
+	status, _ = bob.do("GET", "/conversations/"+aliceConv.String(), nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = bob.do("POST", "/chat", models.ChatRequest{Message: "hello again", ConversationID: aliceConv})
+	assert.Equal(t, http.StatusInternalServerError, status)
+	status, _ = bob.do("DELETE", "/conversations/"+aliceConv.String(), nil)
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	status, body = alice.do("GET", "/conversations/"+aliceConv.String(), nil)
+	require.Equal(t, http.StatusOK, status)
+	var conv models.Conversation
+	require.NoError(t, json.Unmarshal(body, &conv))
+	assert.Len(t, conv.Messages, 2)
+
+	// Listings and exports only hold the caller's own conversations
+	for _, client := range []*authClient{alice, bob} {
+		status, body := client.do("GET", "/conversations", nil)
+		require.Equal(t, http.StatusOK, status)
+		var page struct {
+			Total int `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(body, &page))
+		assert.Equal(t, 1, page.Total)
+
+		status, body = client.do("GET", "/conversations/export", nil)
+		require.Equal(t, http.StatusOK, status)
+		convs, err := conversation.ReadJSON(bytes.NewReader(body))
+		require.NoError(t, err)
+		assert.Len(t, convs, 1)
+	}
+}
+
+func TestAuth_WebSocketOnlySendsOwnMessages(t *testing.T) {
+	handler, users := newAuthHandler(t)
+	alice, err := users.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+	bob, err := users.CreateUser("bob", "correct horse", false)
+	require.NoError(t, err)
+	server := setupAuthServer(t, handler)
+
+	client := newAuthClient(t, server)
+	client.login("alice", "correct horse")
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	header := http.Header{}
+	for _, cookie := range client.http.Jar.Cookies(serverURL) {
+		header.Add("Cookie", cookie.String())
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?topics=" + events.TopicConversationMessage
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "subscribed", readServerMessage(t, conn).Type)
+
+	bobsConv, alicesConv := uuid.New(), uuid.New()
+	handler.events.Publish(events.TopicConversationMessage, models.ConversationMessageEvent{ConversationID: bobsConv, UserID: bob.ID})
+	handler.events.Publish(events.TopicConversationMessage, models.ConversationMessageEvent{ConversationID: alicesConv, UserID: alice.ID})
+
+	event := readServerMessage(t, conn)
+	assert.Equal(t, alicesConv.String(), event.Data.(map[string]any)["conversation_id"])
+}
+
+func TestAuth_PreferencesFollowTheUser(t *testing.T) {
+	handler, users := newAuthHandler(t, `{"response": "Hello.", "actions": []}`)
+	_, err := users.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+	server := setupAuthServer(t, handler)
+
+	alice := newAuthClient(t, server)
+	alice.login("alice", "correct horse")
+
+	status, _ := alice.do("PUT", "/auth/me/preferences", map[string]string{"temperature_unit": "celsius"})
+	require.Equal(t, http.StatusOK, status)
+
+	status, body := alice.do("POST", "/chat", models.ChatRequest{Message: "hello there"})
+	require.Equal(t, http.StatusOK, status, string(body))
+	var reply models.ChatResponse
+	require.NoError(t, json.Unmarshal(body, &reply))
+	assert.Equal(t, "celsius", reply.Context.UserPreferences["temperature_unit"])
+}
+
+func TestAuth_Disabled(t *testing.T) {
+	server := setupAuthServer(t, setupTestHandler())
+	client := newAuthClient(t, server)
+
+	status, _ := client.do("POST", "/auth/login", credentials{Username: "alice", Password: "correct horse"})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Everything else works without signing in
+	status, _ = client.do("GET", "/conversations", nil)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestAuth_DeviceAccess(t *testing.T) {
+	acl := &device.ACL{
+		Policies: map[string]device.AccessPolicy{
+			"kids":   {Read: []device.AccessRule{{}}, Control: []device.AccessRule{{Domains: []string{"light"}, Actions: []string{"turn_on", "turn_off"}}}},
+			"guests": {Control: []device.AccessRule{{Devices: []string{"light.1"}}}},
+		},
+		Principals: map[string]string{"kid": "kids", "guest": "guests"},
+	}
+	users := auth.NewManager(0)
+	llmService := newFakeOllama(t, `{"response": "The switch is on.", "actions": [{"action": "turn_on", "targets": ["switch.1"]}]}`)
+	handler := NewHandlerWithOptions(device.NewManagerWithOptions(&mockHAClient{}, device.ManagerOptions{ACL: acl}), llmService, conversation.NewManager(), HandlerOptions{Users: users})
+	server := setupAuthServer(t, handler)
+	for _, name := range []string{"kid", "guest"} {
+		_, err := users.CreateUser(name, "correct horse", false)
+		require.NoError(t, err)
+	}
+
+	kid := newAuthClient(t, server)
+	kid.login("kid", "correct horse")
+	status, body := kid.do("GET", "/devices", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, string(body), `"total":2`)
+
+	status, _ = kid.do("POST", "/devices/light.1/control", models.DeviceAction{Action: "turn_on"})
+	assert.Equal(t, http.StatusOK, status)
+	status, body = kid.do("POST", "/devices/switch.1/control", models.DeviceAction{Action: "turn_on"})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Contains(t, string(body), "not allowed to turn on Test Switch")
+
+	status, body = kid.do("POST", "/actions", models.BulkActionRequest{Items: []models.BulkActionItem{
+		{Targets: []string{"light.1", "switch.1"}, Action: models.DeviceAction{Action: "turn_off"}},
+	}})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Contains(t, string(body), `"status":"forbidden"`)
+
+	// Luna explains the refusal instead of claiming the switch is on
+	status, body = kid.do("POST", "/chat", models.ChatRequest{Message: "Turn on the switch"})
+	require.Equal(t, http.StatusOK, status, string(body))
+	var reply models.ChatResponse
+	require.NoError(t, json.Unmarshal(body, &reply))
+	assert.True(t, strings.HasPrefix(reply.Response, "Sorry, you're not allowed to turn on Test Switch"), reply.Response)
+	assert.Empty(t, reply.ActionsPerformed)
+
+	guest := newAuthClient(t, server)
+	guest.login("guest", "correct horse")
+	status, body = guest.do("GET", "/devices", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, string(body), `"total":1`)
+	assert.NotContains(t, string(body), "switch.1")
+	status, _ = guest.do("GET", "/devices/switch.1", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = guest.do("GET", "/devices/light.1", nil)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestAuth_BearerToken(t *testing.T) {
+	handler, users := newAuthHandler(t)
+	alice, err := users.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+	token, _, err := users.CreateSession(alice.ID)
+	require.NoError(t, err)
+	server := setupAuthServer(t, handler)
+
+	get := func(authorization string) int {
+		req, err := http.NewRequest("GET", server.URL+"/auth/me", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", authorization)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("Bearer "+token))
+	assert.Equal(t, http.StatusUnauthorized, get("Bearer not-a-session"))
+	assert.Equal(t, http.StatusUnauthorized, get(token))
+}
@@ -1,14 +1,24 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/tienpdinh/gpt-home/internal/auth"
 	"github.com/tienpdinh/gpt-home/internal/conversation"
 	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/events"
 	"github.com/tienpdinh/gpt-home/internal/llm"
+	"github.com/tienpdinh/gpt-home/internal/speech"
 	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -19,19 +29,61 @@ type Handler struct {
 	deviceManager       *device.Manager
 	llmService          *llm.Service
 	conversationManager *conversation.Manager
+	events              *events.Hub
+	users               *auth.Manager      // nil when user accounts are disabled
+	transcriber         speech.Transcriber // nil when voice input is disabled
+	synthesizer         speech.Synthesizer // nil when spoken replies are disabled
+	spoken              *speech.AudioCache
 	startTime           time.Time
 }
 
+// HandlerOptions turn on the handler's optional features. The zero value has
+// no accounts, voice input or spoken replies, and events go to a hub of the
+// handler's own.
+type HandlerOptions struct {
+	Events      *events.Hub        // receives conversation messages and feeds WebSocket clients
+	Users       *auth.Manager      // users sign in and only see their own conversations
+	Transcriber speech.Transcriber // turns spoken messages into text
+	Synthesizer speech.Synthesizer // speaks replies
+}
+
 func NewHandler(deviceManager *device.Manager, llmService *llm.Service, conversationManager *conversation.Manager) *Handler {
+	return NewHandlerWithOptions(deviceManager, llmService, conversationManager, HandlerOptions{})
+}
+
+// NewHandlerWithOptions creates a handler configured by opts
+func NewHandlerWithOptions(deviceManager *device.Manager, llmService *llm.Service, conversationManager *conversation.Manager, opts HandlerOptions) *Handler {
+	hub := opts.Events
+	if hub == nil {
+		hub = events.NewHub()
+	}
 	return &Handler{
 		deviceManager:       deviceManager,
 		llmService:          llmService,
 		conversationManager: conversationManager,
+		events:              hub,
+		users:               opts.Users,
+		transcriber:         opts.Transcriber,
+		synthesizer:         opts.Synthesizer,
+		spoken:              speech.NewAudioCache(spokenCacheSize),
 		startTime:           time.Now(),
 	}
 }
 
-// HandleChat processes chat messages and returns AI responses
+// chatError is a chat request that failed, with the status to answer it with
+type chatError struct {
+	status     int
+	message    string
+	retryAfter time.Duration // set for 429s
+}
+
+func (e *chatError) Error() string {
+	return e.message
+}
+
+// HandleChat processes chat messages and returns AI responses. Clients that
+// accept text/event-stream are answered with server-sent events instead, so
+// they can show their place in line while the model is busy.
 func (h *Handler) HandleChat(c *gin.Context) {
 	var req models.ChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,47 +91,223 @@ func (h *Handler) HandleChat(c *gin.Context) {
 		return
 	}
 
-	startTime := time.Now()
+	if req.Speak && h.synthesizer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Spoken replies are not configured"})
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		h.streamChat(c, req)
+		return
+	}
+
+	response, chatErr := h.chat(c, req, nil)
+	if chatErr != nil {
+		respondChatError(c, chatErr)
+		return
+	}
+	if req.Speak {
+		h.speakReply(c.Request.Context(), response)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// streamChat answers a chat request with "queued" events giving the request's
+// place in line while it waits for the model, then a "response" or "error"
+// event. A request turned away before anything was sent gets a plain error
+// response, so a full queue still answers 429 with Retry-After.
+func (h *Handler) streamChat(c *gin.Context, req models.ChatRequest) {
+	streaming := false
+	onQueue := func(position int) {
+		streaming = true
+		c.SSEvent("queued", gin.H{"position": position})
+		c.Writer.Flush()
+	}
+
+	response, chatErr := h.chat(c, req, onQueue)
+	switch {
+	case chatErr == nil:
+		if req.Speak {
+			h.speakReply(c.Request.Context(), response)
+		}
+		c.SSEvent("response", response)
+	case streaming:
+		c.SSEvent("error", gin.H{"error": chatErr.message})
+	default:
+		respondChatError(c, chatErr)
+		return
+	}
+	c.Writer.Flush()
+}
+
+func respondChatError(c *gin.Context, chatErr *chatError) {
+	if chatErr.status == http.StatusTooManyRequests {
+		respondTooManyRequests(c, chatErr.retryAfter, chatErr.message)
+		return
+	}
+	c.JSON(chatErr.status, gin.H{"error": chatErr.message})
+}
+
+// chat answers one chat message. onQueue, if set, is told the request's place
+// in line while it waits for the model.
+func (h *Handler) chat(c *gin.Context, req models.ChatRequest, onQueue func(position int)) (*models.ChatResponse, *chatError) {
+	return h.chatAs(c.Request.Context(), CurrentUser(c), req, onQueue)
+}
+
+// Chat answers a chat message that didn't come over HTTP, such as one from
+// MQTT. It acts for no user, so device access follows the ACL's default policy.
+func (h *Handler) Chat(ctx context.Context, req models.ChatRequest) (*models.ChatResponse, error) {
+	response, chatErr := h.chatAs(ctx, nil, req, nil)
+	if chatErr != nil {
+		return nil, chatErr
+	}
+	return response, nil
+}
+
+// chatAs answers a chat message from user, nil when there is none, in the
+// conversation it names or a new one, and stores the conversation
+func (h *Handler) chatAs(ctx context.Context, user *models.User, req models.ChatRequest, onQueue func(position int)) (*models.ChatResponse, *chatError) {
+	req.UserID = userID(user)
 
 	// Get or create conversation
 	var conv *models.Conversation
 	var err error
 
 	if req.ConversationID != uuid.Nil {
-		conv, err = h.conversationManager.GetConversation(req.ConversationID)
+		conv, err = h.ownConversation(req.UserID, req.ConversationID)
 		if err != nil {
 			logrus.WithError(err).Error("Failed to get conversation")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get conversation"})
-			return
+			return nil, &chatError{status: http.StatusInternalServerError, message: "Failed to get conversation"}
 		}
+	} else if user != nil {
+		conv = h.conversationManager.CreateConversationForUser(user.ID, user.Preferences)
 	} else {
 		conv = h.conversationManager.CreateConversation()
 	}
 
+	// Preferences belong to the user, so changes reach their existing conversations
+	if user != nil {
+		conv.Context.UserPreferences = user.Preferences
+	}
+
+	response, chatErr := h.converse(ctx, principalOf(user), conv, req.Message, true, onQueue)
+	if chatErr != nil {
+		return nil, chatErr
+	}
+
+	// Update conversation
+	if err := h.conversationManager.UpdateConversation(conv); err != nil {
+		logrus.WithError(err).Warn("Failed to update conversation")
+	}
+
+	for _, message := range conv.Messages[len(conv.Messages)-2:] {
+		h.events.Publish(events.TopicConversationMessage, models.ConversationMessageEvent{ConversationID: conv.ID, UserID: conv.UserID, Message: message})
+	}
+
+	return response, nil
+}
+
+// converse answers message as the next turn of conv, carrying out the actions
+// the model plans, and appends both messages to conv. Storing conv is up to
+// the caller. With summarize, older messages are folded into conv's summary;
+// conversations that aren't kept would only summarize the same messages again
+// next time, so they rely on the prompt dropping older messages instead.
+func (h *Handler) converse(ctx context.Context, principal string, conv *models.Conversation, message string, summarize bool, onQueue func(position int)) (*models.ChatResponse, *chatError) {
+	startTime := time.Now()
+
+	// Every model call for this message happens within one turn on the model
+	release, err := h.llmService.Acquire(ctx, onQueue)
+	if err != nil {
+		var busy *llm.BusyError
+		if errors.As(err, &busy) {
+			logrus.Warnf("Turned away chat message: %v", err)
+			return nil, &chatError{status: http.StatusTooManyRequests, message: "Luna is busy right now, please try again shortly", retryAfter: busy.RetryAfter}
+		}
+		return nil, &chatError{status: http.StatusServiceUnavailable, message: "Request cancelled while waiting for the model"}
+	}
+	defer release()
+
+	// The prompt gets the message separately from the messages before it
+	history := conv.Messages
+
 	// Add user message to conversation
 	userMessage := models.Message{
 		ID:        uuid.New(),
 		Role:      models.MessageRoleUser,
-		Content:   req.Message,
+		Content:   message,
 		Timestamp: time.Now(),
 	}
 	conv.Messages = append(conv.Messages, userMessage)
 
-	// Process message with LLM, including conversation history
-	response, actions, err := h.llmService.ProcessMessageWithHistory(req.Message, conv.Context, conv.Messages)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to process message")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process message"})
-		return
+	// State and history questions are answered from real device data rather than the model's guess
+	var response string
+	var planned []llm.PlannedAction
+	var consulted []string
+	var confidence float64
+	var usage *models.TokenUsage
+	switch {
+	case llm.IsHistoryQuery(message):
+		response, consulted = h.answerHistoryQuery(ctx, principal, message)
+	case llm.IsStateQuery(message):
+		response, consulted = h.answerStateQuery(ctx, principal, message)
 	}
 
-	// Execute device actions if any
-	for _, action := range actions {
-		if err := h.deviceManager.ExecuteAction(action); err != nil {
-			logrus.WithError(err).Errorf("Failed to execute action: %s", action.Action)
+	// A refused question is answered without consulting any device
+	if len(consulted) == 0 && response == "" {
+		// Fold older messages into the summary before they drop out of the prompt
+		if summarize {
+			if err := h.llmService.UpdateSummary(ctx, &conv.Context, history); err != nil {
+				logrus.WithError(err).Warn("Failed to update conversation summary")
+			}
+		}
+
+		// The model sees the devices it can control; without them it still gets the conversation
+		devices, err := h.deviceManager.GetAllDevices(ctx)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to get devices for the prompt")
+		}
+		devices = h.deviceManager.ReadableDevices(principal, devices)
+
+		// Process message with LLM, including conversation history
+		reply, err := h.llmService.Chat(ctx, message, conv.Context, history, devices)
+		if ctx.Err() != nil {
+			return nil, &chatError{status: http.StatusServiceUnavailable, message: "Request cancelled while waiting for the model"}
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Failed to process message")
+			return nil, &chatError{status: http.StatusInternalServerError, message: "Failed to process message"}
+		}
+		response, planned = reply.Response, reply.Actions
+		confidence = float64(reply.Confidence)
+		usage = &reply.Usage
+
+		// "Do the same for the bedroom lamp" repeats the last action if the model didn't plan one
+		if len(planned) == 0 && conv.Context.LastAction != nil && device.RepeatsAction(message) {
+			planned = []llm.PlannedAction{{DeviceAction: *conv.Context.LastAction}}
 		}
 	}
 
+	// Execute device actions on the devices they resolve to
+	outcome := h.executePlannedActions(ctx, principal, message, conv.Context, planned)
+
+	// Luna owns up to refused actions rather than claiming they were done
+	if refusal := device.ExplainRefusal(outcome.refused); refusal != "" {
+		if len(outcome.performed) == 0 {
+			response = refusal
+		} else {
+			response += "\n\n" + refusal
+		}
+	}
+
+	// Follow-ups like "turn it off" target the devices this turn was about
+	referenced := mergeDeviceIDs(consulted, outcome.devices)
+	if len(referenced) > 0 {
+		conv.Context.ReferencedDevices = referenced
+	}
+	if outcome.lastAction != nil {
+		conv.Context.LastAction = outcome.lastAction
+	}
+
 	// Add assistant response to conversation
 	assistantMessage := models.Message{
 		ID:        uuid.New(),
@@ -87,56 +315,369 @@ func (h *Handler) HandleChat(c *gin.Context) {
 		Content:   response,
 		Timestamp: time.Now(),
 		Metadata: models.Metadata{
-			ProcessingTime: time.Since(startTime).Seconds(),
-			ModelUsed:      h.llmService.GetModelInfo().Name,
+			DevicesReferenced: referenced,
+			ActionsPerformed:  outcome.performed,
+			DevicesFailed:     outcome.failed,
+			ProcessingTime:    time.Since(startTime).Seconds(),
+			ModelUsed:         h.llmService.GetModelInfo().Name,
+			Confidence:        confidence,
+			TokenUsage:        usage,
 		},
 	}
 	conv.Messages = append(conv.Messages, assistantMessage)
 
-	// Update conversation
-	if err := h.conversationManager.UpdateConversation(conv); err != nil {
-		logrus.WithError(err).Warn("Failed to update conversation")
-	}
-
-	// Return response
-	chatResponse := models.ChatResponse{
+	return &models.ChatResponse{
 		Response:         response,
 		ConversationID:   conv.ID,
 		MessageID:        assistantMessage.ID,
 		Context:          conv.Context,
-		ActionsPerformed: actions,
+		ActionsPerformed: outcome.actions,
 		Metadata:         assistantMessage.Metadata,
+	}, nil
+}
+
+// actionOutcome is what a chat turn's actions actually did
+type actionOutcome struct {
+	actions    []models.DeviceAction // actions that succeeded on at least one device
+	performed  []string              // "action device_id" for each successful call
+	devices    []string              // devices the actions were resolved to
+	failed     []string              // devices an action failed on or was refused for
+	lastAction *models.DeviceAction  // the last action that succeeded
+	refused    []*device.AccessDeniedError
+}
+
+// executePlannedActions runs each planned action on the devices it resolves to,
+// using the conversation context for "it" and "them", as far as principal is
+// allowed to
+func (h *Handler) executePlannedActions(ctx context.Context, principal, message string, conv models.Context, planned []llm.PlannedAction) actionOutcome {
+	var outcome actionOutcome
+	for _, action := range planned {
+		targets := h.deviceManager.ResolveTargets(ctx, message, action.Targets, conv.ReferencedDevices)
+		if len(targets) == 0 {
+			logrus.Warnf("No devices found for action: %s", action.Action)
+			continue
+		}
+		outcome.devices = mergeDeviceIDs(outcome.devices, targets)
+
+		succeeded := false
+		for _, target := range targets {
+			if err := h.deviceManager.ExecuteActionAs(ctx, principal, target, action.DeviceAction); err != nil {
+				var denied *device.AccessDeniedError
+				if errors.As(err, &denied) {
+					logrus.Infof("Refused action %s on device %s for %q", action.Action, target, principal)
+					outcome.refused = append(outcome.refused, denied)
+				} else {
+					logrus.WithError(err).Errorf("Failed to execute action %s on device %s", action.Action, target)
+				}
+				outcome.failed = mergeDeviceIDs(outcome.failed, []string{target})
+				continue
+			}
+			outcome.performed = append(outcome.performed, action.Action+" "+target)
+			succeeded = true
+		}
+
+		if succeeded {
+			executed := action.DeviceAction
+			outcome.actions = append(outcome.actions, executed)
+			outcome.lastAction = &executed
+		}
+	}
+	return outcome
+}
+
+// mergeDeviceIDs appends the IDs in b missing from a, keeping their order
+func mergeDeviceIDs(a, b []string) []string {
+	merged := append([]string(nil), a...)
+	seen := make(map[string]bool, len(a)+len(b))
+	for _, id := range a {
+		seen[id] = true
+	}
+	for _, id := range b {
+		if !seen[id] {
+			seen[id] = true
+			merged = append(merged, id)
+		}
+	}
+	return merged
+}
+
+// answerStateQuery grounds an answer to a state question in the current readings of the
+// devices it refers to. It returns the IDs of the devices consulted, or none if the
+// question couldn't be matched to any device or asked about devices principal can't read.
+func (h *Handler) answerStateQuery(ctx context.Context, principal, message string) (string, []string) {
+	devices, refusal, err := h.findReadableDevices(ctx, principal, message)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to look up devices for state query")
+		return "", nil
+	}
+	if len(devices) == 0 {
+		return refusal, nil
+	}
+
+	facts := make([]string, 0, len(devices))
+	consulted := make([]string, 0, len(devices))
+	for _, d := range devices {
+		facts = append(facts, device.DescribeState(d))
+		consulted = append(consulted, d.ID)
+	}
+
+	return h.llmService.AnswerStateQuery(ctx, message, facts), consulted
+}
+
+// answerHistoryQuery grounds an answer to a question about past device activity in the
+// Home Assistant history of the devices it refers to
+func (h *Handler) answerHistoryQuery(ctx context.Context, principal, message string) (string, []string) {
+	devices, refusal, err := h.findReadableDevices(ctx, principal, message)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to look up devices for history query")
+		return "", nil
+	}
+	if len(devices) == 0 {
+		return refusal, nil
+	}
+
+	consulted := make([]string, 0, len(devices))
+	for _, d := range devices {
+		consulted = append(consulted, d.ID)
+	}
+
+	start, end := llm.HistoryWindow(message, time.Now())
+	history, err := h.deviceManager.GetHistory(ctx, consulted, start, end)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get device history")
+		return "", nil
+	}
+
+	var facts []string
+	for _, d := range devices {
+		facts = append(facts, device.SummarizeHistory(d, history[d.ID], start, end)...)
+	}
+
+	return h.llmService.AnswerStateQuery(ctx, message, facts), consulted
+}
+
+// findReadableDevices finds the devices a question asks about that principal may
+// read. If it asks only about devices principal can't read, it returns a refusal.
+func (h *Handler) findReadableDevices(ctx context.Context, principal, message string) ([]models.Device, string, error) {
+	readable, denied, err := h.deviceManager.FindReadableDevicesForQuery(ctx, principal, message)
+	if err != nil {
+		return nil, "", err
 	}
 
-	c.JSON(http.StatusOK, chatResponse)
+	if len(denied) > 0 {
+		denials := make([]*device.AccessDeniedError, 0, len(denied))
+		for _, d := range denied {
+			denials = append(denials, &device.AccessDeniedError{Principal: principal, DeviceID: d.ID, DeviceName: d.Name, Action: device.ReadAction})
+		}
+		return nil, device.ExplainRefusal(denials), nil
+	}
+	return readable, "", nil
 }
 
-// GetDevices returns all available devices
+// GetDevices returns the available devices. Query parameters filter (type, domain,
+// state, q, area, has_attribute), sort (sort=name or sort=-last_updated) and paginate
+// (limit, cursor) the list, and fields projects each device onto the named fields.
 func (h *Handler) GetDevices(c *gin.Context) {
-	devices, err := h.deviceManager.GetAllDevices()
+	opts := device.ListOptions{
+		Type:      models.DeviceType(c.Query("type")),
+		Domain:    c.Query("domain"),
+		State:     c.Query("state"),
+		Search:    c.Query("q"),
+		Area:      c.Query("area"),
+		Cursor:    c.Query("cursor"),
+		Principal: currentPrincipal(c),
+	}
+	opts.HasAttribute = splitListParam(c.QueryArray("has_attribute"))
+
+	if sortBy := c.Query("sort"); sortBy != "" {
+		opts.Descending = strings.HasPrefix(sortBy, "-")
+		opts.SortBy = strings.TrimPrefix(sortBy, "-")
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+			return
+		}
+		opts.Limit = limit
+	}
+
+	fields := splitListParam(c.QueryArray("fields"))
+	for _, field := range fields {
+		if !deviceFields[field] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown field: %s", field)})
+			return
+		}
+	}
+
+	page, err := h.deviceManager.ListDevices(c.Request.Context(), opts)
 	if err != nil {
+		if errors.Is(err, device.ErrInvalidListOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		logrus.WithError(err).Error("Failed to get devices")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get devices"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"devices": devices})
+	response := gin.H{"total": page.Total}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+
+	if len(fields) == 0 {
+		response["devices"] = page.Devices
+	} else {
+		projected := make([]map[string]any, 0, len(page.Devices))
+		for _, d := range page.Devices {
+			projected = append(projected, projectDevice(d, fields))
+		}
+		response["devices"] = projected
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// deviceFields are the device JSON fields that can be selected with ?fields=
+var deviceFields = map[string]bool{
+	"id": true, "name": true, "type": true, "state": true, "attributes": true, "last_updated": true,
+	"last_changed": true, "domain": true, "entity_id": true, "area": true,
+}
+
+// projectDevice keeps only the requested fields of a device, using its JSON names
+func projectDevice(d models.Device, fields []string) map[string]any {
+	projected := make(map[string]any, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projected[field] = d.ID
+		case "name":
+			projected[field] = d.Name
+		case "type":
+			projected[field] = d.Type
+		case "state":
+			projected[field] = d.State
+		case "attributes":
+			projected[field] = d.Attributes
+		case "last_updated":
+			projected[field] = d.LastUpdated
+		case "last_changed":
+			projected[field] = d.LastChanged
+		case "domain":
+			projected[field] = d.Domain
+		case "entity_id":
+			projected[field] = d.EntityID
+		case "area":
+			projected[field] = d.Area
+		}
+	}
+	return projected
+}
+
+// splitListParam flattens repeated and comma-separated query values
+func splitListParam(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
 }
 
 // GetDevice returns a specific device by ID
 func (h *Handler) GetDevice(c *gin.Context) {
 	deviceID := c.Param("id")
 
-	device, err := h.deviceManager.GetDevice(deviceID)
+	device, err := h.deviceManager.GetDevice(c.Request.Context(), deviceID)
 	if err != nil {
 		logrus.WithError(err).Errorf("Failed to get device: %s", deviceID)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
 		return
 	}
 
+	// Devices the caller can't read are hidden like unknown ones
+	if !h.deviceManager.CanRead(c.Request.Context(), currentPrincipal(c), deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
 	c.JSON(http.StatusOK, device)
 }
 
+// GetDeviceHistory returns a device's recorded states and logbook entries.
+// The optional start and end query parameters are RFC3339 timestamps; the
+// default range is the past 24 hours.
+func (h *Handler) GetDeviceHistory(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	end := time.Now()
+	if endStr := c.Query("end"); endStr != "" {
+		parsed, err := time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end time, expected RFC3339"})
+			return
+		}
+		end = parsed
+	}
+
+	start := end.Add(-24 * time.Hour)
+	if startStr := c.Query("start"); startStr != "" {
+		parsed, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start time, expected RFC3339"})
+			return
+		}
+		start = parsed
+	}
+
+	if !start.Before(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be before end"})
+		return
+	}
+
+	if _, err := h.deviceManager.GetDevice(c.Request.Context(), deviceID); err != nil {
+		logrus.WithError(err).Errorf("Failed to get device: %s", deviceID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if !h.deviceManager.CanRead(c.Request.Context(), currentPrincipal(c), deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	history, err := h.deviceManager.GetHistory(c.Request.Context(), []string{deviceID}, start, end)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to get history for device: %s", deviceID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get device history"})
+		return
+	}
+
+	logbook, err := h.deviceManager.GetLogbook(c.Request.Context(), deviceID, start, end)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to get logbook for device: %s", deviceID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get device logbook"})
+		return
+	}
+
+	states := history[deviceID]
+	if states == nil {
+		states = []models.StateChange{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"start":     start,
+		"end":       end,
+		"history":   states,
+		"logbook":   logbook,
+	})
+}
+
 // ControlDevice executes an action on a specific device
 func (h *Handler) ControlDevice(c *gin.Context) {
 	deviceID := c.Param("id")
@@ -147,7 +688,12 @@ func (h *Handler) ControlDevice(c *gin.Context) {
 		return
 	}
 
-	if err := h.deviceManager.ExecuteActionOnDevice(deviceID, action); err != nil {
+	if err := h.deviceManager.ExecuteActionAs(c.Request.Context(), currentPrincipal(c), deviceID, action); err != nil {
+		var denied *device.AccessDeniedError
+		if errors.As(err, &denied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": device.ExplainRefusal([]*device.AccessDeniedError{denied})})
+			return
+		}
 		logrus.WithError(err).Errorf("Failed to control device: %s", deviceID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to control device"})
 		return
@@ -156,6 +702,91 @@ func (h *Handler) ControlDevice(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
+// ExecuteActions validates and executes many device actions in one request and
+// reports a result for every target. Nothing is executed if any target is invalid.
+func (h *Handler) ExecuteActions(c *gin.Context) {
+	var req models.BulkActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one item is required"})
+		return
+	}
+	for i, item := range req.Items {
+		if len(item.Targets) == 0 || item.Action.Action == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("item %d requires targets and an action", i)})
+			return
+		}
+	}
+
+	results, executed := h.deviceManager.ExecuteBulkAs(c.Request.Context(), currentPrincipal(c), req.Items)
+	if !executed {
+		status := http.StatusUnprocessableEntity
+		for _, result := range results {
+			if result.Status == models.ActionStatusForbidden {
+				status = http.StatusForbidden
+				break
+			}
+		}
+		c.JSON(status, gin.H{"executed": false, "results": results})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"executed": true, "results": results})
+}
+
+// ListConversations returns conversation summaries, most recently active first.
+// q searches message content, since and until (RFC3339) filter by the time of the
+// last message, and limit and cursor paginate.
+func (h *Handler) ListConversations(c *gin.Context) {
+	opts := conversation.ListOptions{
+		UserID: currentUserID(c),
+		Search: c.Query("q"),
+		Cursor: c.Query("cursor"),
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s time, expected RFC3339", param)})
+				return
+			}
+			*dest = parsed
+		}
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		opts.Limit = limit
+	}
+
+	page, err := h.conversationManager.ListConversations(opts)
+	if err != nil {
+		if errors.Is(err, conversation.ErrInvalidListOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.WithError(err).Error("Failed to list conversations")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list conversations"})
+		return
+	}
+
+	response := gin.H{"conversations": page.Conversations, "total": page.Total}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // GetConversation returns a specific conversation
 func (h *Handler) GetConversation(c *gin.Context) {
 	conversationIDStr := c.Param("id")
@@ -165,7 +796,7 @@ func (h *Handler) GetConversation(c *gin.Context) {
 		return
 	}
 
-	conv, err := h.conversationManager.GetConversation(conversationID)
+	conv, err := h.getOwnConversation(c, conversationID)
 	if err != nil {
 		logrus.WithError(err).Errorf("Failed to get conversation: %s", conversationID)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
@@ -175,6 +806,120 @@ func (h *Handler) GetConversation(c *gin.Context) {
 	c.JSON(http.StatusOK, conv)
 }
 
+// ExportConversation downloads one conversation as JSON (the default) or, with
+// format=markdown, as a readable transcript
+func (h *Handler) ExportConversation(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	if _, err := h.getOwnConversation(c, conversationID); err != nil {
+		logrus.WithError(err).Errorf("Failed to export conversation: %s", conversationID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	convs, err := h.conversationManager.ExportConversations(conversationID)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to export conversation: %s", conversationID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	h.writeExport(c, "conversation-"+conversationID.String(), convs)
+}
+
+// ExportConversations downloads every conversation the caller may see as JSON or Markdown
+func (h *Handler) ExportConversations(c *gin.Context) {
+	convs, err := h.conversationManager.ExportConversations()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to export conversations")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export conversations"})
+		return
+	}
+
+	if h.users != nil {
+		userID := currentUserID(c)
+		own := make([]*models.Conversation, 0, len(convs))
+		for _, conv := range convs {
+			if conv.UserID == userID {
+				own = append(own, conv)
+			}
+		}
+		convs = own
+	}
+
+	h.writeExport(c, "conversations-"+time.Now().Format("20060102-150405"), convs)
+}
+
+func (h *Handler) writeExport(c *gin.Context, filename string, convs []*models.Conversation) {
+	var buf bytes.Buffer
+	var contentType string
+
+	switch format := c.DefaultQuery("format", "json"); format {
+	case "json":
+		contentType = "application/json; charset=utf-8"
+		filename += ".json"
+		if err := conversation.WriteJSON(&buf, convs); err != nil {
+			logrus.WithError(err).Error("Failed to write JSON export")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export conversations"})
+			return
+		}
+	case "markdown", "md":
+		contentType = "text/markdown; charset=utf-8"
+		filename += ".md"
+		if err := conversation.WriteMarkdown(&buf, convs); err != nil {
+			logrus.WithError(err).Error("Failed to write Markdown export")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export conversations"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported format: %s", format)})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// ImportConversations adds conversations from a JSON export. on_conflict decides
+// what happens to conversations that already exist: error (the default, nothing
+// is imported), skip, replace, or new to import them under new IDs. Signed-in
+// users import conversations as their own.
+func (h *Handler) ImportConversations(c *gin.Context) {
+	mode, err := conversation.ParseConflictMode(c.Query("on_conflict"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	convs, err := conversation.ReadJSON(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.conversationManager.ImportConversationsForUser(convs, mode, currentUserID(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, conversation.ErrConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": result.Conflicts})
+		case errors.Is(err, conversation.ErrInvalidImport):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			logrus.WithError(err).Error("Failed to import conversations")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import conversations"})
+		}
+		return
+	}
+
+	logrus.Infof("Imported conversations: %d created, %d replaced, %d skipped, %d renamed",
+		len(result.Created), len(result.Replaced), len(result.Skipped), len(result.Renamed))
+	c.JSON(http.StatusOK, result)
+}
+
 // DeleteConversation deletes a specific conversation
 func (h *Handler) DeleteConversation(c *gin.Context) {
 	conversationIDStr := c.Param("id")
@@ -184,6 +929,13 @@ func (h *Handler) DeleteConversation(c *gin.Context) {
 		return
 	}
 
+	// Other users' conversations fail the same way as missing ones
+	if _, err := h.getOwnConversation(c, conversationID); err != nil {
+		logrus.WithError(err).Errorf("Failed to delete conversation: %s", conversationID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete conversation"})
+		return
+	}
+
 	if err := h.conversationManager.DeleteConversation(conversationID); err != nil {
 		logrus.WithError(err).Errorf("Failed to delete conversation: %s", conversationID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete conversation"})
@@ -208,10 +960,12 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 			LLM: models.ServiceStatus{
 				Status:      h.getLLMStatus(),
 				LastChecked: time.Now(),
+				Message:     describeQueue(h.llmService.QueueStats()),
+				Breaker:     breakerState(h.llmService.UpstreamStats()),
 			},
 			HomeAssistant: models.ServiceStatus{
-				Status:      h.getHAStatus(),
 				LastChecked: time.Now(),
+				Breaker:     breakerState(h.deviceManager.UpstreamStats()),
 			},
 			Database: models.ServiceStatus{
 				Status:      "healthy",
@@ -220,18 +974,99 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 		},
 	}
 
+	if instances := h.deviceManager.InstanceStatuses(c.Request.Context()); instances != nil {
+		health.Services.HomeAssistantInstances = instanceStatuses(instances)
+		health.Services.HomeAssistant.Status = combinedStatus(health.Services.HomeAssistantInstances)
+	} else {
+		health.Services.HomeAssistant.Status = h.getHAStatus(c.Request.Context())
+	}
+
 	c.JSON(http.StatusOK, health)
 }
 
-func (h *Handler) getLLMStatus() string {
-	if h.llmService.IsLoaded() {
+// instanceStatuses reports each Home Assistant instance for the health check
+func instanceStatuses(instances []device.InstanceStatus) []models.ServiceStatus {
+	statuses := make([]models.ServiceStatus, 0, len(instances))
+	for _, instance := range instances {
+		status := models.ServiceStatus{
+			Name:        instance.Name,
+			Status:      "healthy",
+			LastChecked: time.Now(),
+			Breaker:     breakerState(instance.Upstreams),
+		}
+		if instance.Err != nil {
+			status.Status = "error"
+			status.Message = instance.Err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// combinedStatus is healthy when every instance is, degraded when only some
+// are and error when none are
+func combinedStatus(statuses []models.ServiceStatus) string {
+	healthy := 0
+	for _, status := range statuses {
+		if status.Status == "healthy" {
+			healthy++
+		}
+	}
+	switch healthy {
+	case len(statuses):
 		return "healthy"
+	case 0:
+		return "error"
+	default:
+		return "degraded"
 	}
-	return "error"
 }
 
-func (h *Handler) getHAStatus() string {
-	if h.deviceManager.IsConnected() {
+// describeQueue summarizes how busy the model is for the health check
+func describeQueue(stats llm.QueueStats) string {
+	return fmt.Sprintf("%d of %d requests in flight, %d of %d queued", stats.InFlight, stats.MaxInFlight, stats.Waiting, stats.QueueLength)
+}
+
+// breakerState is the worst state among a service's circuit breakers, or empty
+// if it has none
+func breakerState(stats []resilience.UpstreamStats) string {
+	state := ""
+	for _, upstream := range stats {
+		switch {
+		case upstream.State == resilience.BreakerOpen:
+			return string(resilience.BreakerOpen)
+		case upstream.State == resilience.BreakerHalfOpen || state == "":
+			state = string(upstream.State)
+		}
+	}
+	return state
+}
+
+// Metrics reports the model queue and the circuit breakers in front of
+// HomeAssistant and Ollama
+func (h *Handler) Metrics(c *gin.Context) {
+	upstreams := append(h.deviceManager.UpstreamStats(), h.llmService.UpstreamStats()...)
+	c.JSON(http.StatusOK, gin.H{
+		"uptime_seconds": int64(time.Since(h.startTime).Seconds()),
+		"llm_queue":      h.llmService.QueueStats(),
+		"upstreams":      upstreams,
+	})
+}
+
+// getLLMStatus is degraded while Ollama's breaker is open, since chat falls
+// back to simple command parsing until it closes
+func (h *Handler) getLLMStatus() string {
+	if !h.llmService.IsLoaded() {
+		return "error"
+	}
+	if breakerState(h.llmService.UpstreamStats()) == string(resilience.BreakerOpen) {
+		return "degraded"
+	}
+	return "healthy"
+}
+
+func (h *Handler) getHAStatus(ctx context.Context) string {
+	if h.deviceManager.IsConnected(ctx) {
 		return "healthy"
 	}
 	return "error"
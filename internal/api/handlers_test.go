@@ -2,10 +2,14 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -15,33 +19,47 @@ import (
 	"github.com/tienpdinh/gpt-home/internal/device"
 	"github.com/tienpdinh/gpt-home/internal/llm"
 	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
+	"github.com/tienpdinh/gpt-home/test/mocks"
 )
 
 // Simple mock HomeAssistant client for testing
 type mockHAClient struct{}
 
-func (m *mockHAClient) GetEntities() ([]models.Device, error) {
+func (m *mockHAClient) GetEntities(ctx context.Context) ([]models.Device, error) {
 	return []models.Device{
-		{ID: "light.1", Name: "Test Light", Type: models.DeviceTypeLight},
+		{ID: "light.1", Name: "Test Light", Type: models.DeviceTypeLight, State: "on"},
 		{ID: "switch.1", Name: "Test Switch", Type: models.DeviceTypeSwitch},
 	}, nil
 }
 
-func (m *mockHAClient) GetEntity(entityID string) (*models.Device, error) {
+func (m *mockHAClient) GetEntity(ctx context.Context, entityID string) (*models.Device, error) {
 	if entityID == "light.1" {
 		return &models.Device{ID: "light.1", Name: "Test Light", Type: models.DeviceTypeLight}, nil
 	}
 	return nil, assert.AnError
 }
 
-func (m *mockHAClient) CallService(domain, service, entityID string, serviceData map[string]interface{}) error {
+func (m *mockHAClient) CallService(ctx context.Context, domain, service, entityID string, serviceData map[string]interface{}) error {
 	return nil
 }
 
-func (m *mockHAClient) TestConnection() error {
+func (m *mockHAClient) CallServiceForEntities(ctx context.Context, domain, service string, entityIDs []string, serviceData map[string]interface{}) error {
 	return nil
 }
 
+func (m *mockHAClient) TestConnection(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockHAClient) GetHistory(ctx context.Context, entityIDs []string, start, end time.Time) (map[string][]models.StateChange, error) {
+	return map[string][]models.StateChange{}, nil
+}
+
+func (m *mockHAClient) GetLogbook(ctx context.Context, entityID string, start, end time.Time) ([]models.LogbookEntry, error) {
+	return []models.LogbookEntry{}, nil
+}
+
 func setupTestHandler() *Handler {
 	haClient := &mockHAClient{}
 	deviceManager := device.NewManager(haClient)
@@ -56,12 +74,25 @@ func setupTestRouter(handler *Handler) *gin.Engine {
 	router := gin.New()
 
 	router.POST("/chat", handler.HandleChat)
+	router.POST("/chat/audio", handler.HandleAudioChat)
+	router.POST("/conversation/process", handler.ProcessConversation)
+	router.GET("/messages/:id/audio", handler.GetMessageAudio)
+	router.GET("/v1/models", handler.ListModels)
+	router.POST("/v1/chat/completions", handler.ChatCompletions)
 	router.GET("/devices", handler.GetDevices)
 	router.GET("/devices/:id", handler.GetDevice)
+	router.GET("/devices/:id/history", handler.GetDeviceHistory)
 	router.POST("/devices/:id/control", handler.ControlDevice)
+	router.POST("/actions", handler.ExecuteActions)
+	router.GET("/conversations", handler.ListConversations)
+	router.GET("/conversations/export", handler.ExportConversations)
+	router.POST("/conversations/import", handler.ImportConversations)
 	router.GET("/conversations/:id", handler.GetConversation)
+	router.GET("/conversations/:id/export", handler.ExportConversation)
 	router.DELETE("/conversations/:id", handler.DeleteConversation)
 	router.GET("/health", handler.HealthCheck)
+	router.GET("/metrics", handler.Metrics)
+	router.GET("/ws", handler.HandleWebSocket)
 
 	return router
 }
@@ -89,6 +120,134 @@ func TestHandleChat_InvalidJSON(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func TestHandleChat_StateQuery(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	body, _ := json.Marshal(models.ChatRequest{Message: "Is the test light on?"})
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ChatResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	// Answered from the device's real state, not a canned reply
+	assert.Contains(t, response.Response, "Test Light (light.1) is on")
+	assert.Equal(t, []string{"light.1"}, response.Metadata.DevicesReferenced)
+	assert.Empty(t, response.ActionsPerformed)
+}
+
+// newFakeOllama returns a connected LLM service whose model answers chat
+// prompts with replies, in order
+func newFakeOllama(t *testing.T, replies ...string) *llm.Service {
+	t.Helper()
+
+	service := llm.NewService(newFakeOllamaServer(t, replies...).URL, "test")
+	require.NoError(t, service.LoadModel())
+	return service
+}
+
+// newFakeOllamaServer serves replies like newFakeOllama, for services that need
+// their own configuration
+func newFakeOllamaServer(t *testing.T, replies ...string) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[]}`))
+		case "/api/generate":
+			var req struct {
+				Prompt string `json:"prompt"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+
+			reply := "Hi"
+			mu.Lock()
+			if strings.Contains(req.Prompt, "Human:") && len(replies) > 0 {
+				reply, replies = replies[0], replies[1:]
+			}
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]any{"response": reply, "done": true})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandleChat_FollowUpCommands(t *testing.T) {
+	llmService := newFakeOllama(t,
+		`{"response": "The test light is on.", "actions": [{"action": "turn_on", "targets": ["light.1"]}]}`,
+		`{"response": "Turned it off.", "actions": [{"action": "turn_off"}]}`,
+		`{"response": "Done.", "actions": []}`,
+	)
+	handler := NewHandler(device.NewManager(&mockHAClient{}), llmService, conversation.NewManager())
+	router := setupTestRouter(handler)
+
+	chat := func(message string, conversationID uuid.UUID) models.ChatResponse {
+		body, _ := json.Marshal(models.ChatRequest{Message: message, ConversationID: conversationID})
+		w := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, request)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response models.ChatResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	first := chat("Switch on the test light", uuid.Nil)
+	assert.Equal(t, []string{"turn_on light.1"}, first.Metadata.ActionsPerformed)
+	assert.Equal(t, []string{"light.1"}, first.Metadata.DevicesReferenced)
+	assert.Equal(t, []string{"light.1"}, first.Context.ReferencedDevices)
+	require.NotNil(t, first.Context.LastAction)
+	assert.Equal(t, "turn_on", first.Context.LastAction.Action)
+	require.Len(t, first.ActionsPerformed, 1)
+
+	// "it" is the light from the previous turn
+	second := chat("Now turn it off", first.ConversationID)
+	assert.Equal(t, []string{"turn_off light.1"}, second.Metadata.ActionsPerformed)
+	assert.Equal(t, "turn_off", second.Context.LastAction.Action)
+
+	// "the same" repeats the last action on the newly named device
+	third := chat("Do the same for the test switch", first.ConversationID)
+	assert.Equal(t, []string{"turn_off switch.1"}, third.Metadata.ActionsPerformed)
+	assert.Equal(t, []string{"switch.1"}, third.Context.ReferencedDevices)
+
+	// The stored conversation matches what was returned
+	conv, err := handler.conversationManager.GetConversation(first.ConversationID)
+	require.NoError(t, err)
+	assert.Equal(t, third.Context.ReferencedDevices, conv.Context.ReferencedDevices)
+	assert.Equal(t, []string{"turn_off switch.1"}, conv.Messages[len(conv.Messages)-1].Metadata.ActionsPerformed)
+}
+
+func TestHandleChat_UntargetedActionChangesNothing(t *testing.T) {
+	llmService := newFakeOllama(t, `{"response": "Turned off the lights.", "actions": [{"action": "turn_off"}]}`)
+	haClient := mocks.NewMockHomeAssistantClient()
+	router := setupTestRouter(NewHandler(device.NewManager(haClient), llmService, conversation.NewManager()))
+
+	body, _ := json.Marshal(models.ChatRequest{Message: "Turn off the lights"})
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// No light was named, so none is switched off
+	var response models.ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Empty(t, response.Metadata.ActionsPerformed)
+	assert.Empty(t, haClient.ServiceCalls())
+}
+
 func TestGetDevices_Success(t *testing.T) {
 	handler := setupTestHandler()
 	router := setupTestRouter(handler)
@@ -100,12 +259,84 @@ func TestGetDevices_Success(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var response map[string][]models.Device
+	var response struct {
+		Devices []models.Device `json:"devices"`
+		Total   int             `json:"total"`
+	}
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	require.NoError(t, err)
 
-	assert.Len(t, response["devices"], 2)
-	assert.Equal(t, "light.1", response["devices"][0].ID)
+	assert.Len(t, response.Devices, 2)
+	assert.Equal(t, 2, response.Total)
+	assert.Equal(t, "light.1", response.Devices[0].ID)
+}
+
+func TestGetDevices_FilterAndProjection(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/devices?type=switch&fields=id,name", nil)
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Devices []map[string]any `json:"devices"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	require.Len(t, response.Devices, 1)
+	assert.Equal(t, map[string]any{"id": "switch.1", "name": "Test Switch"}, response.Devices[0])
+}
+
+func TestGetDevices_Pagination(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/devices?sort=-id&limit=1", nil)
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Devices    []models.Device `json:"devices"`
+		Total      int             `json:"total"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	require.Len(t, response.Devices, 1)
+	assert.Equal(t, "switch.1", response.Devices[0].ID)
+	assert.Equal(t, 2, response.Total)
+	require.NotEmpty(t, response.NextCursor)
+
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/devices?sort=-id&limit=1&cursor="+response.NextCursor, nil)
+	router.ServeHTTP(w, request)
+
+	response.NextCursor = ""
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	require.Len(t, response.Devices, 1)
+	assert.Equal(t, "light.1", response.Devices[0].ID)
+	assert.Empty(t, response.NextCursor)
+}
+
+func TestGetDevices_InvalidParameters(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	for _, query := range []string{"sort=color", "limit=abc", "limit=5000", "cursor=not-a-cursor", "fields=secret"} {
+		t.Run(query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/devices?"+query, nil)
+			router.ServeHTTP(w, request)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
 }
 
 func TestGetDevice_Success(t *testing.T) {
@@ -139,6 +370,68 @@ func TestGetDevice_NotFound(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
+func TestGetDeviceHistory(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/devices/light.1/history?start=2024-01-01T00:00:00Z&end=2024-01-02T00:00:00Z", nil)
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		DeviceID string                `json:"device_id"`
+		Start    time.Time             `json:"start"`
+		History  []models.StateChange  `json:"history"`
+		Logbook  []models.LogbookEntry `json:"logbook"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.Equal(t, "light.1", response.DeviceID)
+	assert.Equal(t, 2024, response.Start.Year())
+	assert.NotNil(t, response.History)
+	assert.NotNil(t, response.Logbook)
+
+	// Invalid time range
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/devices/light.1/history?start=yesterday", nil)
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/devices/light.1/history?start=2024-01-02T00:00:00Z&end=2024-01-01T00:00:00Z", nil)
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Unknown device
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/devices/nonexistent/history", nil)
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleChat_HistoryQuery(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	body, _ := json.Marshal(models.ChatRequest{Message: "When did the test light last change?"})
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ChatResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	assert.Contains(t, response.Response, "Test Light (light.1) has no recorded history")
+	assert.Equal(t, []string{"light.1"}, response.Metadata.DevicesReferenced)
+}
+
 func TestControlDevice_Success(t *testing.T) {
 	handler := setupTestHandler()
 	router := setupTestRouter(handler)
@@ -179,6 +472,227 @@ func TestControlDevice_InvalidJSON(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func TestExecuteActions(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	req := models.BulkActionRequest{
+		Items: []models.BulkActionItem{
+			{Targets: []string{"light.1"}, Action: models.DeviceAction{Action: "turn_on"}},
+		},
+	}
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/actions", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Executed bool                  `json:"executed"`
+		Results  []models.TargetResult `json:"results"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.True(t, response.Executed)
+	require.Len(t, response.Results, 1)
+	assert.Equal(t, models.ActionStatusSuccess, response.Results[0].Status)
+
+	// An unknown target fails validation and nothing runs
+	req.Items = append(req.Items, models.BulkActionItem{Targets: []string{"nonexistent"}, Action: models.DeviceAction{Action: "turn_on"}})
+	body, _ = json.Marshal(req)
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "/actions", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.False(t, response.Executed)
+	assert.Equal(t, models.ActionStatusSkipped, response.Results[0].Status)
+	assert.Equal(t, models.ActionStatusValidationError, response.Results[1].Status)
+
+	// Items without targets are rejected outright
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "/actions", bytes.NewBuffer([]byte(`{"items":[{"targets":[],"action":{"action":"turn_on"}}]}`)))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListConversations(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	// Chat through the API so conversations look like real ones
+	for _, message := range []string{"Is the test light on?", "Is the test switch on?"} {
+		body, _ := json.Marshal(models.ChatRequest{Message: message})
+		w := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, request)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/conversations?q=light&since=2000-01-01T00:00:00Z", nil)
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Conversations []models.ConversationSummary `json:"conversations"`
+		Total         int                          `json:"total"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	require.Len(t, response.Conversations, 1)
+	assert.Equal(t, 1, response.Total)
+	summary := response.Conversations[0]
+	assert.Equal(t, "Is the test light on?", summary.Title)
+	assert.Equal(t, 2, summary.MessageCount)
+	assert.Equal(t, []string{"light.1"}, summary.ReferencedDevices)
+	assert.False(t, summary.LastMessageAt.IsZero())
+}
+
+func TestListConversations_InvalidParameters(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	for _, query := range []string{"since=yesterday", "until=2024-13-01", "limit=0", "limit=9999", "cursor=bogus"} {
+		t.Run(query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/conversations?"+query, nil)
+			router.ServeHTTP(w, request)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestExportConversation(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	body, _ := json.Marshal(models.ChatRequest{Message: "Is the test light on?"})
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var chat models.ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &chat))
+
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/conversations/"+chat.ConversationID.String()+"/export", nil)
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "conversation-"+chat.ConversationID.String()+".json")
+
+	var export conversation.Export
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+	assert.Equal(t, conversation.ExportVersion, export.Version)
+	require.Len(t, export.Conversations, 1)
+	assert.Len(t, export.Conversations[0].Messages, 2)
+
+	w = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/conversations/"+chat.ConversationID.String()+"/export?format=markdown", nil)
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/markdown")
+	assert.Contains(t, w.Body.String(), "# Is the test light on?")
+	assert.Contains(t, w.Body.String(), "## Luna · ")
+}
+
+func TestExportConversations_Errors(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/conversations/export?format=pdf", http.StatusBadRequest},
+		{"/conversations/invalid-uuid/export", http.StatusBadRequest},
+		{"/conversations/" + uuid.New().String() + "/export", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", tt.path, nil)
+			router.ServeHTTP(w, request)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestImportConversations(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	conv := &models.Conversation{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		Messages:  []models.Message{{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Turn on the test light", Timestamp: time.Now()}},
+	}
+	var body bytes.Buffer
+	require.NoError(t, conversation.WriteJSON(&body, []*models.Conversation{conv}))
+	export := body.Bytes()
+
+	importExport := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/conversations/import"+query, bytes.NewReader(export))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	w := importExport("")
+	require.Equal(t, http.StatusOK, w.Code)
+	var result conversation.ImportResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, []uuid.UUID{conv.ID}, result.Created)
+
+	w = httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/conversations/"+conv.ID.String(), nil)
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Importing again conflicts unless told what to do
+	w = importExport("")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var conflict struct {
+		Conflicts []uuid.UUID `json:"conflicts"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &conflict))
+	assert.Equal(t, []uuid.UUID{conv.ID}, conflict.Conflicts)
+
+	w = importExport("?on_conflict=new")
+	require.Equal(t, http.StatusOK, w.Code)
+	result = conversation.ImportResult{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Contains(t, result.Renamed, conv.ID.String())
+
+	assert.Equal(t, http.StatusBadRequest, importExport("?on_conflict=merge").Code)
+}
+
+func TestImportConversations_InvalidBody(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/conversations/import", bytes.NewBufferString("# Not JSON"))
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func TestGetConversation_InvalidID(t *testing.T) {
 	handler := setupTestHandler()
 	router := setupTestRouter(handler)
@@ -253,6 +767,68 @@ func TestHealthCheck(t *testing.T) {
 	assert.Equal(t, "healthy", response.Services.Database.Status)
 	assert.NotEmpty(t, response.Uptime)
 	assert.NotEmpty(t, response.MemoryUsage)
+	assert.Equal(t, "closed", response.Services.LLM.Breaker)
+	assert.Empty(t, response.Services.HomeAssistant.Breaker) // the mock client has no breaker
+}
+
+func TestHealthCheck_Instances(t *testing.T) {
+	cabin := mocks.NewMockHomeAssistantClient()
+	cabin.SetConnectionError(true)
+	deviceManager, err := device.NewManagerWithInstances([]device.Instance{
+		{Name: "home", Client: &mockHAClient{}},
+		{Name: "cabin", Client: cabin},
+	}, device.ManagerOptions{})
+	require.NoError(t, err)
+	handler := NewHandler(deviceManager, llm.NewService("/tmp/test", "test"), conversation.NewManager())
+	router := setupTestRouter(handler)
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/health", nil)
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.HealthStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "degraded", response.Services.HomeAssistant.Status)
+	require.Len(t, response.Services.HomeAssistantInstances, 2)
+	assert.Equal(t, "home", response.Services.HomeAssistantInstances[0].Name)
+	assert.Equal(t, "healthy", response.Services.HomeAssistantInstances[0].Status)
+	assert.Equal(t, "cabin", response.Services.HomeAssistantInstances[1].Name)
+	assert.Equal(t, "error", response.Services.HomeAssistantInstances[1].Status)
+	assert.NotEmpty(t, response.Services.HomeAssistantInstances[1].Message)
+}
+
+func TestMetrics(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		LLMQueue  llm.QueueStats             `json:"llm_queue"`
+		Upstreams []resilience.UpstreamStats `json:"upstreams"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	assert.Equal(t, 2, response.LLMQueue.MaxInFlight)
+	require.Len(t, response.Upstreams, 1)
+	assert.Equal(t, "ollama", response.Upstreams[0].Name)
+	assert.Equal(t, resilience.BreakerClosed, response.Upstreams[0].State)
+}
+
+func TestBreakerState(t *testing.T) {
+	closed := resilience.UpstreamStats{State: resilience.BreakerClosed}
+	halfOpen := resilience.UpstreamStats{State: resilience.BreakerHalfOpen}
+	open := resilience.UpstreamStats{State: resilience.BreakerOpen}
+
+	assert.Equal(t, "", breakerState(nil))
+	assert.Equal(t, "closed", breakerState([]resilience.UpstreamStats{closed, closed}))
+	assert.Equal(t, "half_open", breakerState([]resilience.UpstreamStats{closed, halfOpen, closed}))
+	assert.Equal(t, "open", breakerState([]resilience.UpstreamStats{halfOpen, open, closed}))
 }
 
 func TestHandler_RouteRegistration(t *testing.T) {
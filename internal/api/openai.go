@@ -0,0 +1,286 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// openAIModel is the model name Luna is offered under to OpenAI clients
+const openAIModel = "luna"
+
+// completionRequest is an OpenAI chat completions request. Sampling options
+// are accepted and ignored; Luna's own model settings apply.
+type completionRequest struct {
+	Model    string              `json:"model"`
+	Messages []completionMessage `json:"messages" binding:"required"`
+	Stream   bool                `json:"stream"`
+}
+
+type completionMessage struct {
+	Role    string         `json:"role"`
+	Content messageContent `json:"content"`
+}
+
+// messageContent is a message's text, sent either as a string or as a list of
+// content parts of which only the text parts are kept
+type messageContent string
+
+func (m *messageContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = messageContent(text)
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content must be a string or a list of content parts")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	*m = messageContent(strings.Join(texts, "\n"))
+	return nil
+}
+
+// completion is a chat completions response, or with Stream one chunk of it.
+// ActionsPerformed extends the OpenAI shape with the device actions Luna
+// carried out.
+type completion struct {
+	ID               string                `json:"id"`
+	Object           string                `json:"object"`
+	Created          int64                 `json:"created"`
+	Model            string                `json:"model"`
+	Choices          []completionChoice    `json:"choices"`
+	Usage            *completionUsage      `json:"usage,omitempty"`
+	ActionsPerformed []models.DeviceAction `json:"actions_performed,omitempty"`
+}
+
+type completionChoice struct {
+	Index        int              `json:"index"`
+	Message      *completionReply `json:"message,omitempty"`
+	Delta        *completionReply `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type completionReply struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type completionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ListModels lists Luna as the one model OpenAI clients can pick
+func (h *Handler) ListModels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data": []gin.H{{
+			"id":       openAIModel,
+			"object":   "model",
+			"created":  h.startTime.Unix(),
+			"owned_by": "gpt-home",
+		}},
+	})
+}
+
+// ChatCompletions answers OpenAI chat completions requests, so tools built for
+// OpenAI can talk to Luna. The client sends the whole conversation each time:
+// its messages become the history of a conversation that isn't stored or
+// summarized, the last one, from the user, is answered, and device actions
+// still run here.
+func (h *Handler) ChatCompletions(c *gin.Context) {
+	var req completionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	conv, message, err := completionConversation(req.Messages)
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if user := CurrentUser(c); user != nil {
+		conv.UserID = user.ID
+		conv.Context.UserPreferences = user.Preferences
+	}
+
+	id := "chatcmpl-" + uuid.NewString()
+	if req.Stream {
+		h.streamCompletion(c, id, conv, message)
+		return
+	}
+
+	response, chatErr := h.converse(c.Request.Context(), currentPrincipal(c), conv, message, false, nil)
+	if chatErr != nil {
+		respondCompletionError(c, chatErr)
+		return
+	}
+
+	stop := "stop"
+	result := newCompletion(id, "chat.completion")
+	result.Choices = []completionChoice{{
+		Message:      &completionReply{Role: string(models.MessageRoleAssistant), Content: response.Response},
+		FinishReason: &stop,
+	}}
+	result.ActionsPerformed = response.ActionsPerformed
+	if usage := response.Metadata.TokenUsage; usage != nil {
+		result.Usage = &completionUsage{
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.PromptTokens + usage.CompletionTokens,
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// streamCompletion answers as chat completion chunks. Luna's reply isn't
+// generated token by token, so it arrives in one chunk; while the request waits
+// for the model, SSE comments keep the connection alive. A request turned away
+// before anything was sent gets a plain error response.
+func (h *Handler) streamCompletion(c *gin.Context, id string, conv *models.Conversation, message string) {
+	streaming := false
+	start := func() {
+		if !streaming {
+			streaming = true
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Status(http.StatusOK)
+		}
+	}
+	onQueue := func(position int) {
+		start()
+		fmt.Fprintf(c.Writer, ": queued at position %d\n\n", position)
+		c.Writer.Flush()
+	}
+
+	response, chatErr := h.converse(c.Request.Context(), currentPrincipal(c), conv, message, false, onQueue)
+	if chatErr != nil {
+		if !streaming {
+			respondCompletionError(c, chatErr)
+			return
+		}
+		writeCompletionEvent(c, gin.H{"error": openAIError("server_error", chatErr.message)})
+		fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+		c.Writer.Flush()
+		return
+	}
+
+	start()
+	stop := "stop"
+	chunks := []completionChoice{
+		{Delta: &completionReply{Role: string(models.MessageRoleAssistant)}},
+		{Delta: &completionReply{Content: response.Response}},
+		{Delta: &completionReply{}, FinishReason: &stop},
+	}
+	for i, choice := range chunks {
+		chunk := newCompletion(id, "chat.completion.chunk")
+		chunk.Choices = []completionChoice{choice}
+		if i == len(chunks)-1 {
+			chunk.ActionsPerformed = response.ActionsPerformed
+		}
+		writeCompletionEvent(c, chunk)
+	}
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+func newCompletion(id, object string) completion {
+	return completion{
+		ID:      id,
+		Object:  object,
+		Created: time.Now().Unix(),
+		Model:   openAIModel,
+	}
+}
+
+func writeCompletionEvent(c *gin.Context, event any) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+	c.Writer.Flush()
+}
+
+// completionConversation turns an OpenAI messages array into a conversation
+// holding all but the last message, and the last message, which must be the
+// user's. System messages are dropped; Luna keeps her own instructions.
+func completionConversation(messages []completionMessage) (*models.Conversation, string, error) {
+	if len(messages) == 0 {
+		return nil, "", fmt.Errorf("messages must not be empty")
+	}
+	last := messages[len(messages)-1]
+	if last.Role != string(models.MessageRoleUser) || strings.TrimSpace(string(last.Content)) == "" {
+		return nil, "", fmt.Errorf("the last message must be a non-empty user message")
+	}
+
+	now := time.Now()
+	conv := &models.Conversation{
+		ID:        uuid.New(),
+		Messages:  []models.Message{},
+		CreatedAt: now,
+		UpdatedAt: now,
+		Context: models.Context{
+			ReferencedDevices: []string{},
+			UserPreferences:   map[string]string{},
+			SessionData:       make(map[string]any),
+		},
+	}
+	for _, message := range messages[:len(messages)-1] {
+		var role models.MessageRole
+		switch message.Role {
+		case "user":
+			role = models.MessageRoleUser
+		case "assistant":
+			role = models.MessageRoleAssistant
+		case "system", "developer", "tool":
+			continue
+		default:
+			return nil, "", fmt.Errorf("unknown message role: %s", message.Role)
+		}
+		conv.Messages = append(conv.Messages, models.Message{
+			ID:        uuid.New(),
+			Role:      role,
+			Content:   string(message.Content),
+			Timestamp: now,
+		})
+	}
+
+	return conv, string(last.Content), nil
+}
+
+func openAIError(errorType, message string) gin.H {
+	return gin.H{"message": message, "type": errorType}
+}
+
+func respondOpenAIError(c *gin.Context, status int, errorType, message string) {
+	c.JSON(status, gin.H{"error": openAIError(errorType, message)})
+}
+
+// respondCompletionError answers a failed chat with an OpenAI error
+func respondCompletionError(c *gin.Context, chatErr *chatError) {
+	switch chatErr.status {
+	case http.StatusTooManyRequests:
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(chatErr.retryAfter)))
+		respondOpenAIError(c, chatErr.status, "rate_limit_error", chatErr.message)
+	default:
+		respondOpenAIError(c, chatErr.status, "server_error", chatErr.message)
+	}
+}
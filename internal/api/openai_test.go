@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/llm"
+)
+
+func postCompletion(router *gin.Engine, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+	return w
+}
+
+func TestListModels(t *testing.T) {
+	router := setupTestRouter(setupTestHandler())
+
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/v1/models", nil)
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Object string `json:"object"`
+		Data   []struct {
+			ID     string `json:"id"`
+			Object string `json:"object"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "list", response.Object)
+	require.Len(t, response.Data, 1)
+	assert.Equal(t, "luna", response.Data[0].ID)
+	assert.Equal(t, "model", response.Data[0].Object)
+}
+
+func TestChatCompletions(t *testing.T) {
+	llmService := newFakeOllama(t,
+		`{"response": "Turned it off.", "actions": [{"action": "turn_off", "targets": ["light.1"]}]}`,
+	)
+	handler := NewHandler(device.NewManager(&mockHAClient{}), llmService, conversation.NewManager())
+	router := setupTestRouter(handler)
+
+	w := postCompletion(router, `{
+		"model": "luna",
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": "Switch on the test light"},
+			{"role": "assistant", "content": "The test light is on."},
+			{"role": "user", "content": [{"type": "text", "text": "Now turn it off"}]}
+		]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response completion
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "chat.completion", response.Object)
+	assert.Equal(t, "luna", response.Model)
+	require.Len(t, response.Choices, 1)
+	assert.Equal(t, "assistant", response.Choices[0].Message.Role)
+	assert.Equal(t, "Turned it off.", response.Choices[0].Message.Content)
+	assert.Equal(t, "stop", *response.Choices[0].FinishReason)
+	require.NotNil(t, response.Usage)
+	assert.Equal(t, response.Usage.PromptTokens+response.Usage.CompletionTokens, response.Usage.TotalTokens)
+	require.Len(t, response.ActionsPerformed, 1)
+	assert.Equal(t, "turn_off", response.ActionsPerformed[0].Action)
+
+	// The conversation belongs to the client and isn't stored
+	assert.Empty(t, handler.conversationManager.GetAllConversations())
+}
+
+func TestChatCompletions_LongHistoryNotSummarized(t *testing.T) {
+	var summaries atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/generate" {
+			body, _ := io.ReadAll(r.Body)
+			if bytes.Contains(body, []byte("running summary")) {
+				summaries.Add(1)
+			}
+			w.Write([]byte(`{"response": "{\"response\": \"Hello again.\", \"actions\": []}", "done": true}`))
+			return
+		}
+		w.Write([]byte(`{"models":[]}`))
+	}))
+	t.Cleanup(server.Close)
+	llmService := llm.NewService(server.URL, "test")
+	require.NoError(t, llmService.LoadModel())
+	router := setupTestRouter(NewHandler(device.NewManager(&mockHAClient{}), llmService, conversation.NewManager()))
+
+	// Well past the summary threshold, but the client sends it all every time
+	messages := make([]map[string]string, 0, 41)
+	for i := 0; i < 20; i++ {
+		messages = append(messages, map[string]string{"role": "user", "content": fmt.Sprintf("Message %d", i)})
+		messages = append(messages, map[string]string{"role": "assistant", "content": fmt.Sprintf("Reply %d", i)})
+	}
+	messages = append(messages, map[string]string{"role": "user", "content": "Hello"})
+	body, _ := json.Marshal(map[string]any{"model": "luna", "messages": messages})
+
+	w := postCompletion(router, string(body))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Zero(t, summaries.Load())
+}
+
+func TestChatCompletions_Stream(t *testing.T) {
+	llmService := newFakeOllama(t,
+		`{"response": "The test light is on.", "actions": [{"action": "turn_on", "targets": ["light.1"]}]}`,
+	)
+	handler := NewHandler(device.NewManager(&mockHAClient{}), llmService, conversation.NewManager())
+	router := setupTestRouter(handler)
+
+	w := postCompletion(router, `{"model": "luna", "stream": true, "messages": [{"role": "user", "content": "Switch on the test light"}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	var chunks []completion
+	var done bool
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data: ")
+		if !found {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk completion
+		require.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		chunks = append(chunks, chunk)
+	}
+	assert.True(t, done)
+
+	var content strings.Builder
+	for _, chunk := range chunks {
+		assert.Equal(t, "chat.completion.chunk", chunk.Object)
+		assert.Equal(t, chunks[0].ID, chunk.ID)
+		require.Len(t, chunk.Choices, 1)
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	assert.Equal(t, "The test light is on.", content.String())
+
+	last := chunks[len(chunks)-1]
+	assert.Equal(t, "stop", *last.Choices[0].FinishReason)
+	require.Len(t, last.ActionsPerformed, 1)
+	assert.Equal(t, "turn_on", last.ActionsPerformed[0].Action)
+}
+
+func TestChatCompletions_InvalidMessages(t *testing.T) {
+	router := setupTestRouter(setupTestHandler())
+
+	for name, body := range map[string]string{
+		"no messages":         `{"model": "luna", "messages": []}`,
+		"last from assistant": `{"messages": [{"role": "user", "content": "Hi"}, {"role": "assistant", "content": "Hello"}]}`,
+		"unknown role":        `{"messages": [{"role": "robot", "content": "Hi"}, {"role": "user", "content": "Hi"}]}`,
+		"bad content":         `{"messages": [{"role": "user", "content": 42}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := postCompletion(router, body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var response struct {
+				Error struct {
+					Type string `json:"type"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, "invalid_request_error", response.Error.Type)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxIdleBuckets is how many clients are tracked before full buckets are dropped
+const maxIdleBuckets = 1024
+
+// RateLimiter gives each client a token bucket: it holds up to burst requests
+// and refills at perMinute requests a minute
+type RateLimiter struct {
+	perMinute float64
+	burst     float64
+	buckets   map[string]*bucket
+	mutex     sync.Mutex
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests a minute per
+// client after an initial burst. A perMinute of 0 or less allows everything.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		perMinute: float64(perMinute),
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+}
+
+// Allow takes a request from the client's bucket. When it is empty it returns
+// false and how long until the next request would be allowed.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	b, exists := l.buckets[client]
+	if !exists {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.pruneLocked(now)
+		}
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Minutes()*l.perMinute)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.perMinute * float64(time.Minute))
+	return false, wait
+}
+
+// pruneLocked forgets clients whose buckets have refilled, since a new bucket
+// would be the same
+func (l *RateLimiter) pruneLocked(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Minutes()*l.perMinute >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// RateLimit turns away clients that send requests faster than limiter allows
+// with 429 and a Retry-After header. Signed-in users are limited per account,
+// everyone else per IP address.
+func RateLimit(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := "ip:" + c.ClientIP()
+		if id := currentUserID(c); id != uuid.Nil {
+			client = "user:" + id.String()
+		}
+
+		if allowed, wait := limiter.Allow(client); !allowed {
+			respondTooManyRequests(c, wait, "Too many messages, please slow down")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// respondTooManyRequests answers 429 with the whole seconds to wait in Retry-After
+func respondTooManyRequests(c *gin.Context, wait time.Duration, message string) {
+	seconds := retryAfterSeconds(wait)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/config"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/llm"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func TestRateLimiter_RefillsOverTime(t *testing.T) {
+	limiter := NewRateLimiter(60, 2)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := limiter.Allow("alice")
+		assert.True(t, allowed)
+	}
+	allowed, wait := limiter.Allow("alice")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	// Clients have separate buckets
+	allowed, _ = limiter.Allow("bob")
+	assert.True(t, allowed)
+
+	now = now.Add(time.Second)
+	allowed, _ = limiter.Allow("alice")
+	assert.True(t, allowed)
+
+	unlimited := NewRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		allowed, _ := unlimited.Allow("alice")
+		require.True(t, allowed)
+	}
+}
+
+func TestRateLimit_RespondsWithRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/chat", RateLimit(NewRateLimiter(1, 1)), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/chat", nil)
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, send().Code)
+
+	w := send()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, float64(60), body["retry_after"])
+}
+
+func TestHandleChat_QueuesWhenModelIsBusy(t *testing.T) {
+	cfg := config.LLMConfig{MaxConcurrent: 1, QueueLength: 1, QueueTimeout: 30, Timeout: 5}
+	llmService := llm.NewServiceWithConfig(newFakeOllamaServer(t).URL, "test", cfg)
+	require.NoError(t, llmService.LoadModel())
+	handler := NewHandler(device.NewManager(&mockHAClient{}), llmService, conversation.NewManager())
+	server := httptest.NewServer(setupTestRouter(handler))
+	defer server.Close()
+
+	// Someone else is using the model
+	release, err := llmService.Acquire(context.Background(), nil)
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(models.ChatRequest{Message: "hello"})
+	request, _ := http.NewRequest("POST", server.URL+"/chat", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "text/event-stream")
+	streamed, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer streamed.Body.Close()
+	assert.Equal(t, "text/event-stream", streamed.Header.Get("Content-Type"))
+
+	events := bufio.NewScanner(streamed.Body)
+	require.True(t, events.Scan())
+	assert.Equal(t, "event:queued", events.Text())
+	require.True(t, events.Scan())
+	assert.Equal(t, `data:{"position":1}`, events.Text())
+
+	// The queue is full, so the next client is turned away
+	rejected, err := http.Post(server.URL+"/chat", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	rejected.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, rejected.StatusCode)
+	assert.NotEmpty(t, rejected.Header.Get("Retry-After"))
+
+	release()
+	var lines []string
+	for events.Scan() {
+		lines = append(lines, events.Text())
+	}
+	stream := strings.Join(lines, "\n")
+	assert.Contains(t, stream, "event:response")
+	assert.Contains(t, stream, `"response":"Hi"`)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/tienpdinh/gpt-home/internal/speech"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// spokenCacheSize is how many replies' audio is kept for playing again
+const spokenCacheSize = 100
+
+// messageAudioURL is where a message's spoken audio is fetched from
+func messageAudioURL(messageID uuid.UUID) string {
+	return fmt.Sprintf("/api/v1/messages/%s/audio", messageID)
+}
+
+// speakReply adds the spoken reply to response. A failed synthesis doesn't
+// fail the chat: the reply comes back without audio, and its audio URL tries
+// again.
+func (h *Handler) speakReply(ctx context.Context, response *models.ChatResponse) {
+	spoken, err := h.messageSpeech(ctx, response.MessageID, response.Response)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to speak reply")
+		return
+	}
+	response.Audio = &models.ReplyAudio{
+		ContentType: spoken.ContentType,
+		Data:        spoken.Data,
+		URL:         messageAudioURL(response.MessageID),
+	}
+}
+
+// messageSpeech returns a message spoken aloud, from the cache when it has
+// been spoken before
+func (h *Handler) messageSpeech(ctx context.Context, messageID uuid.UUID, text string) (*speech.Speech, error) {
+	if spoken, ok := h.spoken.Get(messageID); ok {
+		return spoken, nil
+	}
+
+	spoken, err := h.synthesizer.Synthesize(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	h.spoken.Add(messageID, spoken)
+	return spoken, nil
+}
+
+// GetMessageAudio serves one of Luna's replies spoken aloud, synthesizing it
+// the first time it's asked for
+func (h *Handler) GetMessageAudio(c *gin.Context) {
+	if h.synthesizer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Spoken replies are not configured"})
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	conv, message, err := h.conversationManager.FindMessage(messageID)
+	if err != nil || (h.users != nil && conv.UserID != currentUserID(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if message.Role != models.MessageRoleAssistant {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only Luna's replies can be spoken"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	spoken, err := h.messageSpeech(ctx, message.ID, message.Content)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request cancelled while synthesizing"})
+			return
+		}
+		logrus.WithError(err).Errorf("Failed to speak message %s", messageID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Speech synthesis failed"})
+		return
+	}
+
+	c.Data(http.StatusOK, spoken.ContentType, spoken.Data)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/conversation"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/speech"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+type fakeSynthesizer struct {
+	err    error
+	spoken []string
+}
+
+func (f *fakeSynthesizer) Synthesize(ctx context.Context, text string) (*speech.Speech, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.spoken = append(f.spoken, text)
+	return &speech.Speech{Data: []byte("wav:" + text), ContentType: "audio/wav"}, nil
+}
+
+func postChat(t *testing.T, router http.Handler, req models.ChatRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+	return w
+}
+
+func TestSpokenReplies(t *testing.T) {
+	synthesizer := &fakeSynthesizer{}
+	handler := NewHandlerWithOptions(device.NewManager(&mockHAClient{}), newFakeOllama(t, `{"response": "Good morning.", "actions": []}`), conversation.NewManager(), HandlerOptions{Synthesizer: synthesizer})
+	router := setupTestRouter(handler)
+
+	w := postChat(t, router, models.ChatRequest{Message: "Hello", Speak: true})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response models.ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NotNil(t, response.Audio)
+	assert.Equal(t, "audio/wav", response.Audio.ContentType)
+	assert.Equal(t, []byte("wav:Good morning."), response.Audio.Data)
+	assert.Equal(t, "/api/v1/messages/"+response.MessageID.String()+"/audio", response.Audio.URL)
+
+	// Fetching it later comes from the cache
+	w = httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/messages/"+response.MessageID.String()+"/audio", nil)
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "audio/wav", w.Header().Get("Content-Type"))
+	assert.Equal(t, "wav:Good morning.", w.Body.String())
+	assert.Equal(t, []string{"Good morning."}, synthesizer.spoken)
+}
+
+func TestGetMessageAudio(t *testing.T) {
+	conversationManager := conversation.NewManager()
+	conv := conversationManager.CreateConversation()
+	question := models.Message{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Is the door locked?"}
+	answer := models.Message{ID: uuid.New(), Role: models.MessageRoleAssistant, Content: "Yes, it is."}
+	require.NoError(t, conversationManager.AddMessage(conv.ID, question))
+	require.NoError(t, conversationManager.AddMessage(conv.ID, answer))
+
+	newRouter := func(synthesizer speech.Synthesizer) http.Handler {
+		return setupTestRouter(NewHandlerWithOptions(device.NewManager(&mockHAClient{}), newFakeOllama(t), conversationManager, HandlerOptions{Synthesizer: synthesizer}))
+	}
+	get := func(router http.Handler, id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/messages/"+id+"/audio", nil)
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	synthesizer := &fakeSynthesizer{}
+	router := newRouter(synthesizer)
+	w := get(router, answer.ID.String())
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "wav:Yes, it is.", w.Body.String())
+	get(router, answer.ID.String())
+	assert.Len(t, synthesizer.spoken, 1, "audio should be synthesized once")
+
+	assert.Equal(t, http.StatusBadRequest, get(router, question.ID.String()).Code)
+	assert.Equal(t, http.StatusBadRequest, get(router, "nope").Code)
+	assert.Equal(t, http.StatusNotFound, get(router, uuid.NewString()).Code)
+	assert.Equal(t, http.StatusBadGateway, get(newRouter(&fakeSynthesizer{err: errors.New("boom")}), answer.ID.String()).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, get(newRouter(nil), answer.ID.String()).Code)
+}
+
+func TestSpokenReplies_Errors(t *testing.T) {
+	// Asking for speech without a TTS server is turned away before chatting
+	handler := NewHandler(device.NewManager(&mockHAClient{}), newFakeOllama(t), conversation.NewManager())
+	w := postChat(t, setupTestRouter(handler), models.ChatRequest{Message: "Hello", Speak: true})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// A failed synthesis still answers, without audio
+	handler = NewHandlerWithOptions(device.NewManager(&mockHAClient{}), newFakeOllama(t), conversation.NewManager(), HandlerOptions{Synthesizer: &fakeSynthesizer{err: errors.New("boom")}})
+	w = postChat(t, setupTestRouter(handler), models.ChatRequest{Message: "Hello", Speak: true})
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Hi", response.Response)
+	assert.Nil(t, response.Audio)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/internal/events"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// wsWriteWait is how long a single write to the client may take
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long the client may stay silent before it is dropped
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod must be shorter than wsPongWait
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsEventBuffer is how many events may queue for a client before it is dropped
+	wsEventBuffer = 64
+	// wsMaxMessageSize limits client messages, which are only subscription requests
+	wsMaxMessageSize = 4096
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsClientMessage is sent by clients to manage their subscriptions
+type wsClientMessage struct {
+	Type   string   `json:"type"` // subscribe, unsubscribe or ping
+	Topics []string `json:"topics,omitempty"`
+}
+
+// wsServerMessage is sent to clients: event notifications and replies to their requests
+type wsServerMessage struct {
+	Type      string     `json:"type"` // event, subscribed, unsubscribed, pong or error
+	Topic     string     `json:"topic,omitempty"`
+	Data      any        `json:"data,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Topics    []string   `json:"topics,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// HandleWebSocket upgrades the request to a WebSocket that streams events for the
+// topics the client subscribes to. Topics can also be given up front with
+// ?topics=device_state,action_result.
+func (h *Handler) HandleWebSocket(c *gin.Context) {
+	initial := splitListParam(c.QueryArray("topics"))
+	if err := validateTopics(initial); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := currentUserID(c)
+	principal := currentPrincipal(c)
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written an error response
+		logrus.WithError(err).Warn("Failed to upgrade WebSocket connection")
+		return
+	}
+	defer conn.Close()
+
+	sub := h.events.Subscribe(wsEventBuffer)
+	defer sub.Close()
+	sub.Add(initial...)
+
+	// Replies to client requests go through the writer so only one goroutine writes
+	replies := make(chan wsServerMessage, 8)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go readWebSocket(conn, sub, replies, done, quit)
+
+	if len(initial) > 0 {
+		replies <- wsServerMessage{Type: "subscribed", Topics: sub.Topics()}
+	}
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			// The server is shutting down
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(wsWriteWait))
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Closed by the hub because the client fell behind
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client too slow"), time.Now().Add(wsWriteWait))
+				return
+			}
+			// Conversation messages only go to the conversation's owner
+			if msg, ok := event.Data.(models.ConversationMessageEvent); ok && h.users != nil && msg.UserID != userID {
+				continue
+			}
+			// Device events only go to clients allowed to read the device
+			if deviceID := eventDeviceID(event.Data); deviceID != "" && !h.deviceManager.CanRead(ctx, principal, deviceID) {
+				continue
+			}
+			err = writeWebSocket(conn, wsServerMessage{Type: "event", Topic: event.Topic, Data: event.Data, Timestamp: &event.Timestamp})
+		case reply := <-replies:
+			err = writeWebSocket(conn, reply)
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		}
+		if err != nil {
+			logrus.WithError(err).Debug("WebSocket write failed")
+			return
+		}
+	}
+}
+
+// readWebSocket applies subscription requests until the connection closes or the
+// writer quits
+func readWebSocket(conn *websocket.Conn, sub *events.Subscription, replies chan<- wsServerMessage, done chan<- struct{}, quit <-chan struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logrus.WithError(err).Debug("WebSocket closed unexpectedly")
+			}
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var reply wsServerMessage
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			reply = wsServerMessage{Type: "error", Error: "messages must be JSON objects"}
+		} else {
+			reply = handleClientMessage(sub, msg)
+		}
+
+		select {
+		case replies <- reply:
+		case <-quit:
+			return
+		}
+	}
+}
+
+func handleClientMessage(sub *events.Subscription, msg wsClientMessage) wsServerMessage {
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		if len(msg.Topics) == 0 {
+			return wsServerMessage{Type: "error", Error: "topics are required"}
+		}
+		if err := validateTopics(msg.Topics); err != nil {
+			return wsServerMessage{Type: "error", Error: err.Error()}
+		}
+		if msg.Type == "subscribe" {
+			sub.Add(msg.Topics...)
+		} else {
+			sub.Remove(msg.Topics...)
+		}
+		return wsServerMessage{Type: msg.Type + "d", Topics: sub.Topics()}
+	case "ping":
+		return wsServerMessage{Type: "pong"}
+	default:
+		return wsServerMessage{Type: "error", Error: fmt.Sprintf("unknown message type: %q", msg.Type)}
+	}
+}
+
+// eventDeviceID is the device an event is about, or "" for other events
+func eventDeviceID(data any) string {
+	switch event := data.(type) {
+	case models.DeviceStateEvent:
+		return event.DeviceID
+	case models.TargetResult:
+		return event.Target
+	}
+	return ""
+}
+
+func writeWebSocket(conn *websocket.Conn, msg wsServerMessage) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return conn.WriteJSON(msg)
+}
+
+func validateTopics(topics []string) error {
+	for _, topic := range topics {
+		if !events.IsTopic(topic) {
+			return fmt.Errorf("unknown topic: %s", topic)
+		}
+	}
+	return nil
+}
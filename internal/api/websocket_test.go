@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/events"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func dialWebSocket(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readServerMessage(t *testing.T, conn *websocket.Conn) wsServerMessage {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	var msg wsServerMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestWebSocket_SubscribeAndReceiveEvents(t *testing.T) {
+	handler := setupTestHandler()
+	server := httptest.NewServer(setupTestRouter(handler))
+	defer server.Close()
+
+	conn := dialWebSocket(t, server, "")
+
+	require.NoError(t, conn.WriteJSON(wsClientMessage{Type: "subscribe", Topics: []string{events.TopicDeviceState, events.TopicActionResult}}))
+	reply := readServerMessage(t, conn)
+	assert.Equal(t, "subscribed", reply.Type)
+	assert.Equal(t, []string{events.TopicActionResult, events.TopicDeviceState}, reply.Topics)
+
+	require.NoError(t, conn.WriteJSON(wsClientMessage{Type: "unsubscribe", Topics: []string{events.TopicActionResult}}))
+	assert.Equal(t, []string{events.TopicDeviceState}, readServerMessage(t, conn).Topics)
+
+	handler.events.Publish(events.TopicActionResult, models.TargetResult{Target: "light.1"})
+	handler.events.Publish(events.TopicDeviceState, models.DeviceStateEvent{DeviceID: "light.1", State: "off"})
+
+	event := readServerMessage(t, conn)
+	assert.Equal(t, "event", event.Type)
+	assert.Equal(t, events.TopicDeviceState, event.Topic)
+	assert.Equal(t, "light.1", event.Data.(map[string]any)["device_id"])
+	assert.NotNil(t, event.Timestamp)
+}
+
+func TestWebSocket_ConversationMessages(t *testing.T) {
+	handler := setupTestHandler()
+	router := setupTestRouter(handler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server, "?topics="+events.TopicConversationMessage)
+	assert.Equal(t, "subscribed", readServerMessage(t, conn).Type)
+
+	body, _ := json.Marshal(models.ChatRequest{Message: "Is the test light on?"})
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/chat", bytes.NewBuffer(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	var roles []string
+	for i := 0; i < 2; i++ {
+		event := readServerMessage(t, conn)
+		data := event.Data.(map[string]any)
+		assert.Equal(t, response.ConversationID.String(), data["conversation_id"])
+		roles = append(roles, data["message"].(map[string]any)["role"].(string))
+	}
+	assert.Equal(t, []string{"user", "assistant"}, roles)
+}
+
+func TestWebSocket_InvalidRequests(t *testing.T) {
+	handler := setupTestHandler()
+	server := httptest.NewServer(setupTestRouter(handler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/ws?topics=weather")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	conn := dialWebSocket(t, server, "")
+
+	require.NoError(t, conn.WriteJSON(wsClientMessage{Type: "subscribe", Topics: []string{"weather"}}))
+	assert.Equal(t, "unknown topic: weather", readServerMessage(t, conn).Error)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	assert.Equal(t, "error", readServerMessage(t, conn).Type)
+
+	require.NoError(t, conn.WriteJSON(wsClientMessage{Type: "dance"}))
+	assert.Equal(t, "error", readServerMessage(t, conn).Type)
+
+	require.NoError(t, conn.WriteJSON(wsClientMessage{Type: "ping"}))
+	assert.Equal(t, "pong", readServerMessage(t, conn).Type)
+}
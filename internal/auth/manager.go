@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/internal/database"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultSessionTTL is how long a login lasts when no TTL is configured
+	DefaultSessionTTL = 30 * 24 * time.Hour
+	// MinPasswordLength is the shortest password an account may have
+	MinPasswordLength = 8
+	// maxPasswordLength is bcrypt's limit; longer passwords would be silently cut
+	maxPasswordLength = 72
+)
+
+var (
+	// ErrInvalidCredentials is returned when a username or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidAccount is returned when a username or password can't be used
+	ErrInvalidAccount = errors.New("invalid account")
+	// ErrUserExists is returned when a username is already taken
+	ErrUserExists = errors.New("username already taken")
+	// ErrUserNotFound is returned for an unknown user ID
+	ErrUserNotFound = errors.New("user not found")
+	// ErrNoSession is returned for an unknown or expired session token
+	ErrNoSession = errors.New("session not found or expired")
+	// ErrSetupComplete is returned when a first account is created but one exists
+	ErrSetupComplete = errors.New("setup is already complete")
+)
+
+// usernamePattern keeps usernames easy to type and safe to show
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// dummyHash is compared against when a username doesn't exist, so a login takes
+// as long for unknown users as for wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+type account struct {
+	user         models.User
+	passwordHash []byte
+}
+
+type session struct {
+	userID    uuid.UUID
+	expiresAt time.Time
+}
+
+// Manager keeps user accounts and their login sessions. Passwords are stored as
+// bcrypt hashes and sessions by a hash of their token.
+type Manager struct {
+	accounts   map[uuid.UUID]*account
+	sessions   map[string]session // keyed by token hash
+	sessionTTL time.Duration
+	mutex      sync.RWMutex
+	db         *database.DB // Optional SQLite persistence
+}
+
+// NewManager creates a manager that keeps accounts in memory. A sessionTTL of 0
+// uses DefaultSessionTTL.
+func NewManager(sessionTTL time.Duration) *Manager {
+	if sessionTTL <= 0 {
+		sessionTTL = DefaultSessionTTL
+	}
+	return &Manager{
+		accounts:   make(map[uuid.UUID]*account),
+		sessions:   make(map[string]session),
+		sessionTTL: sessionTTL,
+	}
+}
+
+// NewManagerWithDB creates a manager that persists accounts and sessions to SQLite
+func NewManagerWithDB(dbPath string, sessionTTL time.Duration) (*Manager, error) {
+	db, err := database.New(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	m := NewManager(sessionTTL)
+	m.db = db
+
+	records, err := db.GetAllUsers()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	for _, record := range records {
+		user := record.User
+		m.accounts[user.ID] = &account{user: user, passwordHash: []byte(record.PasswordHash)}
+	}
+
+	sessions, err := db.GetActiveSessions(time.Now())
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+	for _, s := range sessions {
+		m.sessions[s.TokenHash] = session{userID: s.UserID, expiresAt: s.ExpiresAt}
+	}
+
+	logrus.Infof("Loaded %d users and %d sessions from database", len(records), len(sessions))
+	return m, nil
+}
+
+// HasUsers reports whether any account exists. Until one does, the first account
+// can be created without signing in.
+func (m *Manager) HasUsers() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.accounts) > 0
+}
+
+// CreateUser adds an account. Usernames are matched without regard to case.
+func (m *Manager) CreateUser(username, password string, admin bool) (*models.User, error) {
+	return m.createUser(username, password, admin, false)
+}
+
+// CreateFirstUser adds the first account, an admin, and fails with
+// ErrSetupComplete once any account exists
+func (m *Manager) CreateFirstUser(username, password string) (*models.User, error) {
+	return m.createUser(username, password, true, true)
+}
+
+func (m *Manager) createUser(username, password string, admin, first bool) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("%w: usernames are 1 to 32 letters, digits, dots, dashes or underscores", ErrInvalidAccount)
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if first && len(m.accounts) > 0 {
+		return nil, ErrSetupComplete
+	}
+	if m.findByUsername(username) != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUserExists, username)
+	}
+
+	acct := &account{
+		user: models.User{
+			ID:          uuid.New(),
+			Username:    username,
+			Admin:       admin,
+			Preferences: make(map[string]string),
+			CreatedAt:   time.Now(),
+		},
+		passwordHash: hash,
+	}
+	if err := m.persist(acct); err != nil {
+		return nil, err
+	}
+	m.accounts[acct.user.ID] = acct
+
+	user := copyUser(acct.user)
+	return &user, nil
+}
+
+// Authenticate returns the user with username if password is theirs
+func (m *Manager) Authenticate(username, password string) (*models.User, error) {
+	m.mutex.RLock()
+	acct := m.findByUsername(strings.TrimSpace(username))
+	m.mutex.RUnlock()
+
+	if acct == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(acct.passwordHash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	user := copyUser(acct.user)
+	return &user, nil
+}
+
+// GetUser returns a user by ID
+func (m *Manager) GetUser(id uuid.UUID) (*models.User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	acct, exists := m.accounts[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	user := copyUser(acct.user)
+	return &user, nil
+}
+
+// ListUsers returns every user, oldest account first
+func (m *Manager) ListUsers() []models.User {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	users := make([]models.User, 0, len(m.accounts))
+	for _, acct := range m.accounts {
+		users = append(users, copyUser(acct.user))
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+	return users
+}
+
+// SetPreferences replaces a user's preferences
+func (m *Manager) SetPreferences(id uuid.UUID, preferences map[string]string) (*models.User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	acct, exists := m.accounts[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+
+	updated := *acct
+	updated.user.Preferences = make(map[string]string, len(preferences))
+	for key, value := range preferences {
+		updated.user.Preferences[key] = value
+	}
+	if err := m.persist(&updated); err != nil {
+		return nil, err
+	}
+	m.accounts[id] = &updated
+
+	user := copyUser(updated.user)
+	return &user, nil
+}
+
+// CreateSession starts a login session for a user and returns its token
+func (m *Manager) CreateSession(userID uuid.UUID) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.accounts[userID]; !exists {
+		return "", time.Time{}, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+
+	now := time.Now()
+	s := session{userID: userID, expiresAt: now.Add(m.sessionTTL)}
+	if m.db != nil {
+		record := database.SessionRecord{TokenHash: hashToken(token), UserID: userID, CreatedAt: now, ExpiresAt: s.expiresAt}
+		if err := m.db.SaveSession(record); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+	m.sessions[hashToken(token)] = s
+
+	return token, s.expiresAt, nil
+}
+
+// UserForSession returns the user a session token belongs to
+func (m *Manager) UserForSession(token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrNoSession
+	}
+	key := hashToken(token)
+
+	m.mutex.RLock()
+	s, exists := m.sessions[key]
+	var acct *account
+	if exists {
+		acct = m.accounts[s.userID]
+	}
+	m.mutex.RUnlock()
+
+	if !exists || acct == nil {
+		return nil, ErrNoSession
+	}
+	if time.Now().After(s.expiresAt) {
+		m.DeleteSession(token)
+		return nil, ErrNoSession
+	}
+
+	user := copyUser(acct.user)
+	return &user, nil
+}
+
+// DeleteSession ends a login session. Ending an unknown session is not an error.
+func (m *Manager) DeleteSession(token string) {
+	key := hashToken(token)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.sessions, key)
+	if m.db != nil {
+		if err := m.db.DeleteSession(key); err != nil {
+			logrus.Warnf("Failed to delete session from database: %v", err)
+		}
+	}
+}
+
+// Close closes the database connection if it exists
+func (m *Manager) Close() error {
+	if m.db != nil {
+		return m.db.Close()
+	}
+	return nil
+}
+
+// findByUsername must be called with the mutex held
+func (m *Manager) findByUsername(username string) *account {
+	for _, acct := range m.accounts {
+		if strings.EqualFold(acct.user.Username, username) {
+			return acct
+		}
+	}
+	return nil
+}
+
+// persist must be called with the mutex held. Accounts are only changed in
+// memory once they're saved, so a failed write never leaves them out of sync.
+func (m *Manager) persist(acct *account) error {
+	if m.db == nil {
+		return nil
+	}
+	return m.db.SaveUser(database.UserRecord{User: acct.user, PasswordHash: string(acct.passwordHash)})
+}
+
+func validatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("%w: passwords need at least %d characters", ErrInvalidAccount, MinPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: passwords can't be longer than %d bytes", ErrInvalidAccount, maxPasswordLength)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// copyUser copies a user so callers can't change the stored preferences
+func copyUser(user models.User) models.User {
+	preferences := make(map[string]string, len(user.Preferences))
+	for key, value := range user.Preferences {
+		preferences[key] = value
+	}
+	user.Preferences = preferences
+	return user
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateUserAndAuthenticate(t *testing.T) {
+	manager := NewManager(0)
+	assert.False(t, manager.HasUsers())
+
+	user, err := manager.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Username)
+	assert.NotEqual(t, uuid.Nil, user.ID)
+	assert.True(t, manager.HasUsers())
+
+	authenticated, err := manager.Authenticate("Alice", "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, authenticated.ID)
+
+	_, err = manager.Authenticate("alice", "wrong password")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = manager.Authenticate("nobody", "correct horse")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestCreateUser_Validation(t *testing.T) {
+	manager := NewManager(0)
+	_, err := manager.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		expected error
+	}{
+		{"taken username", "ALICE", "correct horse", ErrUserExists},
+		{"empty username", " ", "correct horse", ErrInvalidAccount},
+		{"username with spaces", "bob smith", "correct horse", ErrInvalidAccount},
+		{"short password", "bob", "short", ErrInvalidAccount},
+		{"password over bcrypt's limit", "bob", string(make([]byte, 73)), ErrInvalidAccount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manager.CreateUser(tt.username, tt.password, false)
+			assert.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
+
+func TestCreateFirstUser(t *testing.T) {
+	manager := NewManager(0)
+
+	admin, err := manager.CreateFirstUser("alice", "correct horse")
+	require.NoError(t, err)
+	assert.True(t, admin.Admin)
+
+	_, err = manager.CreateFirstUser("mallory", "correct horse")
+	assert.ErrorIs(t, err, ErrSetupComplete)
+}
+
+func TestSessions(t *testing.T) {
+	manager := NewManager(time.Hour)
+	user, err := manager.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+
+	token, expires, err := manager.CreateSession(user.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+
+	found, err := manager.UserForSession(token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	_, err = manager.UserForSession("not-a-token")
+	assert.ErrorIs(t, err, ErrNoSession)
+
+	manager.DeleteSession(token)
+	_, err = manager.UserForSession(token)
+	assert.ErrorIs(t, err, ErrNoSession)
+
+	_, _, err = manager.CreateSession(uuid.New())
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestSessions_Expire(t *testing.T) {
+	manager := NewManager(time.Millisecond)
+	user, err := manager.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+
+	token, _, err := manager.CreateSession(user.ID)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = manager.UserForSession(token)
+	assert.ErrorIs(t, err, ErrNoSession)
+}
+
+func TestSetPreferences(t *testing.T) {
+	manager := NewManager(0)
+	user, err := manager.CreateUser("alice", "correct horse", false)
+	require.NoError(t, err)
+
+	prefs := map[string]string{"temperature_unit": "celsius"}
+	updated, err := manager.SetPreferences(user.ID, prefs)
+	require.NoError(t, err)
+	assert.Equal(t, prefs, updated.Preferences)
+
+	// Callers get copies, so changing one doesn't change the account
+	updated.Preferences["temperature_unit"] = "fahrenheit"
+	prefs["temperature_unit"] = "kelvin"
+	stored, err := manager.GetUser(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "celsius", stored.Preferences["temperature_unit"])
+
+	_, err = manager.SetPreferences(uuid.New(), prefs)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestManagerWithDB_Persists(t *testing.T) {
+	dir, err := os.MkdirTemp("", "auth_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.db")
+
+	manager, err := NewManagerWithDB(path, time.Hour)
+	require.NoError(t, err)
+	alice, err := manager.CreateFirstUser("alice", "correct horse")
+	require.NoError(t, err)
+	_, err = manager.CreateUser("bob", "battery staple", false)
+	require.NoError(t, err)
+	_, err = manager.SetPreferences(alice.ID, map[string]string{"brightness": "50%"})
+	require.NoError(t, err)
+	token, _, err := manager.CreateSession(alice.ID)
+	require.NoError(t, err)
+	loggedOut, _, err := manager.CreateSession(alice.ID)
+	require.NoError(t, err)
+	manager.DeleteSession(loggedOut)
+	require.NoError(t, manager.Close())
+
+	reopened, err := NewManagerWithDB(path, time.Hour)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	users := reopened.ListUsers()
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice", users[0].Username)
+	assert.True(t, users[0].Admin)
+	assert.Equal(t, "50%", users[0].Preferences["brightness"])
+
+	_, err = reopened.Authenticate("bob", "battery staple")
+	assert.NoError(t, err)
+
+	found, err := reopened.UserForSession(token)
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, found.ID)
+	_, err = reopened.UserForSession(loggedOut)
+	assert.ErrorIs(t, err, ErrNoSession)
+}
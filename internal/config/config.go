@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -13,31 +15,59 @@ type Config struct {
 	HomeAssistant HomeAssistantConfig `json:"home_assistant"`
 	LLM           LLMConfig           `json:"llm"`
 	Storage       StorageConfig       `json:"storage"`
+	Auth          AuthConfig          `json:"auth"`
+	MQTT          MQTTConfig          `json:"mqtt"`
+	Speech        SpeechConfig        `json:"speech"`
+	Wyoming       WyomingConfig       `json:"wyoming"`
 	LogLevel      string              `json:"log_level"`
 }
 
 type ServerConfig struct {
-	Port         int           `json:"port"`
-	Host         string        `json:"host"`
-	Mode         string        `json:"mode"`
-	ReadTimeout  time.Duration `json:"read_timeout"`
-	WriteTimeout time.Duration `json:"write_timeout"`
+	Port          int           `json:"port"`
+	Host          string        `json:"host"`
+	Mode          string        `json:"mode"`
+	ReadTimeout   time.Duration `json:"read_timeout"`
+	WriteTimeout  time.Duration `json:"write_timeout"`
+	ChatRateLimit int           `json:"chat_rate_limit"` // chat messages per minute per client, 0 disables
+	ChatRateBurst int           `json:"chat_rate_burst"` // messages a client may send in quick succession
 }
 
 type HomeAssistantConfig struct {
-	URL     string `json:"url"`
-	Token   string `json:"token"`
-	Timeout int    `json:"timeout"`
+	URL               string `json:"url"`
+	Token             string `json:"token"`
+	Timeout           int    `json:"timeout"`
+	PollInterval      int    `json:"poll_interval"`       // seconds between device state polls, 0 disables
+	Retries           int    `json:"retries"`             // tries for each read, 1 disables retries
+	RetryServiceCalls bool   `json:"retry_service_calls"` // also retry service calls that can't have arrived
+	BreakerThreshold  int    `json:"breaker_threshold"`   // failures in a row before calls fail fast
+	BreakerCooldown   int    `json:"breaker_cooldown"`    // seconds calls fail fast before trying again
+	// Instances are the named servers to use instead of URL and Token, when
+	// there are several; the other settings apply to each
+	Instances []HomeAssistantInstance `json:"instances,omitempty"`
+}
+
+type HomeAssistantInstance struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
 }
 
 type LLMConfig struct {
-	OllamaURL   string  `json:"ollama_url"`
-	Model       string  `json:"model"`
-	MaxTokens   int     `json:"max_tokens"`
-	Temperature float32 `json:"temperature"`
-	TopP        float32 `json:"top_p"`
-	TopK        int     `json:"top_k"`
-	Timeout     int     `json:"timeout"`
+	OllamaURL        string  `json:"ollama_url"`
+	Model            string  `json:"model"`
+	MaxTokens        int     `json:"max_tokens"`
+	Temperature      float32 `json:"temperature"`
+	TopP             float32 `json:"top_p"`
+	TopK             int     `json:"top_k"`
+	Timeout          int     `json:"timeout"`
+	ContextLength    int     `json:"context_length"`    // caps the model's context window, 0 uses the model's own
+	SummaryThreshold int     `json:"summary_threshold"` // messages before older ones are summarized, 0 disables
+	MaxConcurrent    int     `json:"max_concurrent"`    // requests sent to Ollama at once
+	QueueLength      int     `json:"queue_length"`      // requests that may wait for a turn
+	QueueTimeout     int     `json:"queue_timeout"`     // seconds a request may wait for a turn
+	Retries          int     `json:"retries"`           // tries for each Ollama call, 1 disables retries
+	BreakerThreshold int     `json:"breaker_threshold"` // failures in a row before calls fail fast
+	BreakerCooldown  int     `json:"breaker_cooldown"`  // seconds calls fail fast before trying again
 }
 
 type StorageConfig struct {
@@ -46,43 +76,162 @@ type StorageConfig struct {
 	InMemory bool   `json:"in_memory"`
 }
 
+type AuthConfig struct {
+	Enabled    bool   `json:"enabled"`     // require users to sign in
+	SessionTTL int    `json:"session_ttl"` // hours a sign-in lasts
+	ACLFile    string `json:"acl_file"`    // JSON device access rules, empty allows everyone everything
+}
+
+type MQTTConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Broker        string `json:"broker"` // tcp://, ssl:// or ws:// URL
+	ClientID      string `json:"client_id"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	CAFile        string `json:"ca_file"`        // CA certificate to verify the broker with, empty uses the system's
+	CertFile      string `json:"cert_file"`      // client certificate for brokers that require one
+	KeyFile       string `json:"key_file"`       // key of the client certificate
+	CommandTopic  string `json:"command_topic"`  // utterances and device actions to carry out
+	ResponseTopic string `json:"response_topic"` // Luna's replies to utterances
+	ActionTopic   string `json:"action_topic"`   // results of device actions from the command topic
+	EventTopic    string `json:"event_topic"`    // prefix for every device, action and conversation event
+	QoS           int    `json:"qos"`
+}
+
+type SpeechConfig struct {
+	STTURL      string `json:"stt_url"`      // speech-to-text server, empty disables voice input
+	STTAPI      string `json:"stt_api"`      // "whispercpp" or "openai" for faster-whisper servers
+	STTModel    string `json:"stt_model"`    // model to ask OpenAI-style servers for
+	STTLanguage string `json:"stt_language"` // spoken language, empty detects it
+	STTTimeout  int    `json:"stt_timeout"`  // seconds a transcription may take
+	TTSURL      string `json:"tts_url"`      // text-to-speech server, empty disables spoken replies
+	TTSVoice    string `json:"tts_voice"`    // voice to speak with, empty for the server's default
+	TTSTimeout  int    `json:"tts_timeout"`  // seconds synthesizing a reply may take
+}
+
+type WyomingConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Port      int    `json:"port"`      // TCP port satellites connect to
+	Languages string `json:"languages"` // comma-separated languages to offer satellites
+}
+
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load()
 
 	config := &Config{
 		Server: ServerConfig{
-			Port:         getEnvAsInt("SERVER_PORT", 8080),
-			Host:         getEnv("SERVER_HOST", "0.0.0.0"),
-			Mode:         getEnv("SERVER_MODE", "debug"),
-			ReadTimeout:  time.Duration(getEnvAsInt("SERVER_READ_TIMEOUT", 10)) * time.Second,
-			WriteTimeout: time.Duration(getEnvAsInt("SERVER_WRITE_TIMEOUT", 10)) * time.Second,
+			Port:          getEnvAsInt("SERVER_PORT", 8080),
+			Host:          getEnv("SERVER_HOST", "0.0.0.0"),
+			Mode:          getEnv("SERVER_MODE", "debug"),
+			ReadTimeout:   time.Duration(getEnvAsInt("SERVER_READ_TIMEOUT", 10)) * time.Second,
+			WriteTimeout:  time.Duration(getEnvAsInt("SERVER_WRITE_TIMEOUT", 10)) * time.Second,
+			ChatRateLimit: getEnvAsInt("CHAT_RATE_LIMIT", 20),
+			ChatRateBurst: getEnvAsInt("CHAT_RATE_BURST", 5),
 		},
 		HomeAssistant: HomeAssistantConfig{
-			URL:     getEnv("HA_URL", "http://homeassistant.local:8123"),
-			Token:   getEnv("HA_TOKEN", ""),
-			Timeout: getEnvAsInt("HA_TIMEOUT", 30),
+			URL:               getEnv("HA_URL", "http://homeassistant.local:8123"),
+			Token:             getEnv("HA_TOKEN", ""),
+			Timeout:           getEnvAsInt("HA_TIMEOUT", 30),
+			PollInterval:      getEnvAsInt("HA_POLL_INTERVAL", 10),
+			Retries:           getEnvAsInt("HA_RETRIES", 3),
+			RetryServiceCalls: getEnvAsBool("HA_RETRY_SERVICE_CALLS", false),
+			BreakerThreshold:  getEnvAsInt("HA_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:   getEnvAsInt("HA_BREAKER_COOLDOWN", 30),
 		},
 		LLM: LLMConfig{
-			OllamaURL:   getEnv("OLLAMA_URL", "http://localhost:11434"),
-			Model:       getEnv("OLLAMA_MODEL", "llama3.2"),
-			MaxTokens:   getEnvAsInt("LLM_MAX_TOKENS", 512),
-			Temperature: getEnvAsFloat32("LLM_TEMPERATURE", 0.7),
-			TopP:        getEnvAsFloat32("LLM_TOP_P", 0.9),
-			TopK:        getEnvAsInt("LLM_TOP_K", 40),
-			Timeout:     getEnvAsInt("LLM_TIMEOUT", 30),
+			OllamaURL:        getEnv("OLLAMA_URL", "http://localhost:11434"),
+			Model:            getEnv("OLLAMA_MODEL", "llama3.2"),
+			MaxTokens:        getEnvAsInt("LLM_MAX_TOKENS", 512),
+			Temperature:      getEnvAsFloat32("LLM_TEMPERATURE", 0.7),
+			TopP:             getEnvAsFloat32("LLM_TOP_P", 0.9),
+			TopK:             getEnvAsInt("LLM_TOP_K", 40),
+			Timeout:          getEnvAsInt("LLM_TIMEOUT", 30),
+			ContextLength:    getEnvAsInt("LLM_CONTEXT_LENGTH", 4096),
+			SummaryThreshold: getEnvAsInt("LLM_SUMMARY_THRESHOLD", 20),
+			MaxConcurrent:    getEnvAsInt("LLM_MAX_CONCURRENT", 2),
+			QueueLength:      getEnvAsInt("LLM_QUEUE_LENGTH", 8),
+			QueueTimeout:     getEnvAsInt("LLM_QUEUE_TIMEOUT", 30),
+			Retries:          getEnvAsInt("LLM_RETRIES", 2),
+			BreakerThreshold: getEnvAsInt("LLM_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:  getEnvAsInt("LLM_BREAKER_COOLDOWN", 30),
 		},
 		Storage: StorageConfig{
 			Type:     getEnv("STORAGE_TYPE", "memory"),
 			Path:     getEnv("STORAGE_PATH", "./data"),
 			InMemory: getEnvAsBool("STORAGE_IN_MEMORY", true),
 		},
+		Auth: AuthConfig{
+			Enabled:    getEnvAsBool("AUTH_ENABLED", false),
+			SessionTTL: getEnvAsInt("AUTH_SESSION_TTL", 720),
+			ACLFile:    getEnv("AUTH_ACL_FILE", ""),
+		},
+		MQTT: MQTTConfig{
+			Enabled:       getEnvAsBool("MQTT_ENABLED", false),
+			Broker:        getEnv("MQTT_BROKER", "tcp://localhost:1883"),
+			ClientID:      getEnv("MQTT_CLIENT_ID", "gpt-home"),
+			Username:      getEnv("MQTT_USERNAME", ""),
+			Password:      getEnv("MQTT_PASSWORD", ""),
+			CAFile:        getEnv("MQTT_CA_FILE", ""),
+			CertFile:      getEnv("MQTT_CERT_FILE", ""),
+			KeyFile:       getEnv("MQTT_KEY_FILE", ""),
+			CommandTopic:  getEnv("MQTT_COMMAND_TOPIC", "gpt-home/command"),
+			ResponseTopic: getEnv("MQTT_RESPONSE_TOPIC", "gpt-home/response"),
+			ActionTopic:   getEnv("MQTT_ACTION_TOPIC", "gpt-home/actions"),
+			EventTopic:    getEnv("MQTT_EVENT_TOPIC", "gpt-home/events"),
+			QoS:           getEnvAsInt("MQTT_QOS", 1),
+		},
+		Speech: SpeechConfig{
+			STTURL:      getEnv("STT_URL", ""),
+			STTAPI:      getEnv("STT_API", "whispercpp"),
+			STTModel:    getEnv("STT_MODEL", "whisper-1"),
+			STTLanguage: getEnv("STT_LANGUAGE", ""),
+			STTTimeout:  getEnvAsInt("STT_TIMEOUT", 60),
+			TTSURL:      getEnv("TTS_URL", ""),
+			TTSVoice:    getEnv("TTS_VOICE", ""),
+			TTSTimeout:  getEnvAsInt("TTS_TIMEOUT", 30),
+		},
+		Wyoming: WyomingConfig{
+			Enabled:   getEnvAsBool("WYOMING_ENABLED", false),
+			Port:      getEnvAsInt("WYOMING_PORT", 10500),
+			Languages: getEnv("WYOMING_LANGUAGES", "en"),
+		},
 		LogLevel: getEnv("LOG_LEVEL", "info"),
 	}
 
+	instances, err := loadHomeAssistantInstances(getEnv("HA_INSTANCES", ""))
+	if err != nil {
+		return nil, err
+	}
+	config.HomeAssistant.Instances = instances
+
 	return config, nil
 }
 
+// loadHomeAssistantInstances reads the servers named in HA_INSTANCES, e.g.
+// "home,cabin", from HA_<NAME>_URL and HA_<NAME>_TOKEN
+func loadHomeAssistantInstances(names string) ([]HomeAssistantInstance, error) {
+	var instances []HomeAssistantInstance
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "HA_" + strings.ToUpper(strings.NewReplacer("-", "_").Replace(name)) + "_"
+		instance := HomeAssistantInstance{
+			Name:  name,
+			URL:   getEnv(prefix+"URL", ""),
+			Token: getEnv(prefix+"TOKEN", ""),
+		}
+		if instance.URL == "" {
+			return nil, fmt.Errorf("Home Assistant instance %s needs %sURL", name, prefix)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
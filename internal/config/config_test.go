@@ -26,6 +26,11 @@ func TestLoadDefaultConfig(t *testing.T) {
 	assert.Equal(t, "http://homeassistant.local:8123", config.HomeAssistant.URL)
 	assert.Equal(t, "", config.HomeAssistant.Token)
 	assert.Equal(t, 30, config.HomeAssistant.Timeout)
+	assert.Equal(t, 10, config.HomeAssistant.PollInterval)
+	assert.Equal(t, 3, config.HomeAssistant.Retries)
+	assert.False(t, config.HomeAssistant.RetryServiceCalls)
+	assert.Equal(t, 5, config.HomeAssistant.BreakerThreshold)
+	assert.Equal(t, 30, config.HomeAssistant.BreakerCooldown)
 
 	assert.Equal(t, "http://localhost:11434", config.LLM.OllamaURL)
 	assert.Equal(t, "llama3.2", config.LLM.Model)
@@ -34,14 +39,69 @@ func TestLoadDefaultConfig(t *testing.T) {
 	assert.Equal(t, float32(0.9), config.LLM.TopP)
 	assert.Equal(t, 40, config.LLM.TopK)
 	assert.Equal(t, 30, config.LLM.Timeout)
+	assert.Equal(t, 4096, config.LLM.ContextLength)
+	assert.Equal(t, 20, config.LLM.SummaryThreshold)
+	assert.Equal(t, 2, config.LLM.MaxConcurrent)
+	assert.Equal(t, 8, config.LLM.QueueLength)
+	assert.Equal(t, 30, config.LLM.QueueTimeout)
+	assert.Equal(t, 2, config.LLM.Retries)
+	assert.Equal(t, 5, config.LLM.BreakerThreshold)
+	assert.Equal(t, 30, config.LLM.BreakerCooldown)
+	assert.Equal(t, 20, config.Server.ChatRateLimit)
+	assert.Equal(t, 5, config.Server.ChatRateBurst)
 
 	assert.Equal(t, "memory", config.Storage.Type)
 	assert.Equal(t, "./data", config.Storage.Path)
 	assert.True(t, config.Storage.InMemory)
 
+	assert.False(t, config.Auth.Enabled)
+	assert.Equal(t, 720, config.Auth.SessionTTL)
+	assert.Equal(t, "", config.Auth.ACLFile)
+
+	assert.False(t, config.MQTT.Enabled)
+	assert.Equal(t, "tcp://localhost:1883", config.MQTT.Broker)
+	assert.Equal(t, "gpt-home", config.MQTT.ClientID)
+	assert.Equal(t, "gpt-home/command", config.MQTT.CommandTopic)
+	assert.Equal(t, "gpt-home/response", config.MQTT.ResponseTopic)
+	assert.Equal(t, "gpt-home/actions", config.MQTT.ActionTopic)
+	assert.Equal(t, "gpt-home/events", config.MQTT.EventTopic)
+	assert.Equal(t, 1, config.MQTT.QoS)
+
+	assert.Equal(t, "", config.Speech.STTURL)
+	assert.Equal(t, "whispercpp", config.Speech.STTAPI)
+	assert.Equal(t, "whisper-1", config.Speech.STTModel)
+	assert.Equal(t, "", config.Speech.STTLanguage)
+	assert.Empty(t, config.HomeAssistant.Instances)
+	assert.Equal(t, 60, config.Speech.STTTimeout)
+	assert.Equal(t, "", config.Speech.TTSURL)
+	assert.Equal(t, "", config.Speech.TTSVoice)
+	assert.Equal(t, 30, config.Speech.TTSTimeout)
+	assert.False(t, config.Wyoming.Enabled)
+	assert.Equal(t, 10500, config.Wyoming.Port)
+	assert.Equal(t, "en", config.Wyoming.Languages)
+
 	assert.Equal(t, "info", config.LogLevel)
 }
 
+func TestLoadHomeAssistantInstances(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("HA_INSTANCES", "home, lake-house")
+	t.Setenv("HA_HOME_URL", "http://home:8123")
+	t.Setenv("HA_HOME_TOKEN", "home-token")
+	t.Setenv("HA_LAKE_HOUSE_URL", "http://lake:8123")
+
+	config, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, []HomeAssistantInstance{
+		{Name: "home", URL: "http://home:8123", Token: "home-token"},
+		{Name: "lake-house", URL: "http://lake:8123"},
+	}, config.HomeAssistant.Instances)
+
+	os.Unsetenv("HA_HOME_URL")
+	_, err = Load()
+	assert.ErrorContains(t, err, "HA_HOME_URL")
+}
+
 func TestLoadConfigFromEnv(t *testing.T) {
 	// Set test environment variables
 	envVars := map[string]string{
@@ -63,6 +123,19 @@ func TestLoadConfigFromEnv(t *testing.T) {
 		"STORAGE_TYPE":         "file",
 		"STORAGE_PATH":         "/custom/data",
 		"STORAGE_IN_MEMORY":    "false",
+		"AUTH_ENABLED":         "true",
+		"AUTH_SESSION_TTL":     "24",
+		"AUTH_ACL_FILE":        "/custom/acl.json",
+		"MQTT_ENABLED":         "true",
+		"MQTT_BROKER":          "ssl://mqtt.local:8883",
+		"MQTT_USERNAME":        "luna",
+		"MQTT_COMMAND_TOPIC":   "home/luna/ask",
+		"STT_URL":              "http://whisper:9000",
+		"STT_API":              "openai",
+		"TTS_URL":              "http://piper:5000",
+		"TTS_VOICE":            "en_US-amy-medium",
+		"WYOMING_ENABLED":      "true",
+		"WYOMING_PORT":         "10600",
 		"LOG_LEVEL":            "debug",
 	}
 
@@ -103,6 +176,22 @@ func TestLoadConfigFromEnv(t *testing.T) {
 	assert.Equal(t, "/custom/data", config.Storage.Path)
 	assert.False(t, config.Storage.InMemory)
 
+	assert.True(t, config.Auth.Enabled)
+	assert.Equal(t, 24, config.Auth.SessionTTL)
+	assert.Equal(t, "/custom/acl.json", config.Auth.ACLFile)
+
+	assert.True(t, config.MQTT.Enabled)
+	assert.Equal(t, "ssl://mqtt.local:8883", config.MQTT.Broker)
+	assert.Equal(t, "luna", config.MQTT.Username)
+	assert.Equal(t, "home/luna/ask", config.MQTT.CommandTopic)
+
+	assert.Equal(t, "http://whisper:9000", config.Speech.STTURL)
+	assert.Equal(t, "openai", config.Speech.STTAPI)
+	assert.Equal(t, "http://piper:5000", config.Speech.TTSURL)
+	assert.Equal(t, "en_US-amy-medium", config.Speech.TTSVoice)
+	assert.True(t, config.Wyoming.Enabled)
+	assert.Equal(t, 10600, config.Wyoming.Port)
+
 	assert.Equal(t, "debug", config.LogLevel)
 }
 
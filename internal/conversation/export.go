@@ -0,0 +1,372 @@
+package conversation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ExportVersion is the version of the JSON export format
+const ExportVersion = 1
+
+var (
+	// ErrInvalidImport is returned when an import can't be read or contains bad data
+	ErrInvalidImport = errors.New("invalid import")
+	// ErrConflict is returned when an import contains conversations that already exist
+	ErrConflict = errors.New("conversation already exists")
+)
+
+// ConflictMode decides what an import does with conversations whose ID already exists
+type ConflictMode string
+
+const (
+	ConflictError   ConflictMode = "error"   // import nothing and report the conflicts
+	ConflictSkip    ConflictMode = "skip"    // keep the existing conversation
+	ConflictReplace ConflictMode = "replace" // overwrite the existing conversation
+	ConflictNew     ConflictMode = "new"     // import under a new ID
+)
+
+// ParseConflictMode validates a conflict mode, defaulting to ConflictError
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch mode := ConflictMode(s); mode {
+	case "":
+		return ConflictError, nil
+	case ConflictError, ConflictSkip, ConflictReplace, ConflictNew:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: unknown conflict mode %q", ErrInvalidImport, s)
+	}
+}
+
+// Export is the JSON document conversations are exported to and imported from
+type Export struct {
+	Version       int                    `json:"version"`
+	ExportedAt    time.Time              `json:"exported_at"`
+	Conversations []*models.Conversation `json:"conversations"`
+}
+
+// ImportResult reports what happened to each imported conversation
+type ImportResult struct {
+	Created   []uuid.UUID          `json:"created"`
+	Replaced  []uuid.UUID          `json:"replaced"`
+	Skipped   []uuid.UUID          `json:"skipped"`
+	Renamed   map[string]uuid.UUID `json:"renamed"` // original ID to the new ID
+	Conflicts []uuid.UUID          `json:"conflicts,omitempty"`
+}
+
+// ExportConversations returns copies of the given conversations, or of all
+// conversations when no IDs are given, oldest first
+func (m *Manager) ExportConversations(ids ...uuid.UUID) ([]*models.Conversation, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var convs []*models.Conversation
+	if len(ids) == 0 {
+		for _, conv := range m.conversations {
+			convs = append(convs, copyConversation(conv))
+		}
+	} else {
+		for _, id := range ids {
+			conv, exists := m.conversations[id]
+			if !exists {
+				return nil, fmt.Errorf("conversation not found: %s", id)
+			}
+			convs = append(convs, copyConversation(conv))
+		}
+	}
+
+	sort.SliceStable(convs, func(i, j int) bool {
+		return convs[i].CreatedAt.Before(convs[j].CreatedAt)
+	})
+	return convs, nil
+}
+
+// ImportConversations adds exported conversations, resolving ID conflicts with
+// mode. With ConflictError nothing is imported if any conversation exists.
+// Imported conversations are persisted when the manager has a database.
+func (m *Manager) ImportConversations(convs []*models.Conversation, mode ConflictMode) (*ImportResult, error) {
+	return m.ImportConversationsForUser(convs, mode, uuid.Nil)
+}
+
+// ImportConversationsForUser imports conversations as owned by userID. Other
+// users' conversations never conflict: an import whose ID one of them already
+// uses gets a new ID. With uuid.Nil the exported owners are kept.
+func (m *Manager) ImportConversationsForUser(convs []*models.Conversation, mode ConflictMode, userID uuid.UUID) (*ImportResult, error) {
+	seen := make(map[uuid.UUID]bool, len(convs))
+	for i, conv := range convs {
+		if conv == nil || conv.ID == uuid.Nil {
+			return nil, fmt.Errorf("%w: conversation %d has no ID", ErrInvalidImport, i)
+		}
+		if seen[conv.ID] {
+			return nil, fmt.Errorf("%w: conversation %s appears more than once", ErrInvalidImport, conv.ID)
+		}
+		seen[conv.ID] = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result := &ImportResult{
+		Created:  []uuid.UUID{},
+		Replaced: []uuid.UUID{},
+		Skipped:  []uuid.UUID{},
+		Renamed:  map[string]uuid.UUID{},
+	}
+
+	// exists reports whether id is taken, and whether by a conversation userID can see
+	exists := func(id uuid.UUID) (taken, visible bool) {
+		existing, taken := m.conversations[id]
+		return taken, taken && (userID == uuid.Nil || existing.UserID == userID)
+	}
+
+	if mode == ConflictError {
+		for _, conv := range convs {
+			if _, visible := exists(conv.ID); visible {
+				result.Conflicts = append(result.Conflicts, conv.ID)
+			}
+		}
+		if len(result.Conflicts) > 0 {
+			return result, fmt.Errorf("%w: %d of %d conversations", ErrConflict, len(result.Conflicts), len(convs))
+		}
+	}
+
+	for _, conv := range convs {
+		conv = normalizeImported(conv)
+		if userID != uuid.Nil {
+			conv.UserID = userID
+		}
+
+		if taken, visible := exists(conv.ID); taken {
+			conflict := mode
+			if !visible {
+				conflict = ConflictNew
+			}
+
+			switch conflict {
+			case ConflictSkip:
+				result.Skipped = append(result.Skipped, conv.ID)
+				continue
+			case ConflictReplace:
+				result.Replaced = append(result.Replaced, conv.ID)
+			case ConflictNew:
+				// Message IDs are unique across conversations, so they change too
+				original := conv.ID
+				conv.ID = uuid.New()
+				for i := range conv.Messages {
+					conv.Messages[i].ID = uuid.New()
+				}
+				result.Renamed[original.String()] = conv.ID
+			}
+		} else {
+			result.Created = append(result.Created, conv.ID)
+		}
+
+		m.conversations[conv.ID] = conv
+		if m.db != nil {
+			if err := m.db.SaveConversation(conv); err != nil {
+				logrus.Warnf("Failed to persist imported conversation to database: %v", err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// normalizeImported copies an imported conversation and fills in anything an
+// older or hand-written export may have left out
+func normalizeImported(conv *models.Conversation) *models.Conversation {
+	conv = copyConversation(conv)
+
+	if conv.Messages == nil {
+		conv.Messages = []models.Message{}
+	}
+	for i := range conv.Messages {
+		if conv.Messages[i].ID == uuid.Nil {
+			conv.Messages[i].ID = uuid.New()
+		}
+	}
+	if conv.CreatedAt.IsZero() {
+		conv.CreatedAt = time.Now()
+		if len(conv.Messages) > 0 && !conv.Messages[0].Timestamp.IsZero() {
+			conv.CreatedAt = conv.Messages[0].Timestamp
+		}
+	}
+	if conv.UpdatedAt.IsZero() {
+		conv.UpdatedAt = conv.CreatedAt
+	}
+	if conv.Context.ReferencedDevices == nil {
+		conv.Context.ReferencedDevices = []string{}
+	}
+	if conv.Context.UserPreferences == nil {
+		conv.Context.UserPreferences = make(map[string]string)
+	}
+	if conv.Context.SessionData == nil {
+		conv.Context.SessionData = make(map[string]any)
+	}
+
+	return conv
+}
+
+// copyConversation copies a conversation deeply enough that changes to the copy's
+// messages and context don't affect the original
+func copyConversation(conv *models.Conversation) *models.Conversation {
+	// A JSON round trip copies nested maps and slices, and matches what an
+	// export followed by an import would produce
+	data, err := json.Marshal(conv)
+	if err != nil {
+		copied := *conv
+		return &copied
+	}
+	var copied models.Conversation
+	if err := json.Unmarshal(data, &copied); err != nil {
+		copied := *conv
+		return &copied
+	}
+	return &copied
+}
+
+// WriteJSON writes conversations as an Export document
+func WriteJSON(w io.Writer, convs []*models.Conversation) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(Export{
+		Version:       ExportVersion,
+		ExportedAt:    time.Now().UTC(),
+		Conversations: convs,
+	})
+}
+
+// ReadJSON reads an Export document. A single conversation object is accepted
+// too, so the output of GET /conversations/:id can be imported directly.
+func ReadJSON(r io.Reader) ([]*models.Conversation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	var export struct {
+		Version       int                    `json:"version"`
+		Conversations []*models.Conversation `json:"conversations"`
+		ID            uuid.UUID              `json:"id"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	if export.Conversations == nil && export.ID != uuid.Nil {
+		var conv models.Conversation
+		if err := json.Unmarshal(data, &conv); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		return []*models.Conversation{&conv}, nil
+	}
+
+	if export.Version > ExportVersion {
+		return nil, fmt.Errorf("%w: export version %d is newer than supported version %d", ErrInvalidImport, export.Version, ExportVersion)
+	}
+	if export.Conversations == nil {
+		return nil, fmt.Errorf("%w: no conversations found", ErrInvalidImport)
+	}
+
+	return export.Conversations, nil
+}
+
+// WriteMarkdown writes conversations as a readable transcript, suitable for
+// attaching to bug reports. Markdown exports can't be imported.
+func WriteMarkdown(w io.Writer, convs []*models.Conversation) error {
+	var b strings.Builder
+
+	for i, conv := range convs {
+		if i > 0 {
+			b.WriteString("\n---\n\n")
+		}
+
+		summary := Summarize(conv)
+		fmt.Fprintf(&b, "# %s\n\n", summary.Title)
+		fmt.Fprintf(&b, "- **ID:** `%s`\n", conv.ID)
+		fmt.Fprintf(&b, "- **Created:** %s\n", conv.CreatedAt.Format(time.RFC3339))
+		fmt.Fprintf(&b, "- **Updated:** %s\n", conv.UpdatedAt.Format(time.RFC3339))
+		fmt.Fprintf(&b, "- **Messages:** %d\n", len(conv.Messages))
+		if len(summary.ReferencedDevices) > 0 {
+			fmt.Fprintf(&b, "- **Devices:** %s\n", codeList(summary.ReferencedDevices))
+		}
+		writeContextMarkdown(&b, conv.Context)
+
+		for _, msg := range conv.Messages {
+			fmt.Fprintf(&b, "\n## %s · %s\n\n", roleTitle(msg.Role), msg.Timestamp.Format(time.RFC3339))
+			b.WriteString(strings.TrimSpace(msg.Content))
+			b.WriteString("\n")
+			writeMetadataMarkdown(&b, msg.Metadata)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeContextMarkdown(b *strings.Builder, ctx models.Context) {
+	if ctx.LastAction == nil && ctx.Summary == "" && len(ctx.UserPreferences) == 0 && len(ctx.SessionData) == 0 {
+		return
+	}
+
+	data, err := json.MarshalIndent(ctx, "", "  ")
+	if err != nil {
+		return
+	}
+	b.WriteString("\n<details><summary>Context</summary>\n\n```json\n")
+	b.Write(data)
+	b.WriteString("\n```\n\n</details>\n")
+}
+
+func writeMetadataMarkdown(b *strings.Builder, metadata models.Metadata) {
+	var details []string
+	if len(metadata.DevicesReferenced) > 0 {
+		details = append(details, "devices: "+codeList(metadata.DevicesReferenced))
+	}
+	if len(metadata.ActionsPerformed) > 0 {
+		details = append(details, "actions: "+codeList(metadata.ActionsPerformed))
+	}
+	if metadata.ModelUsed != "" {
+		details = append(details, "model: "+metadata.ModelUsed)
+	}
+	if metadata.ProcessingTime > 0 {
+		details = append(details, fmt.Sprintf("took %.2fs", metadata.ProcessingTime))
+	}
+	if metadata.Confidence > 0 {
+		details = append(details, fmt.Sprintf("confidence %.2f", metadata.Confidence))
+	}
+
+	if len(details) > 0 {
+		fmt.Fprintf(b, "\n> %s\n", strings.Join(details, " · "))
+	}
+}
+
+func roleTitle(role models.MessageRole) string {
+	switch role {
+	case models.MessageRoleUser:
+		return "User"
+	case models.MessageRoleAssistant:
+		return "Luna"
+	case models.MessageRoleSystem:
+		return "System"
+	default:
+		return string(role)
+	}
+}
+
+func codeList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = "`" + item + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,294 @@
+package conversation
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func sampleConversation() *models.Conversation {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return &models.Conversation{
+		ID:        uuid.New(),
+		CreatedAt: created,
+		UpdatedAt: created.Add(time.Minute),
+		Messages: []models.Message{
+			{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Turn on the kitchen lights", Timestamp: created},
+			{
+				ID:        uuid.New(),
+				Role:      models.MessageRoleAssistant,
+				Content:   "The kitchen lights are on.",
+				Timestamp: created.Add(time.Minute),
+				Metadata: models.Metadata{
+					DevicesReferenced: []string{"light.kitchen"},
+					ActionsPerformed:  []string{"turn_on"},
+					ModelUsed:         "llama3.2",
+					ProcessingTime:    1.5,
+				},
+			},
+		},
+		Context: models.Context{
+			ReferencedDevices: []string{"light.kitchen"},
+			LastAction:        &models.DeviceAction{Action: "turn_on", Parameters: map[string]any{}},
+			UserPreferences:   map[string]string{"units": "metric"},
+			SessionData:       map[string]any{},
+		},
+	}
+}
+
+func TestJSONExportRoundTrip(t *testing.T) {
+	conv := sampleConversation()
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteJSON(&buf, []*models.Conversation{conv}))
+	assert.Contains(t, buf.String(), `"version": 1`)
+
+	imported, err := ReadJSON(&buf)
+	require.NoError(t, err)
+	require.Len(t, imported, 1)
+
+	assert.Equal(t, conv.ID, imported[0].ID)
+	assert.Equal(t, conv.Context.UserPreferences, imported[0].Context.UserPreferences)
+	assert.Equal(t, "turn_on", imported[0].Context.LastAction.Action)
+	require.Len(t, imported[0].Messages, 2)
+	assert.Equal(t, conv.Messages[1].Metadata, imported[0].Messages[1].Metadata)
+	assert.True(t, conv.Messages[1].Timestamp.Equal(imported[0].Messages[1].Timestamp))
+}
+
+func TestReadJSON(t *testing.T) {
+	single := `{"id": "` + uuid.New().String() + `", "messages": [{"role": "user", "content": "hi"}]}`
+	convs, err := ReadJSON(strings.NewReader(single))
+	require.NoError(t, err)
+	require.Len(t, convs, 1)
+
+	for name, input := range map[string]string{
+		"not json":         "# Transcript",
+		"newer version":    `{"version": 99, "conversations": []}`,
+		"no conversations": `{"version": 1}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadJSON(strings.NewReader(input))
+			assert.ErrorIs(t, err, ErrInvalidImport)
+		})
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	conv := sampleConversation()
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteMarkdown(&buf, []*models.Conversation{conv, sampleConversation()}))
+	transcript := buf.String()
+
+	assert.True(t, strings.HasPrefix(transcript, "# Turn on the kitchen lights\n"))
+	assert.Contains(t, transcript, "- **ID:** `"+conv.ID.String()+"`")
+	assert.Contains(t, transcript, "## User · 2024-03-01T12:00:00Z\n\nTurn on the kitchen lights\n")
+	assert.Contains(t, transcript, "## Luna · 2024-03-01T12:01:00Z\n\nThe kitchen lights are on.\n")
+	assert.Contains(t, transcript, "> devices: `light.kitchen` · actions: `turn_on` · model: llama3.2 · took 1.50s")
+	assert.Contains(t, transcript, `"units": "metric"`)
+	assert.Equal(t, 1, strings.Count(transcript, "\n---\n"))
+}
+
+func TestImportConversations_ConflictModes(t *testing.T) {
+	existing := sampleConversation()
+
+	tests := []struct {
+		mode   ConflictMode
+		check  func(t *testing.T, manager *Manager, result *ImportResult)
+		hasErr bool
+	}{
+		{
+			mode:   ConflictError,
+			hasErr: true,
+			check: func(t *testing.T, manager *Manager, result *ImportResult) {
+				assert.Equal(t, []uuid.UUID{existing.ID}, result.Conflicts)
+				// Nothing is imported, not even the new conversation
+				assert.Len(t, manager.GetAllConversations(), 1)
+			},
+		},
+		{
+			mode: ConflictSkip,
+			check: func(t *testing.T, manager *Manager, result *ImportResult) {
+				assert.Equal(t, []uuid.UUID{existing.ID}, result.Skipped)
+				assert.Len(t, result.Created, 1)
+				conv, _ := manager.GetConversation(existing.ID)
+				assert.Len(t, conv.Messages, 2)
+			},
+		},
+		{
+			mode: ConflictReplace,
+			check: func(t *testing.T, manager *Manager, result *ImportResult) {
+				assert.Equal(t, []uuid.UUID{existing.ID}, result.Replaced)
+				conv, _ := manager.GetConversation(existing.ID)
+				assert.Len(t, conv.Messages, 1)
+			},
+		},
+		{
+			mode: ConflictNew,
+			check: func(t *testing.T, manager *Manager, result *ImportResult) {
+				renamed, ok := result.Renamed[existing.ID.String()]
+				require.True(t, ok)
+				assert.NotEqual(t, existing.ID, renamed)
+				assert.Len(t, manager.GetAllConversations(), 3)
+
+				original, _ := manager.GetConversation(existing.ID)
+				copied, _ := manager.GetConversation(renamed)
+				assert.Len(t, original.Messages, 2)
+				require.Len(t, copied.Messages, 1)
+				assert.NotEqual(t, existing.Messages[0].ID, copied.Messages[0].ID)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			manager := NewManager()
+			_, err := manager.ImportConversations([]*models.Conversation{existing}, ConflictError)
+			require.NoError(t, err)
+
+			// The import edits the existing conversation and adds a new one
+			edited := sampleConversation()
+			edited.ID = existing.ID
+			edited.Messages = edited.Messages[:1]
+			edited.Messages[0].ID = existing.Messages[0].ID
+
+			result, err := manager.ImportConversations([]*models.Conversation{edited, sampleConversation()}, tt.mode)
+			if tt.hasErr {
+				assert.ErrorIs(t, err, ErrConflict)
+			} else {
+				require.NoError(t, err)
+			}
+			tt.check(t, manager, result)
+		})
+	}
+}
+
+func TestImportConversationsForUser(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+
+	manager := NewManager()
+	_, err := manager.ImportConversations([]*models.Conversation{sampleConversation()}, ConflictError)
+	require.NoError(t, err)
+
+	bobs := sampleConversation()
+	bobs.UserID = bob
+	_, err = manager.ImportConversations([]*models.Conversation{bobs}, ConflictError)
+	require.NoError(t, err)
+
+	// Alice imports a copy of Bob's conversation. She can't see his, so it
+	// doesn't conflict, and replace mustn't overwrite it.
+	imported := sampleConversation()
+	imported.ID = bobs.ID
+	result, err := manager.ImportConversationsForUser([]*models.Conversation{imported}, ConflictReplace, alice)
+	require.NoError(t, err)
+	assert.Empty(t, result.Replaced)
+	require.Contains(t, result.Renamed, bobs.ID.String())
+
+	stillBobs, err := manager.GetConversation(bobs.ID)
+	require.NoError(t, err)
+	assert.Equal(t, bob, stillBobs.UserID)
+
+	alicesID := result.Renamed[bobs.ID.String()]
+	alices, err := manager.GetConversation(alicesID)
+	require.NoError(t, err)
+	assert.Equal(t, alice, alices.UserID)
+
+	// Her own conversations still conflict as usual
+	again := sampleConversation()
+	again.ID = alicesID
+	result, err = manager.ImportConversationsForUser([]*models.Conversation{again}, ConflictError, alice)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, []uuid.UUID{alicesID}, result.Conflicts)
+}
+
+func TestImportConversations_Invalid(t *testing.T) {
+	manager := NewManager()
+	conv := sampleConversation()
+
+	_, err := manager.ImportConversations([]*models.Conversation{conv, conv}, ConflictSkip)
+	assert.ErrorIs(t, err, ErrInvalidImport)
+
+	_, err = manager.ImportConversations([]*models.Conversation{{}}, ConflictSkip)
+	assert.ErrorIs(t, err, ErrInvalidImport)
+
+	_, err = ParseConflictMode("merge")
+	assert.ErrorIs(t, err, ErrInvalidImport)
+}
+
+func TestImportConversations_FillsMissingFields(t *testing.T) {
+	manager := NewManager()
+	sent := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	conv := &models.Conversation{
+		ID:       uuid.New(),
+		Messages: []models.Message{{Role: models.MessageRoleUser, Content: "hi", Timestamp: sent}},
+	}
+	_, err := manager.ImportConversations([]*models.Conversation{conv}, ConflictError)
+	require.NoError(t, err)
+
+	imported, err := manager.GetConversation(conv.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, imported.Messages[0].ID)
+	assert.Equal(t, sent, imported.CreatedAt)
+	assert.NotNil(t, imported.Context.UserPreferences)
+	assert.NotNil(t, imported.Context.SessionData)
+
+	// The caller's conversation isn't modified
+	assert.Equal(t, uuid.Nil, conv.Messages[0].ID)
+}
+
+func TestImportConversations_Persists(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "conversations.db")
+	manager, err := NewManagerWithDB(dbPath)
+	require.NoError(t, err)
+
+	conv := sampleConversation()
+	_, err = manager.ImportConversations([]*models.Conversation{conv}, ConflictError)
+	require.NoError(t, err)
+
+	// Replacing with fewer messages removes the others from the database too
+	edited := sampleConversation()
+	edited.ID = conv.ID
+	edited.Messages = edited.Messages[:1]
+	_, err = manager.ImportConversations([]*models.Conversation{edited}, ConflictReplace)
+	require.NoError(t, err)
+	require.NoError(t, manager.Close())
+
+	reopened, err := NewManagerWithDB(dbPath)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	loaded, err := reopened.GetConversation(conv.ID)
+	require.NoError(t, err)
+	assert.Len(t, loaded.Messages, 1)
+}
+
+func TestExportConversations(t *testing.T) {
+	manager := NewManager()
+	first := manager.CreateConversation()
+	second := manager.CreateConversation()
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+
+	all, err := manager.ExportConversations()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, first.ID, all[0].ID)
+
+	one, err := manager.ExportConversations(second.ID)
+	require.NoError(t, err)
+	require.Len(t, one, 1)
+
+	// Exports are copies
+	one[0].Messages = append(one[0].Messages, models.Message{Content: "changed"})
+	assert.Empty(t, second.Messages)
+
+	_, err = manager.ExportConversations(uuid.New())
+	assert.Error(t, err)
+}
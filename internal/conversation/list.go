@@ -0,0 +1,259 @@
+package conversation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPageSize is used when a listing doesn't ask for a page size
+	DefaultPageSize = 50
+	// MaxPageSize caps how many conversations a single page can return
+	MaxPageSize = 500
+	// maxTitleLength is where derived titles are cut off
+	maxTitleLength = 60
+)
+
+// ErrInvalidListOptions is returned when limit or cursor can't be used
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// ListOptions filters and paginates the conversation list. Empty fields don't filter.
+type ListOptions struct {
+	UserID uuid.UUID // owner; uuid.Nil lists every conversation
+	Search string    // words that must all start words of one message
+	Since  time.Time // last message at or after
+	Until  time.Time // last message before
+	Limit  int       // 0 uses DefaultPageSize
+	Cursor string    // opaque cursor from a previous page
+}
+
+// ConversationPage is one page of conversation summaries, most recently active first
+type ConversationPage struct {
+	Conversations []models.ConversationSummary
+	Total         int    // matching conversations across all pages
+	NextCursor    string // empty on the last page
+}
+
+// pageCursor marks the last conversation of a page
+type pageCursor struct {
+	LastMessageAt time.Time `json:"t"`
+	ID            uuid.UUID `json:"id"`
+}
+
+// ListConversations returns summaries of the conversations matching opts, most
+// recently active first. Searches use the SQLite full-text index when the
+// manager has a database and fall back to scanning messages otherwise.
+func (m *Manager) ListConversations(opts ListOptions) (*ConversationPage, error) {
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListOptions, MaxPageSize)
+	}
+
+	var after *pageCursor
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = cursor
+	}
+
+	var searchHits map[uuid.UUID]bool
+	if strings.TrimSpace(opts.Search) != "" && m.db != nil {
+		ids, err := m.db.SearchConversations(opts.Search)
+		if err == nil {
+			searchHits = make(map[uuid.UUID]bool, len(ids))
+			for _, id := range ids {
+				searchHits[id] = true
+			}
+		} else {
+			logrus.WithError(err).Debug("Full-text search unavailable, scanning messages")
+		}
+	}
+	searchTerms := searchWords(opts.Search)
+
+	m.mutex.RLock()
+	summaries := make([]models.ConversationSummary, 0, len(m.conversations))
+	for _, conv := range m.conversations {
+		if opts.UserID != uuid.Nil && conv.UserID != opts.UserID {
+			continue
+		}
+
+		switch {
+		case searchHits != nil:
+			if !searchHits[conv.ID] {
+				continue
+			}
+		case len(searchTerms) > 0:
+			if !containsWords(conv, searchTerms) {
+				continue
+			}
+		}
+
+		summary := Summarize(conv)
+		if !opts.Since.IsZero() && summary.LastMessageAt.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && !summary.LastMessageAt.Before(opts.Until) {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	m.mutex.RUnlock()
+
+	// Newest first, ties broken by ID so pages never overlap or skip
+	before := func(a, b pageCursor) bool {
+		if !a.LastMessageAt.Equal(b.LastMessageAt) {
+			return a.LastMessageAt.After(b.LastMessageAt)
+		}
+		return a.ID.String() < b.ID.String()
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return before(cursorOf(summaries[i]), cursorOf(summaries[j]))
+	})
+
+	page := &ConversationPage{Total: len(summaries)}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(summaries), func(i int) bool {
+			return before(*after, cursorOf(summaries[i]))
+		})
+	}
+
+	end := len(summaries)
+	if start+limit < end {
+		end = start + limit
+		page.NextCursor = encodeCursor(cursorOf(summaries[end-1]))
+	}
+
+	page.Conversations = summaries[start:end]
+	return page, nil
+}
+
+// Summarize describes a conversation without its messages. The title is the
+// start of the first user message, the last message time falls back to the
+// creation time for empty conversations, and referenced devices combine the
+// conversation context with the devices each message referred to.
+func Summarize(conv *models.Conversation) models.ConversationSummary {
+	summary := models.ConversationSummary{
+		ID:            conv.ID,
+		Title:         "New conversation",
+		MessageCount:  len(conv.Messages),
+		CreatedAt:     conv.CreatedAt,
+		LastMessageAt: conv.CreatedAt,
+	}
+
+	devices := make(map[string]bool)
+	for _, id := range conv.Context.ReferencedDevices {
+		devices[id] = true
+	}
+
+	titled := false
+	for i, msg := range conv.Messages {
+		if !titled && msg.Role == models.MessageRoleUser && strings.TrimSpace(msg.Content) != "" {
+			summary.Title = truncateTitle(msg.Content)
+			titled = true
+		}
+		if i == 0 || msg.Timestamp.After(summary.LastMessageAt) {
+			summary.LastMessageAt = msg.Timestamp
+		}
+		for _, id := range msg.Metadata.DevicesReferenced {
+			devices[id] = true
+		}
+	}
+
+	summary.ReferencedDevices = make([]string, 0, len(devices))
+	for id := range devices {
+		summary.ReferencedDevices = append(summary.ReferencedDevices, id)
+	}
+	sort.Strings(summary.ReferencedDevices)
+
+	return summary
+}
+
+func truncateTitle(content string) string {
+	title := strings.Join(strings.Fields(content), " ")
+	runes := []rune(title)
+	if len(runes) <= maxTitleLength {
+		return title
+	}
+
+	// Cut at the last word boundary that fits
+	cut := string(runes[:maxTitleLength])
+	if i := strings.LastIndex(cut, " "); i > maxTitleLength/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// searchWords splits a search into lowercase words, the same way the full-text
+// index does
+func searchWords(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsWords reports whether some message of conv has every word, matching
+// words by prefix like the full-text index
+func containsWords(conv *models.Conversation, words []string) bool {
+	for _, msg := range conv.Messages {
+		messageWords := searchWords(msg.Content)
+		matched := true
+		for _, word := range words {
+			if !hasWordWithPrefix(messageWords, word) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func hasWordWithPrefix(words []string, prefix string) bool {
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func cursorOf(summary models.ConversationSummary) pageCursor {
+	return pageCursor{LastMessageAt: summary.LastMessageAt, ID: summary.ID}
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == uuid.Nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	return &cursor, nil
+}
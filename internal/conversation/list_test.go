@@ -0,0 +1,169 @@
+package conversation
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// addConversation stores a conversation whose messages were sent at the given times
+func addConversation(t *testing.T, manager *Manager, start time.Time, contents ...string) *models.Conversation {
+	t.Helper()
+
+	conv := manager.CreateConversation()
+	for i, content := range contents {
+		role := models.MessageRoleUser
+		if i%2 == 1 {
+			role = models.MessageRoleAssistant
+		}
+		conv.Messages = append(conv.Messages, models.Message{
+			ID:        uuid.New(),
+			Role:      role,
+			Content:   content,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	require.NoError(t, manager.UpdateConversation(conv))
+	return conv
+}
+
+func summaryIDs(summaries []models.ConversationSummary) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(summaries))
+	for _, s := range summaries {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestListConversations_NewestFirstWithPagination(t *testing.T) {
+	manager := NewManager()
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	oldest := addConversation(t, manager, base, "Turn on the lights", "Done")
+	middle := addConversation(t, manager, base.Add(time.Hour), "Lock the door")
+	newest := addConversation(t, manager, base.Add(2*time.Hour), "Open the garage", "Opened")
+
+	page, err := manager.ListConversations(ListOptions{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, []uuid.UUID{newest.ID, middle.ID}, summaryIDs(page.Conversations))
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = manager.ListConversations(ListOptions{Limit: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{oldest.ID}, summaryIDs(page.Conversations))
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestListConversations_DateFilters(t *testing.T) {
+	manager := NewManager()
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	addConversation(t, manager, base, "Turn on the lights")
+	march2 := addConversation(t, manager, base.Add(24*time.Hour), "Lock the door")
+	addConversation(t, manager, base.Add(48*time.Hour), "Open the garage")
+
+	page, err := manager.ListConversations(ListOptions{
+		Since: base.Add(23 * time.Hour),
+		Until: base.Add(47 * time.Hour),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{march2.ID}, summaryIDs(page.Conversations))
+}
+
+func TestListConversations_Search(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	memory := NewManager()
+	persistent, err := NewManagerWithDB(filepath.Join(t.TempDir(), "conversations.db"))
+	require.NoError(t, err)
+	defer persistent.Close()
+
+	for name, manager := range map[string]*Manager{"memory": memory, "sqlite": persistent} {
+		t.Run(name, func(t *testing.T) {
+			lights := addConversation(t, manager, base, "Turn on the kitchen lights", "The kitchen light is on")
+			addConversation(t, manager, base.Add(time.Hour), "Lock the front door")
+
+			page, err := manager.ListConversations(ListOptions{Search: "kitchen light"})
+			require.NoError(t, err)
+			assert.Equal(t, []uuid.UUID{lights.ID}, summaryIDs(page.Conversations))
+
+			// Words that never appear together in a conversation match nothing
+			page, err = manager.ListConversations(ListOptions{Search: "kitchen door"})
+			require.NoError(t, err)
+			assert.Empty(t, page.Conversations)
+
+			// Query syntax characters are treated as plain text
+			page, err = manager.ListConversations(ListOptions{Search: `"lock" OR NEAR(`})
+			require.NoError(t, err)
+			assert.Empty(t, page.Conversations)
+		})
+	}
+}
+
+func TestListConversations_ByUser(t *testing.T) {
+	manager := NewManager()
+	alice, bob := uuid.New(), uuid.New()
+
+	mine := manager.CreateConversationForUser(alice, nil)
+	manager.CreateConversationForUser(bob, nil)
+	manager.CreateConversation()
+
+	page, err := manager.ListConversations(ListOptions{UserID: alice})
+	require.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, []uuid.UUID{mine.ID}, summaryIDs(page.Conversations))
+
+	page, err = manager.ListConversations(ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+}
+
+func TestListConversations_InvalidOptions(t *testing.T) {
+	manager := NewManager()
+
+	_, err := manager.ListConversations(ListOptions{Limit: MaxPageSize + 1})
+	assert.ErrorIs(t, err, ErrInvalidListOptions)
+
+	_, err = manager.ListConversations(ListOptions{Cursor: "not-a-cursor"})
+	assert.ErrorIs(t, err, ErrInvalidListOptions)
+}
+
+func TestSummarize(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	conv := &models.Conversation{
+		ID:        uuid.New(),
+		CreatedAt: created,
+		Context:   models.Context{ReferencedDevices: []string{"light.kitchen"}},
+		Messages: []models.Message{
+			{Role: models.MessageRoleSystem, Content: "You are Luna", Timestamp: created},
+			{Role: models.MessageRoleUser, Content: "  Turn on the\nkitchen lights  " + strings.Repeat(" please", 20), Timestamp: created.Add(time.Minute)},
+			{
+				Role:      models.MessageRoleAssistant,
+				Content:   "Done",
+				Timestamp: created.Add(2 * time.Minute),
+				Metadata:  models.Metadata{DevicesReferenced: []string{"switch.fan", "light.kitchen"}},
+			},
+		},
+	}
+
+	summary := Summarize(conv)
+	assert.Equal(t, conv.ID, summary.ID)
+	assert.Equal(t, 3, summary.MessageCount)
+	assert.Equal(t, created.Add(2*time.Minute), summary.LastMessageAt)
+	assert.Equal(t, []string{"light.kitchen", "switch.fan"}, summary.ReferencedDevices)
+	assert.True(t, strings.HasPrefix(summary.Title, "Turn on the kitchen lights please"))
+	assert.True(t, strings.HasSuffix(summary.Title, "…"))
+	assert.LessOrEqual(t, len([]rune(summary.Title)), maxTitleLength+1)
+
+	empty := Summarize(&models.Conversation{ID: uuid.New(), CreatedAt: created})
+	assert.Equal(t, "New conversation", empty.Title)
+	assert.Equal(t, created, empty.LastMessageAt)
+	assert.Empty(t, empty.ReferencedDevices)
+}
@@ -65,17 +65,29 @@ func (m *Manager) loadConversationsFromDB() error {
 }
 
 func (m *Manager) CreateConversation() *models.Conversation {
+	return m.CreateConversationForUser(uuid.Nil, nil)
+}
+
+// CreateConversationForUser creates a conversation owned by a user, starting
+// from their preferences
+func (m *Manager) CreateConversationForUser(userID uuid.UUID, preferences map[string]string) *models.Conversation {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	prefs := make(map[string]string, len(preferences))
+	for key, value := range preferences {
+		prefs[key] = value
+	}
+
 	conv := &models.Conversation{
 		ID:        uuid.New(),
+		UserID:    userID,
 		Messages:  []models.Message{},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		Context: models.Context{
 			ReferencedDevices: []string{},
-			UserPreferences:   make(map[string]string),
+			UserPreferences:   prefs,
 			SessionData:       make(map[string]any),
 		},
 	}
@@ -96,6 +108,23 @@ func (m *Manager) GetConversation(id uuid.UUID) (*models.Conversation, error) {
 	return conv, nil
 }
 
+// FindMessage returns a message by its ID and the conversation holding it
+func (m *Manager) FindMessage(id uuid.UUID) (*models.Conversation, *models.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, conv := range m.conversations {
+		for i := range conv.Messages {
+			if conv.Messages[i].ID == id {
+				message := conv.Messages[i]
+				return conv, &message, nil
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("message not found: %s", id)
+}
+
 func (m *Manager) UpdateConversation(conv *models.Conversation) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -127,6 +156,14 @@ func (m *Manager) DeleteConversation(id uuid.UUID) error {
 	}
 
 	delete(m.conversations, id)
+
+	// Remove it from the database too, or it would come back on the next start
+	if m.db != nil {
+		if err := m.db.DeleteConversation(id); err != nil {
+			logrus.Warnf("Failed to delete conversation from database: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -38,6 +38,21 @@ func TestCreateConversation(t *testing.T) {
 	assert.Equal(t, conv.ID, storedConv.ID)
 }
 
+func TestCreateConversationForUser(t *testing.T) {
+	manager := NewManager()
+	userID := uuid.New()
+	prefs := map[string]string{"temperature_unit": "celsius"}
+
+	conv := manager.CreateConversationForUser(userID, prefs)
+
+	assert.Equal(t, userID, conv.UserID)
+	assert.Equal(t, prefs, conv.Context.UserPreferences)
+
+	// The conversation has its own copy of the preferences
+	prefs["temperature_unit"] = "fahrenheit"
+	assert.Equal(t, "celsius", conv.Context.UserPreferences["temperature_unit"])
+}
+
 func TestGetConversation(t *testing.T) {
 	manager := NewManager()
 
@@ -55,6 +70,22 @@ func TestGetConversation(t *testing.T) {
 	assert.Equal(t, conv.CreatedAt, retrievedConv.CreatedAt)
 }
 
+func TestFindMessage(t *testing.T) {
+	manager := NewManager()
+	manager.CreateConversation()
+	conv := manager.CreateConversation()
+	message := models.Message{ID: uuid.New(), Role: models.MessageRoleAssistant, Content: "Hello"}
+	require.NoError(t, manager.AddMessage(conv.ID, message))
+
+	foundConv, foundMessage, err := manager.FindMessage(message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, conv.ID, foundConv.ID)
+	assert.Equal(t, "Hello", foundMessage.Content)
+
+	_, _, err = manager.FindMessage(uuid.New())
+	assert.Error(t, err)
+}
+
 func TestUpdateConversation(t *testing.T) {
 	manager := NewManager()
 	conv := manager.CreateConversation()
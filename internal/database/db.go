@@ -3,8 +3,11 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -13,8 +16,13 @@ import (
 	"github.com/tienpdinh/gpt-home/pkg/models"
 )
 
+// ErrFullTextUnavailable is returned by SearchConversations when this SQLite build
+// has no full-text search module
+var ErrFullTextUnavailable = errors.New("full-text search is not available")
+
 type DB struct {
 	conn *sql.DB
+	fts  string // full-text module indexing messages: fts5, fts4 or empty
 }
 
 // New creates a new database connection and initializes the schema
@@ -62,6 +70,23 @@ func (db *DB) initSchema() error {
 
 	CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages(conversation_id);
 	CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		admin INTEGER NOT NULL DEFAULT 0,
+		preferences_data TEXT,
+		created_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at DATETIME,
+		expires_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
 	`
 
 	_, err := db.conn.Exec(schema)
@@ -69,9 +94,89 @@ func (db *DB) initSchema() error {
 		return fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
+	// Databases from before user accounts have no conversation owner column
+	if err := db.addColumn("conversations", "user_id", "TEXT"); err != nil {
+		return err
+	}
+
+	return db.initFullTextSearch()
+}
+
+// addColumn adds a column to a table created by an older version, if it's missing
+func (db *DB) addColumn(table, column, definition string) error {
+	rows, err := db.conn.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect %s table: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.conn.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// initFullTextSearch indexes message content with FTS5, or FTS4 when SQLite was
+// built without FTS5 (go-sqlite3 needs the sqlite_fts5 build tag for it). Without
+// either, searches fall back to scanning conversations in memory.
+func (db *DB) initFullTextSearch() error {
+	tables := []struct{ module, schema string }{
+		{"fts5", `CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(content, message_id UNINDEXED, conversation_id UNINDEXED)`},
+		{"fts4", `CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts4(content, message_id, conversation_id, notindexed=message_id, notindexed=conversation_id)`},
+	}
+
+	for _, table := range tables {
+		if _, err := db.conn.Exec(table.schema); err != nil {
+			logrus.Debugf("SQLite %s unavailable: %v", table.module, err)
+			continue
+		}
+		db.fts = table.module
+		break
+	}
+
+	if db.fts == "" {
+		logrus.Warn("SQLite full-text search unavailable, conversation search will scan messages")
+		return nil
+	}
+
+	// Index messages saved before the index existed
+	var indexed int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM messages_fts`).Scan(&indexed); err != nil {
+		return fmt.Errorf("failed to inspect search index: %w", err)
+	}
+	if indexed == 0 {
+		if _, err := db.conn.Exec(`
+			INSERT INTO messages_fts (content, message_id, conversation_id)
+			SELECT content, id, conversation_id FROM messages
+		`); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// FullTextEngine returns the SQLite module indexing messages, or "" if there is none
+func (db *DB) FullTextEngine() string {
+	return db.fts
+}
+
 // SaveConversation saves a conversation and all its messages to the database
 func (db *DB) SaveConversation(conv *models.Conversation) error {
 	tx, err := db.conn.Begin()
@@ -87,13 +192,25 @@ func (db *DB) SaveConversation(conv *models.Conversation) error {
 	}
 
 	_, err = tx.Exec(`
-		INSERT OR REPLACE INTO conversations (id, created_at, updated_at, context_data)
-		VALUES (?, ?, ?, ?)
-	`, conv.ID.String(), conv.CreatedAt, conv.UpdatedAt, string(contextJSON))
+		INSERT OR REPLACE INTO conversations (id, user_id, created_at, updated_at, context_data)
+		VALUES (?, ?, ?, ?, ?)
+	`, conv.ID.String(), nullableID(conv.UserID), conv.CreatedAt, conv.UpdatedAt, string(contextJSON))
 	if err != nil {
 		return fmt.Errorf("failed to save conversation: %w", err)
 	}
 
+	// Replace the stored messages so ones removed from the conversation don't linger
+	if _, err := tx.Exec(`DELETE FROM messages WHERE conversation_id = ?`, conv.ID.String()); err != nil {
+		return fmt.Errorf("failed to clear messages: %w", err)
+	}
+
+	// Reindex the conversation's messages for search
+	if db.fts != "" {
+		if _, err := tx.Exec(`DELETE FROM messages_fts WHERE conversation_id = ?`, conv.ID.String()); err != nil {
+			return fmt.Errorf("failed to clear search index: %w", err)
+		}
+	}
+
 	// Save messages
 	for _, msg := range conv.Messages {
 		metadataJSON, err := json.Marshal(msg.Metadata)
@@ -108,6 +225,15 @@ func (db *DB) SaveConversation(conv *models.Conversation) error {
 		if err != nil {
 			return fmt.Errorf("failed to save message: %w", err)
 		}
+
+		if db.fts != "" {
+			_, err = tx.Exec(`
+				INSERT INTO messages_fts (content, message_id, conversation_id) VALUES (?, ?, ?)
+			`, msg.Content, msg.ID.String(), conv.ID.String())
+			if err != nil {
+				return fmt.Errorf("failed to index message: %w", err)
+			}
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -120,11 +246,12 @@ func (db *DB) SaveConversation(conv *models.Conversation) error {
 // GetConversation retrieves a conversation by ID from the database
 func (db *DB) GetConversation(id uuid.UUID) (*models.Conversation, error) {
 	var contextJSON string
+	var userID sql.NullString
 	var createdAt, updatedAt time.Time
 
 	err := db.conn.QueryRow(`
-		SELECT created_at, updated_at, context_data FROM conversations WHERE id = ?
-	`, id.String()).Scan(&createdAt, &updatedAt, &contextJSON)
+		SELECT user_id, created_at, updated_at, context_data FROM conversations WHERE id = ?
+	`, id.String()).Scan(&userID, &createdAt, &updatedAt, &contextJSON)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("conversation not found: %s", id)
@@ -186,8 +313,14 @@ func (db *DB) GetConversation(id uuid.UUID) (*models.Conversation, error) {
 		return nil, fmt.Errorf("failed to parse conversation ID: %w", err)
 	}
 
+	owner, err := parseNullableID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse conversation owner: %w", err)
+	}
+
 	return &models.Conversation{
 		ID:        convID,
+		UserID:    owner,
 		Messages:  messages,
 		CreatedAt: createdAt,
 		UpdatedAt: updatedAt,
@@ -211,13 +344,78 @@ func (db *DB) DeleteConversation(id uuid.UUID) error {
 		return fmt.Errorf("conversation not found: %s", id)
 	}
 
+	// Foreign keys aren't enforced, so remove the messages and their index entries too
+	if _, err := db.conn.Exec(`DELETE FROM messages WHERE conversation_id = ?`, id.String()); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+	if db.fts != "" {
+		if _, err := db.conn.Exec(`DELETE FROM messages_fts WHERE conversation_id = ?`, id.String()); err != nil {
+			return fmt.Errorf("failed to delete search index entries: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// SearchConversations returns the IDs of conversations with a message containing
+// every word of query. Words match by prefix, so "light" also finds "lights".
+func (db *DB) SearchConversations(query string) ([]uuid.UUID, error) {
+	if db.fts == "" {
+		return nil, ErrFullTextUnavailable
+	}
+
+	match := fullTextQuery(query)
+	if match == "" {
+		return []uuid.UUID{}, nil
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT DISTINCT conversation_id FROM messages_fts WHERE messages_fts MATCH ?
+	`, match)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		convID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse conversation ID: %w", err)
+		}
+		ids = append(ids, convID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search results: %w", err)
+	}
+
+	return ids, nil
+}
+
+// fullTextQuery turns free text into a MATCH expression of prefix terms. Only
+// letters and digits are kept and terms are lowercased so user input can't form
+// operators (AND, NEAR, column filters) or break the query syntax.
+func fullTextQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, word+"*")
+	}
+	return strings.Join(terms, " ")
+}
+
 // GetAllConversations retrieves all conversations from the database
 func (db *DB) GetAllConversations() ([]*models.Conversation, error) {
 	rows, err := db.conn.Query(`
-		SELECT id, created_at, updated_at, context_data FROM conversations
+		SELECT id, user_id, created_at, updated_at, context_data FROM conversations
 		ORDER BY updated_at DESC
 	`)
 	if err != nil {
@@ -228,9 +426,10 @@ func (db *DB) GetAllConversations() ([]*models.Conversation, error) {
 	conversations := []*models.Conversation{}
 	for rows.Next() {
 		var id, contextJSON string
+		var userID sql.NullString
 		var createdAt, updatedAt time.Time
 
-		if err := rows.Scan(&id, &createdAt, &updatedAt, &contextJSON); err != nil {
+		if err := rows.Scan(&id, &userID, &createdAt, &updatedAt, &contextJSON); err != nil {
 			return nil, fmt.Errorf("failed to scan conversation: %w", err)
 		}
 
@@ -239,6 +438,11 @@ func (db *DB) GetAllConversations() ([]*models.Conversation, error) {
 			return nil, fmt.Errorf("failed to parse conversation ID: %w", err)
 		}
 
+		owner, err := parseNullableID(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse conversation owner: %w", err)
+		}
+
 		var context models.Context
 		if err := json.Unmarshal([]byte(contextJSON), &context); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal context: %w", err)
@@ -292,6 +496,7 @@ func (db *DB) GetAllConversations() ([]*models.Conversation, error) {
 
 		conversations = append(conversations, &models.Conversation{
 			ID:        convID,
+			UserID:    owner,
 			Messages:  messages,
 			CreatedAt: createdAt,
 			UpdatedAt: updatedAt,
@@ -306,6 +511,21 @@ func (db *DB) GetAllConversations() ([]*models.Conversation, error) {
 	return conversations, nil
 }
 
+// nullableID stores uuid.Nil as NULL
+func nullableID(id uuid.UUID) any {
+	if id == uuid.Nil {
+		return nil
+	}
+	return id.String()
+}
+
+func parseNullableID(id sql.NullString) (uuid.UUID, error) {
+	if !id.Valid || id.String == "" {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(id.String)
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	return db.conn.Close()
@@ -204,3 +204,90 @@ func TestDBUpdateConversation(t *testing.T) {
 	assert.Equal(t, 2, len(retrieved.Messages))
 	assert.Equal(t, "Hi there!", retrieved.Messages[1].Content)
 }
+
+func TestDBSearchConversations(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_*.db")
+	require.NoError(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	db, err := New(tmpFile.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	if db.FullTextEngine() == "" {
+		t.Skip("SQLite built without full-text search")
+	}
+
+	lights := &models.Conversation{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Messages: []models.Message{
+			{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Turn on the kitchen lights", Timestamp: time.Now()},
+		},
+	}
+	door := &models.Conversation{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Messages: []models.Message{
+			{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Lock the front door", Timestamp: time.Now()},
+		},
+	}
+	require.NoError(t, db.SaveConversation(lights))
+	require.NoError(t, db.SaveConversation(door))
+
+	// Saving again reindexes instead of duplicating
+	require.NoError(t, db.SaveConversation(lights))
+
+	ids, err := db.SearchConversations("KITCHEN light")
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{lights.ID}, ids)
+
+	ids, err = db.SearchConversations(`"front" (door:`)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{door.ID}, ids)
+
+	require.NoError(t, db.DeleteConversation(door.ID))
+	ids, err = db.SearchConversations("door")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestDBSearchIndexesExistingMessages(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_*.db")
+	require.NoError(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	db, err := New(tmpFile.Name())
+	require.NoError(t, err)
+	if db.FullTextEngine() == "" {
+		db.Close()
+		t.Skip("SQLite built without full-text search")
+	}
+
+	conv := &models.Conversation{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Messages: []models.Message{
+			{ID: uuid.New(), Role: models.MessageRoleUser, Content: "Open the garage", Timestamp: time.Now()},
+		},
+	}
+	require.NoError(t, db.SaveConversation(conv))
+
+	// Simulate a database written before the search index existed
+	_, err = db.conn.Exec(`DROP TABLE messages_fts`)
+	require.NoError(t, err)
+	db.Close()
+
+	db, err = New(tmpFile.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	ids, err := db.SearchConversations("garage")
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{conv.ID}, ids)
+}
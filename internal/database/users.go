@@ -0,0 +1,156 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// UserRecord is a stored user account and its password hash
+type UserRecord struct {
+	User         models.User
+	PasswordHash string
+}
+
+// SessionRecord is a stored login session. Only a hash of the session token is
+// kept, so the database alone can't be used to sign in.
+type SessionRecord struct {
+	TokenHash string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// SaveUser creates or updates a user account
+func (db *DB) SaveUser(record UserRecord) error {
+	preferencesJSON, err := json.Marshal(record.User.Preferences)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preferences: %w", err)
+	}
+
+	_, err = db.conn.Exec(`
+		INSERT INTO users (id, username, password_hash, admin, preferences_data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			username = excluded.username,
+			password_hash = excluded.password_hash,
+			admin = excluded.admin,
+			preferences_data = excluded.preferences_data
+	`, record.User.ID.String(), record.User.Username, record.PasswordHash, record.User.Admin,
+		string(preferencesJSON), record.User.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save user: %w", err)
+	}
+
+	return nil
+}
+
+// GetAllUsers retrieves every user account, oldest first
+func (db *DB) GetAllUsers() ([]UserRecord, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, username, password_hash, admin, preferences_data, created_at FROM users
+		ORDER BY created_at ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	records := []UserRecord{}
+	for rows.Next() {
+		var id, username, passwordHash string
+		var preferencesJSON sql.NullString
+		var admin bool
+		var createdAt time.Time
+
+		if err := rows.Scan(&id, &username, &passwordHash, &admin, &preferencesJSON, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+
+		userID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse user ID: %w", err)
+		}
+
+		preferences := make(map[string]string)
+		if preferencesJSON.Valid && preferencesJSON.String != "" {
+			if err := json.Unmarshal([]byte(preferencesJSON.String), &preferences); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal preferences: %w", err)
+			}
+		}
+
+		records = append(records, UserRecord{
+			User: models.User{
+				ID:          userID,
+				Username:    username,
+				Admin:       admin,
+				Preferences: preferences,
+				CreatedAt:   createdAt,
+			},
+			PasswordHash: passwordHash,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+
+	return records, nil
+}
+
+// SaveSession stores a login session
+func (db *DB) SaveSession(session SessionRecord) error {
+	_, err := db.conn.Exec(`
+		INSERT OR REPLACE INTO sessions (token_hash, user_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?)
+	`, session.TokenHash, session.UserID.String(), session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession removes a login session. Removing one that doesn't exist is not an error.
+func (db *DB) DeleteSession(tokenHash string) error {
+	if _, err := db.conn.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// GetActiveSessions removes expired sessions and returns the rest
+func (db *DB) GetActiveSessions(now time.Time) ([]SessionRecord, error) {
+	if _, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+		return nil, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	rows, err := db.conn.Query(`SELECT token_hash, user_id, created_at, expires_at FROM sessions`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []SessionRecord{}
+	for rows.Next() {
+		var session SessionRecord
+		var userID string
+		if err := rows.Scan(&session.TokenHash, &userID, &session.CreatedAt, &session.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if session.UserID, err = uuid.Parse(userID); err != nil {
+			return nil, fmt.Errorf("failed to parse session user ID: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func newTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+
+	tmpFile, err := os.CreateTemp("", "test_*.db")
+	require.NoError(t, err)
+	tmpFile.Close()
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	db, err := New(tmpFile.Name())
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db, tmpFile.Name()
+}
+
+func TestDBSaveAndGetUsers(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	alice := UserRecord{
+		User: models.User{
+			ID:          uuid.New(),
+			Username:    "alice",
+			Admin:       true,
+			Preferences: map[string]string{"temperature_unit": "celsius"},
+			CreatedAt:   time.Now().Add(-time.Hour),
+		},
+		PasswordHash: "hash-a",
+	}
+	bob := UserRecord{
+		User:         models.User{ID: uuid.New(), Username: "bob", CreatedAt: time.Now()},
+		PasswordHash: "hash-b",
+	}
+	require.NoError(t, db.SaveUser(alice))
+	require.NoError(t, db.SaveUser(bob))
+
+	// Updating keeps the account and changes its fields
+	bob.User.Preferences = map[string]string{"brightness": "50%"}
+	require.NoError(t, db.SaveUser(bob))
+
+	// Usernames are unique regardless of case
+	clash := UserRecord{User: models.User{ID: uuid.New(), Username: "ALICE", CreatedAt: time.Now()}, PasswordHash: "x"}
+	assert.Error(t, db.SaveUser(clash))
+
+	users, err := db.GetAllUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice", users[0].User.Username)
+	assert.True(t, users[0].User.Admin)
+	assert.Equal(t, "hash-a", users[0].PasswordHash)
+	assert.Equal(t, "celsius", users[0].User.Preferences["temperature_unit"])
+	assert.Equal(t, "50%", users[1].User.Preferences["brightness"])
+}
+
+func TestDBSessions(t *testing.T) {
+	db, _ := newTestDB(t)
+	now := time.Now()
+	userID := uuid.New()
+
+	active := SessionRecord{TokenHash: "active", UserID: userID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	expired := SessionRecord{TokenHash: "expired", UserID: userID, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	require.NoError(t, db.SaveSession(active))
+	require.NoError(t, db.SaveSession(expired))
+
+	sessions, err := db.GetActiveSessions(now)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "active", sessions[0].TokenHash)
+	assert.Equal(t, userID, sessions[0].UserID)
+
+	require.NoError(t, db.DeleteSession("active"))
+	require.NoError(t, db.DeleteSession("unknown"))
+	sessions, err = db.GetActiveSessions(now)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestDBConversationOwner(t *testing.T) {
+	db, path := newTestDB(t)
+
+	owned := &models.Conversation{ID: uuid.New(), UserID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	unowned := &models.Conversation{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	require.NoError(t, db.SaveConversation(owned))
+	require.NoError(t, db.SaveConversation(unowned))
+
+	retrieved, err := db.GetConversation(owned.ID)
+	require.NoError(t, err)
+	assert.Equal(t, owned.UserID, retrieved.UserID)
+
+	retrieved, err = db.GetConversation(unowned.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, retrieved.UserID)
+
+	// Opening the database again doesn't add the owner column twice
+	db.Close()
+	db, err = New(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	all, err := db.GetAllConversations()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+}
+
+func TestDBAddsOwnerColumnToOldDatabases(t *testing.T) {
+	db, path := newTestDB(t)
+
+	// Simulate a database written before user accounts existed
+	_, err := db.conn.Exec(`DROP TABLE conversations`)
+	require.NoError(t, err)
+	_, err = db.conn.Exec(`CREATE TABLE conversations (id TEXT PRIMARY KEY, created_at DATETIME, updated_at DATETIME, context_data TEXT)`)
+	require.NoError(t, err)
+	_, err = db.conn.Exec(`INSERT INTO conversations VALUES (?, ?, ?, '{}')`, uuid.New().String(), time.Now(), time.Now())
+	require.NoError(t, err)
+	db.Close()
+
+	db, err = New(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	all, err := db.GetAllConversations()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, uuid.Nil, all[0].UserID)
+}
@@ -0,0 +1,259 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// ErrAccessDenied is returned when a principal may not control a device or use an action
+var ErrAccessDenied = errors.New("access denied")
+
+// ReadAction is the action named in an AccessDeniedError for a device that can't be read
+const ReadAction = "read"
+
+// AccessRule selects devices by entity ID, area or domain, and the actions allowed
+// on them. A device matches if any of its lists match; a rule with no devices,
+// areas or domains matches every device. Empty Actions allows every action.
+// Devices of a Home Assistant instance are only matched by their prefixed ID,
+// as in cabin:light.porch, so a rule never reaches into another instance.
+type AccessRule struct {
+	Devices []string `json:"devices,omitempty"`
+	Areas   []string `json:"areas,omitempty"`
+	Domains []string `json:"domains,omitempty"`
+	Actions []string `json:"actions,omitempty"` // only used by control rules
+}
+
+// AccessPolicy lists the devices a principal may read and those it may control.
+// Devices that can be controlled can always be read.
+type AccessPolicy struct {
+	Read    []AccessRule `json:"read,omitempty"`
+	Control []AccessRule `json:"control,omitempty"`
+}
+
+// ACL maps principals (usernames) to named policies. Principals that aren't
+// listed, including everyone when user accounts are disabled, get the default
+// policy; with no default they have full access.
+type ACL struct {
+	Policies   map[string]AccessPolicy `json:"policies"`
+	Principals map[string]string       `json:"principals"`
+	Default    string                  `json:"default,omitempty"`
+}
+
+// AccessDeniedError says who was refused which action on which device
+type AccessDeniedError struct {
+	Principal  string
+	DeviceID   string
+	DeviceName string
+	Action     string
+}
+
+func (e *AccessDeniedError) Error() string {
+	principal := e.Principal
+	if principal == "" {
+		principal = "anonymous user"
+	}
+	return fmt.Sprintf("%s may not %s %s", principal, e.Action, e.DeviceID)
+}
+
+func (e *AccessDeniedError) Unwrap() error {
+	return ErrAccessDenied
+}
+
+// LoadACL reads an ACL from a JSON file
+func LoadACL(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL file: %w", err)
+	}
+
+	var acl ACL
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL file: %w", err)
+	}
+	if err := acl.Validate(); err != nil {
+		return nil, err
+	}
+	return &acl, nil
+}
+
+// Validate checks that every principal and the default refer to a defined policy
+func (a *ACL) Validate() error {
+	if a.Default != "" {
+		if _, ok := a.Policies[a.Default]; !ok {
+			return fmt.Errorf("default policy %q is not defined", a.Default)
+		}
+	}
+	for principal, name := range a.Principals {
+		if _, ok := a.Policies[name]; !ok {
+			return fmt.Errorf("policy %q for %s is not defined", name, principal)
+		}
+	}
+	return nil
+}
+
+// policyFor returns the policy that applies to principal, or nil for full access
+func (a *ACL) policyFor(principal string) *AccessPolicy {
+	if a == nil {
+		return nil
+	}
+	name, listed := a.Principals[principal]
+	if !listed {
+		for candidate, policy := range a.Principals {
+			if strings.EqualFold(candidate, principal) {
+				name, listed = policy, true
+				break
+			}
+		}
+	}
+	if !listed {
+		name = a.Default
+	}
+	if name == "" {
+		return nil
+	}
+	policy := a.Policies[name]
+	return &policy
+}
+
+// CanRead reports whether principal may see a device and its state
+func (a *ACL) CanRead(principal string, device models.Device) bool {
+	policy := a.policyFor(principal)
+	if policy == nil {
+		return true
+	}
+	for _, rules := range [][]AccessRule{policy.Read, policy.Control} {
+		for _, rule := range rules {
+			if rule.matchesDevice(device) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanControl reports whether principal may use action on a device
+func (a *ACL) CanControl(principal string, device models.Device, action string) bool {
+	policy := a.policyFor(principal)
+	if policy == nil {
+		return true
+	}
+	for _, rule := range policy.Control {
+		if rule.matchesDevice(device) && rule.allowsAction(action) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r AccessRule) matchesDevice(device models.Device) bool {
+	if len(r.Devices) == 0 && len(r.Areas) == 0 && len(r.Domains) == 0 {
+		return true
+	}
+	for _, id := range r.Devices {
+		if strings.EqualFold(id, device.ID) || (device.Instance == "" && strings.EqualFold(id, device.EntityID)) {
+			return true
+		}
+	}
+	for _, area := range r.Areas {
+		if InArea(device, area) {
+			return true
+		}
+	}
+	domain := device.Domain
+	if domain == "" {
+		_, entityID := SplitDeviceID(device.ID)
+		domain, _, _ = strings.Cut(entityID, ".")
+	}
+	for _, d := range r.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r AccessRule) allowsAction(action string) bool {
+	if len(r.Actions) == 0 {
+		return true
+	}
+	for _, allowed := range r.Actions {
+		if strings.EqualFold(allowed, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// refusalPhrases describe actions in a refusal, as in "you're not allowed to unlock ..."
+var refusalPhrases = map[string]string{
+	ReadAction:        "check on",
+	"turn_on":         "turn on",
+	"turn_off":        "turn off",
+	"toggle":          "switch",
+	"set_brightness":  "change the brightness of",
+	"set_color":       "change the color of",
+	"set_temperature": "change the temperature on",
+	"set_hvac_mode":   "change the mode of",
+	"set_position":    "move",
+	"set_speed":       "change the speed of",
+	"volume_set":      "change the volume of",
+}
+
+// ExplainRefusal turns access denials into a polite sentence for the user, naming
+// the devices refused for each action. It returns "" when nothing was refused.
+func ExplainRefusal(denials []*AccessDeniedError) string {
+	if len(denials) == 0 {
+		return ""
+	}
+
+	var actions []string
+	names := make(map[string][]string)
+	for _, denial := range denials {
+		name := denial.DeviceName
+		if name == "" {
+			name = denial.DeviceID
+		}
+		if _, seen := names[denial.Action]; !seen {
+			actions = append(actions, denial.Action)
+		}
+		if !containsString(names[denial.Action], name) {
+			names[denial.Action] = append(names[denial.Action], name)
+		}
+	}
+
+	clauses := make([]string, 0, len(actions))
+	for _, action := range actions {
+		phrase, ok := refusalPhrases[action]
+		if !ok {
+			phrase = strings.ReplaceAll(action, "_", " ")
+		}
+		devices := names[action]
+		sort.Strings(devices)
+		clauses = append(clauses, phrase+" "+joinOr(devices))
+	}
+
+	return fmt.Sprintf("Sorry, you're not allowed to %s. Please ask someone in the household who can do that for you.", joinOr(clauses))
+}
+
+// joinOr lists items as "a", "a or b" or "a, b or c"
+func joinOr(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+func testACL() *ACL {
+	return &ACL{
+		Policies: map[string]AccessPolicy{
+			"kids": {
+				Read:    []AccessRule{{}},
+				Control: []AccessRule{{Domains: []string{"light"}, Actions: []string{"turn_on", "turn_off"}}},
+			},
+			"guests": {
+				Control: []AccessRule{{Areas: []string{"bedroom"}}},
+			},
+		},
+		Principals: map[string]string{"emma": "kids"},
+		Default:    "guests",
+	}
+}
+
+func TestACL_Policies(t *testing.T) {
+	acl := testACL()
+	light := models.Device{ID: "light.bedroom", Name: "Bedroom Light", Domain: "light"}
+	thermostat := models.Device{ID: "climate.main", Name: "Main Thermostat", Domain: "climate"}
+
+	tests := []struct {
+		name      string
+		principal string
+		device    models.Device
+		action    string
+		read      bool
+		control   bool
+	}{
+		{"kid turns on a light", "emma", light, "turn_on", true, true},
+		{"usernames ignore case", "Emma", light, "turn_off", true, true},
+		{"kid changes brightness", "emma", light, "set_brightness", true, false},
+		{"kid changes the thermostat", "emma", thermostat, "set_temperature", true, false},
+		{"guest in the guest room", "visitor", light, "set_brightness", true, true},
+		{"guest elsewhere", "visitor", thermostat, "set_temperature", false, false},
+		{"accounts disabled", "", thermostat, "set_temperature", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.read, acl.CanRead(tt.principal, tt.device))
+			assert.Equal(t, tt.control, acl.CanControl(tt.principal, tt.device, tt.action))
+		})
+	}
+
+	var none *ACL
+	assert.True(t, none.CanControl("anyone", thermostat, "set_temperature"))
+}
+
+func TestLoadACL(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "acl.json")
+	require.NoError(t, os.WriteFile(valid, []byte(`{
+		"policies": {"kids": {"control": [{"domains": ["light"]}]}},
+		"principals": {"emma": "kids"}
+	}`), 0o600))
+
+	acl, err := LoadACL(valid)
+	require.NoError(t, err)
+	assert.True(t, acl.CanControl("emma", models.Device{ID: "light.bedroom"}, "toggle"))
+	assert.True(t, acl.CanControl("dad", models.Device{ID: "lock.front_door"}, "unlock"))
+
+	undefined := filepath.Join(dir, "undefined.json")
+	require.NoError(t, os.WriteFile(undefined, []byte(`{"principals": {"emma": "kids"}}`), 0o600))
+	_, err = LoadACL(undefined)
+	assert.Error(t, err)
+
+	_, err = LoadACL(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestExecuteActionAs_Denied(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManagerWithOptions(mockClient, ManagerOptions{ACL: testACL()})
+
+	err := manager.ExecuteActionAs(context.Background(), "emma", "climate.main", models.DeviceAction{
+		Action:     "set_temperature",
+		Parameters: map[string]any{"temperature": 21.0},
+	})
+	require.ErrorIs(t, err, ErrAccessDenied)
+	var denied *AccessDeniedError
+	require.True(t, errors.As(err, &denied))
+	assert.Equal(t, "Main Thermostat", denied.DeviceName)
+
+	require.NoError(t, manager.ExecuteActionAs(context.Background(), "emma", "light.living_room", models.DeviceAction{Action: "turn_on"}))
+	assert.Len(t, mockClient.ServiceCalls(), 1)
+}
+
+func TestExecuteBulkAs_DeniedExecutesNothing(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManagerWithOptions(mockClient, ManagerOptions{ACL: testACL()})
+
+	results, executed := manager.ExecuteBulkAs(context.Background(), "emma", []models.BulkActionItem{
+		{Targets: []string{"light.living_room", "switch.porch"}, Action: models.DeviceAction{Action: "turn_on"}},
+	})
+	assert.False(t, executed)
+	require.Len(t, results, 2)
+	assert.Equal(t, models.ActionStatusSkipped, results[0].Status)
+	assert.Equal(t, models.ActionStatusForbidden, results[1].Status)
+	assert.Empty(t, mockClient.ServiceCalls())
+}
+
+func TestListDevices_OnlyReadable(t *testing.T) {
+	manager := NewManagerWithOptions(mocks.NewMockHomeAssistantClient(), ManagerOptions{ACL: testACL()})
+
+	page, err := manager.ListDevices(context.Background(), ListOptions{Principal: "visitor"})
+	require.NoError(t, err)
+	require.Len(t, page.Devices, 1)
+	assert.Equal(t, "light.bedroom", page.Devices[0].ID)
+
+	assert.True(t, manager.CanRead(context.Background(), "emma", "climate.main"))
+	assert.False(t, manager.CanRead(context.Background(), "visitor", "climate.main"))
+	assert.False(t, manager.CanRead(context.Background(), "emma", "nonexistent.device"))
+}
+
+func TestFindReadableDevicesForQuery(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	// Enough unreadable lights sorting before the bedroom to fill a capped match
+	for i := 0; i < maxQueryDevices; i++ {
+		id := fmt.Sprintf("light.attic_%02d", i)
+		mockClient.AddMockEntity(models.Device{ID: id, EntityID: id, Name: fmt.Sprintf("Attic %d", i), Type: models.DeviceTypeLight, Domain: "light", State: "off"})
+	}
+	manager := NewManagerWithOptions(mockClient, ManagerOptions{ACL: testACL()})
+	ctx := context.Background()
+
+	readable, denied, err := manager.FindReadableDevicesForQuery(ctx, "visitor", "are the lights on?")
+	require.NoError(t, err)
+	assert.Empty(t, denied)
+	require.Len(t, readable, 1)
+	assert.Equal(t, "light.bedroom", readable[0].ID)
+
+	readable, denied, err = manager.FindReadableDevicesForQuery(ctx, "visitor", "what's the thermostat set to?")
+	require.NoError(t, err)
+	assert.Empty(t, readable)
+	require.Len(t, denied, 1)
+	assert.Equal(t, "climate.main", denied[0].ID)
+}
+
+func TestExplainRefusal(t *testing.T) {
+	assert.Equal(t, "", ExplainRefusal(nil))
+
+	refusal := ExplainRefusal([]*AccessDeniedError{
+		{DeviceID: "climate.main", DeviceName: "Main Thermostat", Action: "set_temperature"},
+		{DeviceID: "cover.garage_door", DeviceName: "Garage Door", Action: "open"},
+		{DeviceID: "lock.back_door", Action: "open"},
+	})
+	assert.Equal(t, "Sorry, you're not allowed to change the temperature on Main Thermostat or open Garage Door or lock.back_door. "+
+		"Please ask someone in the household who can do that for you.", refusal)
+}
@@ -0,0 +1,147 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/tienpdinh/gpt-home/internal/events"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxConcurrentServiceCalls bounds how many grouped service calls run at once
+const maxConcurrentServiceCalls = 8
+
+// serviceGroup is one HomeAssistant service call shared by several targets
+type serviceGroup struct {
+	call    *serviceCall
+	targets []int // indexes into the result slice
+}
+
+// ExecuteBulk validates every target of every item before executing any of them.
+// If any target fails validation nothing is executed: invalid targets report a
+// validation error and the rest are marked skipped. Otherwise targets that map to
+// the same service call with the same data are sent to HomeAssistant as one
+// multi-entity call, and independent calls run concurrently.
+func (m *Manager) ExecuteBulk(ctx context.Context, items []models.BulkActionItem) ([]models.TargetResult, bool) {
+	return m.ExecuteBulkAs(ctx, "", items)
+}
+
+// ExecuteBulkAs is ExecuteBulk on behalf of principal. Targets the ACL doesn't
+// allow are reported as forbidden and, like invalid ones, stop the whole batch.
+func (m *Manager) ExecuteBulkAs(ctx context.Context, principal string, items []models.BulkActionItem) ([]models.TargetResult, bool) {
+	var results []models.TargetResult
+	var calls []*serviceCall
+	valid := true
+
+	for _, item := range items {
+		for _, target := range item.Targets {
+			result := models.TargetResult{Target: target, Action: item.Action.Action}
+
+			// Validation may rewrite parameters, so each target gets its own copy
+			call, warning, err := m.prepareAction(ctx, principal, target, copyAction(item.Action))
+			if err != nil {
+				result.Status = actionErrorStatus(err)
+				result.Error = err.Error()
+				valid = false
+			}
+			result.Warning = warning
+
+			results = append(results, result)
+			calls = append(calls, call)
+		}
+	}
+
+	if !valid {
+		for i := range results {
+			if results[i].Status == "" {
+				results[i].Status = models.ActionStatusSkipped
+			}
+		}
+		return results, false
+	}
+
+	groups := groupServiceCalls(calls)
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentServiceCalls)
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group *serviceGroup) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			seen := make(map[string]bool, len(group.targets))
+			entityIDs := make([]string, 0, len(group.targets))
+			for _, idx := range group.targets {
+				if target := results[idx].Target; !seen[target] {
+					seen[target] = true
+					entityIDs = append(entityIDs, target)
+				}
+			}
+
+			err := m.haClient.CallServiceForEntities(ctx, group.call.domain, group.call.service, entityIDs, group.call.serviceData)
+			for _, idx := range group.targets {
+				switch {
+				case err != nil:
+					results[idx].Status = models.ActionStatusHAError
+					results[idx].Error = err.Error()
+				case results[idx].Warning != "":
+					results[idx].Status = models.ActionStatusWarning
+				default:
+					results[idx].Status = models.ActionStatusSuccess
+				}
+			}
+
+			if err != nil {
+				logrus.WithError(err).Errorf("Bulk service call %s.%s failed", group.call.domain, group.call.service)
+			} else {
+				logrus.Infof("Executed %s.%s on %d devices", group.call.domain, group.call.service, len(entityIDs))
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		m.events.Publish(events.TopicActionResult, result)
+	}
+
+	return results, true
+}
+
+// groupServiceCalls merges calls for the same service with identical data on the
+// same Home Assistant instance into one group, keeping groups in first-seen order
+func groupServiceCalls(calls []*serviceCall) []*serviceGroup {
+	var groups []*serviceGroup
+	byKey := make(map[string]*serviceGroup)
+
+	for idx, call := range calls {
+		// encoding/json sorts map keys, so equal service data yields equal keys
+		key := fmt.Sprintf("unmergeable-%d", idx)
+		if data, err := json.Marshal(call.serviceData); err == nil {
+			key = call.instance + InstanceSeparator + call.domain + "." + call.service + " " + string(data)
+		}
+
+		group, ok := byKey[key]
+		if !ok {
+			group = &serviceGroup{call: call}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.targets = append(group.targets, idx)
+	}
+
+	return groups
+}
+
+func copyAction(action models.DeviceAction) models.DeviceAction {
+	params := make(map[string]any, len(action.Parameters))
+	for key, value := range action.Parameters {
+		params[key] = value
+	}
+	return models.DeviceAction{Action: action.Action, Parameters: params}
+}
@@ -0,0 +1,114 @@
+package device
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+func TestExecuteBulk_GroupsSameService(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	items := []models.BulkActionItem{
+		{
+			Targets: []string{"light.living_room", "light.bedroom"},
+			Action:  models.DeviceAction{Action: "turn_off"},
+		},
+		{
+			Targets: []string{"switch.porch"},
+			Action:  models.DeviceAction{Action: "turn_on"},
+		},
+		{
+			Targets: []string{"climate.main"},
+			Action:  models.DeviceAction{Action: "set_temperature", Parameters: map[string]any{"temperature": 30.0}},
+		},
+	}
+
+	results, executed := manager.ExecuteBulk(context.Background(), items)
+	require.True(t, executed)
+	require.Len(t, results, 4)
+
+	assert.Equal(t, models.ActionStatusSuccess, results[0].Status)
+	assert.Equal(t, models.ActionStatusSuccess, results[1].Status)
+	assert.Equal(t, models.ActionStatusSuccess, results[2].Status)
+	assert.Equal(t, models.ActionStatusWarning, results[3].Status)
+	assert.Contains(t, results[3].Warning, "very warm")
+
+	// Both lights share light.turn_off and go out in a single call
+	calls := mockClient.ServiceCalls()
+	assert.Len(t, calls, 3)
+	for _, call := range calls {
+		if call.Domain == "light" {
+			assert.Equal(t, "turn_off", call.Service)
+			assert.ElementsMatch(t, []string{"light.living_room", "light.bedroom"}, call.EntityIDs)
+		}
+	}
+}
+
+func TestExecuteBulk_ValidationFailureExecutesNothing(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	items := []models.BulkActionItem{
+		{
+			Targets: []string{"light.living_room", "nonexistent.device"},
+			Action:  models.DeviceAction{Action: "turn_on"},
+		},
+		{
+			Targets: []string{"light.bedroom"},
+			Action:  models.DeviceAction{Action: "set_brightness", Parameters: map[string]any{"brightness": 400}},
+		},
+	}
+
+	results, executed := manager.ExecuteBulk(context.Background(), items)
+	assert.False(t, executed)
+	require.Len(t, results, 3)
+
+	assert.Equal(t, models.ActionStatusSkipped, results[0].Status)
+	assert.Equal(t, models.ActionStatusValidationError, results[1].Status)
+	assert.Contains(t, results[1].Error, "device not found")
+	assert.Equal(t, models.ActionStatusValidationError, results[2].Status)
+	assert.Contains(t, results[2].Error, "brightness cannot exceed 255")
+
+	assert.Empty(t, mockClient.ServiceCalls())
+}
+
+func TestExecuteBulk_HAError(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	// Populate the cache before HA starts failing
+	_, err := manager.GetAllDevices(context.Background())
+	require.NoError(t, err)
+	mockClient.SetServiceError(true)
+
+	results, executed := manager.ExecuteBulk(context.Background(), []models.BulkActionItem{
+		{Targets: []string{"light.living_room", "light.bedroom"}, Action: models.DeviceAction{Action: "turn_on"}},
+	})
+	assert.True(t, executed)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Equal(t, models.ActionStatusHAError, result.Status)
+		assert.Contains(t, result.Error, "service error")
+	}
+}
+
+func TestGroupServiceCalls(t *testing.T) {
+	calls := []*serviceCall{
+		{domain: "light", service: "turn_on", serviceData: map[string]interface{}{"brightness": 128}},
+		{domain: "light", service: "turn_on", serviceData: map[string]interface{}{"brightness": 255}},
+		{domain: "light", service: "turn_on", serviceData: map[string]interface{}{"brightness": 128}},
+		{domain: "switch", service: "turn_on", serviceData: map[string]interface{}{}},
+	}
+
+	groups := groupServiceCalls(calls)
+	require.Len(t, groups, 3)
+	assert.Equal(t, []int{0, 2}, groups[0].targets)
+	assert.Equal(t, []int{1}, groups[1].targets)
+	assert.Equal(t, []int{3}, groups[2].targets)
+}
@@ -0,0 +1,156 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// ValidateActionFor validates an action for a particular device. On top of the
+// checks ValidateAction makes, it rejects actions the device can't perform and
+// clamps values to the device's own ranges, warning when it does. Devices whose
+// capabilities aren't known only get the generic checks.
+func (v *Validator) ValidateActionFor(device models.Device, action *models.DeviceAction) ValidationResult {
+	caps := device.Capabilities
+	if caps == nil || action == nil {
+		return v.ValidateAction(action)
+	}
+
+	if missing := missingCapability(device, action.Action); missing != "" {
+		return ValidationResult{
+			Valid: false,
+			Error: fmt.Sprintf("%s can't %s", device.Name, missing),
+		}
+	}
+
+	// The device's own color temperature range replaces the typical one
+	if action.Action == "set_color_temp" && caps.MaxColorTempKelvin > 0 {
+		return v.validateColorTempRange(action, float64(caps.MinColorTempKelvin), float64(caps.MaxColorTempKelvin))
+	}
+
+	result := v.ValidateAction(action)
+	if !result.Valid {
+		return result
+	}
+
+	switch action.Action {
+	case "set_temperature":
+		if caps.MaxTemp > 0 {
+			// Home Assistant reports the range in its own unit system
+			unit, _ := device.Attributes["unit_of_measurement"].(string)
+			clampParameter(&result, "temperature", caps.MinTemp, caps.MaxTemp, unit)
+		}
+	case "set_hvac_mode":
+		checkMode(&result, device, "hvac_mode", caps.HVACModes)
+	case "set_fan_mode":
+		checkMode(&result, device, "fan_mode", caps.FanModes)
+	case "set_preset_mode":
+		checkMode(&result, device, "preset_mode", caps.PresetModes)
+	}
+
+	return result
+}
+
+// missingCapability names what a device would need to do for an action but
+// can't, or returns "" if nothing is missing
+func missingCapability(device models.Device, action string) string {
+	caps := device.Capabilities
+	switch device.Type {
+	case models.DeviceTypeLight:
+		switch {
+		case action == "set_brightness" && !caps.SupportsBrightness():
+			return "be dimmed"
+		case action == "set_color_temp" && !caps.SupportsColorTemp():
+			return "change color temperature"
+		case action == "set_color" && !caps.SupportsColor():
+			return "change color"
+		}
+	case models.DeviceTypeClimate:
+		switch {
+		case action == "set_temperature" && !caps.Supports(models.ClimateSupportTargetTemperature):
+			return "set a target temperature"
+		case action == "set_fan_mode" && !caps.Supports(models.ClimateSupportFanMode):
+			return "change fan mode"
+		case action == "set_preset_mode" && !caps.Supports(models.ClimateSupportPresetMode):
+			return "change preset"
+		}
+	case models.DeviceTypeCover:
+		switch {
+		case action == "open" && !caps.Supports(models.CoverSupportOpen):
+			return "open"
+		case action == "close" && !caps.Supports(models.CoverSupportClose):
+			return "close"
+		}
+	case models.DeviceTypeFan:
+		if action == "set_preset_mode" && !caps.Supports(models.FanSupportPresetMode) {
+			return "change preset"
+		}
+	}
+	return ""
+}
+
+// validateColorTempRange validates a color temperature in kelvin, clamping it
+// to the range a light supports
+func (v *Validator) validateColorTempRange(action *models.DeviceAction, min, max float64) ValidationResult {
+	kelvin, ok := action.Parameters["color_temp"].(float64)
+	if value, isInt := action.Parameters["color_temp"].(int); isInt {
+		kelvin, ok = float64(value), true
+	}
+	if !ok {
+		return ValidationResult{
+			Valid: false,
+			Error: "color_temp action requires a 'color_temp' parameter in kelvin",
+		}
+	}
+
+	result := ValidationResult{
+		Valid: true,
+		SafeAction: &models.DeviceAction{
+			Action:     action.Action,
+			Parameters: map[string]any{"color_temp": kelvin},
+		},
+	}
+	clampParameter(&result, "color_temp", min, max, "K")
+	return result
+}
+
+// clampParameter keeps a validated numeric parameter within min and max,
+// warning when it had to be moved
+func clampParameter(result *ValidationResult, param string, min, max float64, unit string) {
+	value, ok := result.SafeAction.Parameters[param].(float64)
+	if !ok {
+		return
+	}
+
+	clamped := math.Min(math.Max(value, min), max)
+	if clamped == value {
+		return
+	}
+
+	result.SafeAction.Parameters[param] = clamped
+	warning := fmt.Sprintf("%s %g%s is outside the device's range (%g-%g%s), using %g%s", param, value, unit, min, max, unit, clamped, unit)
+	if result.Warning != "" {
+		warning = result.Warning + "; " + warning
+	}
+	result.Warning = warning
+}
+
+// checkMode rejects a mode the device doesn't list. Devices that don't list
+// their modes accept any.
+func checkMode(result *ValidationResult, device models.Device, param string, modes []string) {
+	if len(modes) == 0 {
+		return
+	}
+	mode := result.SafeAction.Parameters[param]
+	for _, supported := range modes {
+		if supported == mode {
+			return
+		}
+	}
+	*result = ValidationResult{
+		Valid: false,
+		Error: fmt.Sprintf("%s doesn't support %s %v (supports %s)", device.Name, param, mode, strings.Join(modes, ", ")),
+	}
+}
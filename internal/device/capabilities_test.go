@@ -0,0 +1,125 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func TestValidateActionFor_UnknownCapabilities(t *testing.T) {
+	validator := NewValidator()
+	device := models.Device{Name: "Lamp", Type: models.DeviceTypeLight}
+
+	result := validator.ValidateActionFor(device, &models.DeviceAction{
+		Action:     "set_color",
+		Parameters: map[string]any{"rgb_color": []any{255.0, 0.0, 0.0}},
+	})
+	assert.True(t, result.Valid)
+	assert.Equal(t, []int{255, 0, 0}, result.SafeAction.Parameters["rgb_color"])
+}
+
+func TestValidateActionFor_RejectsMissingCapability(t *testing.T) {
+	validator := NewValidator()
+	bulb := models.Device{
+		Name:         "Hallway Light",
+		Type:         models.DeviceTypeLight,
+		Capabilities: &models.DeviceCapabilities{ColorModes: []string{"brightness"}},
+	}
+
+	result := validator.ValidateActionFor(bulb, &models.DeviceAction{
+		Action:     "set_color",
+		Parameters: map[string]any{"rgb_color": []int{0, 0, 255}},
+	})
+	assert.False(t, result.Valid)
+	assert.Equal(t, "Hallway Light can't change color", result.Error)
+
+	result = validator.ValidateActionFor(bulb, &models.DeviceAction{
+		Action:     "set_brightness",
+		Parameters: map[string]any{"brightness": 128},
+	})
+	assert.True(t, result.Valid)
+
+	door := models.Device{
+		Name:         "Gate",
+		Type:         models.DeviceTypeCover,
+		Capabilities: &models.DeviceCapabilities{SupportedFeatures: models.CoverSupportClose},
+	}
+	result = validator.ValidateActionFor(door, &models.DeviceAction{Action: "open"})
+	assert.False(t, result.Valid)
+	assert.Equal(t, "Gate can't open", result.Error)
+}
+
+func TestValidateActionFor_ClampsColorTemp(t *testing.T) {
+	validator := NewValidator()
+	bulb := models.Device{
+		Name: "Bedroom Light",
+		Type: models.DeviceTypeLight,
+		Capabilities: &models.DeviceCapabilities{
+			ColorModes:         []string{"color_temp"},
+			MinColorTempKelvin: 2000,
+			MaxColorTempKelvin: 4000,
+		},
+	}
+
+	// Within the device's range but outside the typical one
+	result := validator.ValidateActionFor(bulb, &models.DeviceAction{
+		Action:     "set_color_temp",
+		Parameters: map[string]any{"color_temp": 2200},
+	})
+	assert.True(t, result.Valid)
+	assert.Empty(t, result.Warning)
+	assert.Equal(t, 2200.0, result.SafeAction.Parameters["color_temp"])
+
+	result = validator.ValidateActionFor(bulb, &models.DeviceAction{
+		Action:     "set_color_temp",
+		Parameters: map[string]any{"color_temp": 6500.0},
+	})
+	assert.True(t, result.Valid)
+	assert.Equal(t, 4000.0, result.SafeAction.Parameters["color_temp"])
+	assert.Contains(t, result.Warning, "outside the device's range")
+}
+
+func TestValidateActionFor_Climate(t *testing.T) {
+	validator := NewValidator()
+	thermostat := models.Device{
+		Name: "Thermostat",
+		Type: models.DeviceTypeClimate,
+		Capabilities: &models.DeviceCapabilities{
+			SupportedFeatures: models.ClimateSupportTargetTemperature,
+			HVACModes:         []string{"off", "heat"},
+			MinTemp:           12,
+			MaxTemp:           26,
+		},
+	}
+
+	result := validator.ValidateActionFor(thermostat, &models.DeviceAction{
+		Action:     "set_temperature",
+		Parameters: map[string]any{"temperature": 30.0},
+	})
+	assert.True(t, result.Valid)
+	assert.Equal(t, 26.0, result.SafeAction.Parameters["temperature"])
+	assert.Contains(t, result.Warning, "very warm")
+	assert.Contains(t, result.Warning, "temperature 30 is outside the device's range (12-26), using 26")
+
+	// The generic safety range still applies first
+	result = validator.ValidateActionFor(thermostat, &models.DeviceAction{
+		Action:     "set_temperature",
+		Parameters: map[string]any{"temperature": 50.0},
+	})
+	assert.False(t, result.Valid)
+
+	result = validator.ValidateActionFor(thermostat, &models.DeviceAction{
+		Action:     "set_hvac_mode",
+		Parameters: map[string]any{"hvac_mode": "cool"},
+	})
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Error, "supports off, heat")
+
+	result = validator.ValidateActionFor(thermostat, &models.DeviceAction{
+		Action:     "set_fan_mode",
+		Parameters: map[string]any{"fan_mode": "auto"},
+	})
+	assert.False(t, result.Valid)
+	assert.Equal(t, "Thermostat can't change fan mode", result.Error)
+}
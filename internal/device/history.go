@@ -0,0 +1,124 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// GetHistory returns the recorded states of the given devices between start and end
+func (m *Manager) GetHistory(ctx context.Context, deviceIDs []string, start, end time.Time) (map[string][]models.StateChange, error) {
+	history, err := m.haClient.GetHistory(ctx, deviceIDs, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch history from HomeAssistant: %w", err)
+	}
+	return history, nil
+}
+
+// GetLogbook returns the logbook entries for a device between start and end
+func (m *Manager) GetLogbook(ctx context.Context, deviceID string, start, end time.Time) ([]models.LogbookEntry, error) {
+	logbook, err := m.haClient.GetLogbook(ctx, deviceID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch logbook from HomeAssistant: %w", err)
+	}
+	return logbook, nil
+}
+
+// SummarizeHistory renders a device's recorded states between start and end as facts:
+// how long it spent in each state, how many times it entered each state and when it
+// last did
+func SummarizeHistory(device models.Device, changes []models.StateChange, start, end time.Time) []string {
+	return summarizeHistory(device, changes, start, end, time.Now())
+}
+
+func summarizeHistory(device models.Device, changes []models.StateChange, start, end, now time.Time) []string {
+	window := fmt.Sprintf("between %s and %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if len(changes) == 0 {
+		return []string{fmt.Sprintf("%s (%s) has no recorded history %s", device.Name, device.ID, window)}
+	}
+
+	durations := make(map[string]time.Duration)
+	lastEntered := make(map[string]time.Time)
+	entered := make(map[string]int)
+	var order []string
+
+	for i, change := range changes {
+		from := change.LastChanged
+		if from.Before(start) {
+			from = start
+		}
+		to := end
+		if i+1 < len(changes) {
+			to = changes[i+1].LastChanged
+		}
+		if _, seen := durations[change.State]; !seen {
+			order = append(order, change.State)
+			durations[change.State] = 0
+		}
+		if to.After(from) {
+			durations[change.State] += to.Sub(from)
+		}
+		// The first entry is the state already in effect at start, not a transition
+		if i > 0 || change.LastChanged.After(start) {
+			lastEntered[change.State] = change.LastChanged
+			entered[change.State]++
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return durations[order[i]] > durations[order[j]] })
+
+	spent := make([]string, 0, len(order))
+	for _, state := range order {
+		spent = append(spent, fmt.Sprintf("%s for %s", state, formatDuration(durations[state])))
+	}
+	facts := []string{fmt.Sprintf("%s (%s) was %s %s", device.Name, device.ID, strings.Join(spent, ", "), window)}
+
+	// Counts answer "how many times" and "how often" questions
+	counts := make([]string, 0, len(order))
+	for _, state := range order {
+		counts = append(counts, fmt.Sprintf("%s %s", state, formatTimes(entered[state])))
+	}
+	facts = append(facts, fmt.Sprintf("%s changed to %s %s", device.Name, strings.Join(counts, ", "), window))
+
+	for _, state := range order {
+		if when, ok := lastEntered[state]; ok {
+			facts = append(facts, fmt.Sprintf("%s last became %s %s (%s)",
+				device.Name, state, formatAgo(now.Sub(when)), when.Format(time.RFC3339)))
+		}
+	}
+
+	return facts
+}
+
+// formatTimes renders a count of occurrences, e.g. "once" or "3 times"
+func formatTimes(n int) string {
+	switch n {
+	case 1:
+		return "once"
+	case 2:
+		return "twice"
+	default:
+		return fmt.Sprintf("%d times", n)
+	}
+}
+
+// formatDuration renders a duration at minute precision, e.g. "2h 15m"
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	hours := int(d / time.Hour)
+	minutes := int((d % time.Hour) / time.Minute)
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+}
@@ -0,0 +1,66 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+func TestGetHistory(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+	now := time.Now()
+
+	history, err := manager.GetHistory(context.Background(), []string{"cover.garage_door", "climate.main"}, now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	logbook, err := manager.GetLogbook(context.Background(), "cover.garage_door", now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+	assert.Len(t, logbook, 2)
+
+	mockClient.SetConnectionError(true)
+	_, err = manager.GetHistory(context.Background(), []string{"cover.garage_door"}, now.Add(-time.Hour), now)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to fetch history")
+}
+
+func TestSummarizeHistory(t *testing.T) {
+	now := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	heater := models.Device{ID: "climate.main", Name: "Main Thermostat", Type: models.DeviceTypeClimate}
+
+	changes := []models.StateChange{
+		{State: "off", LastChanged: start},
+		{State: "heat", LastChanged: start.Add(6 * time.Hour)},
+		{State: "off", LastChanged: start.Add(8*time.Hour + 15*time.Minute)},
+	}
+
+	facts := summarizeHistory(heater, changes, start, now, now)
+	require.Len(t, facts, 4)
+	assert.Equal(t, "Main Thermostat (climate.main) was off for 15h 45m, heat for 2h 15m between 2024-01-01T00:00:00Z and 2024-01-01T18:00:00Z", facts[0])
+	// The state in effect at start wasn't entered in the window
+	assert.Equal(t, "Main Thermostat changed to off once, heat once between 2024-01-01T00:00:00Z and 2024-01-01T18:00:00Z", facts[1])
+	assert.Equal(t, "Main Thermostat last became off 9 hours ago (2024-01-01T08:15:00Z)", facts[2])
+	assert.Equal(t, "Main Thermostat last became heat 12 hours ago (2024-01-01T06:00:00Z)", facts[3])
+
+	// Each opening counts, not just the last
+	door := models.Device{ID: "cover.garage_door", Name: "Garage Door", Type: models.DeviceTypeCover}
+	facts = summarizeHistory(door, []models.StateChange{
+		{State: "closed", LastChanged: start},
+		{State: "open", LastChanged: start.Add(7 * time.Hour)},
+		{State: "closed", LastChanged: start.Add(7*time.Hour + 5*time.Minute)},
+		{State: "open", LastChanged: start.Add(12 * time.Hour)},
+		{State: "closed", LastChanged: start.Add(12*time.Hour + 5*time.Minute)},
+		{State: "open", LastChanged: start.Add(17 * time.Hour)},
+	}, start, now, now)
+	assert.Equal(t, "Garage Door changed to closed twice, open 3 times between 2024-01-01T00:00:00Z and 2024-01-01T18:00:00Z", facts[1])
+
+	facts = summarizeHistory(heater, nil, start, now, now)
+	assert.Equal(t, []string{"Main Thermostat (climate.main) has no recorded history between 2024-01-01T00:00:00Z and 2024-01-01T18:00:00Z"}, facts)
+}
@@ -0,0 +1,294 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/pkg/homeassistant"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InstanceSeparator joins an instance's name to an entity ID to make a device
+// ID, as in cabin:climate.heater
+const InstanceSeparator = ":"
+
+// instanceNames are the names instances may have, so they read well in device
+// IDs and URLs
+var instanceNames = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Instance is one of several Home Assistant servers behind a manager
+type Instance struct {
+	Name   string
+	Client homeassistant.ClientInterface
+}
+
+// InstanceStatus is how one Home Assistant server is doing
+type InstanceStatus struct {
+	Name      string
+	Err       error // nil when the server answers
+	Upstreams []resilience.UpstreamStats
+}
+
+// NewManagerWithInstances creates a manager over several Home Assistant
+// servers. Device IDs are namespaced by instance, as in cabin:climate.heater,
+// and each action goes to the server owning its device. A single instance
+// keeps plain entity IDs, as NewManagerWithOptions does.
+func NewManagerWithInstances(instances []Instance, opts ManagerOptions) (*Manager, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no Home Assistant instances configured")
+	}
+	if len(instances) == 1 {
+		return NewManagerWithOptions(instances[0].Client, opts), nil
+	}
+
+	router := &instanceRouter{clients: make(map[string]homeassistant.ClientInterface, len(instances))}
+	for _, instance := range instances {
+		if !instanceNames.MatchString(instance.Name) {
+			return nil, fmt.Errorf("invalid Home Assistant instance name %q: use lowercase letters, digits, - and _", instance.Name)
+		}
+		if _, exists := router.clients[instance.Name]; exists {
+			return nil, fmt.Errorf("duplicate Home Assistant instance name %q", instance.Name)
+		}
+		router.clients[instance.Name] = instance.Client
+		router.names = append(router.names, instance.Name)
+	}
+	return NewManagerWithOptions(router, opts), nil
+}
+
+// InstanceStatuses reports each Home Assistant server separately, or nil when
+// there is just one
+func (m *Manager) InstanceStatuses(ctx context.Context) []InstanceStatus {
+	router, ok := m.haClient.(*instanceRouter)
+	if !ok {
+		return nil
+	}
+	return router.statuses(ctx)
+}
+
+// fetchDevices gets every device, along with the instances that couldn't be
+// reached and so are missing from it
+func (m *Manager) fetchDevices(ctx context.Context) ([]models.Device, []string, error) {
+	if router, ok := m.haClient.(*instanceRouter); ok {
+		return router.fetchEntities(ctx)
+	}
+	devices, err := m.haClient.GetEntities(ctx)
+	return devices, nil, err
+}
+
+// SplitDeviceID splits a namespaced device ID into its instance and entity ID.
+// The instance is empty for plain entity IDs.
+func SplitDeviceID(deviceID string) (instance, entityID string) {
+	if instance, entityID, ok := strings.Cut(deviceID, InstanceSeparator); ok {
+		return instance, entityID
+	}
+	return "", deviceID
+}
+
+// instanceRouter is a Home Assistant client over several servers. Devices are
+// gathered from all of them under namespaced IDs, and calls go to the server
+// each ID names.
+type instanceRouter struct {
+	names   []string // in configured order
+	clients map[string]homeassistant.ClientInterface
+}
+
+// route finds the client and entity ID for a namespaced device ID
+func (r *instanceRouter) route(deviceID string) (homeassistant.ClientInterface, string, error) {
+	instance, entityID := SplitDeviceID(deviceID)
+	client, ok := r.clients[instance]
+	if !ok {
+		return nil, "", fmt.Errorf("device %s names no known Home Assistant instance", deviceID)
+	}
+	return client, entityID, nil
+}
+
+// groupByInstance splits namespaced device IDs into entity IDs per instance
+func (r *instanceRouter) groupByInstance(deviceIDs []string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	for _, deviceID := range deviceIDs {
+		if _, _, err := r.route(deviceID); err != nil {
+			return nil, err
+		}
+		instance, entityID := SplitDeviceID(deviceID)
+		groups[instance] = append(groups[instance], entityID)
+	}
+	return groups, nil
+}
+
+func namespace(instance string, device models.Device) models.Device {
+	device.ID = instance + InstanceSeparator + device.ID
+	device.Instance = instance
+	return device
+}
+
+// GetEntities gathers every instance's devices. An instance that can't be
+// reached is left out rather than failing the rest; only when none answer is
+// it an error.
+func (r *instanceRouter) GetEntities(ctx context.Context) ([]models.Device, error) {
+	devices, _, err := r.fetchEntities(ctx)
+	return devices, err
+}
+
+// fetchEntities is GetEntities, also naming the instances that were left out
+func (r *instanceRouter) fetchEntities(ctx context.Context) ([]models.Device, []string, error) {
+	type result struct {
+		devices []models.Device
+		err     error
+	}
+	results := make([]result, len(r.names))
+
+	var wg sync.WaitGroup
+	for i, name := range r.names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i].devices, results[i].err = r.clients[name].GetEntities(ctx)
+		}(i, name)
+	}
+	wg.Wait()
+
+	var devices []models.Device
+	var failed []string
+	var errs []error
+	for i, name := range r.names {
+		if err := results[i].err; err != nil {
+			logrus.WithError(err).Warnf("Failed to fetch devices from Home Assistant instance %s", name)
+			failed = append(failed, name)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		for _, device := range results[i].devices {
+			devices = append(devices, namespace(name, device))
+		}
+	}
+	if len(errs) == len(r.names) {
+		return nil, nil, errors.Join(errs...)
+	}
+	return devices, failed, nil
+}
+
+func (r *instanceRouter) GetEntity(ctx context.Context, deviceID string) (*models.Device, error) {
+	client, entityID, err := r.route(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	device, err := client.GetEntity(ctx, entityID)
+	if err != nil {
+		return nil, err
+	}
+	instance, _ := SplitDeviceID(deviceID)
+	namespaced := namespace(instance, *device)
+	return &namespaced, nil
+}
+
+func (r *instanceRouter) CallService(ctx context.Context, domain, service, deviceID string, serviceData map[string]interface{}) error {
+	client, entityID, err := r.route(deviceID)
+	if err != nil {
+		return err
+	}
+	return client.CallService(ctx, domain, service, entityID, serviceData)
+}
+
+// CallServiceForEntities makes one call per instance the devices belong to
+func (r *instanceRouter) CallServiceForEntities(ctx context.Context, domain, service string, deviceIDs []string, serviceData map[string]interface{}) error {
+	groups, err := r.groupByInstance(deviceIDs)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range r.names {
+		if entityIDs, ok := groups[name]; ok {
+			if err := r.clients[name].CallServiceForEntities(ctx, domain, service, entityIDs, serviceData); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TestConnection fails if any instance can't be reached
+func (r *instanceRouter) TestConnection(ctx context.Context) error {
+	var errs []error
+	for _, status := range r.statuses(ctx) {
+		if status.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", status.Name, status.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *instanceRouter) GetHistory(ctx context.Context, deviceIDs []string, start, end time.Time) (map[string][]models.StateChange, error) {
+	groups, err := r.groupByInstance(deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make(map[string][]models.StateChange)
+	for _, name := range r.names {
+		entityIDs, ok := groups[name]
+		if !ok {
+			continue
+		}
+		instanceHistory, err := r.clients[name].GetHistory(ctx, entityIDs, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for entityID, changes := range instanceHistory {
+			history[name+InstanceSeparator+entityID] = changes
+		}
+	}
+	return history, nil
+}
+
+func (r *instanceRouter) GetLogbook(ctx context.Context, deviceID string, start, end time.Time) ([]models.LogbookEntry, error) {
+	client, entityID, err := r.route(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetLogbook(ctx, entityID, start, end)
+}
+
+// UpstreamStats reports every instance's breakers, named after their instance
+func (r *instanceRouter) UpstreamStats() []resilience.UpstreamStats {
+	var stats []resilience.UpstreamStats
+	for _, name := range r.names {
+		if reporter, ok := r.clients[name].(resilience.Reporter); ok {
+			for _, upstream := range reporter.UpstreamStats() {
+				upstream.Name = name + InstanceSeparator + upstream.Name
+				stats = append(stats, upstream)
+			}
+		}
+	}
+	return stats
+}
+
+// statuses checks every instance at once
+func (r *instanceRouter) statuses(ctx context.Context) []InstanceStatus {
+	statuses := make([]InstanceStatus, len(r.names))
+
+	var wg sync.WaitGroup
+	for i, name := range r.names {
+		statuses[i].Name = name
+		client := r.clients[name]
+		if reporter, ok := client.(resilience.Reporter); ok {
+			statuses[i].Upstreams = reporter.UpstreamStats()
+		}
+		wg.Add(1)
+		go func(status *InstanceStatus) {
+			defer wg.Done()
+			status.Err = client.TestConnection(ctx)
+		}(&statuses[i])
+	}
+	wg.Wait()
+
+	return statuses
+}
@@ -0,0 +1,209 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+// newInstanceManager creates a manager over a home and a cabin instance, each
+// with a space heater
+func newInstanceManager(t *testing.T) (*Manager, *mocks.MockHomeAssistantClient, *mocks.MockHomeAssistantClient) {
+	home := mocks.NewMockHomeAssistantClient()
+	cabin := mocks.NewMockHomeAssistantClient()
+	for _, client := range []*mocks.MockHomeAssistantClient{home, cabin} {
+		client.AddMockEntity(models.Device{
+			ID:       "climate.space_heater",
+			EntityID: "climate.space_heater",
+			Name:     "Space Heater",
+			Type:     models.DeviceTypeClimate,
+			State:    "off",
+		})
+	}
+
+	manager, err := NewManagerWithInstances([]Instance{{Name: "home", Client: home}, {Name: "cabin", Client: cabin}}, ManagerOptions{})
+	require.NoError(t, err)
+	return manager, home, cabin
+}
+
+func TestNewManagerWithInstances_Names(t *testing.T) {
+	client := mocks.NewMockHomeAssistantClient()
+
+	_, err := NewManagerWithInstances(nil, ManagerOptions{})
+	assert.Error(t, err)
+
+	_, err = NewManagerWithInstances([]Instance{{Name: "home", Client: client}, {Name: "Lake House", Client: client}}, ManagerOptions{})
+	assert.ErrorContains(t, err, "invalid Home Assistant instance name")
+
+	_, err = NewManagerWithInstances([]Instance{{Name: "home", Client: client}, {Name: "home", Client: client}}, ManagerOptions{})
+	assert.ErrorContains(t, err, "duplicate")
+
+	// One instance keeps plain entity IDs
+	manager, err := NewManagerWithInstances([]Instance{{Name: "home", Client: client}}, ManagerOptions{})
+	require.NoError(t, err)
+	device, err := manager.GetDevice(context.Background(), "light.bedroom")
+	require.NoError(t, err)
+	assert.Empty(t, device.Instance)
+	assert.Nil(t, manager.InstanceStatuses(context.Background()))
+}
+
+func TestInstances_NamespaceDevices(t *testing.T) {
+	manager, _, _ := newInstanceManager(t)
+
+	devices, err := manager.GetAllDevices(context.Background())
+	require.NoError(t, err)
+
+	ids := make(map[string]string)
+	for _, device := range devices {
+		ids[device.ID] = device.Instance
+	}
+	assert.Equal(t, "home", ids["home:light.bedroom"])
+	assert.Equal(t, "cabin", ids["cabin:light.bedroom"])
+	assert.Equal(t, "cabin", ids["cabin:climate.space_heater"])
+	assert.NotContains(t, ids, "light.bedroom")
+
+	device, err := manager.GetDevice(context.Background(), "cabin:climate.space_heater")
+	require.NoError(t, err)
+	assert.Equal(t, "climate.space_heater", device.EntityID)
+	assert.Equal(t, "cabin", device.Instance)
+}
+
+func TestInstances_RouteActions(t *testing.T) {
+	manager, home, cabin := newInstanceManager(t)
+	ctx := context.Background()
+
+	err := manager.ExecuteActionOnDevice(ctx, "cabin:light.bedroom", models.DeviceAction{Action: "turn_on"})
+	require.NoError(t, err)
+	require.Len(t, cabin.ServiceCalls(), 1)
+	assert.Equal(t, []string{"light.bedroom"}, cabin.ServiceCalls()[0].EntityIDs)
+	assert.Empty(t, home.ServiceCalls())
+
+	err = manager.ExecuteActionOnDevice(ctx, "attic:light.bedroom", models.DeviceAction{Action: "turn_on"})
+	assert.Error(t, err)
+}
+
+func TestInstances_BulkSplitsByInstance(t *testing.T) {
+	manager, home, cabin := newInstanceManager(t)
+
+	results, executed := manager.ExecuteBulk(context.Background(), []models.BulkActionItem{{
+		Targets: []string{"home:light.living_room", "home:light.bedroom", "cabin:light.bedroom"},
+		Action:  models.DeviceAction{Action: "turn_off"},
+	}})
+	require.True(t, executed)
+	for _, result := range results {
+		assert.Equal(t, models.ActionStatusSuccess, result.Status, result.Target)
+	}
+
+	require.Len(t, home.ServiceCalls(), 1)
+	assert.ElementsMatch(t, []string{"light.living_room", "light.bedroom"}, home.ServiceCalls()[0].EntityIDs)
+	require.Len(t, cabin.ServiceCalls(), 1)
+	assert.Equal(t, []string{"light.bedroom"}, cabin.ServiceCalls()[0].EntityIDs)
+}
+
+func TestInstances_PartialFailure(t *testing.T) {
+	manager, home, cabin := newInstanceManager(t)
+	home.SetConnectionError(true)
+	ctx := context.Background()
+
+	devices, err := manager.GetAllDevices(ctx)
+	require.NoError(t, err)
+	for _, device := range devices {
+		assert.Equal(t, "cabin", device.Instance)
+	}
+
+	statuses := manager.InstanceStatuses(ctx)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "home", statuses[0].Name)
+	assert.Error(t, statuses[0].Err)
+	assert.Equal(t, "cabin", statuses[1].Name)
+	assert.NoError(t, statuses[1].Err)
+	assert.False(t, manager.IsConnected(ctx))
+
+	cabin.SetConnectionError(true)
+	assert.Error(t, manager.RefreshDevices(ctx))
+}
+
+func TestInstances_RefreshKeepsOfflineInstance(t *testing.T) {
+	manager, home, cabin := newInstanceManager(t)
+	ctx := context.Background()
+	require.NoError(t, manager.RefreshDevices(ctx))
+
+	// The home server goes offline while the cabin's heater changes
+	home.SetConnectionError(true)
+	cabin.SetState("climate.space_heater", "heat", nil)
+	require.NoError(t, manager.RefreshDevices(ctx))
+
+	devices, err := manager.GetAllDevices(ctx)
+	require.NoError(t, err)
+	ids := make(map[string]string)
+	for _, device := range devices {
+		ids[device.ID] = device.State
+	}
+	assert.Equal(t, "off", ids["home:climate.space_heater"])
+	assert.Equal(t, "heat", ids["cabin:climate.space_heater"])
+	assert.Contains(t, ids, "home:light.bedroom")
+
+	device, err := manager.GetDevice(ctx, "home:light.living_room")
+	require.NoError(t, err)
+	assert.Equal(t, "home", device.Instance)
+}
+
+func TestInstances_ACLRulesStayInTheirInstance(t *testing.T) {
+	home := mocks.NewMockHomeAssistantClient()
+	cabin := mocks.NewMockHomeAssistantClient()
+	acl := &ACL{
+		Policies: map[string]AccessPolicy{
+			"guests": {Control: []AccessRule{{Devices: []string{"home:light.bedroom", "switch.porch"}}}},
+		},
+		Default: "guests",
+	}
+	manager, err := NewManagerWithInstances([]Instance{{Name: "home", Client: home}, {Name: "cabin", Client: cabin}}, ManagerOptions{ACL: acl})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Both instances have a light.bedroom, but the rule names the home one
+	require.NoError(t, manager.ExecuteActionAs(ctx, "", "home:light.bedroom", models.DeviceAction{Action: "turn_on"}))
+	assert.ErrorIs(t, manager.ExecuteActionAs(ctx, "", "cabin:light.bedroom", models.DeviceAction{Action: "turn_on"}), ErrAccessDenied)
+	assert.False(t, manager.CanRead(ctx, "", "cabin:light.bedroom"))
+
+	// A plain entity ID doesn't pick out any instance's device
+	assert.ErrorIs(t, manager.ExecuteActionAs(ctx, "", "home:switch.porch", models.DeviceAction{Action: "turn_on"}), ErrAccessDenied)
+	assert.Len(t, home.ServiceCalls(), 1)
+	assert.Empty(t, cabin.ServiceCalls())
+}
+
+func TestInstances_History(t *testing.T) {
+	manager, _, cabin := newInstanceManager(t)
+	now := time.Now()
+	cabin.AddMockHistory("climate.space_heater", models.StateChange{EntityID: "climate.space_heater", State: "heat", LastChanged: now.Add(-time.Hour)})
+
+	history, err := manager.GetHistory(context.Background(), []string{"home:cover.garage_door", "cabin:climate.space_heater"}, now.Add(-48*time.Hour), now)
+	require.NoError(t, err)
+	assert.NotEmpty(t, history["home:cover.garage_door"])
+	assert.NotEmpty(t, history["cabin:climate.space_heater"])
+	assert.NotContains(t, history, "climate.space_heater")
+}
+
+func TestInstances_FindDevicesForQuery(t *testing.T) {
+	manager, _, _ := newInstanceManager(t)
+
+	devices, err := manager.FindDevicesForQuery(context.Background(), "is the cabin space heater on?")
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "cabin:climate.space_heater", devices[0].ID)
+}
+
+func TestSplitDeviceID(t *testing.T) {
+	instance, entityID := SplitDeviceID("cabin:climate.heater")
+	assert.Equal(t, "cabin", instance)
+	assert.Equal(t, "climate.heater", entityID)
+
+	instance, entityID = SplitDeviceID("light.bedroom")
+	assert.Empty(t, instance)
+	assert.Equal(t, "light.bedroom", entityID)
+}
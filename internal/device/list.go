@@ -0,0 +1,213 @@
+package device
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+const (
+	// DefaultPageSize is used when a listing doesn't ask for a page size
+	DefaultPageSize = 100
+	// MaxPageSize caps how many devices a single page can return
+	MaxPageSize = 1000
+)
+
+// ErrInvalidListOptions is returned when sort, limit or cursor can't be used
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// ListOptions filters, sorts and paginates the device list. Empty fields don't filter.
+type ListOptions struct {
+	Type         models.DeviceType
+	Domain       string
+	State        string
+	Search       string // case-insensitive substring of name or entity ID
+	Area         string
+	HasAttribute []string // devices must have every listed attribute
+	SortBy       string   // id, name, type, domain, state, last_updated or last_changed
+	Descending   bool
+	Limit        int    // 0 uses DefaultPageSize
+	Cursor       string // opaque cursor from a previous page
+	Principal    string // only devices the ACL lets this principal read are listed
+}
+
+// DevicePage is one page of a device listing
+type DevicePage struct {
+	Devices    []models.Device
+	Total      int    // matching devices across all pages
+	NextCursor string // empty on the last page
+}
+
+// pageCursor marks the last device of a page by its sort key and ID
+type pageCursor struct {
+	Sort string `json:"s,omitempty"`
+	Key  string `json:"k"`
+	ID   string `json:"id"`
+}
+
+// sortKeys maps each supported sort field to the value it orders by
+var sortKeys = map[string]func(models.Device) string{
+	"id":     func(d models.Device) string { return d.ID },
+	"name":   func(d models.Device) string { return strings.ToLower(d.Name) },
+	"type":   func(d models.Device) string { return string(d.Type) },
+	"domain": func(d models.Device) string { return d.Domain },
+	"state":  func(d models.Device) string { return d.State },
+	"last_updated": func(d models.Device) string {
+		return d.LastUpdated.UTC().Format("2006-01-02T15:04:05.000000000Z")
+	},
+	"last_changed": func(d models.Device) string {
+		return d.LastChanged.UTC().Format("2006-01-02T15:04:05.000000000Z")
+	},
+}
+
+// ListDevices returns the devices matching opts in a stable order, one page at a time.
+// Ties in the sort field are broken by device ID so pages never overlap or skip.
+func (m *Manager) ListDevices(ctx context.Context, opts ListOptions) (*DevicePage, error) {
+	sortBy := opts.SortBy
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	keyOf, ok := sortKeys[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported sort field %s", ErrInvalidListOptions, sortBy)
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListOptions, MaxPageSize)
+	}
+
+	var after *pageCursor
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != sortOrder(sortBy, opts.Descending) {
+			return nil, fmt.Errorf("%w: cursor does not match the requested sort order", ErrInvalidListOptions)
+		}
+		after = cursor
+	}
+
+	devices, err := m.GetAllDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]models.Device, 0, len(devices))
+	for _, device := range devices {
+		if opts.matches(device) && m.acl.CanRead(opts.Principal, device) {
+			matches = append(matches, device)
+		}
+	}
+
+	// Cursors only compare key and ID; the sort field is checked above
+	less := func(a, b pageCursor) bool {
+		if a.Key == b.Key {
+			a.Key, b.Key = a.ID, b.ID
+		}
+		if opts.Descending {
+			return a.Key > b.Key
+		}
+		return a.Key < b.Key
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return less(pageCursor{Key: keyOf(matches[i]), ID: matches[i].ID}, pageCursor{Key: keyOf(matches[j]), ID: matches[j].ID})
+	})
+
+	page := &DevicePage{Total: len(matches)}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matches), func(i int) bool {
+			return less(*after, pageCursor{Key: keyOf(matches[i]), ID: matches[i].ID})
+		})
+	}
+
+	end := len(matches)
+	if start+limit < end {
+		end = start + limit
+		last := matches[end-1]
+		page.NextCursor = encodeCursor(pageCursor{Sort: sortOrder(sortBy, opts.Descending), Key: keyOf(last), ID: last.ID})
+	}
+
+	page.Devices = matches[start:end]
+	return page, nil
+}
+
+func (opts ListOptions) matches(device models.Device) bool {
+	if opts.Type != "" && device.Type != opts.Type {
+		return false
+	}
+	if opts.Domain != "" && device.Domain != opts.Domain {
+		return false
+	}
+	if opts.State != "" && !strings.EqualFold(device.State, opts.State) {
+		return false
+	}
+	if opts.Search != "" {
+		search := strings.ToLower(opts.Search)
+		if !strings.Contains(strings.ToLower(device.Name), search) && !strings.Contains(strings.ToLower(device.ID), search) {
+			return false
+		}
+	}
+	if opts.Area != "" && !InArea(device, opts.Area) {
+		return false
+	}
+	for _, attribute := range opts.HasAttribute {
+		if _, ok := device.Attributes[attribute]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// InArea reports whether a device belongs to an area. The Home Assistant area is
+// used when known; otherwise the area name is matched against the device's name
+// and entity ID, which is how most installations name their entities.
+func InArea(device models.Device, area string) bool {
+	area = normalizeArea(area)
+	if area == "" {
+		return false
+	}
+	if device.Area != "" {
+		return normalizeArea(device.Area) == area
+	}
+	return strings.Contains(normalizeArea(device.Name), area) || strings.Contains(normalizeArea(device.ID), area)
+}
+
+func normalizeArea(s string) string {
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(s)))
+}
+
+func sortOrder(sortBy string, descending bool) string {
+	if descending {
+		return "-" + sortBy
+	}
+	return sortBy
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	return &cursor, nil
+}
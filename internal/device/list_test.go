@@ -0,0 +1,144 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+func deviceIDs(devices []models.Device) []string {
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestListDevices_Filters(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	tests := []struct {
+		name     string
+		opts     ListOptions
+		expected []string
+	}{
+		{
+			name:     "by type",
+			opts:     ListOptions{Type: models.DeviceTypeLight},
+			expected: []string{"light.bedroom", "light.living_room"},
+		},
+		{
+			name:     "by domain and state",
+			opts:     ListOptions{Domain: "light", State: "ON"},
+			expected: []string{"light.bedroom"},
+		},
+		{
+			name:     "by name search",
+			opts:     ListOptions{Search: "living room"},
+			expected: []string{"light.living_room", "media_player.living_room"},
+		},
+		{
+			name:     "by area from name",
+			opts:     ListOptions{Area: "Living Room"},
+			expected: []string{"light.living_room", "media_player.living_room"},
+		},
+		{
+			name:     "by attribute presence",
+			opts:     ListOptions{HasAttribute: []string{"device_class"}},
+			expected: []string{"cover.garage_door", "sensor.temperature"},
+		},
+		{
+			name:     "sorted by name descending",
+			opts:     ListOptions{Type: models.DeviceTypeLight, SortBy: "name", Descending: true},
+			expected: []string{"light.living_room", "light.bedroom"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := manager.ListDevices(context.Background(), tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, deviceIDs(page.Devices))
+			assert.Equal(t, len(tt.expected), page.Total)
+			assert.Empty(t, page.NextCursor)
+		})
+	}
+}
+
+func TestListDevices_Pagination(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	all, err := manager.ListDevices(context.Background(), ListOptions{SortBy: "state"})
+	require.NoError(t, err)
+
+	// Walking pages of 3 yields every device exactly once, in the same order
+	var walked []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		page, err := manager.ListDevices(context.Background(), ListOptions{SortBy: "state", Limit: 3, Cursor: cursor})
+		require.NoError(t, err)
+		assert.Equal(t, all.Total, page.Total)
+		walked = append(walked, deviceIDs(page.Devices)...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, deviceIDs(all.Devices), walked)
+}
+
+func TestListDevices_DefaultPageSize(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	for i := 0; i < DefaultPageSize+5; i++ {
+		id := fmt.Sprintf("sensor.probe_%03d", i)
+		mockClient.AddMockEntity(models.Device{ID: id, EntityID: id, Name: id, Type: models.DeviceTypeSensor, Domain: "sensor", State: "1"})
+	}
+	manager := NewManager(mockClient)
+
+	// Without a limit the listing still pages
+	page, err := manager.ListDevices(context.Background(), ListOptions{Domain: "sensor"})
+	require.NoError(t, err)
+	assert.Len(t, page.Devices, DefaultPageSize)
+	assert.Equal(t, DefaultPageSize+6, page.Total)
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = manager.ListDevices(context.Background(), ListOptions{Domain: "sensor", Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Len(t, page.Devices, 6)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestListDevices_InvalidOptions(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	page, err := manager.ListDevices(context.Background(), ListOptions{Limit: 2})
+	require.NoError(t, err)
+
+	tests := []ListOptions{
+		{SortBy: "color"},
+		{Limit: -1},
+		{Limit: MaxPageSize + 1},
+		{Cursor: "not-a-cursor"},
+		{Cursor: page.NextCursor, SortBy: "name"},
+	}
+
+	for _, opts := range tests {
+		_, err := manager.ListDevices(context.Background(), opts)
+		assert.ErrorIs(t, err, ErrInvalidListOptions)
+	}
+}
+
+func TestInArea(t *testing.T) {
+	assert.True(t, InArea(models.Device{ID: "light.guest_room_lamp", Name: "Lamp"}, "guest room"))
+	assert.True(t, InArea(models.Device{ID: "light.x", Name: "Lamp", Area: "guest_room"}, "Guest Room"))
+	assert.False(t, InArea(models.Device{ID: "light.guest_room_lamp", Name: "Lamp", Area: "office"}, "guest room"))
+	assert.False(t, InArea(models.Device{ID: "light.kitchen", Name: "Kitchen"}, ""))
+}
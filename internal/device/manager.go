@@ -1,13 +1,18 @@
 package device
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/tienpdinh/gpt-home/internal/events"
 	"github.com/tienpdinh/gpt-home/pkg/homeassistant"
 	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
 
 	"github.com/sirupsen/logrus"
 )
@@ -18,23 +23,39 @@ type Manager struct {
 	devicesMutex sync.RWMutex
 	lastUpdate   time.Time
 	validator    *Validator
+	events       *events.Hub
+	acl          *ACL // nil gives everyone full access
+}
+
+// ManagerOptions configure what a manager does besides talking to Home
+// Assistant. The zero value publishes nothing and gives everyone full access.
+type ManagerOptions struct {
+	Events *events.Hub // receives device state changes and action results
+	ACL    *ACL        // lets each principal read and control only the devices it allows
 }
 
 func NewManager(haClient homeassistant.ClientInterface) *Manager {
+	return NewManagerWithOptions(haClient, ManagerOptions{})
+}
+
+// NewManagerWithOptions creates a manager configured by opts
+func NewManagerWithOptions(haClient homeassistant.ClientInterface, opts ManagerOptions) *Manager {
 	return &Manager{
 		haClient:  haClient,
 		devices:   make(map[string]models.Device),
 		validator: NewValidator(),
+		events:    opts.Events,
+		acl:       opts.ACL,
 	}
 }
 
-func (m *Manager) GetAllDevices() ([]models.Device, error) {
+func (m *Manager) GetAllDevices(ctx context.Context) ([]models.Device, error) {
 	m.devicesMutex.RLock()
 
 	// Refresh devices if cache is stale (older than 30 seconds)
 	if time.Since(m.lastUpdate) > 30*time.Second {
 		m.devicesMutex.RUnlock()
-		if err := m.RefreshDevices(); err != nil {
+		if err := m.RefreshDevices(ctx); err != nil {
 			// If refresh fails and we have no cached data, return error
 			m.devicesMutex.RLock()
 			if len(m.devices) == 0 {
@@ -56,14 +77,14 @@ func (m *Manager) GetAllDevices() ([]models.Device, error) {
 	return devices, nil
 }
 
-func (m *Manager) GetDevice(deviceID string) (*models.Device, error) {
+func (m *Manager) GetDevice(ctx context.Context, deviceID string) (*models.Device, error) {
 	m.devicesMutex.RLock()
 	device, exists := m.devices[deviceID]
 	m.devicesMutex.RUnlock()
 
 	if !exists {
 		// Try to get fresh data from HomeAssistant
-		freshDevice, err := m.haClient.GetEntity(deviceID)
+		freshDevice, err := m.haClient.GetEntity(ctx, deviceID)
 		if err != nil {
 			return nil, fmt.Errorf("device not found: %s", deviceID)
 		}
@@ -79,14 +100,42 @@ func (m *Manager) GetDevice(deviceID string) (*models.Device, error) {
 	return &device, nil
 }
 
-func (m *Manager) RefreshDevices() error {
-	devices, err := m.haClient.GetEntities()
+func (m *Manager) RefreshDevices(ctx context.Context) error {
+	devices, unreachable, err := m.fetchDevices(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to fetch devices from HomeAssistant: %w", err)
 	}
 
 	m.devicesMutex.Lock()
-	defer m.devicesMutex.Unlock()
+
+	// Devices of instances that couldn't be reached keep their last known state
+	if len(unreachable) > 0 {
+		offline := make(map[string]bool, len(unreachable))
+		for _, instance := range unreachable {
+			offline[instance] = true
+		}
+		for _, device := range m.devices {
+			if offline[device.Instance] {
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	// Devices seen for the first time aren't reported as changes
+	var changes []models.DeviceStateEvent
+	for _, device := range devices {
+		previous, known := m.devices[device.ID]
+		if known && (previous.State != device.State || !reflect.DeepEqual(previous.Attributes, device.Attributes)) {
+			changes = append(changes, models.DeviceStateEvent{
+				DeviceID:      device.ID,
+				Name:          device.Name,
+				PreviousState: previous.State,
+				State:         device.State,
+				Attributes:    device.Attributes,
+				LastChanged:   device.LastChanged,
+			})
+		}
+	}
 
 	// Clear existing devices
 	m.devices = make(map[string]models.Device)
@@ -97,30 +146,103 @@ func (m *Manager) RefreshDevices() error {
 	}
 
 	m.lastUpdate = time.Now()
+	m.devicesMutex.Unlock()
+
 	logrus.Infof("Refreshed %d devices from HomeAssistant", len(devices))
 
+	for _, change := range changes {
+		m.events.Publish(events.TopicDeviceState, change)
+	}
+
 	return nil
 }
 
+// WatchStates refreshes devices every interval until ctx is done, so state changes
+// made outside GPT-Home are published as they are noticed
+func (m *Manager) WatchStates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.RefreshDevices(ctx); err != nil {
+				logrus.WithError(err).Warn("Failed to poll device states")
+			}
+		}
+	}
+}
+
 func (m *Manager) ExecuteAction(action models.DeviceAction) error {
 	// This would need device context - for now, return error
 	return fmt.Errorf("action execution requires device context")
 }
 
-func (m *Manager) ExecuteActionOnDevice(deviceID string, action models.DeviceAction) error {
-	device, err := m.GetDevice(deviceID)
+func (m *Manager) ExecuteActionOnDevice(ctx context.Context, deviceID string, action models.DeviceAction) error {
+	return m.ExecuteActionAs(ctx, "", deviceID, action)
+}
+
+// ExecuteActionAs executes an action on a device on behalf of principal. Actions
+// the ACL doesn't allow fail with an *AccessDeniedError.
+func (m *Manager) ExecuteActionAs(ctx context.Context, principal, deviceID string, action models.DeviceAction) error {
+	result := models.TargetResult{Target: deviceID, Action: action.Action}
+	defer func() { m.events.Publish(events.TopicActionResult, result) }()
+
+	call, warning, err := m.prepareAction(ctx, principal, deviceID, action)
 	if err != nil {
-		return fmt.Errorf("device not found: %s", deviceID)
+		result.Status = actionErrorStatus(err)
+		result.Error = err.Error()
+		return err
 	}
 
-	// Validate action before execution
-	validationResult := m.validator.ValidateAction(&action)
-	if !validationResult.Valid {
-		return fmt.Errorf("action validation failed: %s", validationResult.Error)
+	if warning != "" {
+		logrus.Warnf("Action warning for device %s: %s", deviceID, warning)
+	}
+
+	// Execute the service call
+	if err := m.haClient.CallService(ctx, call.domain, call.service, deviceID, call.serviceData); err != nil {
+		result.Status = models.ActionStatusHAError
+		result.Error = err.Error()
+		return fmt.Errorf("failed to execute action: %w", err)
 	}
 
-	if validationResult.Warning != "" {
-		logrus.Warnf("Action warning for device %s: %s", deviceID, validationResult.Warning)
+	result.Status = models.ActionStatusSuccess
+	if warning != "" {
+		result.Status = models.ActionStatusWarning
+		result.Warning = warning
+	}
+
+	logrus.Infof("Executed action %s on device %s", action.Action, deviceID)
+	return nil
+}
+
+// serviceCall is a validated action mapped onto a HomeAssistant service
+type serviceCall struct {
+	instance    string // Home Assistant instance owning the device, when there are several
+	domain      string
+	service     string
+	serviceData map[string]interface{}
+}
+
+// prepareAction checks that principal may perform an action on a device, validates
+// it and maps it to a service call without executing it. It returns any validation
+// warning alongside the call.
+func (m *Manager) prepareAction(ctx context.Context, principal, deviceID string, action models.DeviceAction) (*serviceCall, string, error) {
+	device, err := m.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, "", fmt.Errorf("device not found: %s", deviceID)
+	}
+
+	if !m.acl.CanControl(principal, *device, action.Action) {
+		return nil, "", &AccessDeniedError{Principal: principal, DeviceID: deviceID, DeviceName: device.Name, Action: action.Action}
+	}
+
+	// Validate action before execution, against what this device can do
+	validationResult := m.validator.ValidateActionFor(*device, &action)
+	if !validationResult.Valid {
+		return nil, "", fmt.Errorf("action validation failed: %s", validationResult.Error)
 	}
 
 	// Use the safe action from validation
@@ -129,16 +251,45 @@ func (m *Manager) ExecuteActionOnDevice(deviceID string, action models.DeviceAct
 	// Map action to HomeAssistant service call
 	domain, service, serviceData := m.mapActionToService(device, *safeAction)
 	if domain == "" || service == "" {
-		return fmt.Errorf("unsupported action %s for device type %s", safeAction.Action, device.Type)
+		return nil, "", fmt.Errorf("unsupported action %s for device type %s", safeAction.Action, device.Type)
 	}
 
-	// Execute the service call
-	if err := m.haClient.CallService(domain, service, deviceID, serviceData); err != nil {
-		return fmt.Errorf("failed to execute action: %w", err)
+	return &serviceCall{instance: device.Instance, domain: domain, service: service, serviceData: serviceData}, validationResult.Warning, nil
+}
+
+// actionErrorStatus is the result status for an action that couldn't be prepared
+func actionErrorStatus(err error) models.ActionStatus {
+	if errors.Is(err, ErrAccessDenied) {
+		return models.ActionStatusForbidden
 	}
+	return models.ActionStatusValidationError
+}
 
-	logrus.Infof("Executed action %s on device %s", safeAction.Action, deviceID)
-	return nil
+// CanRead reports whether principal may see a device and its state. Without an
+// ACL every device can be read; with one, unknown devices can't.
+func (m *Manager) CanRead(ctx context.Context, principal, deviceID string) bool {
+	if m.acl == nil {
+		return true
+	}
+	device, err := m.GetDevice(ctx, deviceID)
+	if err != nil {
+		return false
+	}
+	return m.acl.CanRead(principal, *device)
+}
+
+// ReadableDevices keeps the devices principal may see
+func (m *Manager) ReadableDevices(principal string, devices []models.Device) []models.Device {
+	if m.acl == nil {
+		return devices
+	}
+	readable := make([]models.Device, 0, len(devices))
+	for _, device := range devices {
+		if m.acl.CanRead(principal, device) {
+			readable = append(readable, device)
+		}
+	}
+	return readable
 }
 
 func (m *Manager) FindDevicesByName(name string) []models.Device {
@@ -171,8 +322,17 @@ func (m *Manager) FindDevicesByType(deviceType models.DeviceType) []models.Devic
 	return matches
 }
 
-func (m *Manager) IsConnected() bool {
-	return m.haClient.TestConnection() == nil
+func (m *Manager) IsConnected(ctx context.Context) bool {
+	return m.haClient.TestConnection(ctx) == nil
+}
+
+// UpstreamStats reports the circuit breakers in front of HomeAssistant, if the
+// client has any
+func (m *Manager) UpstreamStats() []resilience.UpstreamStats {
+	if reporter, ok := m.haClient.(resilience.Reporter); ok {
+		return reporter.UpstreamStats()
+	}
+	return nil
 }
 
 func (m *Manager) mapActionToService(device *models.Device, action models.DeviceAction) (domain, service string, serviceData map[string]interface{}) {
@@ -204,6 +364,12 @@ func (m *Manager) mapActionToService(device *models.Device, action models.Device
 			if rgb, ok := action.Parameters["rgb_color"]; ok {
 				serviceData["rgb_color"] = rgb
 			}
+		case "set_color_temp":
+			service = "turn_on"
+			delete(serviceData, "color_temp")
+			if kelvin, ok := action.Parameters["color_temp"]; ok {
+				serviceData["color_temp_kelvin"] = kelvin
+			}
 		}
 
 	case models.DeviceTypeSwitch:
@@ -230,6 +396,10 @@ func (m *Manager) mapActionToService(device *models.Device, action models.Device
 			if mode, ok := action.Parameters["hvac_mode"]; ok {
 				serviceData["hvac_mode"] = mode
 			}
+		case "set_fan_mode":
+			service = "set_fan_mode"
+		case "set_preset_mode":
+			service = "set_preset_mode"
 		}
 
 	case models.DeviceTypeCover:
@@ -262,6 +432,8 @@ func (m *Manager) mapActionToService(device *models.Device, action models.Device
 			if speed, ok := action.Parameters["percentage"]; ok {
 				serviceData["percentage"] = speed
 			}
+		case "set_preset_mode":
+			service = "set_preset_mode"
 		}
 
 	case models.DeviceTypeMedia:
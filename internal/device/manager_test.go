@@ -1,11 +1,13 @@
 package device
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/events"
 	"github.com/tienpdinh/gpt-home/pkg/models"
 	"github.com/tienpdinh/gpt-home/test/mocks"
 )
@@ -25,7 +27,7 @@ func TestGetAllDevices(t *testing.T) {
 	manager := NewManager(mockClient)
 
 	// Test initial call (should fetch from HA)
-	devices, err := manager.GetAllDevices()
+	devices, err := manager.GetAllDevices(context.Background())
 	require.NoError(t, err)
 	assert.Greater(t, len(devices), 0)
 
@@ -33,7 +35,7 @@ func TestGetAllDevices(t *testing.T) {
 	assert.NotEmpty(t, manager.devices)
 
 	// Test cached call (within 30 seconds)
-	devices2, err := manager.GetAllDevices()
+	devices2, err := manager.GetAllDevices(context.Background())
 	require.NoError(t, err)
 	assert.Equal(t, len(devices), len(devices2))
 }
@@ -43,7 +45,7 @@ func TestGetAllDevicesWithConnectionError(t *testing.T) {
 	mockClient.SetConnectionError(true)
 	manager := NewManager(mockClient)
 
-	devices, err := manager.GetAllDevices()
+	devices, err := manager.GetAllDevices(context.Background())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "connection error")
 	assert.Empty(t, devices)
@@ -54,14 +56,14 @@ func TestGetDevice(t *testing.T) {
 	manager := NewManager(mockClient)
 
 	// Test getting existing device
-	device, err := manager.GetDevice("light.living_room")
+	device, err := manager.GetDevice(context.Background(), "light.living_room")
 	require.NoError(t, err)
 	assert.Equal(t, "light.living_room", device.ID)
 	assert.Equal(t, "Living Room Light", device.Name)
 	assert.Equal(t, models.DeviceTypeLight, device.Type)
 
 	// Test getting non-existent device
-	_, err = manager.GetDevice("nonexistent.device")
+	_, err = manager.GetDevice(context.Background(), "nonexistent.device")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "device not found")
 }
@@ -81,11 +83,11 @@ func TestRefreshDevices(t *testing.T) {
 	}
 	mockClient.AddMockEntity(customDevice)
 
-	err := manager.RefreshDevices()
+	err := manager.RefreshDevices(context.Background())
 	require.NoError(t, err)
 
 	// Verify the custom device is now available
-	device, err := manager.GetDevice("light.custom")
+	device, err := manager.GetDevice(context.Background(), "light.custom")
 	require.NoError(t, err)
 	assert.Equal(t, "Custom Light", device.Name)
 }
@@ -95,7 +97,7 @@ func TestRefreshDevicesWithError(t *testing.T) {
 	mockClient.SetConnectionError(true)
 	manager := NewManager(mockClient)
 
-	err := manager.RefreshDevices()
+	err := manager.RefreshDevices(context.Background())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to fetch devices")
 }
@@ -163,7 +165,7 @@ func TestExecuteActionOnDevice(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := manager.ExecuteActionOnDevice(tt.deviceID, tt.action)
+			err := manager.ExecuteActionOnDevice(context.Background(), tt.deviceID, tt.action)
 			if tt.wantErr {
 				assert.Error(t, err)
 			} else {
@@ -183,17 +185,33 @@ func TestExecuteActionOnDeviceWithServiceError(t *testing.T) {
 		Parameters: map[string]any{},
 	}
 
-	err := manager.ExecuteActionOnDevice("light.living_room", action)
+	err := manager.ExecuteActionOnDevice(context.Background(), "light.living_room", action)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to execute action")
 }
 
+func TestExecuteActionOnDeviceWithCancelledContext(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	// Populate cache so only the service call would reach HomeAssistant
+	_, err := manager.GetAllDevices(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = manager.ExecuteActionOnDevice(ctx, "light.living_room", models.DeviceAction{Action: "turn_on", Parameters: map[string]any{}})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, mockClient.ServiceCalls())
+}
+
 func TestFindDevicesByName(t *testing.T) {
 	mockClient := mocks.NewMockHomeAssistantClient()
 	manager := NewManager(mockClient)
 
 	// Populate cache
-	_, err := manager.GetAllDevices()
+	_, err := manager.GetAllDevices(context.Background())
 	require.NoError(t, err)
 
 	tests := []struct {
@@ -236,7 +254,7 @@ func TestFindDevicesByType(t *testing.T) {
 	manager := NewManager(mockClient)
 
 	// Populate cache
-	_, err := manager.GetAllDevices()
+	_, err := manager.GetAllDevices(context.Background())
 	require.NoError(t, err)
 
 	tests := []struct {
@@ -284,11 +302,11 @@ func TestIsConnected(t *testing.T) {
 	manager := NewManager(mockClient)
 
 	// Test successful connection
-	assert.True(t, manager.IsConnected())
+	assert.True(t, manager.IsConnected(context.Background()))
 
 	// Test connection failure
 	mockClient.SetConnectionError(true)
-	assert.False(t, manager.IsConnected())
+	assert.False(t, manager.IsConnected(context.Background()))
 }
 
 func TestMapActionToService(t *testing.T) {
@@ -322,6 +340,17 @@ func TestMapActionToService(t *testing.T) {
 			expectedService: "turn_on",
 			expectedData:    map[string]interface{}{"brightness": 255},
 		},
+		{
+			name:   "light set color temp",
+			device: &models.Device{Type: models.DeviceTypeLight},
+			action: models.DeviceAction{
+				Action:     "set_color_temp",
+				Parameters: map[string]any{"color_temp": 3000.0},
+			},
+			expectedDomain:  "light",
+			expectedService: "turn_on",
+			expectedData:    map[string]interface{}{"color_temp_kelvin": 3000.0},
+		},
 		{
 			name:            "switch toggle",
 			device:          &models.Device{Type: models.DeviceTypeSwitch},
@@ -401,7 +430,7 @@ func TestCacheExpiration(t *testing.T) {
 	manager := NewManager(mockClient)
 
 	// First call populates cache
-	devices1, err := manager.GetAllDevices()
+	devices1, err := manager.GetAllDevices(context.Background())
 	require.NoError(t, err)
 	assert.NotEmpty(t, devices1)
 
@@ -420,12 +449,61 @@ func TestCacheExpiration(t *testing.T) {
 	mockClient.AddMockEntity(newDevice)
 
 	// Second call should refresh cache
-	devices2, err := manager.GetAllDevices()
+	devices2, err := manager.GetAllDevices(context.Background())
 	require.NoError(t, err)
 	assert.Greater(t, len(devices2), len(devices1))
 
 	// Verify new device is available
-	device, err := manager.GetDevice("light.new")
+	device, err := manager.GetDevice(context.Background(), "light.new")
 	require.NoError(t, err)
 	assert.Equal(t, "New Light", device.Name)
 }
+
+func TestRefreshDevicesPublishesStateChanges(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	hub := events.NewHub()
+	sub := hub.Subscribe(10)
+	defer sub.Close()
+	sub.Add(events.TopicDeviceState)
+
+	manager := NewManagerWithOptions(mockClient, ManagerOptions{Events: hub})
+
+	// The first refresh only fills the cache
+	require.NoError(t, manager.RefreshDevices(context.Background()))
+	assert.Empty(t, sub.Events())
+
+	mockClient.UpdateMockEntity("light.living_room", map[string]interface{}{"state": "on"})
+	mockClient.UpdateMockEntity("light.bedroom", map[string]interface{}{"attributes": map[string]interface{}{"brightness": 50}})
+	require.NoError(t, manager.RefreshDevices(context.Background()))
+
+	changes := make(map[string]models.DeviceStateEvent)
+	for len(sub.Events()) > 0 {
+		event := <-sub.Events()
+		change := event.Data.(models.DeviceStateEvent)
+		changes[change.DeviceID] = change
+	}
+
+	require.Len(t, changes, 2)
+	assert.Equal(t, "off", changes["light.living_room"].PreviousState)
+	assert.Equal(t, "on", changes["light.living_room"].State)
+	assert.Equal(t, 50, changes["light.bedroom"].Attributes["brightness"])
+}
+
+func TestExecuteActionOnDevicePublishesResult(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	hub := events.NewHub()
+	sub := hub.Subscribe(10)
+	defer sub.Close()
+	sub.Add(events.TopicActionResult)
+
+	manager := NewManagerWithOptions(mockClient, ManagerOptions{Events: hub})
+
+	require.NoError(t, manager.ExecuteActionOnDevice(context.Background(), "light.living_room", models.DeviceAction{Action: "turn_on"}))
+	assert.Error(t, manager.ExecuteActionOnDevice(context.Background(), "light.nonexistent", models.DeviceAction{Action: "turn_on"}))
+
+	require.Len(t, sub.Events(), 2)
+	first := (<-sub.Events()).Data.(models.TargetResult)
+	assert.Equal(t, models.TargetResult{Target: "light.living_room", Action: "turn_on", Status: models.ActionStatusSuccess}, first)
+	second := (<-sub.Events()).Data.(models.TargetResult)
+	assert.Equal(t, models.ActionStatusValidationError, second.Status)
+}
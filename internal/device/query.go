@@ -0,0 +1,302 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// maxQueryDevices caps how many devices a single state question can consult
+const maxQueryDevices = 10
+
+// deviceMatcher describes which devices a keyword in a question refers to
+type deviceMatcher struct {
+	deviceClasses []string
+	domains       []string
+}
+
+// queryKeywords maps words in a question to the kinds of devices they ask about
+var queryKeywords = map[string]deviceMatcher{
+	"temperature": {deviceClasses: []string{"temperature"}, domains: []string{"climate"}},
+	"temp":        {deviceClasses: []string{"temperature"}, domains: []string{"climate"}},
+	"warm":        {deviceClasses: []string{"temperature"}, domains: []string{"climate"}},
+	"hot":         {deviceClasses: []string{"temperature"}, domains: []string{"climate"}},
+	"cold":        {deviceClasses: []string{"temperature"}, domains: []string{"climate"}},
+	"thermostat":  {domains: []string{"climate"}},
+	"heating":     {domains: []string{"climate"}},
+	"heater":      {domains: []string{"climate"}},
+	"humidity":    {deviceClasses: []string{"humidity"}},
+	"humid":       {deviceClasses: []string{"humidity"}},
+	"door":        {deviceClasses: []string{"door", "garage_door", "garage"}},
+	"garage":      {deviceClasses: []string{"garage_door", "garage"}},
+	"window":      {deviceClasses: []string{"window"}},
+	"motion":      {deviceClasses: []string{"motion", "occupancy", "presence"}},
+	"battery":     {deviceClasses: []string{"battery"}},
+	"power":       {deviceClasses: []string{"power"}},
+	"energy":      {deviceClasses: []string{"energy"}},
+	"light":       {domains: []string{"light"}},
+	"lamp":        {domains: []string{"light"}},
+	"switch":      {domains: []string{"switch"}},
+	"fan":         {domains: []string{"fan"}},
+	"blind":       {domains: []string{"cover"}},
+	"shade":       {domains: []string{"cover"}},
+	"curtain":     {domains: []string{"cover"}},
+	"lock":        {domains: []string{"lock"}},
+	"locked":      {domains: []string{"lock"}},
+	"speaker":     {domains: []string{"media_player"}},
+	"tv":          {domains: []string{"media_player"}},
+	"playing":     {domains: []string{"media_player"}},
+}
+
+// queryStopWords are ignored when matching question text against device names
+var queryStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "was": true, "what": true,
+	"whats": true, "which": true, "how": true, "in": true, "on": true, "of": true, "at": true,
+	"it": true, "its": true, "my": true, "our": true, "any": true, "all": true, "there": true,
+	"does": true, "do": true, "right": true, "now": true, "currently": true, "current": true,
+	"state": true, "status": true, "tell": true, "me": true, "check": true, "open": true,
+	"closed": true, "off": true, "still": true, "reading": true, "level": true,
+}
+
+// FindDevicesForQuery returns the devices a natural-language state question asks about.
+// Keywords like "temperature" or "door" narrow the candidates by device class or domain,
+// and words from the question are matched against device names to pick the best ones.
+// At most maxQueryDevices are returned.
+func (m *Manager) FindDevicesForQuery(ctx context.Context, query string) ([]models.Device, error) {
+	matches, err := m.matchQuery(ctx, query, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > maxQueryDevices {
+		matches = matches[:maxQueryDevices]
+	}
+	return matches, nil
+}
+
+// FindReadableDevicesForQuery is FindDevicesForQuery for principal: devices it
+// can't read are dropped before the cap, so they never crowd out ones it can.
+// When the question names only devices principal can't read, they are
+// returned as denied instead.
+func (m *Manager) FindReadableDevicesForQuery(ctx context.Context, principal, query string) (readable, denied []models.Device, err error) {
+	matches, err := m.matchQuery(ctx, query, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	readable = m.ReadableDevices(principal, matches)
+	if len(readable) == 0 {
+		readable, denied = nil, matches
+	}
+	if len(readable) > maxQueryDevices {
+		readable = readable[:maxQueryDevices]
+	}
+	if len(denied) > maxQueryDevices {
+		denied = denied[:maxQueryDevices]
+	}
+	return readable, denied, nil
+}
+
+// matchQuery returns every device query names, ordered by ID. With anyOfKind, a
+// query naming only a kind of device, as in "what's the temperature?", matches
+// every device of that kind; otherwise it matches none.
+func (m *Manager) matchQuery(ctx context.Context, query string, anyOfKind bool) ([]models.Device, error) {
+	devices, err := m.GetAllDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	words := tokenizeQuery(query)
+
+	// Narrow candidates by the kinds of devices mentioned, if any
+	var matchers []deviceMatcher
+	for _, word := range words {
+		if matcher, ok := queryKeywords[word]; ok {
+			matchers = append(matchers, matcher)
+		}
+	}
+
+	candidates := devices
+	if len(matchers) > 0 {
+		candidates = nil
+		for _, device := range devices {
+			for _, matcher := range matchers {
+				if matcher.matches(device) {
+					candidates = append(candidates, device)
+					break
+				}
+			}
+		}
+	}
+
+	// Score candidates by how many question words appear in their name
+	bestScore := 0
+	var best []models.Device
+	for _, device := range candidates {
+		score := nameOverlap(words, device)
+		switch {
+		case score > bestScore:
+			bestScore = score
+			best = []models.Device{device}
+		case score == bestScore && score > 0:
+			best = append(best, device)
+		}
+	}
+
+	matches := best
+	if bestScore == 0 {
+		if len(matchers) == 0 || !anyOfKind {
+			return nil, nil
+		}
+		// "What's the temperature?" with no name given: consult every matching device
+		matches = candidates
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches, nil
+}
+
+// DescribeState renders a device's current state, units and last change as a short fact
+func DescribeState(device models.Device) string {
+	return describeState(device, time.Now())
+}
+
+func describeState(device models.Device, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s) is %s", device.Name, device.ID, device.State)
+	if unit, ok := device.Attributes["unit_of_measurement"].(string); ok && unit != "" {
+		fmt.Fprintf(&b, " %s", unit)
+	}
+
+	var details []string
+	switch device.Type {
+	case models.DeviceTypeClimate:
+		if current, ok := device.Attributes["current_temperature"]; ok {
+			details = append(details, fmt.Sprintf("current temperature %v", current))
+		}
+		if target, ok := device.Attributes["temperature"]; ok {
+			details = append(details, fmt.Sprintf("target %v", target))
+		}
+		if action, ok := device.Attributes["hvac_action"]; ok {
+			details = append(details, fmt.Sprintf("currently %v", action))
+		}
+	case models.DeviceTypeLight:
+		if brightness, ok := device.Attributes["brightness"]; ok && device.State == "on" {
+			details = append(details, fmt.Sprintf("brightness %v/255", brightness))
+		}
+	case models.DeviceTypeCover:
+		if position, ok := device.Attributes["current_position"]; ok {
+			details = append(details, fmt.Sprintf("position %v%%", position))
+		}
+	case models.DeviceTypeFan:
+		if percentage, ok := device.Attributes["percentage"]; ok && device.State == "on" {
+			details = append(details, fmt.Sprintf("speed %v%%", percentage))
+		}
+	case models.DeviceTypeMedia:
+		if title, ok := device.Attributes["media_title"].(string); ok && title != "" {
+			details = append(details, fmt.Sprintf("playing %q", title))
+		}
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+	}
+
+	changed := device.LastChanged
+	if changed.IsZero() {
+		changed = device.LastUpdated
+	}
+	if !changed.IsZero() {
+		fmt.Fprintf(&b, ", last changed %s (%s)", formatAgo(now.Sub(changed)), changed.Format(time.RFC3339))
+	}
+
+	return b.String()
+}
+
+// formatAgo renders an elapsed duration the way a person would say it
+func formatAgo(elapsed time.Duration) string {
+	switch {
+	case elapsed < time.Minute:
+		return "just now"
+	case elapsed < time.Hour:
+		return pluralize(int(elapsed/time.Minute), "minute") + " ago"
+	case elapsed < 24*time.Hour:
+		return pluralize(int(elapsed/time.Hour), "hour") + " ago"
+	default:
+		return pluralize(int(elapsed/(24*time.Hour)), "day") + " ago"
+	}
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func (dm deviceMatcher) matches(device models.Device) bool {
+	for _, domain := range dm.domains {
+		if device.Domain == domain || string(device.Type) == domain {
+			return true
+		}
+	}
+	class, _ := device.Attributes["device_class"].(string)
+	for _, deviceClass := range dm.deviceClasses {
+		if class == deviceClass {
+			return true
+		}
+	}
+	return false
+}
+
+// nameOverlap counts the meaningful question words found in a device's name or entity ID.
+// Device-kind keywords already narrowed the candidates, so they don't count again here.
+func nameOverlap(words []string, device models.Device) int {
+	nameWords := make(map[string]bool)
+	for _, word := range tokenizeQuery(device.Name) {
+		nameWords[word] = true
+	}
+	objectID := device.ID
+	if idx := strings.Index(objectID, "."); idx != -1 {
+		objectID = objectID[idx+1:]
+	}
+	for _, word := range tokenizeQuery(strings.ReplaceAll(objectID, "_", " ")) {
+		nameWords[word] = true
+	}
+	// "the cabin heater" picks the heater at the cabin instance
+	for _, word := range tokenizeQuery(strings.NewReplacer("_", " ", "-", " ").Replace(device.Instance)) {
+		nameWords[word] = true
+	}
+
+	score := 0
+	for _, word := range words {
+		if _, isKeyword := queryKeywords[word]; isKeyword || queryStopWords[word] {
+			continue
+		}
+		if nameWords[word] {
+			score++
+		}
+	}
+	return score
+}
+
+// tokenizeQuery lowercases text and splits it into words, dropping punctuation,
+// possessives and simple plurals so "doors" matches "Door"
+func tokenizeQuery(text string) []string {
+	text = strings.ToLower(strings.ReplaceAll(text, "'s", ""))
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	words := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if len(field) > 3 && strings.HasSuffix(field, "s") && !strings.HasSuffix(field, "ss") && !strings.HasSuffix(field, "us") {
+			field = strings.TrimSuffix(field, "s")
+		}
+		words = append(words, field)
+	}
+	return words
+}
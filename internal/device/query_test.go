@@ -0,0 +1,156 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+func TestFindDevicesForQuery(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	mockClient.AddMockEntity(models.Device{
+		ID:       "sensor.bedroom_temperature",
+		Name:     "Bedroom Temperature",
+		Type:     models.DeviceTypeSensor,
+		State:    "19.8",
+		Domain:   "sensor",
+		EntityID: "sensor.bedroom_temperature",
+		Attributes: map[string]any{
+			"unit_of_measurement": "°C",
+			"device_class":        "temperature",
+		},
+	})
+	mockClient.AddMockEntity(models.Device{
+		ID:       "binary_sensor.front_door",
+		Name:     "Front Door",
+		Type:     models.DeviceTypeSensor,
+		State:    "off",
+		Domain:   "binary_sensor",
+		EntityID: "binary_sensor.front_door",
+		Attributes: map[string]any{
+			"device_class": "door",
+		},
+	})
+	manager := NewManager(mockClient)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "temperature in a named room",
+			query:    "What's the temperature in the bedroom?",
+			expected: []string{"sensor.bedroom_temperature"},
+		},
+		{
+			name:     "door by name",
+			query:    "Is the front door open?",
+			expected: []string{"binary_sensor.front_door"},
+		},
+		{
+			name:     "garage door by device class",
+			query:    "is the garage open?",
+			expected: []string{"cover.garage_door"},
+		},
+		{
+			name:     "temperature without a room consults all temperature devices",
+			query:    "what's the temperature?",
+			expected: []string{"climate.main", "sensor.bedroom_temperature", "sensor.temperature"},
+		},
+		{
+			name:     "light by name",
+			query:    "are the living room lights on?",
+			expected: []string{"light.living_room"},
+		},
+		{
+			name:     "unrelated question",
+			query:    "what's your name?",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices, err := manager.FindDevicesForQuery(context.Background(), tt.query)
+			require.NoError(t, err)
+
+			var ids []string
+			for _, d := range devices {
+				ids = append(ids, d.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+}
+
+func TestFindDevicesForQueryWithConnectionError(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	mockClient.SetConnectionError(true)
+	manager := NewManager(mockClient)
+
+	devices, err := manager.FindDevicesForQuery(context.Background(), "what's the temperature?")
+	assert.Error(t, err)
+	assert.Empty(t, devices)
+}
+
+func TestDescribeState(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		device   models.Device
+		expected string
+	}{
+		{
+			name: "sensor with unit",
+			device: models.Device{
+				ID:          "sensor.bedroom_temperature",
+				Name:        "Bedroom Temperature",
+				Type:        models.DeviceTypeSensor,
+				State:       "19.8",
+				Attributes:  map[string]any{"unit_of_measurement": "°C"},
+				LastChanged: now.Add(-5 * time.Minute),
+			},
+			expected: "Bedroom Temperature (sensor.bedroom_temperature) is 19.8 °C, last changed 5 minutes ago (2024-01-01T11:55:00Z)",
+		},
+		{
+			name: "climate with current and target temperature",
+			device: models.Device{
+				ID:    "climate.main",
+				Name:  "Main Thermostat",
+				Type:  models.DeviceTypeClimate,
+				State: "heat",
+				Attributes: map[string]any{
+					"current_temperature": 21.5,
+					"temperature":         22.0,
+				},
+				LastChanged: now.Add(-2 * time.Hour),
+			},
+			expected: "Main Thermostat (climate.main) is heat (current temperature 21.5, target 22), last changed 2 hours ago (2024-01-01T10:00:00Z)",
+		},
+		{
+			name: "falls back to last updated",
+			device: models.Device{
+				ID:          "cover.garage_door",
+				Name:        "Garage Door",
+				Type:        models.DeviceTypeCover,
+				State:       "open",
+				Attributes:  map[string]any{"current_position": 100},
+				LastUpdated: now.Add(-30 * time.Second),
+			},
+			expected: "Garage Door (cover.garage_door) is open (position 100%), last changed just now (2024-01-01T11:59:30Z)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, describeState(tt.device, now))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package device
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// deviceAnaphora are words that point back at devices from earlier in the conversation
+var deviceAnaphora = map[string]bool{
+	"it": true, "them": true, "they": true, "that": true, "those": true,
+	"this": true, "these": true, "both": true,
+}
+
+// actionAnaphora are words that ask for the previous action again
+var actionAnaphora = map[string]bool{
+	"same": true, "again": true,
+}
+
+// RefersBack reports whether a message points at earlier devices, as in
+// "turn it off" or "make them brighter"
+func RefersBack(message string) bool {
+	return containsAny(tokenizeQuery(message), deviceAnaphora)
+}
+
+// RepeatsAction reports whether a message asks for the previous action again,
+// as in "do the same for the bedroom lamp" or "do that again"
+func RepeatsAction(message string) bool {
+	return containsAny(tokenizeQuery(message), actionAnaphora)
+}
+
+func containsAny(words []string, set map[string]bool) bool {
+	for _, word := range words {
+		if set[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveTargets decides which devices an action asked for in message applies
+// to. Known devices in explicit (the targets the model named) win. Otherwise a
+// message that refers back with "it" or "them" targets the previously
+// referenced devices, then devices named in the message are used, and a
+// message naming none falls back to the previous devices too. Unlike state
+// questions, every named device is targeted, but a kind of device alone, as in
+// "turn on the lights", names none.
+func (m *Manager) ResolveTargets(ctx context.Context, message string, explicit, previous []string) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, id := range explicit {
+		if seen[id] {
+			continue
+		}
+		if _, err := m.GetDevice(ctx, id); err != nil {
+			logrus.Debugf("Ignoring unknown action target: %s", id)
+			continue
+		}
+		seen[id] = true
+		targets = append(targets, id)
+	}
+	if len(targets) > 0 {
+		return targets
+	}
+
+	if len(previous) > 0 && RefersBack(message) {
+		return append([]string(nil), previous...)
+	}
+
+	named, err := m.matchQuery(ctx, message, false)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to look up devices named in message")
+	}
+	if len(named) > 0 {
+		for _, device := range named {
+			targets = append(targets, device.ID)
+		}
+		return targets
+	}
+
+	return append([]string(nil), previous...)
+}
@@ -0,0 +1,98 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+)
+
+func TestRefersBackAndRepeatsAction(t *testing.T) {
+	assert.True(t, RefersBack("Turn it off"))
+	assert.True(t, RefersBack("make them brighter"))
+	assert.False(t, RefersBack("turn off the bedroom light"))
+
+	assert.True(t, RepeatsAction("Do the same for the porch"))
+	assert.True(t, RepeatsAction("again please"))
+	assert.False(t, RepeatsAction("turn it off"))
+}
+
+func TestResolveTargets(t *testing.T) {
+	manager := NewManager(mocks.NewMockHomeAssistantClient())
+	previous := []string{"light.living_room"}
+
+	tests := []struct {
+		name     string
+		message  string
+		explicit []string
+		expected []string
+	}{
+		{
+			name:     "known explicit targets win",
+			message:  "turn it off",
+			explicit: []string{"light.bedroom", "light.unknown", "light.bedroom"},
+			expected: []string{"light.bedroom"},
+		},
+		{
+			name:     "pronoun targets previous devices",
+			message:  "turn it off",
+			explicit: []string{"light.unknown"},
+			expected: previous,
+		},
+		{
+			name:     "named devices",
+			message:  "do the same for the porch switch",
+			expected: []string{"switch.porch"},
+		},
+		{
+			name:     "nothing named falls back to previous devices",
+			message:  "brighter please",
+			expected: previous,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, manager.ResolveTargets(context.Background(), tt.message, tt.explicit, previous))
+		})
+	}
+
+	assert.Empty(t, manager.ResolveTargets(context.Background(), "turn it off", nil, nil))
+}
+
+func TestResolveTargets_Uncapped(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	for i := 0; i < maxQueryDevices+2; i++ {
+		id := fmt.Sprintf("light.hall_%02d", i)
+		mockClient.AddMockEntity(models.Device{ID: id, EntityID: id, Name: fmt.Sprintf("Hall %d", i), Type: models.DeviceTypeLight, Domain: "light", State: "on"})
+	}
+	manager := NewManager(mockClient)
+
+	// A state question consults a few lights, but an action reaches them all
+	queried, err := manager.FindDevicesForQuery(context.Background(), "are the hall lights on?")
+	require.NoError(t, err)
+	assert.Len(t, queried, maxQueryDevices)
+
+	targets := manager.ResolveTargets(context.Background(), "turn off the hall lights", nil, nil)
+	assert.Len(t, targets, maxQueryDevices+2)
+	assert.Contains(t, targets, "light.hall_11")
+	assert.NotContains(t, targets, "light.living_room")
+}
+
+func TestResolveTargets_KindAloneNamesNothing(t *testing.T) {
+	mockClient := mocks.NewMockHomeAssistantClient()
+	manager := NewManager(mockClient)
+
+	// A state question about the lights consults them all
+	queried, err := manager.FindDevicesForQuery(context.Background(), "are the lights on?")
+	require.NoError(t, err)
+	assert.NotEmpty(t, queried)
+
+	// but an action needs to name which ones
+	assert.Empty(t, manager.ResolveTargets(context.Background(), "turn off the lights", nil, nil))
+	assert.Empty(t, mockClient.ServiceCalls())
+}
@@ -45,6 +45,10 @@ func (v *Validator) ValidateAction(action *models.DeviceAction) ValidationResult
 		return v.validateHumidity(action)
 	case "open", "close":
 		return v.validateCoverAction(action)
+	case "set_color":
+		return v.validateColor(action)
+	case "set_hvac_mode", "set_fan_mode", "set_preset_mode":
+		return v.validateMode(action)
 	default:
 		return ValidationResult{
 			Valid: false,
@@ -317,3 +321,80 @@ func (v *Validator) validateCoverAction(action *models.DeviceAction) ValidationR
 		SafeAction: action,
 	}
 }
+
+// validateColor validates an RGB color, three values of 0-255
+func (v *Validator) validateColor(action *models.DeviceAction) ValidationResult {
+	var channels []any
+	switch value := action.Parameters["rgb_color"].(type) {
+	case []any:
+		channels = value
+	case []int:
+		for _, channel := range value {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) != 3 {
+		return ValidationResult{
+			Valid: false,
+			Error: "color action requires an 'rgb_color' parameter of three values",
+		}
+	}
+
+	rgb := make([]int, 3)
+	for i, channel := range channels {
+		var value float64
+		switch c := channel.(type) {
+		case float64:
+			value = c
+		case int:
+			value = float64(c)
+		default:
+			return ValidationResult{
+				Valid: false,
+				Error: "rgb_color values must be numbers",
+			}
+		}
+		if value < 0 || value > 255 {
+			return ValidationResult{
+				Valid: false,
+				Error: "rgb_color values must be between 0 and 255",
+			}
+		}
+		rgb[i] = int(value)
+	}
+
+	return ValidationResult{
+		Valid: true,
+		SafeAction: &models.DeviceAction{
+			Action:     action.Action,
+			Parameters: map[string]any{"rgb_color": rgb},
+		},
+	}
+}
+
+// modeParameters names the parameter each mode action sets
+var modeParameters = map[string]string{
+	"set_hvac_mode":   "hvac_mode",
+	"set_fan_mode":    "fan_mode",
+	"set_preset_mode": "preset_mode",
+}
+
+// validateMode validates actions that pick one of a device's named modes
+func (v *Validator) validateMode(action *models.DeviceAction) ValidationResult {
+	param := modeParameters[action.Action]
+	mode, ok := action.Parameters[param].(string)
+	if !ok || mode == "" {
+		return ValidationResult{
+			Valid: false,
+			Error: fmt.Sprintf("%s action requires a '%s' parameter", action.Action, param),
+		}
+	}
+
+	return ValidationResult{
+		Valid: true,
+		SafeAction: &models.DeviceAction{
+			Action:     action.Action,
+			Parameters: map[string]any{param: mode},
+		},
+	}
+}
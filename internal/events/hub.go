@@ -0,0 +1,164 @@
+package events
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Topics clients can subscribe to
+const (
+	TopicDeviceState         = "device_state"
+	TopicActionResult        = "action_result"
+	TopicConversationMessage = "conversation_message"
+)
+
+var topics = map[string]bool{
+	TopicDeviceState:         true,
+	TopicActionResult:        true,
+	TopicConversationMessage: true,
+}
+
+// IsTopic reports whether topic is one the hub publishes
+func IsTopic(topic string) bool {
+	return topics[topic]
+}
+
+// Event is a single notification delivered to subscribers
+type Event struct {
+	Topic     string    `json:"topic"`
+	Data      any       `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Hub fans published events out to the subscribers of each topic. A nil *Hub
+// is valid and discards everything published to it.
+type Hub struct {
+	mutex       sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber with no topics. buffer is how many events may
+// queue up before the subscriber is considered too slow and closed.
+func (h *Hub) Subscribe(buffer int) *Subscription {
+	sub := &Subscription{
+		hub:    h,
+		events: make(chan Event, buffer),
+		topics: make(map[string]bool),
+	}
+
+	h.mutex.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mutex.Unlock()
+
+	return sub
+}
+
+// Publish delivers an event to every subscriber of its topic without blocking.
+// Subscribers whose buffer is full are closed so they can reconnect and resync
+// instead of silently missing events.
+func (h *Hub) Publish(topic string, data any) {
+	if h == nil {
+		return
+	}
+
+	event := Event{Topic: topic, Data: data, Timestamp: time.Now()}
+
+	var slow []*Subscription
+	h.mutex.RLock()
+	for sub := range h.subscribers {
+		if !sub.IsSubscribed(topic) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, sub := range slow {
+		logrus.Warnf("Closing slow event subscriber after dropping %s event", topic)
+		sub.Close()
+	}
+}
+
+// SubscriberCount returns how many subscribers are connected
+func (h *Hub) SubscriberCount() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.subscribers)
+}
+
+// Subscription receives the events of the topics it is subscribed to
+type Subscription struct {
+	hub    *Hub
+	events chan Event
+	mutex  sync.RWMutex
+	topics map[string]bool
+}
+
+// Events is closed when the subscription is closed
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Add subscribes to more topics
+func (s *Subscription) Add(topics ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+}
+
+// Remove unsubscribes from topics
+func (s *Subscription) Remove(topics ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, topic := range topics {
+		delete(s.topics, topic)
+	}
+}
+
+// IsSubscribed reports whether the subscription receives events of topic
+func (s *Subscription) IsSubscribed(topic string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.topics[topic]
+}
+
+// Topics returns the subscribed topics in sorted order
+func (s *Subscription) Topics() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Close unregisters the subscription and closes its event channel. It is safe to
+// call more than once.
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+
+	if _, ok := s.hub.subscribers[s]; !ok {
+		return
+	}
+	delete(s.hub.subscribers, s)
+	close(s.events)
+}
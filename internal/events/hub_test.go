@@ -0,0 +1,83 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events():
+		require.True(t, ok, "subscription closed")
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestPublish_DeliversToSubscribedTopics(t *testing.T) {
+	hub := NewHub()
+	devices := hub.Subscribe(4)
+	defer devices.Close()
+	devices.Add(TopicDeviceState)
+
+	everything := hub.Subscribe(4)
+	defer everything.Close()
+	everything.Add(TopicDeviceState, TopicActionResult)
+
+	hub.Publish(TopicActionResult, "result")
+	hub.Publish(TopicDeviceState, "state")
+
+	assert.Equal(t, "state", receive(t, devices).Data)
+	assert.Empty(t, devices.Events())
+
+	assert.Equal(t, TopicActionResult, receive(t, everything).Topic)
+	event := receive(t, everything)
+	assert.Equal(t, TopicDeviceState, event.Topic)
+	assert.False(t, event.Timestamp.IsZero())
+}
+
+func TestSubscription_Remove(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(4)
+	defer sub.Close()
+
+	sub.Add(TopicDeviceState, TopicConversationMessage)
+	sub.Remove(TopicDeviceState)
+	assert.Equal(t, []string{TopicConversationMessage}, sub.Topics())
+
+	hub.Publish(TopicDeviceState, "state")
+	assert.Empty(t, sub.Events())
+}
+
+func TestPublish_ClosesSlowSubscribers(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(1)
+	sub.Add(TopicDeviceState)
+
+	hub.Publish(TopicDeviceState, 1)
+	hub.Publish(TopicDeviceState, 2)
+
+	assert.Equal(t, 0, hub.SubscriberCount())
+	assert.Equal(t, 1, receive(t, sub).Data)
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+
+	// Closing again is harmless
+	sub.Close()
+}
+
+func TestNilHub_DiscardsEvents(t *testing.T) {
+	var hub *Hub
+	assert.NotPanics(t, func() { hub.Publish(TopicDeviceState, "state") })
+}
+
+func TestIsTopic(t *testing.T) {
+	assert.True(t, IsTopic(TopicConversationMessage))
+	assert.False(t, IsTopic("weather"))
+}
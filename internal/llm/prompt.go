@@ -0,0 +1,336 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// systemInstructions tell the model who it is and how to answer. They're always sent in full.
+const systemInstructions = `You are Luna, a helpful smart home assistant. You can control lights, switches, climate, and other devices.
+
+Available actions:
+- turn_on/turn_off: For lights and switches
+- set_brightness: For lights (0-255)
+- set_temperature: For climate (18-28 degrees)
+- set_color_temp: For lights (kelvin 2700-6500)
+
+Respond naturally and briefly as Luna. If you perform an action, mention it. Always introduce yourself as Luna when asked about your name.
+
+Each action's targets are device IDs from the device list. When the user says "it", "them" or "the same", they mean the previously referenced devices or the last action.
+
+You must respond with valid JSON only (no additional text) in this exact format:
+{
+  "understanding": "brief description of what the user asked",
+  "response": "natural conversational response to the user",
+  "actions": [{"action": "action_name", "targets": ["light.kitchen"], "parameters": {"key": "value"}}],
+  "confidence": 0.95
+}`
+
+const (
+	// defaultContextWindow is Ollama's num_ctx for models we know nothing about
+	defaultContextWindow = 2048
+	// defaultContextLength caps num_ctx for NewService; big windows are slow on small hardware
+	defaultContextLength = 4096
+	// charsPerToken is the rough size of a token in English text
+	charsPerToken = 4
+)
+
+// modelContextWindows holds the context window of known model families
+var modelContextWindows = map[string]int{
+	"llama2":    4096,
+	"llama3":    8192,
+	"llama3.1":  131072,
+	"llama3.2":  131072,
+	"tinyllama": 2048,
+	"mistral":   32768,
+	"phi3":      4096,
+	"gemma2":    8192,
+	"qwen2.5":   32768,
+}
+
+// Prompt section names reported when a section had to be cut to fit
+const (
+	sectionMessage = "message"
+	sectionSummary = "summary"
+	sectionDevices = "devices"
+	sectionHistory = "history"
+)
+
+// contextWindow returns the num_ctx to run a model with: its known context
+// window, capped by limit when limit is positive
+func contextWindow(model string, limit int) int {
+	name := strings.ToLower(model)
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	// The longest matching family wins, so llama3.2 isn't mistaken for llama3
+	window, family := 0, ""
+	for f, size := range modelContextWindows {
+		if strings.HasPrefix(name, f) && len(f) > len(family) {
+			window, family = size, f
+		}
+	}
+
+	switch {
+	case window == 0 && limit > 0:
+		return limit
+	case window == 0:
+		return defaultContextWindow
+	case limit > 0 && limit < window:
+		return limit
+	default:
+		return window
+	}
+}
+
+// estimateTokens approximates how many tokens text takes. It counts bytes, so
+// it errs high for non-English text rather than overflowing the window.
+func estimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// truncateToTokens cuts text to about maxTokens, at a word boundary when one
+// is close, and marks the cut with "…"
+func truncateToTokens(text string, maxTokens int) string {
+	if estimateTokens(text) <= maxTokens {
+		return text
+	}
+
+	limit := maxTokens*charsPerToken - len("…")
+	if limit <= 0 {
+		return ""
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+
+	cut := text[:limit]
+	if i := strings.LastIndexAny(cut, " \n"); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimSpace(cut) + "…"
+}
+
+// promptAssembler fits a chat prompt into a model's context window. The system
+// instructions are always sent and room is kept for the reply. The rest of the
+// budget goes to the user's message, then the conversation summary, the device
+// inventory and finally as much recent history as fits. Each part is cut the
+// same way every time, so the same inputs always give the same prompt.
+type promptAssembler struct {
+	contextWindow int // num_ctx
+	replyTokens   int // kept free for the model's answer
+}
+
+// assembledPrompt is a prompt and how much of the context window it uses
+type assembledPrompt struct {
+	text      string
+	tokens    int
+	truncated []string // sections that were cut to fit
+}
+
+func (s *Service) newPromptAssembler() promptAssembler {
+	window := contextWindow(s.config.Model, s.config.ContextLength)
+
+	reply := s.config.MaxTokens
+	if reply <= 0 || reply > window/2 {
+		reply = window / 4
+	}
+
+	return promptAssembler{contextWindow: window, replyTokens: reply}
+}
+
+// assemble builds the prompt for message. history holds the earlier messages of
+// the conversation, not message itself.
+func (a promptAssembler) assemble(message string, ctx models.Context, history []models.Message, devices []models.Device) assembledPrompt {
+	var truncated []string
+	budget := a.contextWindow - a.replyTokens - estimateTokens(systemInstructions)
+
+	// The user's message may take up to half of what's left
+	const humanPrefix, assistantSuffix = "\n\nHuman: ", "\nAssistant:"
+	messageText := truncateToTokens(message, budget/2-estimateTokens(humanPrefix+assistantSuffix))
+	if messageText != message {
+		truncated = append(truncated, sectionMessage)
+	}
+	human := humanPrefix + messageText + assistantSuffix
+	budget -= estimateTokens(human)
+
+	// The summary stands in for everything before the history, so it comes next
+	summary := ""
+	if ctx.Summary != "" {
+		const header = "\n\nSummary of the earlier conversation:\n"
+		text := truncateToTokens(ctx.Summary, budget/4-estimateTokens(header))
+		if text != ctx.Summary {
+			truncated = append(truncated, sectionSummary)
+		}
+		if text != "" {
+			summary = header + text
+			budget -= estimateTokens(summary)
+		}
+	}
+
+	inventory, cut := deviceInventory(devices, ctx.ReferencedDevices, ctx.LastAction, budget/2)
+	if cut {
+		truncated = append(truncated, sectionDevices)
+	}
+	budget -= estimateTokens(inventory)
+
+	// Messages the summary covers don't need repeating
+	recent := history
+	if ctx.SummarizedMessages <= len(history) {
+		recent = history[ctx.SummarizedMessages:]
+	}
+	conversation, cut := recentHistory(recent, budget)
+	if cut {
+		truncated = append(truncated, sectionHistory)
+	}
+
+	text := systemInstructions + inventory + summary + conversation + human
+	return assembledPrompt{
+		text:      text,
+		tokens:    estimateTokens(text),
+		truncated: truncated,
+	}
+}
+
+// deviceInventory lists devices within maxTokens, those the conversation
+// referenced first and the rest by ID, after the previously referenced devices
+// and last action. It reports whether anything was left out.
+func deviceInventory(devices []models.Device, referenced []string, lastAction *models.DeviceAction, maxTokens int) (string, bool) {
+	var context []string
+	if len(referenced) > 0 {
+		context = append(context, "Previously referenced devices: "+strings.Join(referenced, ", "))
+	}
+	if lastAction != nil {
+		context = append(context, "Last action: "+describeAction(*lastAction))
+	}
+
+	var b strings.Builder
+	if len(context) > 0 {
+		b.WriteString("\n\n" + strings.Join(context, "\n"))
+	}
+	if estimateTokens(b.String()) > maxTokens {
+		return "", true
+	}
+	if len(devices) == 0 {
+		return b.String(), false
+	}
+
+	rank := make(map[string]int, len(referenced))
+	for i, id := range referenced {
+		rank[id] = i + 1
+	}
+	sorted := make([]models.Device, len(devices))
+	copy(sorted, devices)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, rj := rank[sorted[i].ID], rank[sorted[j].ID]
+		switch {
+		case ri > 0 && rj > 0:
+			return ri < rj
+		case ri > 0 || rj > 0:
+			return ri > 0
+		default:
+			return sorted[i].ID < sorted[j].ID
+		}
+	})
+
+	const header = "\n\nDevices in the home:"
+	omittedNote := func(n int) string { return fmt.Sprintf("\n- …and %d more", n) }
+
+	used := estimateTokens(b.String() + header)
+	var lines strings.Builder
+	listed := 0
+	for _, d := range sorted {
+		line := fmt.Sprintf("\n- %s (%s): %s", d.ID, d.Name, d.State)
+		if d.Area != "" {
+			line += ", in " + d.Area
+		}
+
+		// Leave room to say how many devices didn't fit
+		reserve := 0
+		if listed < len(sorted)-1 {
+			reserve = estimateTokens(omittedNote(len(sorted)))
+		}
+		if used+estimateTokens(line)+reserve > maxTokens {
+			break
+		}
+		lines.WriteString(line)
+		used += estimateTokens(line)
+		listed++
+	}
+
+	if listed == 0 {
+		return b.String(), true
+	}
+	b.WriteString(header)
+	b.WriteString(lines.String())
+	if listed < len(sorted) {
+		b.WriteString(omittedNote(len(sorted) - listed))
+		return b.String(), true
+	}
+	return b.String(), false
+}
+
+// recentHistory renders as many of the latest messages as fit in maxTokens,
+// whole messages only. It reports whether older messages were left out.
+func recentHistory(history []models.Message, maxTokens int) (string, bool) {
+	if len(history) == 0 {
+		return "", false
+	}
+
+	const header = "\n\nRecent conversation history:"
+	used := estimateTokens(header)
+	start := len(history)
+	var lines []string
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		role := "User"
+		if msg.Role == models.MessageRoleAssistant {
+			role = "Luna"
+		}
+		line := fmt.Sprintf("\n%s: %s", role, msg.Content)
+		if used+estimateTokens(line) > maxTokens {
+			break
+		}
+		lines = append(lines, line)
+		used += estimateTokens(line)
+		start = i
+	}
+
+	if len(lines) == 0 {
+		return "", true
+	}
+
+	var b strings.Builder
+	b.WriteString(header)
+	for i := len(lines) - 1; i >= 0; i-- {
+		b.WriteString(lines[i])
+	}
+	return b.String(), start > 0
+}
+
+// describeAction renders an action and its parameters, in a stable order
+func describeAction(action models.DeviceAction) string {
+	if len(action.Parameters) == 0 {
+		return action.Action
+	}
+
+	keys := make([]string, 0, len(action.Parameters))
+	for key := range action.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, len(keys))
+	for i, key := range keys {
+		params[i] = fmt.Sprintf("%s=%v", key, action.Parameters[key])
+	}
+	return fmt.Sprintf("%s (%s)", action.Action, strings.Join(params, ", "))
+}
@@ -0,0 +1,152 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model    string
+		limit    int
+		expected int
+	}{
+		{"llama3.2", 0, 131072},
+		{"llama3.2:3b", 4096, 4096},
+		{"library/llama3:latest", 0, 8192},
+		{"tinyllama", 4096, 2048},
+		{"some-new-model", 0, defaultContextWindow},
+		{"some-new-model", 16384, 16384},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.model, tt.limit), func(t *testing.T) {
+			assert.Equal(t, tt.expected, contextWindow(tt.model, tt.limit))
+		})
+	}
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	assert.Equal(t, "short text", truncateToTokens("short text", 10))
+
+	cut := truncateToTokens("turn on every light in the whole house please", 5)
+	assert.Equal(t, "turn on every…", cut)
+
+	// Multi-byte characters are never split
+	cut = truncateToTokens(strings.Repeat("é", 40), 5)
+	assert.True(t, utf8.ValidString(cut))
+	assert.LessOrEqual(t, estimateTokens(cut), 5)
+
+	assert.Empty(t, truncateToTokens("anything at all", 0))
+}
+
+func newTestAssembler(window int) promptAssembler {
+	return promptAssembler{contextWindow: window, replyTokens: window / 4}
+}
+
+func TestAssemblePrompt_Sections(t *testing.T) {
+	history := []models.Message{
+		{Role: models.MessageRoleUser, Content: "turn on the kitchen light"},
+		{Role: models.MessageRoleAssistant, Content: "The kitchen light is on."},
+	}
+	devices := []models.Device{
+		{ID: "switch.fan", Name: "Fan", State: "off"},
+		{ID: "light.kitchen", Name: "Kitchen Light", State: "on", Area: "Kitchen"},
+	}
+	ctx := models.Context{
+		ReferencedDevices: []string{"light.kitchen"},
+		LastAction:        &models.DeviceAction{Action: "set_brightness", Parameters: map[string]any{"brightness": 128}},
+	}
+
+	prompt := newTestAssembler(4096).assemble("and dim it", ctx, history, devices)
+
+	assert.Empty(t, prompt.truncated)
+	assert.True(t, strings.HasPrefix(prompt.text, systemInstructions))
+	assert.True(t, strings.HasSuffix(prompt.text, "\n\nHuman: and dim it\nAssistant:"))
+	assert.Contains(t, prompt.text, "\n\nPreviously referenced devices: light.kitchen\nLast action: set_brightness (brightness=128)")
+	assert.Contains(t, prompt.text, "Devices in the home:\n- light.kitchen (Kitchen Light): on, in Kitchen\n- switch.fan (Fan): off")
+	assert.Contains(t, prompt.text, "Recent conversation history:\nUser: turn on the kitchen light\nLuna: The kitchen light is on.")
+	assert.Equal(t, estimateTokens(prompt.text), prompt.tokens)
+
+	// The message and history appear once each
+	assert.Equal(t, 1, strings.Count(prompt.text, "and dim it"))
+	assert.Equal(t, 1, strings.Count(prompt.text, "Recent conversation history"))
+}
+
+func TestAssemblePrompt_FitsBudget(t *testing.T) {
+	var history []models.Message
+	for i := 0; i < 200; i++ {
+		history = append(history, models.Message{Role: models.MessageRoleUser, Content: fmt.Sprintf("message number %d", i)})
+	}
+	var devices []models.Device
+	for i := 0; i < 200; i++ {
+		devices = append(devices, models.Device{ID: fmt.Sprintf("light.room_%03d", i), Name: "Light", State: "off"})
+	}
+	ctx := models.Context{Summary: strings.Repeat("The user likes warm light. ", 200)}
+
+	assembler := newTestAssembler(2048)
+	prompt := assembler.assemble(strings.Repeat("please ", 2000), ctx, history, devices)
+
+	assert.LessOrEqual(t, prompt.tokens, assembler.contextWindow-assembler.replyTokens)
+	assert.Equal(t, []string{sectionMessage, sectionSummary, sectionDevices, sectionHistory}, prompt.truncated)
+
+	// The newest history is kept, and devices say how many were left out
+	assert.Contains(t, prompt.text, "User: message number 199\n\nHuman: please")
+	assert.NotContains(t, prompt.text, "message number 0\n")
+	assert.Regexp(t, `- …and \d+ more`, prompt.text)
+
+	// The same inputs always give the same prompt, whatever order devices come in
+	reversed := make([]models.Device, len(devices))
+	for i, d := range devices {
+		reversed[len(devices)-1-i] = d
+	}
+	again := assembler.assemble(strings.Repeat("please ", 2000), ctx, history, reversed)
+	assert.Equal(t, prompt.text, again.text)
+}
+
+func TestAssemblePrompt_SkipsSummarizedMessages(t *testing.T) {
+	history := numberedMessages(0, 30)
+	ctx := models.Context{Summary: "Earlier the user set up the lights.", SummarizedMessages: 25}
+
+	prompt := newTestAssembler(4096).assemble("hi", ctx, history, nil)
+
+	assert.Contains(t, prompt.text, "message 25\n")
+	assert.NotContains(t, prompt.text, "message 24\n")
+}
+
+func TestChat_RecordsTokenUsage(t *testing.T) {
+	var options map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[]}`))
+		case "/api/generate":
+			var req OllamaGenerateRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			options = req.Options
+			w.Write([]byte(`{"response":"{\"response\":\"Done.\",\"actions\":[],\"confidence\":0.8}","done":true,"prompt_eval_count":321,"eval_count":12}`))
+		}
+	}))
+	defer server.Close()
+
+	service := NewService(server.URL, "llama3.2")
+	require.NoError(t, service.LoadModel())
+
+	reply, err := service.Chat(context.Background(), "turn on the lights", models.Context{}, nil, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Done.", reply.Response)
+	assert.InDelta(t, 0.8, reply.Confidence, 0.001)
+	assert.Equal(t, models.TokenUsage{PromptTokens: 321, CompletionTokens: 12, ContextWindow: defaultContextLength}, reply.Usage)
+	assert.Equal(t, float64(defaultContextLength), options["num_ctx"])
+}
@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// questionPrefixes start messages that ask about state rather than request a change
+var questionPrefixes = []string{
+	"what", "whats", "what's", "is", "are", "was", "were", "how", "which", "does", "do", "did",
+	"has", "have", "when", "where", "tell me", "check", "show me", "can you tell",
+}
+
+// commandPrefixes start messages that ask Luna to change something
+var commandPrefixes = []string{
+	"turn", "switch", "set", "dim", "brighten", "open", "close", "lock", "unlock",
+	"start", "stop", "play", "pause", "make", "change", "increase", "decrease", "raise", "lower",
+	"please turn", "please set", "do the same", "do that again", "do it again", "same for",
+}
+
+// IsStateQuery reports whether a message asks about the current state of devices
+// (e.g. "what's the temperature in the bedroom?") rather than asking to control them
+func IsStateQuery(message string) bool {
+	message = strings.ToLower(strings.TrimSpace(message))
+	if message == "" {
+		return false
+	}
+
+	for _, prefix := range commandPrefixes {
+		if hasWordPrefix(message, prefix) {
+			return false
+		}
+	}
+
+	for _, prefix := range questionPrefixes {
+		if hasWordPrefix(message, prefix) {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(message, "?")
+}
+
+// historyPhrases mark questions about what devices did in the past
+var historyPhrases = []string{
+	"when did", "when was", "when were", "last time", "how long", "how many times", "how often", "history",
+}
+
+// IsHistoryQuery reports whether a message asks about past device activity
+// (e.g. "when did the garage door last open?" or "how long was the heater on today?")
+func IsHistoryQuery(message string) bool {
+	message = strings.ToLower(strings.TrimSpace(message))
+	for _, prefix := range commandPrefixes {
+		if hasWordPrefix(message, prefix) {
+			return false
+		}
+	}
+
+	for _, phrase := range historyPhrases {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryWindow picks the time range a history question refers to. "today" and
+// "yesterday" map to calendar days, "last"/"when" questions look back a week, and
+// anything else covers the past 24 hours.
+func HistoryWindow(message string, now time.Time) (start, end time.Time) {
+	message = strings.ToLower(message)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch {
+	case strings.Contains(message, "yesterday"):
+		return midnight.AddDate(0, 0, -1), midnight
+	case strings.Contains(message, "today") || strings.Contains(message, "this morning"):
+		return midnight, now
+	case strings.Contains(message, "week") || strings.Contains(message, "last") || strings.Contains(message, "when"):
+		return now.Add(-7 * 24 * time.Hour), now
+	default:
+		return now.Add(-24 * time.Hour), now
+	}
+}
+
+// AnswerStateQuery answers a state or history question using only the supplied device data.
+// Each fact describes one device reading; if the LLM is unavailable the facts are returned directly
+// so the answer is still grounded in real data.
+func (s *Service) AnswerStateQuery(ctx context.Context, message string, facts []string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	fallback := formatFacts(facts)
+	if !s.isConnected {
+		return fallback
+	}
+
+	answer, err := s.generateResponse(ctx, createStateQueryPrompt(message, facts))
+	if err != nil {
+		logrus.Errorf("Failed to generate state answer: %v", err)
+		return fallback
+	}
+
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return fallback
+	}
+
+	return answer
+}
+
+func createStateQueryPrompt(message string, facts []string) string {
+	return fmt.Sprintf(`You are Luna, a helpful smart home assistant.
+
+Device data from Home Assistant:
+- %s
+
+Answer the user's question briefly and naturally using only the data above.
+Include units, times and durations if relevant. If the data does not
+answer the question, say so instead of guessing. Respond with plain text, not JSON.
+
+Human: %s
+Assistant:`, strings.Join(facts, "\n- "), message)
+}
+
+// formatFacts renders device readings as a plain answer without the LLM
+func formatFacts(facts []string) string {
+	switch len(facts) {
+	case 0:
+		return "I couldn't find any devices matching that question."
+	case 1:
+		return "Here's what I see: " + facts[0] + "."
+	default:
+		return "Here's what I found:\n- " + strings.Join(facts, "\n- ")
+	}
+}
+
+func hasWordPrefix(message, prefix string) bool {
+	if !strings.HasPrefix(message, prefix) {
+		return false
+	}
+	rest := message[len(prefix):]
+	return rest == "" || strings.IndexAny(rest[:1], " ,?!.'") == 0
+}
@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStateQuery(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected bool
+	}{
+		{"What's the temperature in the bedroom?", true},
+		{"is the front door open?", true},
+		{"are the lights on", true},
+		{"how warm is it upstairs", true},
+		{"the garage is open?", true},
+		{"turn on the living room lights", false},
+		{"open the garage door", false},
+		{"set the temperature to 22", false},
+		{"dim the lights", false},
+		{"do the same for the porch", false},
+		{"island mode", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsStateQuery(tt.message))
+		})
+	}
+}
+
+func TestAnswerStateQuery_NotConnected(t *testing.T) {
+	service := NewService("http://localhost:11434", "llama3.2")
+
+	facts := []string{"Bedroom Temperature (sensor.bedroom_temperature) is 19.8 °C"}
+	answer := service.AnswerStateQuery(context.Background(), "what's the temperature in the bedroom?", facts)
+	assert.Equal(t, "Here's what I see: Bedroom Temperature (sensor.bedroom_temperature) is 19.8 °C.", answer)
+
+	facts = append(facts, "Front Door (binary_sensor.front_door) is off")
+	answer = service.AnswerStateQuery(context.Background(), "status?", facts)
+	assert.Contains(t, answer, "- Bedroom Temperature")
+	assert.Contains(t, answer, "- Front Door")
+}
+
+func TestAnswerStateQuery_UsesLLM(t *testing.T) {
+	var prompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		r.Body.Read(body)
+		prompt = string(body)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"response":"It's 19.8 °C in the bedroom.","done":true}`))
+	}))
+	defer server.Close()
+
+	service := NewService(server.URL, "test")
+	service.isConnected = true
+
+	answer := service.AnswerStateQuery(context.Background(), "what's the temperature in the bedroom?",
+		[]string{"Bedroom Temperature (sensor.bedroom_temperature) is 19.8 °C"})
+
+	assert.Equal(t, "It's 19.8 °C in the bedroom.", answer)
+	assert.Contains(t, prompt, "sensor.bedroom_temperature")
+}
+
+func TestAnswerStateQuery_LLMErrorFallsBack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	service := NewService(server.URL, "test")
+	service.isConnected = true
+
+	answer := service.AnswerStateQuery(context.Background(), "is the door open?", []string{"Front Door (binary_sensor.front_door) is off"})
+	assert.Equal(t, "Here's what I see: Front Door (binary_sensor.front_door) is off.", answer)
+}
+
+func TestIsHistoryQuery(t *testing.T) {
+	assert.True(t, IsHistoryQuery("When did the garage door last open?"))
+	assert.True(t, IsHistoryQuery("how long was the heater on today?"))
+	assert.True(t, IsHistoryQuery("how many times was the front door opened yesterday"))
+	assert.False(t, IsHistoryQuery("is the garage door open?"))
+	assert.False(t, IsHistoryQuery("turn on the lights for how long you like"))
+}
+
+func TestHistoryWindow(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+	midnight := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	start, end := HistoryWindow("how long was the heater on today?", now)
+	assert.Equal(t, midnight, start)
+	assert.Equal(t, now, end)
+
+	start, end = HistoryWindow("was the heater on yesterday?", now)
+	assert.Equal(t, midnight.AddDate(0, 0, -1), start)
+	assert.Equal(t, midnight, end)
+
+	start, end = HistoryWindow("when did the garage door last open?", now)
+	assert.Equal(t, now.Add(-7*24*time.Hour), start)
+	assert.Equal(t, now, end)
+
+	start, _ = HistoryWindow("how often did the fan run", now)
+	assert.Equal(t, now.Add(-24*time.Hour), start)
+}
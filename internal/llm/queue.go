@@ -0,0 +1,224 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxInFlight is how many requests NewService lets reach Ollama at once
+	defaultMaxInFlight = 2
+	// defaultQueueLength is how many requests NewService lets wait for a slot
+	defaultQueueLength = 8
+	// defaultQueueTimeout is how long a request may wait for a slot
+	defaultQueueTimeout = 30 * time.Second
+	// initialHoldEstimate stands in for request duration until one has been measured
+	initialHoldEstimate = 5 * time.Second
+)
+
+// ErrBusy is returned when a request can't get a slot on the model
+var ErrBusy = errors.New("the model is busy")
+
+// BusyError says why a request was turned away and when a retry may succeed
+type BusyError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrBusy, e.Reason)
+}
+
+func (e *BusyError) Unwrap() error {
+	return ErrBusy
+}
+
+// QueueStats is a snapshot of the queue in front of the model
+type QueueStats struct {
+	InFlight    int `json:"in_flight"`
+	Waiting     int `json:"waiting"`
+	MaxInFlight int `json:"max_in_flight"`
+	QueueLength int `json:"queue_length"`
+}
+
+// Queue bounds how many requests use the model at once. Requests beyond that
+// wait in order, up to a maximum queue length and for at most a timeout.
+type Queue struct {
+	maxInFlight int
+	maxWaiting  int
+	timeout     time.Duration
+
+	mutex    sync.Mutex
+	inFlight int
+	waiting  []*queueWaiter
+	avgHold  time.Duration // moving average of how long a slot is held
+}
+
+type queueWaiter struct {
+	ready chan struct{} // closed when the slot is handed over
+	moved chan struct{} // signalled when the waiter moves up the queue
+}
+
+// NewQueue creates a queue that lets maxInFlight requests run at once and up to
+// maxWaiting wait for at most timeout. A maxInFlight or timeout of 0 or less
+// uses the default, as does a negative maxWaiting; 0 turns away every request
+// that can't run at once.
+func NewQueue(maxInFlight, maxWaiting int, timeout time.Duration) *Queue {
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	if maxWaiting < 0 {
+		maxWaiting = defaultQueueLength
+	}
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	return &Queue{
+		maxInFlight: maxInFlight,
+		maxWaiting:  maxWaiting,
+		timeout:     timeout,
+		avgHold:     initialHoldEstimate,
+	}
+}
+
+// Acquire waits for a slot and returns the function that gives it back. While
+// waiting, onPosition (if set) is called with the request's place in line,
+// starting at 1, whenever it changes. A full queue, a wait past the timeout or a
+// cancelled ctx turn the request away without a slot.
+func (q *Queue) Acquire(ctx context.Context, onPosition func(position int)) (func(), error) {
+	q.mutex.Lock()
+	if q.inFlight < q.maxInFlight && len(q.waiting) == 0 {
+		q.inFlight++
+		q.mutex.Unlock()
+		return q.releaser(), nil
+	}
+	if len(q.waiting) >= q.maxWaiting {
+		retryAfter := q.retryAfterLocked()
+		q.mutex.Unlock()
+		return nil, &BusyError{Reason: "too many requests are waiting", RetryAfter: retryAfter}
+	}
+
+	w := &queueWaiter{ready: make(chan struct{}), moved: make(chan struct{}, 1)}
+	q.waiting = append(q.waiting, w)
+	position := len(q.waiting)
+	q.mutex.Unlock()
+
+	if onPosition != nil {
+		onPosition(position)
+	}
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.ready:
+			return q.releaser(), nil
+		case <-w.moved:
+			if position := q.position(w); position > 0 && onPosition != nil {
+				onPosition(position)
+			}
+		case <-timer.C:
+			if q.leave(w) {
+				return nil, &BusyError{Reason: "timed out waiting for the model", RetryAfter: q.RetryAfter()}
+			}
+			// The slot was handed over as the timer fired
+			return q.releaser(), nil
+		case <-ctx.Done():
+			if !q.leave(w) {
+				q.releaser()()
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stats returns how many requests are running and waiting
+func (q *Queue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return QueueStats{InFlight: q.inFlight, Waiting: len(q.waiting), MaxInFlight: q.maxInFlight, QueueLength: q.maxWaiting}
+}
+
+// RetryAfter estimates when a new request could get a slot, from how long
+// requests have been holding one and how many are waiting
+func (q *Queue) RetryAfter() time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.retryAfterLocked()
+}
+
+func (q *Queue) retryAfterLocked() time.Duration {
+	ahead := float64(len(q.waiting)+1) / float64(q.maxInFlight)
+	seconds := math.Ceil(q.avgHold.Seconds() * ahead)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// releaser returns the function that frees a slot taken now, handing it to the
+// first waiter if there is one. Calling it more than once has no effect.
+func (q *Queue) releaser() func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+
+			q.avgHold = (q.avgHold*4 + time.Since(start)) / 5
+			if len(q.waiting) == 0 {
+				q.inFlight--
+				return
+			}
+			next := q.waiting[0]
+			q.waiting = q.waiting[1:]
+			close(next.ready)
+			q.notifyMovedLocked(0)
+		})
+	}
+}
+
+// leave removes a waiter that gives up. It returns false if the waiter was
+// already handed a slot, which it then owns.
+func (q *Queue) leave(w *queueWaiter) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, waiter := range q.waiting {
+		if waiter == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.notifyMovedLocked(i)
+			return true
+		}
+	}
+	return false
+}
+
+// position is a waiter's place in line, or 0 once it has a slot
+func (q *Queue) position(w *queueWaiter) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, waiter := range q.waiting {
+		if waiter == w {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// notifyMovedLocked tells the waiters from index on that they moved up
+func (q *Queue) notifyMovedLocked(from int) {
+	for _, waiter := range q.waiting[from:] {
+		select {
+		case waiter.moved <- struct{}{}:
+		default:
+		}
+	}
+}
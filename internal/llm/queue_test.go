@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue_LimitsInFlight(t *testing.T) {
+	queue := NewQueue(2, 4, time.Second)
+
+	first, err := queue.Acquire(context.Background(), nil)
+	require.NoError(t, err)
+	second, err := queue.Acquire(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, QueueStats{InFlight: 2, Waiting: 0, MaxInFlight: 2, QueueLength: 4}, queue.Stats())
+
+	positions := make(chan int, 4)
+	acquired := make(chan func())
+	go func() {
+		release, err := queue.Acquire(context.Background(), func(position int) { positions <- position })
+		if err == nil {
+			acquired <- release
+		}
+	}()
+
+	assert.Equal(t, 1, <-positions)
+	assert.Equal(t, 1, queue.Stats().Waiting)
+
+	// Releasing hands the slot straight to the waiter
+	first()
+	first()
+	third := <-acquired
+	assert.Equal(t, QueueStats{InFlight: 2, Waiting: 0, MaxInFlight: 2, QueueLength: 4}, queue.Stats())
+
+	second()
+	third()
+	assert.Equal(t, 0, queue.Stats().InFlight)
+}
+
+func TestQueue_ReportsPositionAsWaitersLeave(t *testing.T) {
+	queue := NewQueue(1, 4, time.Second)
+	release, err := queue.Acquire(context.Background(), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	firstQueued := make(chan int, 1)
+	go func() {
+		_, err := queue.Acquire(ctx, func(position int) { firstQueued <- position })
+		firstErr <- err
+	}()
+	assert.Equal(t, 1, <-firstQueued)
+
+	positions := make(chan int, 4)
+	done := make(chan func())
+	go func() {
+		release, err := queue.Acquire(context.Background(), func(position int) { positions <- position })
+		if err == nil {
+			done <- release
+		}
+	}()
+	assert.Equal(t, 2, <-positions)
+
+	// The first waiter gives up, so the second moves to the front
+	cancel()
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+	assert.Equal(t, 1, <-positions)
+
+	release()
+	(<-done)()
+	assert.Equal(t, 0, queue.Stats().InFlight)
+}
+
+func TestQueue_TurnsAwayWhenFull(t *testing.T) {
+	queue := NewQueue(1, 0, time.Second)
+	release, err := queue.Acquire(context.Background(), nil)
+	require.NoError(t, err)
+	defer release()
+
+	_, err = queue.Acquire(context.Background(), nil)
+	require.ErrorIs(t, err, ErrBusy)
+	var busy *BusyError
+	require.True(t, errors.As(err, &busy))
+	assert.GreaterOrEqual(t, busy.RetryAfter, time.Second)
+}
+
+func TestQueue_TimesOut(t *testing.T) {
+	queue := NewQueue(1, 1, 20*time.Millisecond)
+	release, err := queue.Acquire(context.Background(), nil)
+	require.NoError(t, err)
+	defer release()
+
+	_, err = queue.Acquire(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrBusy)
+	assert.Equal(t, 0, queue.Stats().Waiting)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/tienpdinh/gpt-home/internal/config"
 	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
 
 	"github.com/sirupsen/logrus"
 )
@@ -25,14 +27,17 @@ type Service struct {
 	modelInfo   ModelInfo
 	httpClient  *http.Client
 	config      OllamaConfig
+	queue       *Queue
+	upstream    *resilience.Upstream
+	retry       resilience.RetryPolicy
 }
 
 // LLMResponse represents the structured response from the LLM
 type LLMResponse struct {
-	Understanding string               `json:"understanding"`
-	Response      string               `json:"response"`
-	Actions       []models.DeviceAction `json:"actions,omitempty"`
-	Confidence    float32              `json:"confidence"`
+	Understanding string          `json:"understanding"`
+	Response      string          `json:"response"`
+	Actions       []PlannedAction `json:"actions,omitempty"`
+	Confidence    float32         `json:"confidence"`
 }
 
 type OllamaConfig struct {
@@ -43,6 +48,25 @@ type OllamaConfig struct {
 	TopP        float32
 	TopK        int
 	Timeout     time.Duration
+	// ContextLength caps the context window (num_ctx), 0 uses the model's own
+	ContextLength int
+	// SummaryThreshold is the message count past which older messages are summarized
+	SummaryThreshold int
+}
+
+// defaultRetryAttempts is how many times a failed generation is tried in all.
+// Generations are slow, so they get fewer tries than HomeAssistant reads.
+const defaultRetryAttempts = 2
+
+// retryPolicy retries Ollama calls that failed quickly. A call that ran out of
+// time isn't retried, since trying again would keep the user waiting as long again.
+func retryPolicy(attempts int) resilience.RetryPolicy {
+	policy := resilience.DefaultRetryPolicy
+	policy.Attempts = attempts
+	policy.RetryIf = func(err error) bool {
+		return !errors.Is(err, context.DeadlineExceeded)
+	}
+	return policy
 }
 
 // Ollama API request/response structures
@@ -54,9 +78,35 @@ type OllamaGenerateRequest struct {
 }
 
 type OllamaGenerateResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
-	Error    string `json:"error,omitempty"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	Error           string `json:"error,omitempty"`
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"`
+	EvalCount       int    `json:"eval_count,omitempty"`
+}
+
+// PlannedAction is an action the model wants performed. Targets are the device
+// IDs it names, if any.
+type PlannedAction struct {
+	models.DeviceAction
+	Targets []string `json:"targets,omitempty"`
+}
+
+// untargeted wraps actions that don't name their devices
+func untargeted(actions []models.DeviceAction) []PlannedAction {
+	planned := make([]PlannedAction, len(actions))
+	for i, action := range actions {
+		planned[i] = PlannedAction{DeviceAction: action}
+	}
+	return planned
+}
+
+// Reply is the model's answer to a chat message
+type Reply struct {
+	Response   string
+	Actions    []PlannedAction
+	Confidence float32
+	Usage      models.TokenUsage
 }
 
 type ModelInfo struct {
@@ -88,7 +138,13 @@ func NewService(ollamaURL, modelName string) *Service {
 			TopP:        0.9,
 			TopK:        40,
 			Timeout:     30 * time.Second,
+
+			ContextLength:    defaultContextLength,
+			SummaryThreshold: defaultSummaryThreshold,
 		},
+		queue:    NewQueue(defaultMaxInFlight, defaultQueueLength, defaultQueueTimeout),
+		upstream: resilience.NewUpstream("ollama", resilience.DefaultBreakerThreshold, resilience.DefaultBreakerCooldown),
+		retry:    retryPolicy(defaultRetryAttempts),
 	}
 }
 
@@ -114,7 +170,13 @@ func NewServiceWithConfig(ollamaURL, modelName string, cfg config.LLMConfig) *Se
 			TopP:        cfg.TopP,
 			TopK:        cfg.TopK,
 			Timeout:     time.Duration(cfg.Timeout) * time.Second,
+
+			ContextLength:    cfg.ContextLength,
+			SummaryThreshold: cfg.SummaryThreshold,
 		},
+		queue:    NewQueue(cfg.MaxConcurrent, cfg.QueueLength, time.Duration(cfg.QueueTimeout)*time.Second),
+		upstream: resilience.NewUpstream("ollama", cfg.BreakerThreshold, time.Duration(cfg.BreakerCooldown)*time.Second),
+		retry:    retryPolicy(cfg.Retries),
 	}
 }
 
@@ -142,21 +204,38 @@ func (s *Service) LoadModel() error {
 }
 
 func (s *Service) testConnection() error {
-	resp, err := s.httpClient.Get(s.ollamaURL + "/api/tags")
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			logrus.Warnf("Failed to close response body: %v", closeErr)
+	return s.upstream.Call(context.Background(), s.retry, func() error {
+		resp, err := s.httpClient.Get(s.ollamaURL + "/api/tags")
+		if err != nil {
+			return err
 		}
-	}()
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				logrus.Warnf("Failed to close response body: %v", closeErr)
+			}
+		}()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("ollama server returned status %d", resp.StatusCode)
+		if resp.StatusCode != http.StatusOK {
+			return unexpectedStatus(resp.StatusCode, fmt.Errorf("ollama server returned status %d", resp.StatusCode))
+		}
+
+		return nil
+	})
+}
+
+// unexpectedStatus wraps err for a response with an unexpected status. Server
+// errors count against Ollama and may be retried; any other status rejects just
+// this request.
+func unexpectedStatus(code int, err error) error {
+	if code >= 500 || code == http.StatusTooManyRequests {
+		return err
 	}
+	return resilience.Permanent(err)
+}
 
-	return nil
+// UpstreamStats reports the state of Ollama's circuit breaker
+func (s *Service) UpstreamStats() []resilience.UpstreamStats {
+	return []resilience.UpstreamStats{s.upstream.Stats()}
 }
 
 func (s *Service) checkModel() error {
@@ -173,22 +252,24 @@ func (s *Service) checkModel() error {
 		return err
 	}
 
-	resp, err := s.httpClient.Post(s.ollamaURL+"/api/generate", "application/json", bytes.NewBuffer(reqBody))
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			logrus.Warnf("Failed to close response body: %v", closeErr)
+	return s.upstream.Call(context.Background(), s.retry, func() error {
+		resp, err := s.httpClient.Post(s.ollamaURL+"/api/generate", "application/json", bytes.NewBuffer(reqBody))
+		if err != nil {
+			return err
 		}
-	}()
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				logrus.Warnf("Failed to close response body: %v", closeErr)
+			}
+		}()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("model test failed: %s", string(body))
-	}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return unexpectedStatus(resp.StatusCode, fmt.Errorf("model test failed: %s", string(body)))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (s *Service) IsLoaded() bool {
@@ -203,42 +284,95 @@ func (s *Service) GetModelInfo() ModelInfo {
 	return s.modelInfo
 }
 
-func (s *Service) ProcessMessage(message string, context models.Context) (string, []models.DeviceAction, error) {
-	return s.ProcessMessageWithHistory(message, context, []models.Message{})
+// Acquire waits for a turn on the model and returns the function that ends it;
+// see Queue.Acquire. Callers hold one turn for all the model calls that answer
+// one request, so a request that has started never waits behind newer ones.
+func (s *Service) Acquire(ctx context.Context, onPosition func(position int)) (func(), error) {
+	return s.queue.Acquire(ctx, onPosition)
+}
+
+// QueueStats reports how many requests are using the model and waiting for it
+func (s *Service) QueueStats() QueueStats {
+	return s.queue.Stats()
+}
+
+func (s *Service) ProcessMessage(ctx context.Context, message string, convContext models.Context) (string, []models.DeviceAction, error) {
+	return s.ProcessMessageWithHistory(ctx, message, convContext, []models.Message{})
+}
+
+// ProcessMessageWithHistory processes a message with the earlier messages of its conversation
+func (s *Service) ProcessMessageWithHistory(ctx context.Context, message string, convContext models.Context, history []models.Message) (string, []models.DeviceAction, error) {
+	reply, err := s.Chat(ctx, message, convContext, history, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	actions := make([]models.DeviceAction, len(reply.Actions))
+	for i, action := range reply.Actions {
+		actions[i] = action.DeviceAction
+	}
+	return reply.Response, actions, nil
 }
 
-// ProcessMessageWithHistory processes a message with full conversation history
-func (s *Service) ProcessMessageWithHistory(message string, context models.Context, history []models.Message) (string, []models.DeviceAction, error) {
+// Chat answers a message using the conversation so far and the home's devices.
+// history holds the earlier messages of the conversation, not message itself.
+// The prompt is fitted to the model's context window and the reply reports how
+// much of it was used. If ctx is done before Ollama answers, ctx's error is
+// returned instead of a rule-based fallback reply nobody is waiting for.
+func (s *Service) Chat(ctx context.Context, message string, convContext models.Context, history []models.Message, devices []models.Device) (*Reply, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	if !s.isConnected {
-		return "", nil, fmt.Errorf("not connected to Ollama")
+		return nil, fmt.Errorf("not connected to Ollama")
 	}
 
-	// Create a smart home assistant prompt that includes conversation history
-	prompt := s.createSmartHomePromptWithHistory(message, context, history)
+	assembler := s.newPromptAssembler()
+	prompt := assembler.assemble(message, convContext, history, devices)
+	usage := models.TokenUsage{
+		PromptTokens:  prompt.tokens,
+		ContextWindow: assembler.contextWindow,
+		Estimated:     true,
+		Truncated:     prompt.truncated,
+	}
+	if len(prompt.truncated) > 0 {
+		logrus.Debugf("Prompt cut to fit %d tokens: %s", assembler.contextWindow, strings.Join(prompt.truncated, ", "))
+	}
 
 	// Generate response using Ollama
-	llmResponseText, err := s.generateResponse(prompt)
+	generated, err := s.generate(ctx, prompt.text, defaultStopSequences)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		logrus.Errorf("Failed to generate response: %v", err)
 		// Fallback to rule-based parsing
-		fallbackResponse, actions := s.parseCommand(message, context)
-		return fallbackResponse, actions, nil
+		fallbackResponse, actions := s.parseCommand(message, convContext)
+		return &Reply{Response: fallbackResponse, Actions: untargeted(actions), Usage: usage}, nil
+	}
+
+	// Ollama reports what the prompt really took
+	if generated.PromptEvalCount > 0 {
+		usage.PromptTokens = generated.PromptEvalCount
+		usage.Estimated = false
 	}
+	usage.CompletionTokens = generated.EvalCount
 
 	// Parse structured JSON response
-	structuredResponse := s.parseStructuredResponse(llmResponseText)
+	structuredResponse := s.parseStructuredResponse(generated.Response)
 	if structuredResponse == nil {
 		// If JSON parsing fails, fall back to text extraction
 		logrus.Warnf("Failed to parse structured JSON, using fallback extraction")
-		actions := s.extractActionsFromResponse(llmResponseText)
-		return llmResponseText, actions, nil
+		actions := s.extractActionsFromResponse(generated.Response)
+		return &Reply{Response: generated.Response, Actions: untargeted(actions), Usage: usage}, nil
 	}
 
 	logrus.Debugf("Processed message: %s -> %+v", message, structuredResponse)
-	return structuredResponse.Response, structuredResponse.Actions, nil
+	return &Reply{
+		Response:   structuredResponse.Response,
+		Actions:    structuredResponse.Actions,
+		Confidence: structuredResponse.Confidence,
+		Usage:      usage,
+	}, nil
 }
 
 func (s *Service) parseCommand(message string, context models.Context) (string, []models.DeviceAction) {
@@ -285,7 +419,7 @@ func (s *Service) parseCommand(message string, context models.Context) (string,
 			})
 			return "I'll adjust the temperature for you.", actions
 		}
-		return "The current temperature is 22°C. Would you like me to adjust it?", actions
+		return "I don't have a live temperature reading for that. Would you like me to adjust the thermostat?", actions
 	}
 
 	// Status queries
@@ -297,39 +431,73 @@ func (s *Service) parseCommand(message string, context models.Context) (string,
 	return "I understand you want to control your smart home, but I'm not sure exactly what you'd like me to do. Could you be more specific?", actions
 }
 
-func (s *Service) generateResponse(prompt string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
-	defer cancel()
+// defaultStopSequences end a chat reply before the model starts writing the next turn
+var defaultStopSequences = []string{"</response>", "Human:", "User:"}
+
+func (s *Service) generateResponse(ctx context.Context, prompt string) (string, error) {
+	generated, err := s.generate(ctx, prompt, defaultStopSequences)
+	if err != nil {
+		return "", err
+	}
+	return generated.Response, nil
+}
+
+// generate runs a prompt through Ollama, stopping at any of the stop sequences.
+// The response text is trimmed.
+func (s *Service) generate(ctx context.Context, prompt string, stop []string) (*OllamaGenerateResponse, error) {
+	options := map[string]interface{}{
+		"num_predict": s.config.MaxTokens,
+		"temperature": s.config.Temperature,
+		"top_p":       s.config.TopP,
+		"top_k":       float64(s.config.TopK),
+		"num_ctx":     contextWindow(s.config.Model, s.config.ContextLength),
+	}
+	if len(stop) > 0 {
+		options["stop"] = stop
+	}
 
 	// Prepare Ollama request
 	req := OllamaGenerateRequest{
-		Model:  s.config.Model,
-		Prompt: prompt,
-		Stream: false,
-		Options: map[string]interface{}{
-			"num_predict": s.config.MaxTokens,
-			"temperature": s.config.Temperature,
-			"top_p":       s.config.TopP,
-			"top_k":       float64(s.config.TopK),
-			"stop":        []string{"</response>", "Human:", "User:"},
-		},
+		Model:   s.config.Model,
+		Prompt:  prompt,
+		Stream:  false,
+		Options: options,
 	}
 
 	reqBody, err := json.Marshal(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	var ollamaResp OllamaGenerateResponse
+	err = s.upstream.Call(ctx, s.retry, func() error {
+		ollamaResp = OllamaGenerateResponse{}
+		return s.post(ctx, reqBody, &ollamaResp)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ollamaResp.Response = strings.TrimSpace(ollamaResp.Response)
+	return &ollamaResp, nil
+}
+
+// post sends one generate request to Ollama, giving it the configured timeout,
+// and decodes the reply into out
+func (s *Service) post(ctx context.Context, reqBody []byte, out *OllamaGenerateResponse) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
 	// Make HTTP request to Ollama
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.ollamaURL+"/api/generate", bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return resilience.Permanent(fmt.Errorf("failed to create request: %w", err))
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.httpClient.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to call Ollama: %w", err)
+		return fmt.Errorf("failed to call Ollama: %w", err)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
@@ -339,99 +507,19 @@ func (s *Service) generateResponse(prompt string) (string, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(body))
+		return unexpectedStatus(resp.StatusCode, fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	// Parse response
-	var ollamaResp OllamaGenerateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resilience.Permanent(fmt.Errorf("failed to decode response: %w", err))
 	}
 
-	if ollamaResp.Error != "" {
-		return "", fmt.Errorf("Ollama error: %s", ollamaResp.Error)
+	if out.Error != "" {
+		return fmt.Errorf("Ollama error: %s", out.Error)
 	}
 
-	return strings.TrimSpace(ollamaResp.Response), nil
-}
-
-func (s *Service) createSmartHomePrompt(message string, context models.Context) string {
-	deviceContext := ""
-	if len(context.ReferencedDevices) > 0 {
-		deviceContext = fmt.Sprintf("\nPreviously referenced devices: %s", strings.Join(context.ReferencedDevices, ", "))
-	}
-
-	return fmt.Sprintf(`You are Luna, a helpful smart home assistant. You can control lights, switches, climate, and other devices.
-
-Available actions:
-- turn_on/turn_off: For lights and switches
-- set_brightness: For lights (0-255)
-- set_temperature: For climate (degrees)
-
-Respond naturally and briefly as Luna. If you perform an action, mention it. Always introduce yourself as Luna when asked about your name.%s
-
-Human: %s
-Assistant:
-You must respond with valid JSON only (no additional text) in this exact format:
-{
-  "understanding": "brief description of what the user asked",
-  "response": "natural conversational response to the user",
-  "actions": [{"action": "action_name", "parameters": {"key": "value"}}],
-  "confidence": 0.95
-}
-
-Available actions: turn_on, turn_off, set_brightness (0-255), set_temperature (18-28), set_color_temp (2700-6500)
-`, deviceContext, message)
-}
-
-// createSmartHomePromptWithHistory creates a prompt that includes conversation history
-func (s *Service) createSmartHomePromptWithHistory(message string, context models.Context, history []models.Message) string {
-	deviceContext := ""
-	if len(context.ReferencedDevices) > 0 {
-		deviceContext = fmt.Sprintf("\nPreviously referenced devices: %s", strings.Join(context.ReferencedDevices, ", "))
-	}
-
-	// Build conversation history context
-	historyContext := ""
-	if len(history) > 0 {
-		// Include recent messages (limit to last 10 for token efficiency)
-		startIdx := 0
-		if len(history) > 10 {
-			startIdx = len(history) - 10
-		}
-
-		historyContext = "\nRecent conversation history:\n"
-		for _, msg := range history[startIdx:] {
-			role := "User"
-			if msg.Role == models.MessageRoleAssistant {
-				role = "Luna"
-			}
-			historyContext += fmt.Sprintf("%s: %s\n", role, msg.Content)
-		}
-	}
-
-	return fmt.Sprintf(`You are Luna, a helpful smart home assistant. You can control lights, switches, climate, and other devices.
-
-Available actions:
-- turn_on/turn_off: For lights and switches
-- set_brightness: For lights (0-255)
-- set_temperature: For climate (degrees)
-- set_color_temp: For lights (kelvin 2700-6500)
-
-Respond naturally and briefly as Luna. If you perform an action, mention it. Always introduce yourself as Luna when asked about your name.%s%s
-
-%sHuman: %s
-Assistant:
-You must respond with valid JSON only (no additional text) in this exact format:
-{
-  "understanding": "brief description of what the user asked",
-  "response": "natural conversational response to the user",
-  "actions": [{"action": "action_name", "parameters": {"key": "value"}}],
-  "confidence": 0.95
-}
-
-Available actions: turn_on, turn_off, set_brightness (0-255), set_temperature (18-28), set_color_temp (2700-6500)
-`, deviceContext, historyContext, historyContext, message)
+	return nil
 }
 
 func (s *Service) parseStructuredResponse(responseText string) *LLMResponse {
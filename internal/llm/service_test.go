@@ -1,6 +1,7 @@
 package llm
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tienpdinh/gpt-home/internal/config"
 	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
 )
 
 func TestNewService(t *testing.T) {
@@ -115,13 +117,13 @@ func TestLoadModel_ModelNotAvailable(t *testing.T) {
 
 func TestProcessMessage_NotConnected(t *testing.T) {
 	service := NewService("http://localhost:11434", "llama3.2")
-	context := models.Context{
+	convContext := models.Context{
 		ReferencedDevices: []string{},
 		UserPreferences:   make(map[string]string),
 		SessionData:       make(map[string]any),
 	}
 
-	_, _, err := service.ProcessMessage("turn on the lights", context)
+	_, _, err := service.ProcessMessage(context.Background(), "turn on the lights", convContext)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not connected to Ollama")
 }
@@ -147,13 +149,13 @@ func TestProcessMessage_Success(t *testing.T) {
 	err := service.LoadModel()
 	require.NoError(t, err)
 
-	context := models.Context{
+	convContext := models.Context{
 		ReferencedDevices: []string{},
 		UserPreferences:   make(map[string]string),
 		SessionData:       make(map[string]any),
 	}
 
-	response, actions, err := service.ProcessMessage("turn on the lights", context)
+	response, actions, err := service.ProcessMessage(context.Background(), "turn on the lights", convContext)
 
 	require.NoError(t, err)
 	assert.Equal(t, "I'll turn on the lights for you.", response)
@@ -204,13 +206,13 @@ func TestProcessMessage_FallbackToRuleBased(t *testing.T) {
 	// Now switch to failing server for ProcessMessage
 	service.ollamaURL = server.URL
 
-	context := models.Context{
+	convContext := models.Context{
 		ReferencedDevices: []string{},
 		UserPreferences:   make(map[string]string),
 		SessionData:       make(map[string]any),
 	}
 
-	response, actions, err := service.ProcessMessage("turn on the lights", context)
+	response, actions, err := service.ProcessMessage(context.Background(), "turn on the lights", convContext)
 
 	// Should fall back to rule-based parsing
 	require.NoError(t, err)
@@ -270,13 +272,13 @@ func TestExtractActionsFromResponse(t *testing.T) {
 func TestCreateSmartHomePrompt(t *testing.T) {
 	service := NewService("http://localhost:11434", "llama3.2")
 
-	context := models.Context{
+	convContext := models.Context{
 		ReferencedDevices: []string{"living_room_light", "bedroom_light"},
 		UserPreferences:   make(map[string]string),
 		SessionData:       make(map[string]any),
 	}
 
-	prompt := service.createSmartHomePrompt("turn on the lights", context)
+	prompt := service.newPromptAssembler().assemble("turn on the lights", convContext, nil, nil).text
 
 	assert.Contains(t, prompt, "smart home assistant")
 	assert.Contains(t, prompt, "turn on the lights")
@@ -289,13 +291,13 @@ func TestCreateSmartHomePrompt(t *testing.T) {
 func TestCreateSmartHomePrompt_NoDevices(t *testing.T) {
 	service := NewService("http://localhost:11434", "llama3.2")
 
-	context := models.Context{
+	convContext := models.Context{
 		ReferencedDevices: []string{},
 		UserPreferences:   make(map[string]string),
 		SessionData:       make(map[string]any),
 	}
 
-	prompt := service.createSmartHomePrompt("what can you do?", context)
+	prompt := service.newPromptAssembler().assemble("what can you do?", convContext, nil, nil).text
 
 	assert.Contains(t, prompt, "smart home assistant")
 	assert.Contains(t, prompt, "what can you do?")
@@ -339,7 +341,7 @@ func TestConcurrentProcessing(t *testing.T) {
 	err := service.LoadModel()
 	require.NoError(t, err)
 
-	context := models.Context{
+	convContext := models.Context{
 		ReferencedDevices: []string{},
 		UserPreferences:   make(map[string]string),
 		SessionData:       make(map[string]any),
@@ -357,7 +359,7 @@ func TestConcurrentProcessing(t *testing.T) {
 
 	for _, msg := range messages {
 		go func(message string) {
-			_, _, err := service.ProcessMessage(message, context)
+			_, _, err := service.ProcessMessage(context.Background(), message, convContext)
 			assert.NoError(t, err)
 			done <- true
 		}(msg)
@@ -400,7 +402,7 @@ func TestNewServiceWithConfig(t *testing.T) {
 
 func TestParseCommand_AllScenarios(t *testing.T) {
 	service := NewService("http://localhost:11434", "llama3.2")
-	context := models.Context{
+	convContext := models.Context{
 		ReferencedDevices: []string{},
 		UserPreferences:   make(map[string]string),
 		SessionData:       make(map[string]any),
@@ -437,7 +439,7 @@ func TestParseCommand_AllScenarios(t *testing.T) {
 		{
 			name:         "temperature query",
 			message:      "what's the temperature?",
-			expectedResp: "The current temperature is 22°C. Would you like me to adjust it?",
+			expectedResp: "I don't have a live temperature reading for that. Would you like me to adjust the thermostat?",
 			hasAction:    false,
 		},
 		{
@@ -450,7 +452,7 @@ func TestParseCommand_AllScenarios(t *testing.T) {
 		{
 			name:         "thermostat query",
 			message:      "check the thermostat",
-			expectedResp: "The current temperature is 22°C. Would you like me to adjust it?",
+			expectedResp: "I don't have a live temperature reading for that. Would you like me to adjust the thermostat?",
 			hasAction:    false,
 		},
 		{
@@ -475,7 +477,7 @@ func TestParseCommand_AllScenarios(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			response, actions := service.parseCommand(tt.message, context)
+			response, actions := service.parseCommand(tt.message, convContext)
 
 			assert.Equal(t, tt.expectedResp, response)
 			if tt.hasAction {
@@ -535,7 +537,7 @@ func TestGenerateResponse_ErrorCases(t *testing.T) {
 	service.config.Timeout = 1 * time.Millisecond // Very short timeout
 
 	// This should timeout
-	_, err := service.generateResponse("test prompt")
+	_, err := service.generateResponse(context.Background(), "test prompt")
 	assert.Error(t, err)
 }
 
@@ -551,7 +553,7 @@ func TestGenerateResponse_JSONErrors(t *testing.T) {
 
 	service := NewService(server.URL, "test-model")
 
-	_, err := service.generateResponse("test prompt")
+	_, err := service.generateResponse(context.Background(), "test prompt")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to decode response")
 }
@@ -568,7 +570,7 @@ func TestGenerateResponse_OllamaError(t *testing.T) {
 
 	service := NewService(server.URL, "test-model")
 
-	_, err := service.generateResponse("test prompt")
+	_, err := service.generateResponse(context.Background(), "test prompt")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "Ollama error: model error")
 }
@@ -608,3 +610,104 @@ func TestServiceConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerate_RetriesServerErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"response":"hello","done":true}`))
+	}))
+	defer server.Close()
+
+	service := NewService(server.URL, "test-model")
+	service.retry.BaseDelay = time.Millisecond
+
+	response, err := service.generateResponse(context.Background(), "test prompt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", response)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, int64(1), service.UpstreamStats()[0].Retries)
+}
+
+func TestGenerate_ClientErrorsAreNotRetried(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"model not found"}`))
+	}))
+	defer server.Close()
+
+	service := NewService(server.URL, "test-model")
+
+	_, err := service.generateResponse(context.Background(), "test prompt")
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, resilience.BreakerClosed, service.UpstreamStats()[0].State)
+}
+
+func TestChat_FallsBackWhileBreakerIsOpen(t *testing.T) {
+	failing := false
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			requests++
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"response":"test","done":true}`))
+	}))
+	defer server.Close()
+
+	service := NewServiceWithConfig(server.URL, "test-model", config.LLMConfig{
+		Timeout:          5,
+		Retries:          1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  60,
+	})
+	require.NoError(t, service.LoadModel())
+	failing = true
+
+	for i := 0; i < 3; i++ {
+		reply, err := service.Chat(context.Background(), "turn on the lights", models.Context{}, nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "I'll turn on the lights for you.", reply.Response)
+	}
+
+	assert.Equal(t, 2, requests, "the third message doesn't reach Ollama")
+	stats := service.UpstreamStats()[0]
+	assert.Equal(t, resilience.BreakerOpen, stats.State)
+	assert.Equal(t, int64(1), stats.Rejected)
+}
+
+func TestChat_CancelledRequestGetsNoFallback(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	service := NewService(server.URL, "test-model")
+	service.mutex.Lock()
+	service.isConnected = true
+	service.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	reply, err := service.Chat(ctx, "turn on the lights", models.Context{}, nil, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, reply)
+	assert.Equal(t, resilience.BreakerClosed, service.UpstreamStats()[0].State)
+}
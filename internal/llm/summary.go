@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+const (
+	// defaultSummaryThreshold is used by NewService
+	defaultSummaryThreshold = 20
+	// recentMessageWindow is how many of the latest messages go into the prompt verbatim
+	recentMessageWindow = 10
+)
+
+// UpdateSummary folds older messages into the rolling summary in conv once more
+// than the summary threshold of messages aren't covered by it. The latest
+// messages are left out because the prompt includes them verbatim. The summary
+// is refreshed incrementally: only messages it doesn't cover yet are sent,
+// along with the previous summary.
+func (s *Service) UpdateSummary(ctx context.Context, conv *models.Context, history []models.Message) error {
+	threshold := s.config.SummaryThreshold
+	if threshold <= 0 {
+		return nil
+	}
+
+	// The conversation was replaced by a shorter one, so the summary is stale
+	if conv.SummarizedMessages > len(history) {
+		conv.Summary = ""
+		conv.SummarizedMessages = 0
+	}
+
+	start := conv.SummarizedMessages
+	end := len(history) - recentMessageWindow
+	if len(history)-start <= threshold || end <= start {
+		return nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.isConnected {
+		return fmt.Errorf("not connected to Ollama")
+	}
+
+	generated, err := s.generate(ctx, createSummaryPrompt(conv.Summary, history[start:end]), nil)
+	if err != nil {
+		return fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+	summary := generated.Response
+	if summary == "" {
+		return fmt.Errorf("failed to summarize conversation: empty summary")
+	}
+
+	conv.Summary = summary
+	conv.SummarizedMessages = end
+	return nil
+}
+
+// createSummaryPrompt asks for the previous summary to be extended with messages
+func createSummaryPrompt(previous string, messages []models.Message) string {
+	if previous == "" {
+		previous = "(none yet)"
+	}
+
+	var transcript strings.Builder
+	for _, msg := range messages {
+		role := "User"
+		if msg.Role == models.MessageRoleAssistant {
+			role = "Luna"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", role, msg.Content)
+	}
+
+	return fmt.Sprintf(`You keep a running summary of a conversation between a user and Luna, a smart home assistant, so Luna can continue it without the full transcript.
+
+Summary so far:
+%s
+
+New messages:
+%s
+Write the updated summary in under 150 words. Keep what may matter later: devices and rooms mentioned, actions Luna took, the user's preferences and anything they asked Luna to remember. Reply with the summary only.
+`, previous, transcript.String())
+}
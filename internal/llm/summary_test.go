@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// promptRecorder keeps the prompts a fake Ollama server was sent
+type promptRecorder struct {
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (r *promptRecorder) add(prompt string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prompts = append(r.prompts, prompt)
+}
+
+func (r *promptRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prompts = nil
+}
+
+func (r *promptRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.prompts...)
+}
+
+// newSummaryServer returns a connected service whose model answers every
+// prompt with reply, and a recorder of the prompts sent after connecting
+func newSummaryServer(t *testing.T, reply string) (*Service, *promptRecorder) {
+	t.Helper()
+
+	recorder := &promptRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3.2"}]}`))
+		case "/api/generate":
+			var req OllamaGenerateRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			recorder.add(req.Prompt)
+			json.NewEncoder(w).Encode(OllamaGenerateResponse{Response: reply, Done: true})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	service := NewService(server.URL, "llama3.2")
+	require.NoError(t, service.LoadModel())
+	recorder.reset() // forget the warm-up prompt LoadModel sends
+	return service, recorder
+}
+
+func numberedMessages(from, to int) []models.Message {
+	var messages []models.Message
+	for i := from; i < to; i++ {
+		role := models.MessageRoleUser
+		if i%2 == 1 {
+			role = models.MessageRoleAssistant
+		}
+		messages = append(messages, models.Message{Role: role, Content: fmt.Sprintf("message %d", i)})
+	}
+	return messages
+}
+
+func TestUpdateSummary_Incremental(t *testing.T) {
+	service, prompts := newSummaryServer(t, "The user turned on the porch light.")
+	ctx := models.Context{}
+
+	// Nothing happens until the conversation passes the threshold
+	history := numberedMessages(0, defaultSummaryThreshold)
+	require.NoError(t, service.UpdateSummary(context.Background(), &ctx, history))
+	assert.Empty(t, prompts.get())
+	assert.Empty(t, ctx.Summary)
+
+	history = numberedMessages(0, defaultSummaryThreshold+1)
+	require.NoError(t, service.UpdateSummary(context.Background(), &ctx, history))
+	require.Len(t, prompts.get(), 1)
+	assert.Equal(t, "The user turned on the porch light.", ctx.Summary)
+	assert.Equal(t, len(history)-recentMessageWindow, ctx.SummarizedMessages)
+	assert.Contains(t, prompts.get()[0], "User: message 0\n")
+	assert.Contains(t, prompts.get()[0], "User: message 10\n")
+	assert.NotContains(t, prompts.get()[0], "message 11\n")
+
+	// The summary is only refreshed once enough new messages build up
+	history = numberedMessages(0, ctx.SummarizedMessages+defaultSummaryThreshold)
+	require.NoError(t, service.UpdateSummary(context.Background(), &ctx, history))
+	assert.Len(t, prompts.get(), 1)
+
+	history = numberedMessages(0, ctx.SummarizedMessages+defaultSummaryThreshold+1)
+	require.NoError(t, service.UpdateSummary(context.Background(), &ctx, history))
+	require.Len(t, prompts.get(), 2)
+	assert.Equal(t, len(history)-recentMessageWindow, ctx.SummarizedMessages)
+
+	// Only new messages are sent, along with the previous summary
+	assert.Contains(t, prompts.get()[1], "Summary so far:\nThe user turned on the porch light.")
+	assert.NotContains(t, prompts.get()[1], "message 10\n")
+	assert.Contains(t, prompts.get()[1], "message 11\n")
+}
+
+func TestUpdateSummary_Disabled(t *testing.T) {
+	service, prompts := newSummaryServer(t, "summary")
+	service.config.SummaryThreshold = 0
+
+	ctx := models.Context{}
+	require.NoError(t, service.UpdateSummary(context.Background(), &ctx, numberedMessages(0, 100)))
+	assert.Empty(t, prompts.get())
+	assert.Empty(t, ctx.Summary)
+}
+
+func TestUpdateSummary_StaleSummaryIsReset(t *testing.T) {
+	service, prompts := newSummaryServer(t, "summary")
+
+	ctx := models.Context{Summary: "old summary", SummarizedMessages: 50}
+	require.NoError(t, service.UpdateSummary(context.Background(), &ctx, numberedMessages(0, 5)))
+	assert.Empty(t, prompts.get())
+	assert.Empty(t, ctx.Summary)
+	assert.Zero(t, ctx.SummarizedMessages)
+}
+
+func TestUpdateSummary_Errors(t *testing.T) {
+	service := NewService("http://localhost:0", "llama3.2")
+	ctx := models.Context{}
+	assert.Error(t, service.UpdateSummary(context.Background(), &ctx, numberedMessages(0, 30)))
+
+	connected, _ := newSummaryServer(t, "")
+	assert.Error(t, connected.UpdateSummary(context.Background(), &ctx, numberedMessages(0, 30)))
+	assert.Empty(t, ctx.Summary)
+	assert.Zero(t, ctx.SummarizedMessages)
+}
+
+func TestAssemblePrompt_Summary(t *testing.T) {
+	service := NewService("http://localhost:11434", "llama3.2")
+	ctx := models.Context{Summary: "The user likes the lights dim.", SummarizedMessages: 20}
+
+	prompt := service.newPromptAssembler().assemble("turn on the lights", ctx, numberedMessages(0, 30), nil).text
+
+	summaryAt := strings.Index(prompt, "Summary of the earlier conversation:\nThe user likes the lights dim.")
+	historyAt := strings.Index(prompt, "Recent conversation history:")
+	require.NotEqual(t, -1, summaryAt)
+	assert.Less(t, summaryAt, historyAt)
+	assert.Contains(t, prompt, "message 20\n")
+	assert.NotContains(t, prompt, "message 19\n")
+}
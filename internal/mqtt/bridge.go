@@ -0,0 +1,278 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/internal/events"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	connectTimeout = 10 * time.Second
+	publishTimeout = 5 * time.Second
+	eventBuffer    = 256
+)
+
+// Chatter answers chat messages, as the chat API does
+type Chatter interface {
+	Chat(ctx context.Context, req models.ChatRequest) (*models.ChatResponse, error)
+}
+
+// DeviceController carries out device actions and says which devices the
+// ACL's default policy, which MQTT clients get, may read
+type DeviceController interface {
+	ExecuteActionOnDevice(ctx context.Context, deviceID string, action models.DeviceAction) error
+	CanRead(ctx context.Context, principal, deviceID string) bool
+}
+
+// Options configure the broker connection and the topics the bridge uses. An
+// empty topic turns off what it's for.
+type Options struct {
+	Broker        string
+	ClientID      string
+	Username      string
+	Password      string
+	TLS           *TLSFiles
+	CommandTopic  string
+	ResponseTopic string
+	ActionTopic   string
+	EventTopic    string
+	QoS           byte
+}
+
+// Bridge lets MQTT clients talk to Luna. Utterances published to the command
+// topic go through the chat pipeline and device actions straight to the device
+// manager; replies, action results and every hub event are published back.
+type Bridge struct {
+	opts    Options
+	chat    Chatter
+	devices DeviceController
+	hub     *events.Hub
+
+	client paho.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewBridge creates a bridge that isn't connected yet
+func NewBridge(opts Options, chat Chatter, devices DeviceController, hub *events.Hub) *Bridge {
+	return &Bridge{
+		opts:    opts,
+		chat:    chat,
+		devices: devices,
+		hub:     hub,
+	}
+}
+
+// Start connects to the broker, subscribes to the command topic and starts
+// publishing events. Commands stop being handled when ctx ends or Stop is
+// called. Lost connections are re-established and resubscribed on their own.
+func (b *Bridge) Start(ctx context.Context) error {
+	clientOpts := paho.NewClientOptions().
+		AddBroker(b.opts.Broker).
+		SetClientID(b.opts.ClientID).
+		SetUsername(b.opts.Username).
+		SetPassword(b.opts.Password).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(b.subscribe).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logrus.WithError(err).Warn("Lost connection to MQTT broker, reconnecting")
+		})
+	if b.opts.TLS != nil {
+		tlsConfig, err := b.opts.TLS.Config()
+		if err != nil {
+			return err
+		}
+		clientOpts.SetTLSConfig(tlsConfig)
+	}
+
+	b.ctx, b.cancel = context.WithCancel(ctx)
+	b.client = paho.NewClient(clientOpts)
+	token := b.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		b.cancel()
+		return fmt.Errorf("timed out connecting to MQTT broker %s", b.opts.Broker)
+	}
+	if err := token.Error(); err != nil {
+		b.cancel()
+		return fmt.Errorf("failed to connect to MQTT broker %s: %w", b.opts.Broker, err)
+	}
+
+	if b.opts.EventTopic != "" && b.hub != nil {
+		b.wg.Add(1)
+		go b.forwardEvents()
+	}
+
+	logrus.Infof("Connected to MQTT broker %s", b.opts.Broker)
+	return nil
+}
+
+// Stop stops taking commands, cancels those being handled and disconnects once
+// they have finished
+func (b *Bridge) Stop() {
+	if b.client == nil {
+		return
+	}
+	if b.opts.CommandTopic != "" {
+		b.client.Unsubscribe(b.opts.CommandTopic).WaitTimeout(publishTimeout)
+	}
+	b.cancel()
+	b.wg.Wait()
+	b.client.Disconnect(250)
+}
+
+// subscribe (re)subscribes to the command topic whenever the client connects
+func (b *Bridge) subscribe(client paho.Client) {
+	if b.opts.CommandTopic == "" {
+		return
+	}
+
+	token := client.Subscribe(b.opts.CommandTopic, b.opts.QoS, func(_ paho.Client, message paho.Message) {
+		// Chat can take a while; don't hold up the messages behind this one
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handleCommand(message.Payload())
+		}()
+	})
+	if token.WaitTimeout(connectTimeout) && token.Error() == nil {
+		logrus.Infof("Listening for commands on MQTT topic %s", b.opts.CommandTopic)
+		return
+	}
+	logrus.WithError(token.Error()).Errorf("Failed to subscribe to MQTT topic %s", b.opts.CommandTopic)
+}
+
+// handleCommand carries out one command and publishes its outcome
+func (b *Bridge) handleCommand(payload []byte) {
+	if b.ctx.Err() != nil {
+		return
+	}
+
+	cmd, err := ParseCommand(payload)
+	if err != nil {
+		logrus.WithError(err).Warn("Ignoring invalid MQTT command")
+		b.publish(b.opts.ResponseTopic, Response{Error: err.Error()})
+		return
+	}
+
+	configured := b.opts.ResponseTopic
+	if cmd.IsAction() {
+		configured = b.opts.ActionTopic
+	}
+	topic, err := cmd.replyTopic(configured)
+	if err != nil {
+		logrus.WithError(err).Warn("Ignoring MQTT command with a disallowed reply topic")
+		b.publish(b.opts.ResponseTopic, Response{ID: cmd.ID, Error: err.Error()})
+		return
+	}
+
+	if cmd.IsAction() {
+		b.publish(topic, b.executeAction(cmd))
+		return
+	}
+	b.publish(topic, b.answer(cmd))
+}
+
+func (b *Bridge) answer(cmd *Command) Response {
+	response := Response{ID: cmd.ID}
+	reply, err := b.chat.Chat(b.ctx, models.ChatRequest{Message: cmd.Message, ConversationID: cmd.ConversationID})
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to answer MQTT message")
+		response.Error = err.Error()
+		return response
+	}
+
+	response.ConversationID = reply.ConversationID.String()
+	response.Response = reply.Response
+	response.ActionsPerformed = reply.ActionsPerformed
+	return response
+}
+
+func (b *Bridge) executeAction(cmd *Command) ActionResult {
+	result := ActionResult{ID: cmd.ID, DeviceID: cmd.DeviceID, Action: cmd.Action, Status: StatusSuccess}
+	action := models.DeviceAction{Action: cmd.Action, Parameters: cmd.Parameters}
+	if err := b.devices.ExecuteActionOnDevice(b.ctx, cmd.DeviceID, action); err != nil {
+		logrus.WithError(err).Warnf("Failed MQTT action %s on %s", cmd.Action, cmd.DeviceID)
+		result.Status = StatusError
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// forwardEvents publishes hub events under the event topic, e.g.
+// gpt-home/events/action_result. Anyone on the broker can read them, so they
+// get what an MQTT client may see: events for devices the default policy can
+// read and messages of conversations that belong to no user. A subscription
+// closed for falling behind is replaced so publishing carries on.
+func (b *Bridge) forwardEvents() {
+	defer b.wg.Done()
+
+	for b.ctx.Err() == nil {
+		sub := b.hub.Subscribe(eventBuffer)
+		sub.Add(events.TopicDeviceState, events.TopicActionResult, events.TopicConversationMessage)
+		b.publishEvents(sub)
+		sub.Close()
+	}
+}
+
+func (b *Bridge) publishEvents(sub *events.Subscription) {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				logrus.Warn("MQTT event publishing fell behind, some events were dropped")
+				return
+			}
+			if b.visible(event) {
+				b.publish(b.opts.EventTopic+"/"+event.Topic, event)
+			}
+		}
+	}
+}
+
+// visible reports whether event may be published for any MQTT client to read
+func (b *Bridge) visible(event events.Event) bool {
+	switch data := event.Data.(type) {
+	case models.ConversationMessageEvent:
+		return data.UserID == uuid.Nil
+	case models.DeviceStateEvent:
+		return b.devices == nil || b.devices.CanRead(b.ctx, "", data.DeviceID)
+	case models.TargetResult:
+		return b.devices == nil || b.devices.CanRead(b.ctx, "", data.Target)
+	}
+	return true
+}
+
+// publish sends v as JSON, logging rather than returning failures since there
+// is no one to return them to
+func (b *Bridge) publish(topic string, v any) {
+	if topic == "" {
+		return
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to encode MQTT message for %s", topic)
+		return
+	}
+
+	token := b.client.Publish(topic, b.opts.QoS, false, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		logrus.Warnf("Timed out publishing to MQTT topic %s", topic)
+		return
+	}
+	if err := token.Error(); err != nil {
+		logrus.WithError(err).Warnf("Failed to publish to MQTT topic %s", topic)
+	}
+}
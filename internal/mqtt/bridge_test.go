@@ -0,0 +1,278 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/device"
+	"github.com/tienpdinh/gpt-home/internal/events"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/test/mocks"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeChatter answers every message with an echo
+type fakeChatter struct {
+	conversationID uuid.UUID
+	err            error
+}
+
+func (f *fakeChatter) Chat(ctx context.Context, req models.ChatRequest) (*models.ChatResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.ChatResponse{
+		Response:         "You said: " + req.Message,
+		ConversationID:   f.conversationID,
+		ActionsPerformed: []models.DeviceAction{{Action: "turn_on"}},
+	}, nil
+}
+
+func testOptions(broker *mocks.MockMQTTBroker) Options {
+	return Options{
+		Broker:        broker.URL(),
+		ClientID:      "gpt-home-test",
+		CommandTopic:  "gpt-home/command",
+		ResponseTopic: "gpt-home/response",
+		ActionTopic:   "gpt-home/actions",
+		EventTopic:    "gpt-home/events",
+		QoS:           1,
+	}
+}
+
+func startBroker(t *testing.T) *mocks.MockMQTTBroker {
+	t.Helper()
+	broker, err := mocks.NewMockMQTTBroker()
+	require.NoError(t, err)
+	t.Cleanup(broker.Close)
+	return broker
+}
+
+func startBridge(t *testing.T, opts Options, chat Chatter, devices DeviceController, hub *events.Hub) *Bridge {
+	t.Helper()
+	bridge := NewBridge(opts, chat, devices, hub)
+	require.NoError(t, bridge.Start(context.Background()))
+	t.Cleanup(bridge.Stop)
+	return bridge
+}
+
+// publish sends a command as another client, such as a button, would
+func publish(t *testing.T, broker *mocks.MockMQTTBroker, topic, payload string) {
+	t.Helper()
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(broker.URL()).SetClientID("button"))
+	token := client.Connect()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	require.NoError(t, token.Error())
+	defer client.Disconnect(100)
+
+	token = client.Publish(topic, 1, false, payload)
+	require.True(t, token.WaitTimeout(5*time.Second))
+	require.NoError(t, token.Error())
+}
+
+func waitFor(t *testing.T, broker *mocks.MockMQTTBroker, topic string, v any) {
+	t.Helper()
+	message, err := broker.WaitForMessage(topic, 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(message.Payload, v))
+}
+
+func TestBridge_AnswersPlainText(t *testing.T) {
+	broker := startBroker(t)
+	chat := &fakeChatter{conversationID: uuid.New()}
+	startBridge(t, testOptions(broker), chat, nil, nil)
+
+	publish(t, broker, "gpt-home/command", "is the garage door open?")
+
+	var response Response
+	waitFor(t, broker, "gpt-home/response", &response)
+	assert.Equal(t, "You said: is the garage door open?", response.Response)
+	assert.Equal(t, chat.conversationID.String(), response.ConversationID)
+	assert.Len(t, response.ActionsPerformed, 1)
+	assert.Empty(t, response.Error)
+}
+
+func TestBridge_RepliesToRequestedTopic(t *testing.T) {
+	broker := startBroker(t)
+	startBridge(t, testOptions(broker), &fakeChatter{err: errors.New("Luna is busy")}, nil, nil)
+
+	publish(t, broker, "gpt-home/command", `{"id": "btn-1", "message": "good night", "reply_to": "gpt-home/response/hall"}`)
+
+	var response Response
+	waitFor(t, broker, "gpt-home/response/hall", &response)
+	assert.Equal(t, "btn-1", response.ID)
+	assert.Equal(t, "Luna is busy", response.Error)
+}
+
+func TestBridge_RejectsForeignReplyTopics(t *testing.T) {
+	broker := startBroker(t)
+	haClient := mocks.NewMockHomeAssistantClient()
+	startBridge(t, testOptions(broker), &fakeChatter{}, device.NewManager(haClient), nil)
+
+	// Replying to another device's command topic would control it as Luna
+	publish(t, broker, "gpt-home/command", `{"id": "evil", "device_id": "switch.porch", "action": "turn_on", "reply_to": "zigbee2mqtt/garage_door/set"}`)
+
+	var response Response
+	waitFor(t, broker, "gpt-home/response", &response)
+	assert.Equal(t, "evil", response.ID)
+	assert.Contains(t, response.Error, "reply_to must be a topic under gpt-home/actions")
+	assert.Empty(t, haClient.ServiceCalls())
+	for _, message := range broker.Messages() {
+		assert.NotEqual(t, "zigbee2mqtt/garage_door/set", message.Topic)
+	}
+}
+
+func TestBridge_ExecutesDeviceActions(t *testing.T) {
+	broker := startBroker(t)
+	haClient := mocks.NewMockHomeAssistantClient()
+	hub := events.NewHub()
+	manager := device.NewManagerWithOptions(haClient, device.ManagerOptions{Events: hub})
+	startBridge(t, testOptions(broker), &fakeChatter{}, manager, hub)
+
+	publish(t, broker, "gpt-home/command", `{"id": "z2m", "device_id": "light.living_room", "action": "set_brightness", "parameters": {"brightness": 128}}`)
+
+	var result ActionResult
+	waitFor(t, broker, "gpt-home/actions", &result)
+	assert.Equal(t, ActionResult{ID: "z2m", DeviceID: "light.living_room", Action: "set_brightness", Status: StatusSuccess}, result)
+	require.Len(t, haClient.ServiceCalls(), 1)
+	assert.Equal(t, "turn_on", haClient.ServiceCalls()[0].Service)
+
+	// The device manager's own result reaches the event topic
+	var event events.Event
+	waitFor(t, broker, "gpt-home/events/action_result", &event)
+	assert.Equal(t, events.TopicActionResult, event.Topic)
+}
+
+func TestBridge_ReportsFailedActions(t *testing.T) {
+	broker := startBroker(t)
+	manager := device.NewManager(mocks.NewMockHomeAssistantClient())
+	startBridge(t, testOptions(broker), &fakeChatter{}, manager, nil)
+
+	publish(t, broker, "gpt-home/command", `{"device_id": "light.nowhere", "action": "turn_on"}`)
+
+	var result ActionResult
+	waitFor(t, broker, "gpt-home/actions", &result)
+	assert.Equal(t, StatusError, result.Status)
+	assert.Contains(t, result.Error, "device not found")
+}
+
+func TestBridge_ForwardsOnlyPublicEvents(t *testing.T) {
+	broker := startBroker(t)
+	hub := events.NewHub()
+	acl := &device.ACL{
+		Policies: map[string]device.AccessPolicy{"guests": {Read: []device.AccessRule{{Areas: []string{"bedroom"}}}}},
+		Default:  "guests",
+	}
+	manager := device.NewManagerWithOptions(mocks.NewMockHomeAssistantClient(), device.ManagerOptions{Events: hub, ACL: acl})
+	require.NoError(t, manager.RefreshDevices(context.Background()))
+	startBridge(t, testOptions(broker), &fakeChatter{}, manager, hub)
+
+	// With accounts enabled, a user's messages stay private, as do devices MQTT clients can't read
+	private := models.ConversationMessageEvent{ConversationID: uuid.New(), UserID: uuid.New(), Message: models.Message{Content: "my alarm code is 1234"}}
+	public := models.ConversationMessageEvent{ConversationID: uuid.New(), Message: models.Message{Content: "good night"}}
+	// The bridge subscribes in the background, so publish until the events get through
+	require.Eventually(t, func() bool {
+		hub.Publish(events.TopicConversationMessage, private)
+		hub.Publish(events.TopicDeviceState, models.DeviceStateEvent{DeviceID: "climate.main", State: "heat"})
+		hub.Publish(events.TopicDeviceState, models.DeviceStateEvent{DeviceID: "light.bedroom", State: "on"})
+		hub.Publish(events.TopicConversationMessage, public)
+		_, err := broker.WaitForMessage("gpt-home/events/conversation_message", 50*time.Millisecond)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var deviceIDs []string
+	for _, message := range broker.Messages() {
+		assert.NotContains(t, string(message.Payload), private.ConversationID.String())
+		if message.Topic == "gpt-home/events/device_state" {
+			var event struct {
+				Data models.DeviceStateEvent `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(message.Payload, &event))
+			deviceIDs = append(deviceIDs, event.Data.DeviceID)
+		}
+	}
+	assert.Contains(t, deviceIDs, "light.bedroom")
+	assert.NotContains(t, deviceIDs, "climate.main")
+}
+
+func TestBridge_RejectsInvalidCommands(t *testing.T) {
+	broker := startBroker(t)
+	startBridge(t, testOptions(broker), &fakeChatter{}, nil, nil)
+
+	publish(t, broker, "gpt-home/command", `{"action": "turn_on"}`)
+
+	var response Response
+	waitFor(t, broker, "gpt-home/response", &response)
+	assert.Contains(t, response.Error, "device_id and action")
+}
+
+func TestBridge_Credentials(t *testing.T) {
+	broker := startBroker(t)
+	broker.RequireCredentials("luna", "secret")
+
+	opts := testOptions(broker)
+	opts.Username, opts.Password = "luna", "wrong"
+	assert.Error(t, NewBridge(opts, &fakeChatter{}, nil, nil).Start(context.Background()))
+
+	opts.Password = "secret"
+	startBridge(t, opts, &fakeChatter{}, nil, nil)
+}
+
+func TestParseCommand(t *testing.T) {
+	cmd, err := ParseCommand([]byte("  turn on the porch light \n"))
+	require.NoError(t, err)
+	assert.Equal(t, "turn on the porch light", cmd.Message)
+	assert.False(t, cmd.IsAction())
+
+	cmd, err = ParseCommand([]byte(`{"device_id": "switch.porch", "action": "turn_off"}`))
+	require.NoError(t, err)
+	assert.True(t, cmd.IsAction())
+
+	for _, payload := range []string{"", "{", `{}`, `{"device_id": "switch.porch"}`} {
+		_, err := ParseCommand([]byte(payload))
+		assert.Error(t, err, payload)
+	}
+}
+
+func TestReplyTopic(t *testing.T) {
+	tests := []struct {
+		replyTo string
+		want    string
+		ok      bool
+	}{
+		{"", "gpt-home/response", true},
+		{"gpt-home/response/hall", "gpt-home/response/hall", true},
+		{"gpt-home/response", "gpt-home/response", true},
+		{"gpt-home/responses", "", false},
+		{"gpt-home/response/#", "", false},
+		{"zigbee2mqtt/porch/set", "", false},
+	}
+	for _, tt := range tests {
+		topic, err := (&Command{ReplyTo: tt.replyTo}).replyTopic("gpt-home/response")
+		assert.Equal(t, tt.ok, err == nil, tt.replyTo)
+		assert.Equal(t, tt.want, topic, tt.replyTo)
+	}
+
+	_, err := (&Command{ReplyTo: "gpt-home/response/hall"}).replyTopic("")
+	assert.Error(t, err)
+}
+
+func TestTLSFiles(t *testing.T) {
+	config, err := (&TLSFiles{}).Config()
+	require.NoError(t, err)
+	assert.Nil(t, config.RootCAs)
+	assert.Empty(t, config.Certificates)
+
+	_, err = (&TLSFiles{CAFile: "/nonexistent/ca.pem"}).Config()
+	assert.Error(t, err)
+
+	_, err = (&TLSFiles{CertFile: "/nonexistent/client.pem"}).Config()
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// Action result statuses
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+)
+
+// Command is a message on the command topic: something to say to Luna, or a
+// device action to carry out. A payload that isn't a JSON object is taken as
+// the message itself, so a button can publish plain text.
+type Command struct {
+	ID             string         `json:"id,omitempty"` // echoed in the reply to match it up
+	Message        string         `json:"message,omitempty"`
+	ConversationID uuid.UUID      `json:"conversation_id,omitempty"`
+	DeviceID       string         `json:"device_id,omitempty"`
+	Action         string         `json:"action,omitempty"`
+	Parameters     map[string]any `json:"parameters,omitempty"`
+	ReplyTo        string         `json:"reply_to,omitempty"` // topic under the configured one to reply on instead
+}
+
+// Response is Luna's reply to a message
+type Response struct {
+	ID               string                `json:"id,omitempty"`
+	ConversationID   string                `json:"conversation_id,omitempty"`
+	Response         string                `json:"response,omitempty"`
+	ActionsPerformed []models.DeviceAction `json:"actions_performed,omitempty"`
+	Error            string                `json:"error,omitempty"`
+}
+
+// ActionResult is the outcome of a device action
+type ActionResult struct {
+	ID       string `json:"id,omitempty"`
+	DeviceID string `json:"device_id"`
+	Action   string `json:"action"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ParseCommand reads a command from an MQTT payload
+func ParseCommand(payload []byte) (*Command, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	if payload[0] != '{' {
+		return &Command{Message: string(payload)}, nil
+	}
+
+	var cmd Command
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return nil, fmt.Errorf("invalid command: %w", err)
+	}
+	switch {
+	case cmd.DeviceID != "" || cmd.Action != "":
+		if cmd.DeviceID == "" || cmd.Action == "" {
+			return nil, fmt.Errorf("device actions need both device_id and action")
+		}
+	case cmd.Message == "":
+		return nil, fmt.Errorf("command needs a message, or a device_id and action")
+	}
+	return &cmd, nil
+}
+
+// IsAction reports whether the command is a device action rather than a message
+func (c *Command) IsAction() bool {
+	return c.DeviceID != ""
+}
+
+// replyTopic picks the topic to answer on. Any client can send commands, so a
+// requested topic must be under the configured one; otherwise Luna could be
+// made to publish to other devices' topics with her broker credentials.
+func (c *Command) replyTopic(configured string) (string, error) {
+	if c.ReplyTo == "" || c.ReplyTo == configured {
+		return configured, nil
+	}
+	if configured == "" {
+		return "", fmt.Errorf("reply_to can't be used while replies are turned off")
+	}
+	if !strings.HasPrefix(c.ReplyTo, configured+"/") || strings.ContainsAny(c.ReplyTo, "+#") {
+		return "", fmt.Errorf("reply_to must be a topic under %s", configured)
+	}
+	return c.ReplyTo, nil
+}
+
+// TLSFiles are the certificates for a TLS connection to the broker. Without a
+// CA file the system's roots verify the broker; the client certificate is only
+// for brokers that ask for one.
+type TLSFiles struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Config loads the certificates into a TLS configuration
+func (f *TLSFiles) Config() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if f.CAFile != "" {
+		pem, err := os.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", f.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if f.CertFile != "" || f.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
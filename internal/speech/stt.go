@@ -0,0 +1,158 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/internal/config"
+)
+
+// STT server APIs
+const (
+	APIWhisperCpp = "whispercpp" // the whisper.cpp server's /inference
+	APIOpenAI     = "openai"     // /v1/audio/transcriptions, as faster-whisper servers offer
+)
+
+// ErrUnsupportedFormat is returned for audio that isn't WAV, Ogg or WebM
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// formats maps the audio content types accepted to a file extension for the STT server
+var formats = map[string]string{
+	"audio/wav":       ".wav",
+	"audio/wave":      ".wav",
+	"audio/x-wav":     ".wav",
+	"audio/vnd.wave":  ".wav",
+	"audio/ogg":       ".ogg",
+	"application/ogg": ".ogg",
+	"audio/webm":      ".webm",
+	"video/webm":      ".webm", // what browsers' MediaRecorder often labels audio-only WebM
+}
+
+// Audio is a recording to transcribe. Its format comes from the content type,
+// or the file name's extension when that doesn't say.
+type Audio struct {
+	Data        []byte
+	ContentType string
+	Filename    string
+}
+
+// Transcript is what was said in a recording
+type Transcript struct {
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"`
+}
+
+// Transcriber turns speech into text
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio Audio) (*Transcript, error)
+}
+
+// AudioFormat returns the file extension of an accepted audio content type,
+// falling back to the file name's, or ErrUnsupportedFormat
+func AudioFormat(contentType, filename string) (string, error) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if ext, ok := formats[strings.ToLower(mediaType)]; ok {
+			return ext, nil
+		}
+	}
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".wav", ".ogg", ".oga", ".opus", ".webm":
+		return ext, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// WhisperClient transcribes with a local Whisper server: the whisper.cpp
+// server, or one with an OpenAI-style transcription API such as
+// faster-whisper-server
+type WhisperClient struct {
+	baseURL    string
+	api        string
+	model      string
+	language   string
+	httpClient *http.Client
+}
+
+// NewWhisperClient creates a client for the STT server in cfg
+func NewWhisperClient(cfg config.SpeechConfig) (*WhisperClient, error) {
+	if cfg.STTAPI != APIWhisperCpp && cfg.STTAPI != APIOpenAI {
+		return nil, fmt.Errorf("unknown STT API %q, expected %q or %q", cfg.STTAPI, APIWhisperCpp, APIOpenAI)
+	}
+	return &WhisperClient{
+		baseURL:    strings.TrimRight(cfg.STTURL, "/"),
+		api:        cfg.STTAPI,
+		model:      cfg.STTModel,
+		language:   cfg.STTLanguage,
+		httpClient: &http.Client{Timeout: time.Duration(cfg.STTTimeout) * time.Second},
+	}, nil
+}
+
+// Transcribe sends a recording to the Whisper server
+func (w *WhisperClient) Transcribe(ctx context.Context, audio Audio) (*Transcript, error) {
+	ext, err := AudioFormat(audio.ContentType, audio.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "audio"+ext)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(audio.Data); err != nil {
+		return nil, err
+	}
+
+	endpoint := w.baseURL + "/inference"
+	fields := map[string]string{"response_format": "json", "temperature": "0"}
+	if w.api == APIOpenAI {
+		endpoint = w.baseURL + "/v1/audio/transcriptions"
+		fields["model"] = w.model
+	}
+	if w.language != "" {
+		fields["language"] = w.language
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transcription request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach STT server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("STT server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var transcript Transcript
+	if err := json.NewDecoder(resp.Body).Decode(&transcript); err != nil {
+		return nil, fmt.Errorf("failed to decode transcription: %w", err)
+	}
+	transcript.Text = strings.TrimSpace(transcript.Text)
+	return &transcript, nil
+}
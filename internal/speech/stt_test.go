@@ -0,0 +1,125 @@
+package speech
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/config"
+)
+
+func TestAudioFormat(t *testing.T) {
+	tests := []struct {
+		contentType string
+		filename    string
+		want        string
+		wantErr     bool
+	}{
+		{"audio/wav", "", ".wav", false},
+		{"audio/webm;codecs=opus", "", ".webm", false},
+		{"video/webm", "", ".webm", false},
+		{"application/ogg", "", ".ogg", false},
+		{"application/octet-stream", "clip.OGA", ".oga", false},
+		{"", "recording.wav", ".wav", false},
+		{"audio/mpeg", "song.mp3", "", true},
+		{"", "", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := AudioFormat(tt.contentType, tt.filename)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrUnsupportedFormat, tt.contentType)
+			continue
+		}
+		require.NoError(t, err, tt.contentType)
+		assert.Equal(t, tt.want, got, tt.contentType)
+	}
+}
+
+// newFakeWhisper serves transcriptions on path, recording the form fields sent
+func newFakeWhisper(t *testing.T, path string, fields map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		fields["filename"] = header.Filename
+		fields["data"] = string(data)
+		for name, values := range r.MultipartForm.Value {
+			fields[name] = values[0]
+		}
+		w.Write([]byte(`{"text": " Turn on the kitchen lights. "}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWhisperClient_WhisperCpp(t *testing.T) {
+	fields := map[string]string{}
+	server := newFakeWhisper(t, "/inference", fields)
+
+	client, err := NewWhisperClient(config.SpeechConfig{STTURL: server.URL + "/", STTAPI: APIWhisperCpp, STTTimeout: 5})
+	require.NoError(t, err)
+
+	transcript, err := client.Transcribe(context.Background(), Audio{Data: []byte("RIFF"), ContentType: "audio/x-wav"})
+	require.NoError(t, err)
+	assert.Equal(t, "Turn on the kitchen lights.", transcript.Text)
+	assert.Equal(t, "audio.wav", fields["filename"])
+	assert.Equal(t, "RIFF", fields["data"])
+	assert.Equal(t, "json", fields["response_format"])
+	assert.NotContains(t, fields, "model")
+	assert.NotContains(t, fields, "language")
+}
+
+func TestWhisperClient_OpenAI(t *testing.T) {
+	fields := map[string]string{}
+	server := newFakeWhisper(t, "/v1/audio/transcriptions", fields)
+
+	client, err := NewWhisperClient(config.SpeechConfig{
+		STTURL:      server.URL,
+		STTAPI:      APIOpenAI,
+		STTModel:    "Systran/faster-whisper-small",
+		STTLanguage: "en",
+		STTTimeout:  5,
+	})
+	require.NoError(t, err)
+
+	transcript, err := client.Transcribe(context.Background(), Audio{Data: []byte("OggS"), Filename: "note.ogg"})
+	require.NoError(t, err)
+	assert.Equal(t, "Turn on the kitchen lights.", transcript.Text)
+	assert.Equal(t, "audio.ogg", fields["filename"])
+	assert.Equal(t, "Systran/faster-whisper-small", fields["model"])
+	assert.Equal(t, "en", fields["language"])
+}
+
+func TestWhisperClient_Errors(t *testing.T) {
+	_, err := NewWhisperClient(config.SpeechConfig{STTURL: "http://localhost", STTAPI: "vosk"})
+	assert.Error(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client, err := NewWhisperClient(config.SpeechConfig{STTURL: server.URL, STTAPI: APIWhisperCpp, STTTimeout: 5})
+	require.NoError(t, err)
+
+	_, err = client.Transcribe(context.Background(), Audio{Data: []byte("ID3"), ContentType: "audio/mpeg"})
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	_, err = client.Transcribe(context.Background(), Audio{Data: []byte("RIFF"), ContentType: "audio/wav"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "model not loaded")
+}
@@ -0,0 +1,145 @@
+package speech
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tienpdinh/gpt-home/internal/config"
+)
+
+// Speech is synthesized audio
+type Speech struct {
+	Data        []byte
+	ContentType string
+}
+
+// Synthesizer turns text into speech
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) (*Speech, error)
+}
+
+// PiperClient synthesizes speech with Piper's HTTP server
+// (python -m piper.http_server), which answers with WAV audio
+type PiperClient struct {
+	url        string
+	voice      string
+	httpClient *http.Client
+}
+
+// NewPiperClient creates a client for the TTS server in cfg
+func NewPiperClient(cfg config.SpeechConfig) *PiperClient {
+	return &PiperClient{
+		url:        cfg.TTSURL,
+		voice:      cfg.TTSVoice,
+		httpClient: &http.Client{Timeout: time.Duration(cfg.TTSTimeout) * time.Second},
+	}
+}
+
+// Synthesize asks the Piper server to speak text
+func (p *PiperClient) Synthesize(ctx context.Context, text string) (*Speech, error) {
+	body, err := json.Marshal(struct {
+		Text  string `json:"text"`
+		Voice string `json:"voice,omitempty"`
+	}{text, p.voice})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create synthesis request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach TTS server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("TTS server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read synthesized audio: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("TTS server returned no audio")
+	}
+
+	// Piper always answers with WAV, whatever it labels it
+	contentType := "audio/wav"
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediaType, "audio/") {
+		contentType = mediaType
+	}
+	return &Speech{Data: data, ContentType: contentType}, nil
+}
+
+// AudioCache keeps the speech of the most recently spoken messages, so a reply
+// is only synthesized once however often it's played
+type AudioCache struct {
+	size    int
+	mutex   sync.Mutex
+	order   *list.List // most recently used first
+	entries map[uuid.UUID]*list.Element
+}
+
+type cachedSpeech struct {
+	messageID uuid.UUID
+	speech    *Speech
+}
+
+// NewAudioCache creates a cache holding the speech of up to size messages
+func NewAudioCache(size int) *AudioCache {
+	return &AudioCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[uuid.UUID]*list.Element),
+	}
+}
+
+// Get returns a message's cached speech
+func (c *AudioCache) Get(messageID uuid.UUID) (*Speech, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[messageID]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedSpeech).speech, true
+}
+
+// Add caches a message's speech, dropping the least recently used beyond the
+// cache's size
+func (c *AudioCache) Add(messageID uuid.UUID, speech *Speech) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[messageID]; ok {
+		element.Value.(*cachedSpeech).speech = speech
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[messageID] = c.order.PushFront(&cachedSpeech{messageID: messageID, speech: speech})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedSpeech).messageID)
+	}
+}
@@ -0,0 +1,81 @@
+package speech
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/internal/config"
+)
+
+func TestPiperClient_Synthesize(t *testing.T) {
+	var got struct {
+		Text  string `json:"text"`
+		Voice string `json:"voice"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("RIFF"))
+	}))
+	defer server.Close()
+
+	client := NewPiperClient(config.SpeechConfig{TTSURL: server.URL, TTSVoice: "en_US-amy-medium", TTSTimeout: 5})
+	spoken, err := client.Synthesize(context.Background(), "The lights are on.")
+	require.NoError(t, err)
+	assert.Equal(t, "The lights are on.", got.Text)
+	assert.Equal(t, "en_US-amy-medium", got.Voice)
+	assert.Equal(t, []byte("RIFF"), spoken.Data)
+	assert.Equal(t, "audio/wav", spoken.ContentType)
+}
+
+func TestPiperClient_Errors(t *testing.T) {
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte("voice not found"))
+		}
+	}))
+	defer server.Close()
+
+	client := NewPiperClient(config.SpeechConfig{TTSURL: server.URL, TTSTimeout: 5})
+	_, err := client.Synthesize(context.Background(), "Hello")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "voice not found")
+
+	status = http.StatusOK
+	_, err = client.Synthesize(context.Background(), "Hello")
+	assert.Error(t, err)
+}
+
+func TestAudioCache(t *testing.T) {
+	cache := NewAudioCache(2)
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+
+	cache.Add(first, &Speech{Data: []byte("1")})
+	cache.Add(second, &Speech{Data: []byte("2")})
+
+	// Using the first keeps it over the second
+	_, ok := cache.Get(first)
+	assert.True(t, ok)
+	cache.Add(third, &Speech{Data: []byte("3")})
+
+	_, ok = cache.Get(second)
+	assert.False(t, ok)
+	spoken, ok := cache.Get(first)
+	require.True(t, ok)
+	assert.Equal(t, []byte("1"), spoken.Data)
+	_, ok = cache.Get(third)
+	assert.True(t, ok)
+
+	// Adding again replaces the audio
+	cache.Add(third, &Speech{Data: []byte("3b")})
+	spoken, _ = cache.Get(third)
+	assert.Equal(t, []byte("3b"), spoken.Data)
+}
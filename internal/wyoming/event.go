@@ -0,0 +1,137 @@
+package wyoming
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// protocolVersion is the Wyoming version events are written as
+const protocolVersion = "1.5.4"
+
+// Limits on what a peer may send, so a bad one can't exhaust memory
+const (
+	maxHeaderBytes  = 64 << 10
+	maxDataBytes    = 1 << 20
+	maxPayloadBytes = 16 << 20
+)
+
+// Event types Luna understands
+const (
+	TypeDescribe   = "describe"
+	TypeInfo       = "info"
+	TypeTranscript = "transcript"
+	TypeHandled    = "handled"
+	TypeNotHandled = "not-handled"
+	TypePing       = "ping"
+	TypePong       = "pong"
+	TypeError      = "error"
+)
+
+// Event is one Wyoming message: a JSON header line, then optionally more JSON
+// data and a binary payload, such as audio, whose lengths the header gives
+type Event struct {
+	Type    string
+	Data    map[string]any
+	Payload []byte
+}
+
+type header struct {
+	Type          string         `json:"type"`
+	Version       string         `json:"version,omitempty"`
+	Data          map[string]any `json:"data,omitempty"`
+	DataLength    int            `json:"data_length,omitempty"`
+	PayloadLength int            `json:"payload_length,omitempty"`
+}
+
+// ReadEvent reads the next event. Data sent after the header is merged over
+// data in it, as other Wyoming implementations do.
+func ReadEvent(r *bufio.Reader) (*Event, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var h header
+	if err := json.Unmarshal(line, &h); err != nil {
+		return nil, fmt.Errorf("invalid event header: %w", err)
+	}
+	if h.Type == "" {
+		return nil, fmt.Errorf("event header has no type")
+	}
+	if h.DataLength < 0 || h.DataLength > maxDataBytes || h.PayloadLength < 0 || h.PayloadLength > maxPayloadBytes {
+		return nil, fmt.Errorf("%s event is too large", h.Type)
+	}
+
+	event := &Event{Type: h.Type, Data: h.Data}
+	if event.Data == nil {
+		event.Data = map[string]any{}
+	}
+
+	if h.DataLength > 0 {
+		data := make([]byte, h.DataLength)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read %s event data: %w", h.Type, err)
+		}
+		var extra map[string]any
+		if err := json.Unmarshal(data, &extra); err != nil {
+			return nil, fmt.Errorf("invalid %s event data: %w", h.Type, err)
+		}
+		for key, value := range extra {
+			event.Data[key] = value
+		}
+	}
+
+	if h.PayloadLength > 0 {
+		event.Payload = make([]byte, h.PayloadLength)
+		if _, err := io.ReadFull(r, event.Payload); err != nil {
+			return nil, fmt.Errorf("failed to read %s event payload: %w", h.Type, err)
+		}
+	}
+
+	return event, nil
+}
+
+// readLine reads a header line without its newline, refusing overlong ones
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxHeaderBytes {
+			return nil, fmt.Errorf("event header is too long")
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// WriteEvent writes an event with its data in the header line, which every
+// Wyoming version reads
+func WriteEvent(w io.Writer, event *Event) error {
+	line, err := json.Marshal(header{
+		Type:          event.Type,
+		Version:       protocolVersion,
+		Data:          event.Data,
+		PayloadLength: len(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	message := append(line, '\n')
+	message = append(message, event.Payload...)
+	_, err = w.Write(message)
+	return err
+}
+
+// text returns a string field of the event's data
+func (e *Event) text(key string) string {
+	value, _ := e.Data[key].(string)
+	return value
+}
@@ -0,0 +1,55 @@
+package wyoming
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadEvent(t *testing.T) {
+	stream := `{"type": "transcript", "data": {"text": "old", "language": "en"}, "data_length": 16, "payload_length": 3}` + "\n" +
+		`{"text": "lamp"}` + "abc" +
+		`{"type": "ping"}` + "\n"
+	reader := bufio.NewReader(strings.NewReader(stream))
+
+	event, err := ReadEvent(reader)
+	require.NoError(t, err)
+	assert.Equal(t, TypeTranscript, event.Type)
+	assert.Equal(t, "lamp", event.text("text"), "data after the header wins")
+	assert.Equal(t, "en", event.text("language"))
+	assert.Equal(t, []byte("abc"), event.Payload)
+
+	event, err = ReadEvent(reader)
+	require.NoError(t, err)
+	assert.Equal(t, TypePing, event.Type)
+	assert.Empty(t, event.Data)
+}
+
+func TestReadEvent_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"not json":          "hello\n",
+		"no type":           `{"data": {}}` + "\n",
+		"data too large":    `{"type": "x", "data_length": 999999999}` + "\n",
+		"truncated payload": `{"type": "x", "payload_length": 10}` + "\nabc",
+		"header too long":   `{"type": "` + strings.Repeat("x", maxHeaderBytes) + `"}` + "\n",
+	}
+	for name, stream := range tests {
+		_, err := ReadEvent(bufio.NewReader(strings.NewReader(stream)))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteEvent(&buf, &Event{Type: TypeHandled, Data: map[string]any{"text": "Done."}, Payload: []byte("xy")}))
+
+	event, err := ReadEvent(bufio.NewReader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, TypeHandled, event.Type)
+	assert.Equal(t, "Done.", event.text("text"))
+	assert.Equal(t, []byte("xy"), event.Payload)
+}
@@ -0,0 +1,216 @@
+package wyoming
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Chatter answers chat messages, as the chat API does
+type Chatter interface {
+	Chat(ctx context.Context, req models.ChatRequest) (*models.ChatResponse, error)
+}
+
+// Options describe the handle service to satellites
+type Options struct {
+	Addr      string   // TCP address to listen on, e.g. ":10500"
+	Languages []string // languages Luna is offered for
+	Version   string
+}
+
+// Server is a Wyoming handle service: satellites and pipelines send it the
+// transcript of what was said and get Luna's reply back as a handled event,
+// ready for their TTS. Each connection keeps one conversation going, and a
+// transcript whose context carries a conversation_id from an earlier reply
+// continues that one.
+type Server struct {
+	opts Options
+	chat Chatter
+
+	listener net.Listener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewServer creates a server that isn't listening yet
+func NewServer(opts Options, chat Chatter) *Server {
+	return &Server{
+		opts:  opts,
+		chat:  chat,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// Start listens for satellites and serves them until ctx ends or Stop is called
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for Wyoming satellites on %s: %w", s.opts.Addr, err)
+	}
+
+	s.listener = listener
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go s.accept()
+
+	// Closing the listener and connections is what unblocks their goroutines
+	go func() {
+		<-s.ctx.Done()
+		s.closeAll()
+	}()
+
+	logrus.Infof("Wyoming server listening on %s", listener.Addr())
+	return nil
+}
+
+// Addr is the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stop closes every connection, cancelling the transcripts being answered,
+// and waits for them to finish
+func (s *Server) Stop() {
+	if s.listener == nil {
+		return
+	}
+	s.cancel()
+	s.closeAll()
+	s.wg.Wait()
+}
+
+func (s *Server) closeAll() {
+	s.listener.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		if s.ctx.Err() != nil {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// serve answers one connection's events until it closes
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	var conversationID uuid.UUID
+	for {
+		event, err := ReadEvent(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logrus.WithError(err).Warnf("Closing Wyoming connection from %s", conn.RemoteAddr())
+				WriteEvent(conn, &Event{Type: TypeError, Data: map[string]any{"text": err.Error()}})
+			}
+			return
+		}
+
+		var reply *Event
+		switch event.Type {
+		case TypeDescribe:
+			reply = s.info()
+		case TypePing:
+			reply = &Event{Type: TypePong, Data: map[string]any{"text": event.text("text")}}
+		case TypeTranscript:
+			reply = s.handle(event, &conversationID)
+		default:
+			logrus.Debugf("Ignoring Wyoming %s event", event.Type)
+			continue
+		}
+
+		if err := WriteEvent(conn, reply); err != nil {
+			logrus.WithError(err).Warnf("Failed to answer Wyoming %s event", event.Type)
+			return
+		}
+	}
+}
+
+// handle answers a transcript, continuing the connection's conversation or the
+// one named in the transcript's context
+func (s *Server) handle(event *Event, conversationID *uuid.UUID) *Event {
+	text := event.text("text")
+	if text == "" {
+		return &Event{Type: TypeNotHandled, Data: map[string]any{"text": "I didn't catch that."}}
+	}
+
+	if extra, ok := event.Data["context"].(map[string]any); ok {
+		if id, err := uuid.Parse(fmt.Sprint(extra["conversation_id"])); err == nil {
+			*conversationID = id
+		}
+	}
+
+	response, err := s.chat.Chat(s.ctx, models.ChatRequest{Message: text, ConversationID: *conversationID})
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to answer Wyoming transcript")
+		return &Event{Type: TypeNotHandled, Data: map[string]any{"text": "Sorry, I couldn't do that right now."}}
+	}
+
+	*conversationID = response.ConversationID
+	return &Event{Type: TypeHandled, Data: map[string]any{
+		"text":    response.Response,
+		"context": map[string]any{"conversation_id": response.ConversationID.String()},
+	}}
+}
+
+// info describes Luna as the one handle program this server offers
+func (s *Server) info() *Event {
+	attribution := map[string]any{"name": "GPT-Home", "url": "https://github.com/tienpdinh/gpt-home"}
+	return &Event{Type: TypeInfo, Data: map[string]any{
+		"handle": []any{map[string]any{
+			"name":        "luna",
+			"description": "Luna, GPT-Home's local assistant",
+			"attribution": attribution,
+			"installed":   true,
+			"version":     s.opts.Version,
+			"models": []any{map[string]any{
+				"name":        "luna",
+				"description": "Luna",
+				"attribution": attribution,
+				"installed":   true,
+				"version":     s.opts.Version,
+				"languages":   s.opts.Languages,
+			}},
+		}},
+	}}
+}
@@ -0,0 +1,139 @@
+package wyoming
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+type fakeChatter struct {
+	mutex    sync.Mutex
+	requests []models.ChatRequest
+	err      error
+}
+
+func (f *fakeChatter) Chat(ctx context.Context, req models.ChatRequest) (*models.ChatResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests = append(f.requests, req)
+	if f.err != nil {
+		return nil, f.err
+	}
+	id := req.ConversationID
+	if id == uuid.Nil {
+		id = uuid.New()
+	}
+	return &models.ChatResponse{Response: "You said: " + req.Message, ConversationID: id}, nil
+}
+
+func startServer(t *testing.T, chat Chatter) *Server {
+	t.Helper()
+
+	server := NewServer(Options{Addr: "127.0.0.1:0", Languages: []string{"en"}, Version: "1.0.0"}, chat)
+	require.NoError(t, server.Start(context.Background()))
+	t.Cleanup(server.Stop)
+	return server
+}
+
+type satellite struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, server *Server) *satellite {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &satellite{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (s *satellite) send(t *testing.T, event *Event) *Event {
+	t.Helper()
+
+	require.NoError(t, WriteEvent(s.conn, event))
+	reply, err := ReadEvent(s.reader)
+	require.NoError(t, err)
+	return reply
+}
+
+func TestServer_Describe(t *testing.T) {
+	client := dial(t, startServer(t, &fakeChatter{}))
+
+	info := client.send(t, &Event{Type: TypeDescribe})
+	assert.Equal(t, TypeInfo, info.Type)
+	programs, ok := info.Data["handle"].([]any)
+	require.True(t, ok)
+	require.Len(t, programs, 1)
+	program := programs[0].(map[string]any)
+	assert.Equal(t, "luna", program["name"])
+	assert.Equal(t, true, program["installed"])
+	model := program["models"].([]any)[0].(map[string]any)
+	assert.Equal(t, []any{"en"}, model["languages"])
+
+	pong := client.send(t, &Event{Type: TypePing, Data: map[string]any{"text": "hi"}})
+	assert.Equal(t, TypePong, pong.Type)
+	assert.Equal(t, "hi", pong.text("text"))
+}
+
+func TestServer_Transcript(t *testing.T) {
+	chat := &fakeChatter{}
+	server := startServer(t, chat)
+	client := dial(t, server)
+
+	first := client.send(t, &Event{Type: TypeTranscript, Data: map[string]any{"text": "Turn on the lamp"}})
+	assert.Equal(t, TypeHandled, first.Type)
+	assert.Equal(t, "You said: Turn on the lamp", first.text("text"))
+	conversationID := first.Data["context"].(map[string]any)["conversation_id"].(string)
+
+	// The connection keeps its conversation going
+	second := client.send(t, &Event{Type: TypeTranscript, Data: map[string]any{"text": "And the fan"}})
+	assert.Equal(t, TypeHandled, second.Type)
+	require.Len(t, chat.requests, 2)
+	assert.Equal(t, conversationID, chat.requests[1].ConversationID.String())
+
+	// Another connection starts its own, unless the context names one
+	other := dial(t, server)
+	other.send(t, &Event{Type: TypeTranscript, Data: map[string]any{"text": "Hello"}})
+	other.send(t, &Event{Type: TypeTranscript, Data: map[string]any{
+		"text":    "Where were we",
+		"context": map[string]any{"conversation_id": conversationID},
+	}})
+	require.Len(t, chat.requests, 4)
+	assert.Equal(t, uuid.Nil, chat.requests[2].ConversationID)
+	assert.Equal(t, conversationID, chat.requests[3].ConversationID.String())
+}
+
+func TestServer_NotHandled(t *testing.T) {
+	client := dial(t, startServer(t, &fakeChatter{err: errors.New("model unavailable")}))
+
+	reply := client.send(t, &Event{Type: TypeTranscript, Data: map[string]any{"text": "Hello"}})
+	assert.Equal(t, TypeNotHandled, reply.Type)
+	assert.NotEmpty(t, reply.text("text"))
+
+	reply = client.send(t, &Event{Type: TypeTranscript})
+	assert.Equal(t, TypeNotHandled, reply.Type)
+}
+
+func TestServer_Stop(t *testing.T) {
+	server := NewServer(Options{Addr: "127.0.0.1:0"}, &fakeChatter{})
+	require.NoError(t, server.Start(context.Background()))
+	client := dial(t, server)
+
+	server.Stop()
+	_, err := ReadEvent(client.reader)
+	assert.Error(t, err, "connections should be closed")
+	_, err = net.Dial("tcp", server.Addr().String())
+	assert.Error(t, err)
+}
@@ -0,0 +1,94 @@
+package homeassistant
+
+import (
+	"math"
+
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// ParseCapabilities reads what a device can do from its attributes. Only
+// lights, climate devices, covers and fans report capabilities; other domains,
+// and devices reporting none of the attributes they're read from, return nil.
+func ParseCapabilities(domain string, attributes map[string]any) *models.DeviceCapabilities {
+	switch domain {
+	case "light", "climate", "cover", "fan":
+	default:
+		return nil
+	}
+
+	features, known := attributeNumber(attributes, "supported_features")
+	capabilities := &models.DeviceCapabilities{SupportedFeatures: int(features)}
+
+	switch domain {
+	case "light":
+		capabilities.ColorModes = attributeStrings(attributes, "supported_color_modes")
+		capabilities.MinColorTempKelvin, capabilities.MaxColorTempKelvin = colorTempRange(attributes)
+	case "climate":
+		capabilities.HVACModes = attributeStrings(attributes, "hvac_modes")
+		capabilities.FanModes = attributeStrings(attributes, "fan_modes")
+		capabilities.PresetModes = attributeStrings(attributes, "preset_modes")
+		capabilities.MinTemp, _ = attributeNumber(attributes, "min_temp")
+		capabilities.MaxTemp, _ = attributeNumber(attributes, "max_temp")
+	case "fan":
+		capabilities.PresetModes = attributeStrings(attributes, "preset_modes")
+	}
+
+	// Without any of these, a zero feature mask would claim the device can't do anything
+	if !known && len(capabilities.ColorModes) == 0 && capabilities.MaxColorTempKelvin == 0 &&
+		len(capabilities.HVACModes) == 0 && len(capabilities.FanModes) == 0 && len(capabilities.PresetModes) == 0 &&
+		capabilities.MaxTemp == 0 {
+		return nil
+	}
+	return capabilities
+}
+
+// colorTempRange is a light's color temperature range in kelvin. Older
+// integrations only report it in mireds, where the scale runs the other way.
+func colorTempRange(attributes map[string]any) (int, int) {
+	minKelvin, hasMin := attributeNumber(attributes, "min_color_temp_kelvin")
+	maxKelvin, hasMax := attributeNumber(attributes, "max_color_temp_kelvin")
+	if hasMin && hasMax {
+		return int(math.Round(minKelvin)), int(math.Round(maxKelvin))
+	}
+
+	minMireds, hasMin := attributeNumber(attributes, "min_mireds")
+	maxMireds, hasMax := attributeNumber(attributes, "max_mireds")
+	if hasMin && hasMax && minMireds > 0 && maxMireds > 0 {
+		return int(math.Round(1e6 / maxMireds)), int(math.Round(1e6 / minMireds))
+	}
+	return 0, 0
+}
+
+// attributeNumber reads a numeric attribute, whether decoded from JSON or set directly
+func attributeNumber(attributes map[string]any, key string) (float64, bool) {
+	switch value := attributes[key].(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	default:
+		return 0, false
+	}
+}
+
+// attributeStrings reads a list-of-strings attribute, whether decoded from JSON or set directly
+func attributeStrings(attributes map[string]any, key string) []string {
+	switch value := attributes[key].(type) {
+	case []string:
+		return append([]string(nil), value...)
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
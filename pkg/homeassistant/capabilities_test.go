@@ -0,0 +1,83 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func TestParseCapabilities_Light(t *testing.T) {
+	var attributes map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"supported_color_modes": ["color_temp", "hs"],
+		"min_color_temp_kelvin": 2202,
+		"max_color_temp_kelvin": 6535
+	}`), &attributes))
+
+	caps := ParseCapabilities("light", attributes)
+	require.NotNil(t, caps)
+	assert.Equal(t, []string{"color_temp", "hs"}, caps.ColorModes)
+	assert.Equal(t, 2202, caps.MinColorTempKelvin)
+	assert.Equal(t, 6535, caps.MaxColorTempKelvin)
+	assert.True(t, caps.SupportsBrightness())
+	assert.True(t, caps.SupportsColorTemp())
+	assert.True(t, caps.SupportsColor())
+}
+
+func TestParseCapabilities_LightMireds(t *testing.T) {
+	caps := ParseCapabilities("light", map[string]any{
+		"supported_features": float64(models.LightSupportBrightness | models.LightSupportColorTemp),
+		"min_mireds":         153.0,
+		"max_mireds":         500.0,
+	})
+	require.NotNil(t, caps)
+	assert.Equal(t, 2000, caps.MinColorTempKelvin)
+	assert.Equal(t, 6536, caps.MaxColorTempKelvin)
+	assert.True(t, caps.SupportsBrightness())
+	assert.True(t, caps.SupportsColorTemp())
+	assert.False(t, caps.SupportsColor())
+}
+
+func TestParseCapabilities_Climate(t *testing.T) {
+	var attributes map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"supported_features": 17,
+		"hvac_modes": ["off", "heat", "cool"],
+		"preset_modes": ["eco", "away"],
+		"min_temp": 7,
+		"max_temp": 35
+	}`), &attributes))
+
+	caps := ParseCapabilities("climate", attributes)
+	require.NotNil(t, caps)
+	assert.True(t, caps.Supports(models.ClimateSupportTargetTemperature))
+	assert.True(t, caps.Supports(models.ClimateSupportPresetMode))
+	assert.False(t, caps.Supports(models.ClimateSupportFanMode))
+	assert.Equal(t, []string{"off", "heat", "cool"}, caps.HVACModes)
+	assert.Equal(t, []string{"eco", "away"}, caps.PresetModes)
+	assert.Equal(t, 7.0, caps.MinTemp)
+	assert.Equal(t, 35.0, caps.MaxTemp)
+}
+
+func TestParseCapabilities_Unknown(t *testing.T) {
+	assert.Nil(t, ParseCapabilities("cover", map[string]any{"friendly_name": "Garage Door"}))
+	assert.Nil(t, ParseCapabilities("light", nil))
+
+	// A reported mask of zero is known to support nothing
+	caps := ParseCapabilities("cover", map[string]any{"supported_features": 0})
+	require.NotNil(t, caps)
+	assert.False(t, caps.Supports(models.CoverSupportOpen))
+
+	// Modes alone are enough to know something
+	caps = ParseCapabilities("climate", map[string]any{"hvac_modes": []any{"off", "heat"}})
+	require.NotNil(t, caps)
+	assert.Equal(t, []string{"off", "heat"}, caps.HVACModes)
+}
+
+func TestParseCapabilities_UnsupportedDomain(t *testing.T) {
+	assert.Nil(t, ParseCapabilities("switch", map[string]any{"supported_features": 0}))
+	assert.Nil(t, ParseCapabilities("sensor", nil))
+}
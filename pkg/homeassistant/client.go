@@ -2,21 +2,73 @@ package homeassistant
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
 
 	"github.com/sirupsen/logrus"
 )
 
 type Client struct {
-	baseURL    string
-	token      string
-	httpClient *http.Client
+	baseURL           string
+	token             string
+	httpClient        *http.Client
+	timeout           time.Duration
+	upstream          *resilience.Upstream
+	retry             resilience.RetryPolicy
+	retryServiceCalls bool
+}
+
+// Options tune a client's timeout, retries and circuit breaker
+type Options struct {
+	Timeout time.Duration // per request; 0 uses 30 seconds
+	// Retry is used for reads. Service calls aren't idempotent, so they are
+	// only retried with RetryServiceCalls, and then only when HomeAssistant
+	// can't have received them.
+	Retry             resilience.RetryPolicy
+	RetryServiceCalls bool
+	BreakerThreshold  int           // failures in a row that open the breaker
+	BreakerCooldown   time.Duration // how long the open breaker fails fast
+}
+
+// DefaultOptions retries reads but not service calls
+func DefaultOptions() Options {
+	return Options{
+		Timeout:          30 * time.Second,
+		Retry:            resilience.DefaultRetryPolicy,
+		BreakerThreshold: resilience.DefaultBreakerThreshold,
+		BreakerCooldown:  resilience.DefaultBreakerCooldown,
+	}
+}
+
+// statusError is a response with an unexpected status
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// unexpectedStatus wraps err for a response with an unexpected status. Server
+// errors and throttling count against HomeAssistant and may be retried; any
+// other status rejects just this request.
+func unexpectedStatus(code int, err error) error {
+	statusErr := &statusError{code: code, err: err}
+	if code >= 500 || code == http.StatusTooManyRequests {
+		return statusErr
+	}
+	return resilience.Permanent(statusErr)
 }
 
 type HAEntity struct {
@@ -45,96 +97,144 @@ type HAServiceTarget struct {
 	EntityID []string `json:"entity_id,omitempty"`
 }
 
+type HALogbookEntry struct {
+	When     string `json:"when"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+	EntityID string `json:"entity_id"`
+	State    string `json:"state"`
+	Domain   string `json:"domain"`
+}
+
 func NewClient(baseURL, token string) *Client {
+	return NewClientWithOptions(baseURL, token, DefaultOptions())
+}
+
+// NewClientWithOptions creates a client with its own timeout, retries and breaker
+func NewClientWithOptions(baseURL, token string, opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
 	return &Client{
 		baseURL: baseURL,
 		token:   token,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: opts.Timeout,
 		},
+		timeout:           opts.Timeout,
+		upstream:          resilience.NewUpstream("homeassistant", opts.BreakerThreshold, opts.BreakerCooldown),
+		retry:             opts.Retry,
+		retryServiceCalls: opts.RetryServiceCalls,
 	}
 }
 
-func (c *Client) GetEntities() ([]models.Device, error) {
-	req, err := http.NewRequest("GET", c.baseURL+"/api/states", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+// UpstreamStats reports the state of the client's circuit breaker
+func (c *Client) UpstreamStats() []resilience.UpstreamStats {
+	return []resilience.UpstreamStats{c.upstream.Stats()}
+}
+
+// send makes an authenticated request through the circuit breaker, retrying as
+// policy allows, and hands the response to handle. handle's errors decide
+// whether the attempt counts as a failure of HomeAssistant. Each attempt gets
+// the client's timeout, and all of them stop when ctx is done.
+func (c *Client) send(ctx context.Context, policy resilience.RetryPolicy, method, path string, body []byte, handle func(*http.Response) error) error {
+	return c.upstream.Call(ctx, policy, func() error {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+		if err != nil {
+			return resilience.Permanent(fmt.Errorf("failed to create request: %w", err))
+		}
 
-	req.Header.Set("Authorization", "Bearer "+c.token)
-	req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logrus.WithError(err).Warn("Failed to close response body")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to make request: %w", err)
 		}
-	}()
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				logrus.WithError(err).Warn("Failed to close response body")
+			}
+		}()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status: %d", resp.StatusCode)
+		return handle(resp)
+	})
+}
+
+// servicePolicy is how a service call may be retried: only when opted in, never
+// for toggles, which a repeat would undo, and only if the call can't have arrived
+func (c *Client) servicePolicy(service string) resilience.RetryPolicy {
+	if !c.retryServiceCalls || strings.Contains(service, "toggle") {
+		return resilience.NoRetry
 	}
+	policy := c.retry
+	policy.RetryIf = notDelivered
+	return policy
+}
 
+// notDelivered reports whether a failed request never reached HomeAssistant:
+// the connection couldn't be made, or a proxy in front of it couldn't reach it
+func notDelivered(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusBadGateway || statusErr.code == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+func (c *Client) GetEntities(ctx context.Context) ([]models.Device, error) {
 	var entities []HAEntity
-	if err := json.NewDecoder(resp.Body).Decode(&entities); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := c.getJSON(ctx, "/api/states", &entities); err != nil {
+		return nil, err
 	}
 
 	devices := make([]models.Device, 0, len(entities))
 	for _, entity := range entities {
-		device := c.convertEntityToDevice(entity)
+		device := EntityToDevice(entity)
 		devices = append(devices, device)
 	}
 
 	return devices, nil
 }
 
-func (c *Client) GetEntity(entityID string) (*models.Device, error) {
-	url := fmt.Sprintf("%s/api/states/%s", c.baseURL, entityID)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.token)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logrus.WithError(err).Warn("Failed to close response body")
-		}
-	}()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("entity not found: %s", entityID)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status: %d", resp.StatusCode)
-	}
-
+func (c *Client) GetEntity(ctx context.Context, entityID string) (*models.Device, error) {
 	var entity HAEntity
-	if err := json.NewDecoder(resp.Body).Decode(&entity); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	err := c.send(ctx, c.retry, "GET", "/api/states/"+entityID, nil, func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusNotFound {
+			return resilience.Permanent(fmt.Errorf("entity not found: %s", entityID))
+		}
+		return decodeResponse(resp, &entity)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	device := c.convertEntityToDevice(entity)
+	device := EntityToDevice(entity)
 	return &device, nil
 }
 
-func (c *Client) CallService(domain, service string, entityID string, serviceData map[string]interface{}) error {
+func (c *Client) CallService(ctx context.Context, domain, service string, entityID string, serviceData map[string]interface{}) error {
+	return c.CallServiceForEntities(ctx, domain, service, []string{entityID}, serviceData)
+}
+
+// CallServiceForEntities calls a service once for several entities that share the same service data
+func (c *Client) CallServiceForEntities(ctx context.Context, domain, service string, entityIDs []string, serviceData map[string]interface{}) error {
 	serviceCall := HAServiceCall{
 		Domain:  domain,
 		Service: service,
 		Target: &HAServiceTarget{
-			EntityID: []string{entityID},
+			EntityID: entityIDs,
 		},
 		ServiceData: serviceData,
 	}
@@ -144,60 +244,128 @@ func (c *Client) CallService(domain, service string, entityID string, serviceDat
 		return fmt.Errorf("failed to marshal service call: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/api/services/%s/%s", c.baseURL, domain, service)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	path := fmt.Sprintf("/api/services/%s/%s", domain, service)
+	err = c.send(ctx, c.servicePolicy(service), "POST", path, jsonData, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return unexpectedStatus(resp.StatusCode, fmt.Errorf("service call failed with status %d: %s", resp.StatusCode, string(body)))
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.token)
-	req.Header.Set("Content-Type", "application/json")
+	logrus.Debugf("Successfully called service %s.%s for entities %s", domain, service, strings.Join(entityIDs, ", "))
+	return nil
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
+// GetHistory returns the recorded states of the given entities between start and end,
+// keyed by entity ID, using the /api/history/period endpoint
+func (c *Client) GetHistory(ctx context.Context, entityIDs []string, start, end time.Time) (map[string][]models.StateChange, error) {
+	query := url.Values{}
+	query.Set("end_time", end.UTC().Format(time.RFC3339))
+	if len(entityIDs) > 0 {
+		query.Set("filter_entity_id", strings.Join(entityIDs, ","))
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logrus.WithError(err).Warn("Failed to close response body")
-		}
-	}()
+	path := fmt.Sprintf("/api/history/period/%s?%s", url.PathEscape(start.UTC().Format(time.RFC3339)), query.Encode())
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("service call failed with status %d: %s", resp.StatusCode, string(body))
+	// HA returns one list of states per entity
+	var periods [][]HAEntity
+	if err := c.getJSON(ctx, path, &periods); err != nil {
+		return nil, err
 	}
 
-	logrus.Debugf("Successfully called service %s.%s for entity %s", domain, service, entityID)
-	return nil
+	history := make(map[string][]models.StateChange, len(periods))
+	for _, states := range periods {
+		if len(states) == 0 {
+			continue
+		}
+		entityID := states[0].EntityID
+		changes := make([]models.StateChange, 0, len(states))
+		for _, state := range states {
+			lastChanged, _ := time.Parse(time.RFC3339, state.LastChanged)
+			changes = append(changes, models.StateChange{
+				EntityID:    entityID,
+				State:       state.State,
+				Attributes:  state.Attributes,
+				LastChanged: lastChanged,
+			})
+		}
+		history[entityID] = changes
+	}
+
+	return history, nil
 }
 
-func (c *Client) TestConnection() error {
-	req, err := http.NewRequest("GET", c.baseURL+"/api/", nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// GetLogbook returns logbook entries between start and end using the /api/logbook
+// endpoint. An empty entityID returns entries for all entities.
+func (c *Client) GetLogbook(ctx context.Context, entityID string, start, end time.Time) ([]models.LogbookEntry, error) {
+	query := url.Values{}
+	query.Set("end_time", end.UTC().Format(time.RFC3339))
+	if entityID != "" {
+		query.Set("entity", entityID)
 	}
+	path := fmt.Sprintf("/api/logbook/%s?%s", url.PathEscape(start.UTC().Format(time.RFC3339)), query.Encode())
 
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	var entries []HALogbookEntry
+	if err := c.getJSON(ctx, path, &entries); err != nil {
+		return nil, err
+	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to connect to HomeAssistant: %w", err)
+	logbook := make([]models.LogbookEntry, 0, len(entries))
+	for _, entry := range entries {
+		when, _ := time.Parse(time.RFC3339, entry.When)
+		logbook = append(logbook, models.LogbookEntry{
+			When:     when,
+			Name:     entry.Name,
+			Message:  entry.Message,
+			EntityID: entry.EntityID,
+			State:    entry.State,
+			Domain:   entry.Domain,
+		})
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logrus.WithError(err).Warn("Failed to close response body")
-		}
-	}()
 
+	return logbook, nil
+}
+
+// getJSON performs an authenticated GET against the HA API and decodes the JSON body into out
+func (c *Client) getJSON(ctx context.Context, path string, out interface{}) error {
+	return c.send(ctx, c.retry, "GET", path, nil, func(resp *http.Response) error {
+		return decodeResponse(resp, out)
+	})
+}
+
+// decodeResponse decodes a 200 response's JSON body into out
+func decodeResponse(resp *http.Response, out interface{}) error {
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HomeAssistant API returned status: %d", resp.StatusCode)
+		return unexpectedStatus(resp.StatusCode, fmt.Errorf("API request failed with status: %d", resp.StatusCode))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resilience.Permanent(fmt.Errorf("failed to decode response: %w", err))
 	}
 
 	return nil
 }
 
-func (c *Client) convertEntityToDevice(entity HAEntity) models.Device {
+func (c *Client) TestConnection(ctx context.Context) error {
+	err := c.send(ctx, c.retry, "GET", "/api/", nil, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return unexpectedStatus(resp.StatusCode, fmt.Errorf("HomeAssistant API returned status: %d", resp.StatusCode))
+		}
+		return nil
+	})
+
+	var statusErr *statusError
+	if err != nil && !errors.As(err, &statusErr) {
+		return fmt.Errorf("failed to connect to HomeAssistant: %w", err)
+	}
+	return err
+}
+
+// EntityToDevice converts an entity's state as HomeAssistant reports it to a device
+func EntityToDevice(entity HAEntity) models.Device {
 	// Parse domain from entity_id
 	domain := ""
 	if len(entity.EntityID) > 0 {
@@ -215,8 +383,11 @@ func (c *Client) convertEntityToDevice(entity HAEntity) models.Device {
 		name = friendlyName
 	}
 
+	// Area is only present when an integration exposes it as an attribute
+	area, _ := entity.Attributes["area_id"].(string)
+
 	// Convert domain to device type
-	deviceType := c.domainToDeviceType(domain)
+	deviceType := domainToDeviceType(domain)
 
 	// Parse last updated time
 	lastUpdated := time.Now()
@@ -226,6 +397,14 @@ func (c *Client) convertEntityToDevice(entity HAEntity) models.Device {
 		}
 	}
 
+	// Parse last changed time (state changes only, not attribute updates)
+	lastChanged := lastUpdated
+	if entity.LastChanged != "" {
+		if t, err := time.Parse(time.RFC3339, entity.LastChanged); err == nil {
+			lastChanged = t
+		}
+	}
+
 	return models.Device{
 		ID:          entity.EntityID,
 		Name:        name,
@@ -233,12 +412,16 @@ func (c *Client) convertEntityToDevice(entity HAEntity) models.Device {
 		State:       entity.State,
 		Attributes:  entity.Attributes,
 		LastUpdated: lastUpdated,
+		LastChanged: lastChanged,
 		Domain:      domain,
 		EntityID:    entity.EntityID,
+		Area:        area,
+
+		Capabilities: ParseCapabilities(domain, entity.Attributes),
 	}
 }
 
-func (c *Client) domainToDeviceType(domain string) models.DeviceType {
+func domainToDeviceType(domain string) models.DeviceType {
 	switch domain {
 	case "light":
 		return models.DeviceTypeLight
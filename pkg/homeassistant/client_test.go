@@ -1,6 +1,7 @@
 package homeassistant
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
 )
 
 func TestNewClient(t *testing.T) {
@@ -52,7 +54,7 @@ func TestGetEntities_Success(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	devices, err := client.GetEntities()
+	devices, err := client.GetEntities(context.Background())
 
 	require.NoError(t, err)
 	assert.Len(t, devices, 2)
@@ -80,7 +82,7 @@ func TestGetEntities_HTTPError(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	devices, err := client.GetEntities()
+	devices, err := client.GetEntities(context.Background())
 
 	assert.Error(t, err)
 	assert.Nil(t, devices)
@@ -95,7 +97,7 @@ func TestGetEntities_InvalidJSON(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	devices, err := client.GetEntities()
+	devices, err := client.GetEntities(context.Background())
 
 	assert.Error(t, err)
 	assert.Nil(t, devices)
@@ -121,7 +123,7 @@ func TestGetEntity_Success(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	device, err := client.GetEntity("light.living_room")
+	device, err := client.GetEntity(context.Background(), "light.living_room")
 
 	require.NoError(t, err)
 	require.NotNil(t, device)
@@ -141,7 +143,7 @@ func TestGetEntity_NotFound(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	device, err := client.GetEntity("nonexistent.entity")
+	device, err := client.GetEntity(context.Background(), "nonexistent.entity")
 
 	assert.Error(t, err)
 	assert.Nil(t, device)
@@ -155,7 +157,7 @@ func TestGetEntity_HTTPError(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	device, err := client.GetEntity("light.living_room")
+	device, err := client.GetEntity(context.Background(), "light.living_room")
 
 	assert.Error(t, err)
 	assert.Nil(t, device)
@@ -187,7 +189,26 @@ func TestCallService_Success(t *testing.T) {
 		"brightness": 255,
 	}
 
-	err := client.CallService("light", "turn_on", "light.living_room", serviceData)
+	err := client.CallService(context.Background(), "light", "turn_on", "light.living_room", serviceData)
+	assert.NoError(t, err)
+}
+
+func TestCallServiceForEntities_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/services/light/turn_off", r.URL.Path)
+
+		var serviceCall HAServiceCall
+		err := json.NewDecoder(r.Body).Decode(&serviceCall)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"light.living_room", "light.bedroom"}, serviceCall.Target.EntityID)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-token")
+	err := client.CallServiceForEntities(context.Background(), "light", "turn_off", []string{"light.living_room", "light.bedroom"}, nil)
 	assert.NoError(t, err)
 }
 
@@ -201,7 +222,7 @@ func TestCallService_HTTPError(t *testing.T) {
 	client := NewClient(server.URL, "test-token")
 	serviceData := map[string]interface{}{"brightness": 255}
 
-	err := client.CallService("light", "turn_on", "light.living_room", serviceData)
+	err := client.CallService(context.Background(), "light", "turn_on", "light.living_room", serviceData)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "service call failed with status 400")
 	assert.Contains(t, err.Error(), "Bad request")
@@ -218,7 +239,7 @@ func TestTestConnection_Success(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	err := client.TestConnection()
+	err := client.TestConnection(context.Background())
 
 	assert.NoError(t, err)
 }
@@ -230,7 +251,7 @@ func TestTestConnection_HTTPError(t *testing.T) {
 	defer server.Close()
 
 	client := NewClient(server.URL, "test-token")
-	err := client.TestConnection()
+	err := client.TestConnection(context.Background())
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "HomeAssistant API returned status: 401")
@@ -238,15 +259,13 @@ func TestTestConnection_HTTPError(t *testing.T) {
 
 func TestTestConnection_NetworkError(t *testing.T) {
 	client := NewClient("http://invalid:9999", "test-token")
-	err := client.TestConnection()
+	err := client.TestConnection(context.Background())
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to connect to HomeAssistant")
 }
 
 func TestConvertEntityToDevice(t *testing.T) {
-	client := NewClient("http://localhost", "token")
-
 	testCases := []struct {
 		name           string
 		entity         HAEntity
@@ -328,7 +347,7 @@ func TestConvertEntityToDevice(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			device := client.convertEntityToDevice(tc.entity)
+			device := EntityToDevice(tc.entity)
 
 			assert.Equal(t, tc.expectedDevice.ID, device.ID)
 			assert.Equal(t, tc.expectedDevice.Name, device.Name)
@@ -343,8 +362,6 @@ func TestConvertEntityToDevice(t *testing.T) {
 }
 
 func TestDomainToDeviceType(t *testing.T) {
-	client := NewClient("http://localhost", "token")
-
 	testCases := []struct {
 		domain   string
 		expected models.DeviceType
@@ -363,7 +380,7 @@ func TestDomainToDeviceType(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.domain, func(t *testing.T) {
-			result := client.domainToDeviceType(tc.domain)
+			result := domainToDeviceType(tc.domain)
 			assert.Equal(t, tc.expected, result)
 		})
 	}
@@ -397,11 +414,11 @@ func TestClient_RequestHeaders(t *testing.T) {
 	client := NewClient(server.URL, "test-token-123")
 
 	// Test GetEntities headers
-	_, err := client.GetEntities()
+	_, err := client.GetEntities(context.Background())
 	assert.NoError(t, err)
 
 	// Test GetEntity headers
-	_, err = client.GetEntity("test.entity")
+	_, err = client.GetEntity(context.Background(), "test.entity")
 	assert.NoError(t, err)
 }
 
@@ -416,7 +433,7 @@ func TestClient_Timeout(t *testing.T) {
 	client := NewClient(server.URL, "test-token")
 	client.httpClient.Timeout = 50 * time.Millisecond // Set very short timeout
 
-	_, err := client.GetEntities()
+	_, err := client.GetEntities(context.Background())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to make request")
 }
@@ -424,7 +441,255 @@ func TestClient_Timeout(t *testing.T) {
 func TestClient_MalformedURL(t *testing.T) {
 	client := NewClient("not-a-url", "test-token")
 
-	_, err := client.GetEntities()
+	_, err := client.GetEntities(context.Background())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to make request")
 }
+
+func TestGetHistory_Success(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/history/period/2023-01-01T00:00:00Z", r.URL.Path)
+		assert.Equal(t, "cover.garage_door,climate.main", r.URL.Query().Get("filter_entity_id"))
+		assert.Equal(t, "2023-01-02T00:00:00Z", r.URL.Query().Get("end_time"))
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+
+		periods := [][]HAEntity{
+			{
+				{EntityID: "cover.garage_door", State: "closed", LastChanged: "2023-01-01T00:00:00+00:00"},
+				{EntityID: "cover.garage_door", State: "open", LastChanged: "2023-01-01T08:30:00.123456+00:00"},
+			},
+			{
+				{EntityID: "climate.main", State: "heat", LastChanged: "2023-01-01T00:00:00+00:00"},
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(periods)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-token")
+	history, err := client.GetHistory(context.Background(), []string{"cover.garage_door", "climate.main"}, start, end)
+
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Len(t, history["cover.garage_door"], 2)
+	assert.Equal(t, "open", history["cover.garage_door"][1].State)
+	assert.Equal(t, 8, history["cover.garage_door"][1].LastChanged.Hour())
+	assert.Equal(t, "heat", history["climate.main"][0].State)
+}
+
+func TestGetHistory_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-token")
+	history, err := client.GetHistory(context.Background(), []string{"light.kitchen"}, time.Now().Add(-time.Hour), time.Now())
+
+	assert.Error(t, err)
+	assert.Nil(t, history)
+	assert.Contains(t, err.Error(), "API request failed with status: 401")
+}
+
+func TestGetLogbook_Success(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/logbook/2023-01-01T00:00:00Z", r.URL.Path)
+		assert.Equal(t, "cover.garage_door", r.URL.Query().Get("entity"))
+		assert.Equal(t, "2023-01-02T00:00:00Z", r.URL.Query().Get("end_time"))
+
+		entries := []HALogbookEntry{
+			{When: "2023-01-01T08:30:00+00:00", Name: "Garage Door", Message: "was opened", EntityID: "cover.garage_door", State: "open", Domain: "cover"},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-token")
+	logbook, err := client.GetLogbook(context.Background(), "cover.garage_door", start, end)
+
+	require.NoError(t, err)
+	require.Len(t, logbook, 1)
+	assert.Equal(t, "Garage Door", logbook[0].Name)
+	assert.Equal(t, "was opened", logbook[0].Message)
+	assert.Equal(t, "open", logbook[0].State)
+	assert.True(t, logbook[0].When.Equal(time.Date(2023, 1, 1, 8, 30, 0, 0, time.UTC)))
+}
+
+// quickOptions retries without waiting, to keep tests fast
+func quickOptions() Options {
+	opts := DefaultOptions()
+	opts.Retry = resilience.RetryPolicy{Attempts: 3}
+	return opts
+}
+
+func TestNewClientWithOptions(t *testing.T) {
+	opts := quickOptions()
+	opts.Timeout = 5 * time.Second
+	client := NewClientWithOptions("http://localhost:8123", "test-token", opts)
+
+	assert.Equal(t, 5*time.Second, client.httpClient.Timeout)
+	stats := client.UpstreamStats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "homeassistant", stats[0].Name)
+	assert.Equal(t, resilience.BreakerClosed, stats[0].State)
+}
+
+func TestGetEntities_RetriesServerErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode([]HAEntity{{EntityID: "light.kitchen", State: "on"}})
+	}))
+	defer server.Close()
+
+	client := NewClientWithOptions(server.URL, "test-token", quickOptions())
+	devices, err := client.GetEntities(context.Background())
+
+	require.NoError(t, err)
+	assert.Len(t, devices, 1)
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, int64(2), client.UpstreamStats()[0].Retries)
+}
+
+func TestGetEntity_NotFoundIsNotRetried(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClientWithOptions(server.URL, "test-token", quickOptions())
+	_, err := client.GetEntity(context.Background(), "light.missing")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "entity not found")
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 0, client.UpstreamStats()[0].ConsecutiveFailures)
+}
+
+func TestCallService_NotRetriedByDefault(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClientWithOptions(server.URL, "test-token", quickOptions())
+	err := client.CallService(context.Background(), "light", "turn_on", "light.kitchen", nil)
+
+	require.Error(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestCallService_RetriesUndeliveredCallsWhenEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  string
+		status   int
+		requests int
+	}{
+		{"proxy couldn't reach HomeAssistant", "turn_on", http.StatusServiceUnavailable, 3},
+		{"HomeAssistant failed the call", "turn_on", http.StatusInternalServerError, 1},
+		{"toggles are never repeated", "toggle", http.StatusServiceUnavailable, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			opts := quickOptions()
+			opts.RetryServiceCalls = true
+			client := NewClientWithOptions(server.URL, "test-token", opts)
+			err := client.CallService(context.Background(), "light", tt.service, "light.kitchen", nil)
+
+			require.Error(t, err)
+			assert.Equal(t, tt.requests, requests)
+		})
+	}
+}
+
+func TestCallService_RetriesRefusedConnectionsWhenEnabled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	opts := quickOptions()
+	opts.RetryServiceCalls = true
+	client := NewClientWithOptions(url, "test-token", opts)
+	err := client.CallService(context.Background(), "light", "turn_on", "light.kitchen", nil)
+
+	require.Error(t, err)
+	assert.Equal(t, int64(2), client.UpstreamStats()[0].Retries)
+}
+
+func TestClient_BreakerFailsFastWhileOpen(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	opts := quickOptions()
+	opts.BreakerThreshold = 2
+	opts.BreakerCooldown = time.Minute
+	client := NewClientWithOptions(server.URL, "test-token", opts)
+
+	_, err := client.GetEntities(context.Background())
+	assert.ErrorIs(t, err, resilience.ErrOpen)
+	assert.Equal(t, 2, requests)
+
+	err = client.TestConnection(context.Background())
+	assert.ErrorIs(t, err, resilience.ErrOpen)
+	assert.Equal(t, 2, requests)
+
+	stats := client.UpstreamStats()[0]
+	assert.Equal(t, resilience.BreakerOpen, stats.State)
+	assert.Equal(t, int64(1), stats.Trips)
+}
+
+func TestClient_StopsWhenContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClientWithOptions(server.URL, "test-token", quickOptions())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := client.GetEntities(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), 5*time.Second)
+	stats := client.UpstreamStats()[0]
+	assert.Zero(t, stats.Retries)
+	assert.Zero(t, stats.ConsecutiveFailures, "the caller giving up says nothing about HomeAssistant")
+}
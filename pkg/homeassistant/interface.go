@@ -1,11 +1,20 @@
 package homeassistant
 
-import "github.com/tienpdinh/gpt-home/pkg/models"
+import (
+	"context"
+	"time"
 
-// ClientInterface defines the interface for HomeAssistant clients
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+// ClientInterface defines the interface for HomeAssistant clients. Calls give
+// up when their context is done.
 type ClientInterface interface {
-	GetEntities() ([]models.Device, error)
-	GetEntity(entityID string) (*models.Device, error)
-	CallService(domain, service, entityID string, serviceData map[string]interface{}) error
-	TestConnection() error
+	GetEntities(ctx context.Context) ([]models.Device, error)
+	GetEntity(ctx context.Context, entityID string) (*models.Device, error)
+	CallService(ctx context.Context, domain, service, entityID string, serviceData map[string]interface{}) error
+	CallServiceForEntities(ctx context.Context, domain, service string, entityIDs []string, serviceData map[string]interface{}) error
+	TestConnection(ctx context.Context) error
+	GetHistory(ctx context.Context, entityIDs []string, start, end time.Time) (map[string][]models.StateChange, error)
+	GetLogbook(ctx context.Context, entityID string, start, end time.Time) ([]models.LogbookEntry, error)
 }
@@ -15,8 +15,15 @@ type Device struct {
 	State       string         `json:"state"`
 	Attributes  map[string]any `json:"attributes"`
 	LastUpdated time.Time      `json:"last_updated"`
+	LastChanged time.Time      `json:"last_changed"`
 	Domain      string         `json:"domain"`
 	EntityID    string         `json:"entity_id"`
+	Area        string         `json:"area,omitempty"`
+	// Instance is the Home Assistant server the device belongs to, when there
+	// are several
+	Instance string `json:"instance,omitempty"`
+	// Capabilities is nil when what the device can do isn't known
+	Capabilities *DeviceCapabilities `json:"capabilities,omitempty"`
 }
 
 // DeviceType represents the type of device
@@ -32,21 +39,187 @@ const (
 	DeviceTypeMedia   DeviceType = "media_player"
 )
 
+// Feature bits of Home Assistant's supported_features attribute. Their meaning
+// depends on the device's domain.
+const (
+	// Lights that predate color modes report these instead
+	LightSupportBrightness = 1
+	LightSupportColorTemp  = 2
+	LightSupportColor      = 16
+
+	ClimateSupportTargetTemperature = 1
+	ClimateSupportFanMode           = 8
+	ClimateSupportPresetMode        = 16
+
+	CoverSupportOpen        = 1
+	CoverSupportClose       = 2
+	CoverSupportSetPosition = 4
+
+	FanSupportSetSpeed   = 1
+	FanSupportPresetMode = 8
+)
+
+// DeviceCapabilities is what a device can do, read from its Home Assistant
+// attributes. Ranges and mode lists are empty when the device doesn't report them.
+type DeviceCapabilities struct {
+	SupportedFeatures  int      `json:"supported_features"`
+	ColorModes         []string `json:"color_modes,omitempty"`
+	MinColorTempKelvin int      `json:"min_color_temp_kelvin,omitempty"`
+	MaxColorTempKelvin int      `json:"max_color_temp_kelvin,omitempty"`
+	HVACModes          []string `json:"hvac_modes,omitempty"`
+	FanModes           []string `json:"fan_modes,omitempty"`
+	PresetModes        []string `json:"preset_modes,omitempty"`
+	MinTemp            float64  `json:"min_temp,omitempty"`
+	MaxTemp            float64  `json:"max_temp,omitempty"`
+}
+
+// Supports reports whether feature is set in supported_features
+func (c *DeviceCapabilities) Supports(feature int) bool {
+	return c.SupportedFeatures&feature != 0
+}
+
+// SupportsBrightness reports whether a light can be dimmed
+func (c *DeviceCapabilities) SupportsBrightness() bool {
+	if len(c.ColorModes) == 0 {
+		return c.Supports(LightSupportBrightness)
+	}
+	for _, mode := range c.ColorModes {
+		if mode != "onoff" {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsColorTemp reports whether a light's white can be made warmer or cooler
+func (c *DeviceCapabilities) SupportsColorTemp() bool {
+	if len(c.ColorModes) == 0 {
+		return c.Supports(LightSupportColorTemp)
+	}
+	return c.hasColorMode("color_temp")
+}
+
+// SupportsColor reports whether a light can be set to a color
+func (c *DeviceCapabilities) SupportsColor() bool {
+	if len(c.ColorModes) == 0 {
+		return c.Supports(LightSupportColor)
+	}
+	return c.hasColorMode("hs", "xy", "rgb", "rgbw", "rgbww")
+}
+
+func (c *DeviceCapabilities) hasColorMode(modes ...string) bool {
+	for _, have := range c.ColorModes {
+		for _, want := range modes {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // DeviceAction represents an action to perform on a device
 type DeviceAction struct {
 	Action     string         `json:"action"`
 	Parameters map[string]any `json:"parameters,omitempty"`
 }
 
+// BulkActionItem applies one action to several target devices
+type BulkActionItem struct {
+	Targets []string     `json:"targets" binding:"required"`
+	Action  DeviceAction `json:"action" binding:"required"`
+}
+
+// BulkActionRequest represents a request to control many devices at once
+type BulkActionRequest struct {
+	Items []BulkActionItem `json:"items" binding:"required"`
+}
+
+// ActionStatus represents the outcome of an action on a single target
+type ActionStatus string
+
+const (
+	ActionStatusSuccess         ActionStatus = "success"
+	ActionStatusWarning         ActionStatus = "warning"
+	ActionStatusValidationError ActionStatus = "validation_error"
+	ActionStatusHAError         ActionStatus = "ha_error"
+	ActionStatusForbidden       ActionStatus = "forbidden"
+	ActionStatusSkipped         ActionStatus = "skipped"
+)
+
+// TargetResult reports what happened when an action was applied to one device
+type TargetResult struct {
+	Target  string       `json:"target"`
+	Action  string       `json:"action"`
+	Status  ActionStatus `json:"status"`
+	Error   string       `json:"error,omitempty"`
+	Warning string       `json:"warning,omitempty"`
+}
+
+// StateChange represents one recorded state of an entity in Home Assistant history
+type StateChange struct {
+	EntityID    string         `json:"entity_id"`
+	State       string         `json:"state"`
+	Attributes  map[string]any `json:"attributes,omitempty"`
+	LastChanged time.Time      `json:"last_changed"`
+}
+
+// LogbookEntry represents a human-readable event from the Home Assistant logbook
+type LogbookEntry struct {
+	When     time.Time `json:"when"`
+	Name     string    `json:"name"`
+	Message  string    `json:"message,omitempty"`
+	EntityID string    `json:"entity_id,omitempty"`
+	State    string    `json:"state,omitempty"`
+	Domain   string    `json:"domain,omitempty"`
+}
+
+// DeviceStateEvent is published when a device's state or attributes change
+type DeviceStateEvent struct {
+	DeviceID      string         `json:"device_id"`
+	Name          string         `json:"name"`
+	PreviousState string         `json:"previous_state"`
+	State         string         `json:"state"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	LastChanged   time.Time      `json:"last_changed"`
+}
+
+// ConversationMessageEvent is published when a message is added to a conversation
+type ConversationMessageEvent struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id,omitempty"` // the conversation's owner
+	Message        Message   `json:"message"`
+}
+
+// User is a member of the household with their own conversations and preferences
+type User struct {
+	ID          uuid.UUID         `json:"id"`
+	Username    string            `json:"username"`
+	Admin       bool              `json:"admin"` // may add other users
+	Preferences map[string]string `json:"preferences"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
 // Conversation represents a chat conversation
 type Conversation struct {
 	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id,omitempty"` // owner, nil when accounts are disabled
 	Messages  []Message `json:"messages"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Context   Context   `json:"context"`
 }
 
+// ConversationSummary describes a conversation without its messages
+type ConversationSummary struct {
+	ID                uuid.UUID `json:"id"`
+	Title             string    `json:"title"`
+	MessageCount      int       `json:"message_count"`
+	CreatedAt         time.Time `json:"created_at"`
+	LastMessageAt     time.Time `json:"last_message_at"`
+	ReferencedDevices []string  `json:"referenced_devices"`
+}
+
 // Message represents a single message in a conversation
 type Message struct {
 	ID        uuid.UUID   `json:"id"`
@@ -71,15 +244,30 @@ type Context struct {
 	LastAction        *DeviceAction     `json:"last_action,omitempty"`
 	UserPreferences   map[string]string `json:"user_preferences"`
 	SessionData       map[string]any    `json:"session_data"`
+	// Summary condenses the first SummarizedMessages messages, which no longer
+	// fit in the prompt
+	Summary            string `json:"summary,omitempty"`
+	SummarizedMessages int    `json:"summarized_messages,omitempty"`
 }
 
 // Metadata represents additional message metadata
 type Metadata struct {
-	DevicesReferenced []string `json:"devices_referenced,omitempty"`
-	ActionsPerformed  []string `json:"actions_performed,omitempty"`
-	ProcessingTime    float64  `json:"processing_time,omitempty"`
-	ModelUsed         string   `json:"model_used,omitempty"`
-	Confidence        float64  `json:"confidence,omitempty"`
+	DevicesReferenced []string    `json:"devices_referenced,omitempty"`
+	ActionsPerformed  []string    `json:"actions_performed,omitempty"`
+	DevicesFailed     []string    `json:"devices_failed,omitempty"` // action targets that failed or were refused
+	ProcessingTime    float64     `json:"processing_time,omitempty"`
+	ModelUsed         string      `json:"model_used,omitempty"`
+	Confidence        float64     `json:"confidence,omitempty"`
+	TokenUsage        *TokenUsage `json:"token_usage,omitempty"`
+}
+
+// TokenUsage records how much of the model's context window a reply used
+type TokenUsage struct {
+	PromptTokens     int      `json:"prompt_tokens"`
+	CompletionTokens int      `json:"completion_tokens"`
+	ContextWindow    int      `json:"context_window"`
+	Estimated        bool     `json:"estimated,omitempty"` // prompt tokens were counted approximately
+	Truncated        []string `json:"truncated,omitempty"` // prompt sections cut to fit
 }
 
 // ChatRequest represents an incoming chat request
@@ -87,6 +275,11 @@ type ChatRequest struct {
 	Message        string    `json:"message" binding:"required"`
 	ConversationID uuid.UUID `json:"conversation_id,omitempty"`
 	Context        *Context  `json:"context,omitempty"`
+	// Speak asks for the reply to be spoken too, in the response's audio
+	Speak bool `json:"speak,omitempty"`
+	// UserID is the signed-in user sending the message, taken from their session
+	// and never from the request body
+	UserID uuid.UUID `json:"-"`
 }
 
 // ChatResponse represents a chat response
@@ -97,6 +290,21 @@ type ChatResponse struct {
 	Context          Context        `json:"context"`
 	ActionsPerformed []DeviceAction `json:"actions_performed,omitempty"`
 	Metadata         Metadata       `json:"metadata"`
+	Audio            *ReplyAudio    `json:"audio,omitempty"`
+}
+
+// ReplyAudio is a reply spoken aloud. Data is base64 in JSON; URL fetches the
+// same audio again later.
+type ReplyAudio struct {
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+	URL         string `json:"url"`
+}
+
+// AudioChatResponse is the reply to a spoken message, with what was heard
+type AudioChatResponse struct {
+	ChatResponse
+	Transcript string `json:"transcript"`
 }
 
 // HealthStatus represents system health
@@ -114,13 +322,17 @@ type Services struct {
 	LLM           ServiceStatus `json:"llm"`
 	HomeAssistant ServiceStatus `json:"home_assistant"`
 	Database      ServiceStatus `json:"database"`
+	// HomeAssistantInstances reports each server when there are several
+	HomeAssistantInstances []ServiceStatus `json:"home_assistant_instances,omitempty"`
 }
 
 // ServiceStatus represents the status of a service
 type ServiceStatus struct {
+	Name        string    `json:"name,omitempty"` // set for one of several instances
 	Status      string    `json:"status"`
 	LastChecked time.Time `json:"last_checked"`
 	Message     string    `json:"message,omitempty"`
+	Breaker     string    `json:"breaker,omitempty"` // circuit breaker state: closed, open or half_open
 }
 
 // LLMConfig represents LLM configuration for Ollama
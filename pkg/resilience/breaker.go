@@ -0,0 +1,141 @@
+package resilience
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is how many failures in a row open a breaker
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long an open breaker fails fast before trying again
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// ErrOpen is returned without calling the upstream while its breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// BreakerState is where a circuit breaker is in its cycle
+type BreakerState string
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails every call fast until the cooldown is over
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets one trial call through to see if the upstream recovered
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// Breaker stops calling an upstream that keeps failing. After threshold
+// failures in a row it opens and fails calls fast; once the cooldown is over it
+// lets one trial call through, closing again if it succeeds.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	trips    int64
+}
+
+// NewBreaker creates a closed breaker. A threshold or cooldown of 0 or less uses
+// the default.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be followed
+// by Success or Failure.
+func (b *Breaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		remaining := b.cooldown - b.now().Sub(b.openedAt)
+		if remaining > 0 {
+			return fmt.Errorf("%w, retrying in %s", ErrOpen, remaining.Round(time.Second))
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return fmt.Errorf("%w, waiting for a trial call", ErrOpen)
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Success records a call that reached a healthy upstream
+func (b *Breaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.state = BreakerClosed
+}
+
+// Failure records a call that failed because of the upstream
+func (b *Breaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.trips++
+	}
+}
+
+// Cancel records an allowed call the caller abandoned, which says nothing about
+// the upstream
+func (b *Breaker) Cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// State returns the breaker's state. An open breaker whose cooldown is over is
+// reported as half open, since the next call will be let through.
+func (b *Breaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// snapshot returns the state, consecutive failures and trips together
+func (b *Breaker) snapshot() (BreakerState, int, int64) {
+	state := b.State()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return state, b.failures, b.trips
+}
@@ -0,0 +1,108 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock tests move by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*Breaker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	breaker := NewBreaker(threshold, cooldown)
+	breaker.now = clock.Now
+	return breaker, clock
+}
+
+func TestNewBreaker_Defaults(t *testing.T) {
+	breaker := NewBreaker(0, 0)
+
+	assert.Equal(t, DefaultBreakerThreshold, breaker.threshold)
+	assert.Equal(t, DefaultBreakerCooldown, breaker.cooldown)
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	breaker, _ := newTestBreaker(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, breaker.Allow())
+		breaker.Failure()
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	require.NoError(t, breaker.Allow())
+	breaker.Failure()
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	err := breaker.Allow()
+	assert.ErrorIs(t, err, ErrOpen)
+	assert.Contains(t, err.Error(), "retrying in 1m0s")
+}
+
+func TestBreaker_SuccessResetsFailures(t *testing.T) {
+	breaker, _ := newTestBreaker(2, time.Minute)
+
+	require.NoError(t, breaker.Allow())
+	breaker.Failure()
+	require.NoError(t, breaker.Allow())
+	breaker.Success()
+	require.NoError(t, breaker.Allow())
+	breaker.Failure()
+
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreaker_HalfOpenLetsOneTrialThrough(t *testing.T) {
+	breaker, clock := newTestBreaker(1, time.Minute)
+
+	require.NoError(t, breaker.Allow())
+	breaker.Failure()
+	clock.now = clock.now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+
+	require.NoError(t, breaker.Allow())
+	assert.ErrorIs(t, breaker.Allow(), ErrOpen, "only one trial call at a time")
+
+	breaker.Success()
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.NoError(t, breaker.Allow())
+}
+
+func TestBreaker_FailedTrialReopens(t *testing.T) {
+	breaker, clock := newTestBreaker(1, time.Minute)
+
+	require.NoError(t, breaker.Allow())
+	breaker.Failure()
+	clock.now = clock.now.Add(time.Minute)
+
+	require.NoError(t, breaker.Allow())
+	breaker.Failure()
+
+	assert.Equal(t, BreakerOpen, breaker.State())
+	state, _, trips := breaker.snapshot()
+	assert.Equal(t, BreakerOpen, state)
+	assert.Equal(t, int64(2), trips)
+}
+
+func TestBreaker_CancelledTrialFreesTheSlot(t *testing.T) {
+	breaker, clock := newTestBreaker(1, time.Minute)
+
+	require.NoError(t, breaker.Allow())
+	breaker.Failure()
+	clock.now = clock.now.Add(time.Minute)
+
+	require.NoError(t, breaker.Allow())
+	breaker.Cancel()
+
+	assert.NoError(t, breaker.Allow())
+	assert.ErrorIs(t, breaker.Allow(), ErrOpen)
+}
@@ -0,0 +1,159 @@
+// Package resilience guards calls to remote services with retries and circuit
+// breakers, so transient failures are smoothed over and a service that is down
+// fails fast instead of holding up every request.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy says how often and how patiently a failed call is retried
+type RetryPolicy struct {
+	Attempts  int           // total tries; 1 or less disables retries
+	BaseDelay time.Duration // delay before the first retry, doubled for each after it
+	MaxDelay  time.Duration // longest delay between tries
+	// RetryIf limits retries to the failures it accepts; nil retries every failure
+	RetryIf func(error) bool
+}
+
+// DefaultRetryPolicy suits idempotent reads
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 250 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// NoRetry tries a call once
+var NoRetry = RetryPolicy{Attempts: 1}
+
+// delay is the pause before retry number n (from 1): a random duration of up to
+// the exponential backoff, so clients that failed together don't retry together
+func (p RetryPolicy) delay(n int) time.Duration {
+	backoff := p.BaseDelay << (n - 1)
+	if backoff <= 0 || (p.MaxDelay > 0 && backoff > p.MaxDelay) {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff))) + 1
+}
+
+// permanentError wraps an error that retrying can't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error from an upstream that answered but rejected the
+// request, such as a 404. It is returned as is, never retried, and doesn't count
+// against the upstream's breaker.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// UpstreamStats describes an upstream's breaker and how often calls to it were retried
+type UpstreamStats struct {
+	Name                string       `json:"name"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Trips               int64        `json:"trips"`    // times the breaker opened
+	Rejected            int64        `json:"rejected"` // calls failed fast while open
+	Retries             int64        `json:"retries"`
+}
+
+// Reporter is implemented by clients that call upstreams through an Upstream
+type Reporter interface {
+	UpstreamStats() []UpstreamStats
+}
+
+// Upstream guards the calls to one remote service with a circuit breaker and retries
+type Upstream struct {
+	name     string
+	breaker  *Breaker
+	retries  atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewUpstream creates an upstream whose breaker opens after threshold failures
+// in a row and stays open for cooldown. Values of 0 or less use the defaults.
+func NewUpstream(name string, threshold int, cooldown time.Duration) *Upstream {
+	return &Upstream{name: name, breaker: NewBreaker(threshold, cooldown)}
+}
+
+// Call runs fn, retrying failures as policy allows. Errors marked Permanent are
+// returned straight away, and while the breaker is open calls fail fast with
+// ErrOpen. Once ctx is done no more tries are made, and a try that failed
+// because of it doesn't count against the upstream. The last error is returned
+// with any Permanent mark removed.
+func (u *Upstream) Call(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = u.try(ctx, fn); err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if errors.Is(err, ErrOpen) || ctx.Err() != nil || attempt >= policy.Attempts || (policy.RetryIf != nil && !policy.RetryIf(err)) {
+			return err
+		}
+
+		u.retries.Add(1)
+		timer := time.NewTimer(policy.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (u *Upstream) try(ctx context.Context, fn func() error) error {
+	if err := u.breaker.Allow(); err != nil {
+		u.rejected.Add(1)
+		return err
+	}
+
+	err := fn()
+	switch {
+	case err == nil || IsPermanent(err):
+		u.breaker.Success()
+	case ctx.Err() != nil || errors.Is(err, context.Canceled):
+		u.breaker.Cancel()
+	default:
+		u.breaker.Failure()
+	}
+	return err
+}
+
+// Stats returns the upstream's breaker state and counters
+func (u *Upstream) Stats() UpstreamStats {
+	state, failures, trips := u.breaker.snapshot()
+	return UpstreamStats{
+		Name:                u.name,
+		State:               state,
+		ConsecutiveFailures: failures,
+		Trips:               trips,
+		Rejected:            u.rejected.Load(),
+		Retries:             u.retries.Load(),
+	}
+}
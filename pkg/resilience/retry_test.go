@@ -0,0 +1,178 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errFlaky = errors.New("connection refused")
+
+// quickRetry retries without waiting, to keep tests fast
+var quickRetry = RetryPolicy{Attempts: 3}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+
+	for i := 0; i < 50; i++ {
+		assert.LessOrEqual(t, policy.delay(1), 100*time.Millisecond)
+		assert.LessOrEqual(t, policy.delay(2), 200*time.Millisecond)
+		assert.LessOrEqual(t, policy.delay(5), 300*time.Millisecond)
+		assert.Positive(t, policy.delay(5))
+	}
+	assert.Zero(t, RetryPolicy{}.delay(1))
+}
+
+func TestUpstream_RetriesUntilSuccess(t *testing.T) {
+	upstream := NewUpstream("test", 10, time.Minute)
+
+	calls := 0
+	err := upstream.Call(context.Background(), quickRetry, func() error {
+		calls++
+		if calls < 3 {
+			return errFlaky
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	stats := upstream.Stats()
+	assert.Equal(t, int64(2), stats.Retries)
+	assert.Equal(t, 0, stats.ConsecutiveFailures)
+	assert.Equal(t, BreakerClosed, stats.State)
+}
+
+func TestUpstream_GivesUpAfterAttempts(t *testing.T) {
+	upstream := NewUpstream("test", 10, time.Minute)
+
+	calls := 0
+	err := upstream.Call(context.Background(), quickRetry, func() error {
+		calls++
+		return errFlaky
+	})
+
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 3, upstream.Stats().ConsecutiveFailures)
+}
+
+func TestUpstream_PermanentErrorsAreNotRetried(t *testing.T) {
+	upstream := NewUpstream("test", 1, time.Minute)
+	notFound := errors.New("entity not found")
+
+	calls := 0
+	err := upstream.Call(context.Background(), quickRetry, func() error {
+		calls++
+		return Permanent(notFound)
+	})
+
+	assert.Equal(t, notFound, err, "the Permanent mark is removed")
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, BreakerClosed, upstream.Stats().State, "a rejected request doesn't count against the upstream")
+}
+
+func TestUpstream_RetryIf(t *testing.T) {
+	upstream := NewUpstream("test", 10, time.Minute)
+	policy := quickRetry
+	policy.RetryIf = func(err error) bool { return !errors.Is(err, context.DeadlineExceeded) }
+
+	calls := 0
+	err := upstream.Call(context.Background(), policy, func() error {
+		calls++
+		return context.DeadlineExceeded
+	})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, calls)
+}
+
+func TestUpstream_FailsFastWhileOpen(t *testing.T) {
+	upstream := NewUpstream("test", 2, time.Minute)
+
+	calls := 0
+	err := upstream.Call(context.Background(), quickRetry, func() error {
+		calls++
+		return errFlaky
+	})
+
+	assert.ErrorIs(t, err, ErrOpen, "the breaker opened before the last attempt")
+	assert.Equal(t, 2, calls)
+
+	err = upstream.Call(context.Background(), quickRetry, func() error {
+		calls++
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrOpen)
+	assert.Equal(t, 2, calls)
+
+	stats := upstream.Stats()
+	assert.Equal(t, "test", stats.Name)
+	assert.Equal(t, BreakerOpen, stats.State)
+	assert.Equal(t, int64(1), stats.Trips)
+	assert.Equal(t, int64(2), stats.Rejected)
+}
+
+func TestUpstream_StopsRetryingWhenCancelled(t *testing.T) {
+	upstream := NewUpstream("test", 10, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{Attempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	calls := 0
+	err := upstream.Call(ctx, policy, func() error {
+		calls++
+		cancel()
+		return errFlaky
+	})
+
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 1, calls)
+}
+
+func TestUpstream_CancelledCallsDontCount(t *testing.T) {
+	upstream := NewUpstream("test", 1, time.Minute)
+
+	err := upstream.Call(context.Background(), NoRetry, func() error {
+		return context.Canceled
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, BreakerClosed, upstream.Stats().State)
+}
+
+func TestUpstream_DoneContext(t *testing.T) {
+	upstream := NewUpstream("test", 1, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := upstream.Call(ctx, quickRetry, func() error {
+		calls++
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, calls)
+}
+
+func TestUpstream_CallerDeadlineDoesntCount(t *testing.T) {
+	upstream := NewUpstream("test", 1, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	err := upstream.Call(ctx, quickRetry, func() error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, BreakerClosed, upstream.Stats().State)
+}
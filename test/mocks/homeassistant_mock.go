@@ -1,115 +1,604 @@
 package mocks
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/tienpdinh/gpt-home/pkg/homeassistant"
 	"github.com/tienpdinh/gpt-home/pkg/models"
 )
 
+// ErrEntityNotFound is returned for entities the mock doesn't have
+var ErrEntityNotFound = errors.New("entity not found")
+
 // MockHomeAssistantClient is a mock implementation of the HomeAssistant client
 type MockHomeAssistantClient struct {
 	entities        []models.Device
+	history         map[string][]models.StateChange
+	logbook         []models.LogbookEntry
+	serviceCalls    []MockServiceCall
 	connectionError bool
 	serviceError    bool
+	transition      time.Duration          // how long covers take to move
+	moving          map[string]*time.Timer // covers on their way, by entity ID
+	listeners       map[int]func(MockStateChange)
+	nextListener    int
+	mutex           sync.Mutex
+}
+
+// MockStateChange is an entity's state before and after it changed. Old is nil
+// for a new entity.
+type MockStateChange struct {
+	EntityID string
+	Old      *models.Device
+	New      *models.Device
+}
+
+// MockServiceCall records a service call made against the mock client
+type MockServiceCall struct {
+	Domain      string
+	Service     string
+	EntityIDs   []string
+	ServiceData map[string]interface{}
 }
 
 // NewMockHomeAssistantClient creates a new mock HomeAssistant client
 func NewMockHomeAssistantClient() *MockHomeAssistantClient {
+	now := time.Now()
 	return &MockHomeAssistantClient{
 		entities:        createMockEntities(),
+		history:         createMockHistory(now),
+		logbook:         createMockLogbook(now),
 		connectionError: false,
 		serviceError:    false,
+		moving:          make(map[string]*time.Timer),
+		listeners:       make(map[int]func(MockStateChange)),
 	}
 }
 
+// NewMockHomeAssistantClientForHouse creates a mock client holding a house's
+// entities, each with its current state as its only history
+func NewMockHomeAssistantClientForHouse(house *House) *MockHomeAssistantClient {
+	m := NewMockHomeAssistantClient()
+	m.entities = house.Devices()
+	m.history = make(map[string][]models.StateChange)
+	m.logbook = nil
+	m.transition = house.Transition
+	for _, entity := range m.entities {
+		m.addHistory(entity.ID, models.StateChange{State: entity.State, Attributes: entity.Attributes, LastChanged: entity.LastChanged})
+	}
+	return m
+}
+
 // SetConnectionError simulates connection failures
 func (m *MockHomeAssistantClient) SetConnectionError(enabled bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.connectionError = enabled
 }
 
 // SetServiceError simulates service call failures
 func (m *MockHomeAssistantClient) SetServiceError(enabled bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.serviceError = enabled
 }
 
+// SetTransitionTime makes covers take this long to open and close, passing
+// through opening and closing. Without it they move at once.
+func (m *MockHomeAssistantClient) SetTransitionTime(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.transition = d
+}
+
+// OnStateChange calls fn after each change to an entity's state or attributes,
+// until the returned function is called
+func (m *MockHomeAssistantClient) OnStateChange(fn func(MockStateChange)) func() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	id := m.nextListener
+	m.nextListener++
+	m.listeners[id] = fn
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.listeners, id)
+	}
+}
+
+// notify tells listeners about changes. It is called without the lock held, so
+// listeners may use the client.
+func (m *MockHomeAssistantClient) notify(changes []MockStateChange) {
+	if len(changes) == 0 {
+		return
+	}
+	m.mutex.Lock()
+	listeners := make([]func(MockStateChange), 0, len(m.listeners))
+	for _, listener := range m.listeners {
+		listeners = append(listeners, listener)
+	}
+	m.mutex.Unlock()
+
+	for _, change := range changes {
+		for _, listener := range listeners {
+			listener(change)
+		}
+	}
+}
+
 // GetEntities returns mock device entities
-func (m *MockHomeAssistantClient) GetEntities() ([]models.Device, error) {
+func (m *MockHomeAssistantClient) GetEntities(ctx context.Context) ([]models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	if m.connectionError {
 		return nil, fmt.Errorf("connection error: unable to connect to HomeAssistant")
 	}
-	return m.entities, nil
+
+	// Copy attributes too, like a real client decoding a fresh response each time
+	entities := make([]models.Device, len(m.entities))
+	for i, entity := range m.entities {
+		entities[i] = copyEntity(entity)
+	}
+	return entities, nil
 }
 
 // GetEntity returns a specific mock entity
-func (m *MockHomeAssistantClient) GetEntity(entityID string) (*models.Device, error) {
+func (m *MockHomeAssistantClient) GetEntity(ctx context.Context, entityID string) (*models.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	if m.connectionError {
 		return nil, fmt.Errorf("connection error: unable to connect to HomeAssistant")
 	}
 
 	for _, entity := range m.entities {
 		if entity.ID == entityID {
+			entity = copyEntity(entity)
 			return &entity, nil
 		}
 	}
-	return nil, fmt.Errorf("entity not found: %s", entityID)
+	return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, entityID)
+}
+
+func copyEntity(entity models.Device) models.Device {
+	if entity.Attributes != nil {
+		attributes := make(map[string]any, len(entity.Attributes))
+		for key, value := range entity.Attributes {
+			attributes[key] = value
+		}
+		entity.Attributes = attributes
+	}
+	return entity
 }
 
 // CallService simulates service calls
-func (m *MockHomeAssistantClient) CallService(domain, service, entityID string, serviceData map[string]interface{}) error {
+func (m *MockHomeAssistantClient) CallService(ctx context.Context, domain, service, entityID string, serviceData map[string]interface{}) error {
+	return m.CallServiceForEntities(ctx, domain, service, []string{entityID}, serviceData)
+}
+
+// CallServiceForEntities simulates a service call targeting several entities at
+// once. Like a real client it makes no call once ctx is done.
+func (m *MockHomeAssistantClient) CallServiceForEntities(ctx context.Context, domain, service string, entityIDs []string, serviceData map[string]interface{}) error {
+	_, err := m.CallServiceForChanges(ctx, domain, service, entityIDs, serviceData)
+	return err
+}
+
+// CallServiceForChanges makes a service call like CallServiceForEntities and
+// returns the changes it made, as HomeAssistant's REST API does
+func (m *MockHomeAssistantClient) CallServiceForChanges(ctx context.Context, domain, service string, entityIDs []string, serviceData map[string]interface{}) ([]MockStateChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	changes, err := m.callService(domain, service, entityIDs, serviceData)
+	if err != nil {
+		return nil, err
+	}
+	m.notify(changes)
+	return changes, nil
+}
+
+func (m *MockHomeAssistantClient) callService(domain, service string, entityIDs []string, serviceData map[string]interface{}) ([]MockStateChange, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	if m.connectionError {
-		return fmt.Errorf("connection error: unable to connect to HomeAssistant")
+		return nil, fmt.Errorf("connection error: unable to connect to HomeAssistant")
 	}
 	if m.serviceError {
-		return fmt.Errorf("service error: failed to call %s.%s", domain, service)
+		return nil, fmt.Errorf("service error: failed to call %s.%s", domain, service)
+	}
+
+	m.serviceCalls = append(m.serviceCalls, MockServiceCall{
+		Domain:      domain,
+		Service:     service,
+		EntityIDs:   append([]string(nil), entityIDs...),
+		ServiceData: serviceData,
+	})
+
+	var changes []MockStateChange
+	for _, entityID := range entityIDs {
+		if change, ok := m.applyService(service, entityID, serviceData); ok {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+// ServiceCalls returns the service calls made so far
+func (m *MockHomeAssistantClient) ServiceCalls() []MockServiceCall {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]MockServiceCall(nil), m.serviceCalls...)
+}
+
+// applyService updates an entity the way HomeAssistant would for a service
+// call, reporting the change if the entity exists
+func (m *MockHomeAssistantClient) applyService(service, entityID string, serviceData map[string]interface{}) (MockStateChange, bool) {
+	i := m.indexOf(entityID)
+	if i < 0 {
+		return MockStateChange{}, false
+	}
+	old := copyEntity(m.entities[i])
+	entity := &m.entities[i]
+	if entity.Attributes == nil {
+		entity.Attributes = make(map[string]any)
+	}
+	setAttributes := func(keys ...string) {
+		for _, key := range keys {
+			if value, ok := serviceData[key]; ok {
+				entity.Attributes[key] = value
+			}
+		}
+	}
+
+	switch service {
+	case "turn_on":
+		entity.State = "on"
+		setAttributes("rgb_color", "color_temp_kelvin", "effect", "percentage", "preset_mode")
+		switch {
+		case serviceData["brightness"] != nil:
+			setAttributes("brightness")
+		case serviceData["brightness_pct"] != nil:
+			if pct, ok := number(serviceData["brightness_pct"]); ok {
+				entity.Attributes["brightness"] = int(pct * 255 / 100)
+			}
+		case entity.Domain == "light" && isZero(entity.Attributes["brightness"]):
+			entity.Attributes["brightness"] = 255
+		}
+	case "turn_off":
+		entity.State = "off"
+		if entity.Domain == "light" {
+			entity.Attributes["brightness"] = 0
+		}
+		if entity.Domain == "fan" {
+			entity.Attributes["percentage"] = 0
+		}
+	case "toggle":
+		next := "turn_on"
+		if entity.State == "on" {
+			next = "turn_off"
+		}
+		return m.applyService(next, entityID, serviceData)
+	case "set_brightness":
+		setAttributes("brightness")
+	case "set_temperature":
+		setAttributes("temperature", "target_temp_low", "target_temp_high")
+		if mode, ok := serviceData["hvac_mode"].(string); ok {
+			entity.State = mode
+			entity.Attributes["hvac_mode"] = mode
+		}
+	case "set_hvac_mode":
+		if mode, ok := serviceData["hvac_mode"].(string); ok {
+			entity.State = mode
+			entity.Attributes["hvac_mode"] = mode
+		}
+	case "set_fan_mode":
+		setAttributes("fan_mode")
+	case "set_preset_mode":
+		setAttributes("preset_mode")
+	case "set_percentage":
+		setAttributes("percentage")
+		entity.State = "on"
+		if isZero(entity.Attributes["percentage"]) {
+			entity.State = "off"
+		}
+	case "open_cover":
+		m.moveCover(i, 100)
+	case "close_cover":
+		m.moveCover(i, 0)
+	case "set_cover_position":
+		if position, ok := number(serviceData["position"]); ok {
+			m.moveCover(i, int(position))
+		}
+	case "stop_cover":
+		if timer, ok := m.moving[entityID]; ok {
+			timer.Stop()
+			delete(m.moving, entityID)
+			entity.State = "open"
+		}
+	case "media_play":
+		entity.State = "playing"
+	case "media_pause":
+		entity.State = "paused"
+	case "media_stop":
+		entity.State = "idle"
+	case "volume_set":
+		setAttributes("volume_level")
+	case "volume_mute":
+		if muted, ok := serviceData["is_volume_muted"]; ok {
+			entity.Attributes["is_volume_muted"] = muted
+		}
+	case "lock":
+		entity.State = "locked"
+	case "unlock":
+		entity.State = "unlocked"
+	}
+
+	return m.touch(i, old), true
+}
+
+// moveCover sends the cover at index i to position. With a transition time it
+// is opening or closing until it gets there.
+func (m *MockHomeAssistantClient) moveCover(i, position int) {
+	entity := &m.entities[i]
+	entityID := entity.ID
+	if timer, ok := m.moving[entityID]; ok {
+		timer.Stop()
+		delete(m.moving, entityID)
+	}
+
+	current, _ := number(entity.Attributes["current_position"])
+	if m.transition <= 0 || position == int(current) {
+		setCoverPosition(entity, position)
+		return
 	}
 
-	// Update entity state based on service call
+	entity.State = "opening"
+	if position < int(current) {
+		entity.State = "closing"
+	}
+	m.moving[entityID] = time.AfterFunc(m.transition, func() {
+		m.mutex.Lock()
+		var changes []MockStateChange
+		if i := m.indexOf(entityID); i >= 0 && m.moving[entityID] != nil {
+			delete(m.moving, entityID)
+			old := copyEntity(m.entities[i])
+			setCoverPosition(&m.entities[i], position)
+			changes = append(changes, m.touch(i, old))
+		}
+		m.mutex.Unlock()
+		m.notify(changes)
+	})
+}
+
+func setCoverPosition(entity *models.Device, position int) {
+	entity.Attributes["current_position"] = position
+	entity.State = "open"
+	if position == 0 {
+		entity.State = "closed"
+	}
+}
+
+// touch stamps the entity at index i as updated, recording a state change in
+// history and the logbook, and returns the change
+func (m *MockHomeAssistantClient) touch(i int, old models.Device) MockStateChange {
+	entity := &m.entities[i]
+	entity.LastUpdated = time.Now()
+	if entity.State != old.State {
+		entity.LastChanged = entity.LastUpdated
+		m.recordStateChange(entity.ID, entity.State, entity.LastUpdated)
+	}
+	updated := copyEntity(*entity)
+	return MockStateChange{EntityID: entity.ID, Old: &old, New: &updated}
+}
+
+func (m *MockHomeAssistantClient) indexOf(entityID string) int {
 	for i, entity := range m.entities {
 		if entity.ID == entityID {
-			switch service {
-			case "turn_on":
-				m.entities[i].State = "on"
-			case "turn_off":
-				m.entities[i].State = "off"
-			case "toggle":
-				if entity.State == "on" {
-					m.entities[i].State = "off"
-				} else {
-					m.entities[i].State = "on"
-				}
-			case "set_temperature":
-				if temp, ok := serviceData["temperature"]; ok {
-					m.entities[i].Attributes["temperature"] = temp
-				}
-			case "set_brightness":
-				if brightness, ok := serviceData["brightness"]; ok {
-					m.entities[i].Attributes["brightness"] = brightness
-				}
-			}
-			m.entities[i].LastUpdated = time.Now()
-			break
+			return i
 		}
 	}
+	return -1
+}
 
-	return nil
+// number reads a numeric service or attribute value
+func number(value any) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func isZero(value any) bool {
+	n, ok := number(value)
+	return !ok || n == 0
+}
+
+// SetState sets an entity's state and attributes, creating it if needed, as
+// integrations do through HomeAssistant's POST /api/states. Attributes not
+// given are kept.
+func (m *MockHomeAssistantClient) SetState(entityID, state string, attributes map[string]any) models.Device {
+	m.mutex.Lock()
+	i := m.indexOf(entityID)
+	var old *models.Device
+	if i < 0 {
+		m.entities = append(m.entities, homeassistant.EntityToDevice(homeassistant.HAEntity{EntityID: entityID, Attributes: map[string]any{}}))
+		i = len(m.entities) - 1
+	} else {
+		previous := copyEntity(m.entities[i])
+		old = &previous
+	}
+
+	entity := &m.entities[i]
+	if entity.Attributes == nil {
+		entity.Attributes = make(map[string]any)
+	}
+	entity.State = state
+	for key, value := range attributes {
+		entity.Attributes[key] = value
+	}
+	if name, ok := entity.Attributes["friendly_name"].(string); ok {
+		entity.Name = name
+	}
+	entity.Capabilities = homeassistant.ParseCapabilities(entity.Domain, entity.Attributes)
+
+	previous := models.Device{}
+	if old != nil {
+		previous = *old
+	}
+	change := m.touch(i, previous)
+	change.Old = old
+	m.mutex.Unlock()
+
+	m.notify([]MockStateChange{change})
+	return *change.New
 }
 
 // TestConnection simulates connection testing
-func (m *MockHomeAssistantClient) TestConnection() error {
+func (m *MockHomeAssistantClient) TestConnection(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	if m.connectionError {
 		return fmt.Errorf("connection test failed")
 	}
 	return nil
 }
 
+// GetHistory returns recorded states for the given entities between start and end.
+// Like Home Assistant, each entity's list starts with the state in effect at start.
+func (m *MockHomeAssistantClient) GetHistory(ctx context.Context, entityIDs []string, start, end time.Time) (map[string][]models.StateChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.connectionError {
+		return nil, fmt.Errorf("connection error: unable to connect to HomeAssistant")
+	}
+
+	history := make(map[string][]models.StateChange)
+	for _, entityID := range entityIDs {
+		var changes []models.StateChange
+		for _, change := range m.history[entityID] {
+			if change.LastChanged.After(end) {
+				break
+			}
+			if !change.LastChanged.After(start) {
+				// Earlier state becomes the initial state of the period
+				change.LastChanged = start
+				changes = []models.StateChange{change}
+				continue
+			}
+			changes = append(changes, change)
+		}
+		if len(changes) > 0 {
+			history[entityID] = changes
+		}
+	}
+
+	return history, nil
+}
+
+// GetLogbook returns logbook entries between start and end, optionally for one entity
+func (m *MockHomeAssistantClient) GetLogbook(ctx context.Context, entityID string, start, end time.Time) ([]models.LogbookEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.connectionError {
+		return nil, fmt.Errorf("connection error: unable to connect to HomeAssistant")
+	}
+
+	entries := []models.LogbookEntry{}
+	for _, entry := range m.logbook {
+		if entityID != "" && entry.EntityID != entityID {
+			continue
+		}
+		if entry.When.Before(start) || entry.When.After(end) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// AddMockHistory appends recorded states for an entity, in chronological order
+func (m *MockHomeAssistantClient) AddMockHistory(entityID string, changes ...models.StateChange) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addHistory(entityID, changes...)
+}
+
+func (m *MockHomeAssistantClient) addHistory(entityID string, changes ...models.StateChange) {
+	for _, change := range changes {
+		change.EntityID = entityID
+		m.history[entityID] = append(m.history[entityID], change)
+	}
+}
+
+// recordStateChange keeps history and logbook in step with simulated service calls
+func (m *MockHomeAssistantClient) recordStateChange(entityID, state string, when time.Time) {
+	m.addHistory(entityID, models.StateChange{State: state, LastChanged: when})
+
+	name := entityID
+	for _, entity := range m.entities {
+		if entity.ID == entityID {
+			name = entity.Name
+			break
+		}
+	}
+	m.logbook = append(m.logbook, models.LogbookEntry{
+		When:     when,
+		Name:     name,
+		Message:  "changed to " + state,
+		EntityID: entityID,
+		State:    state,
+		Domain:   strings.SplitN(entityID, ".", 2)[0],
+	})
+}
+
 // AddMockEntity adds a new mock entity for testing
 func (m *MockHomeAssistantClient) AddMockEntity(device models.Device) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	m.entities = append(m.entities, device)
 }
 
 // UpdateMockEntity updates an existing mock entity
 func (m *MockHomeAssistantClient) UpdateMockEntity(entityID string, updates map[string]interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	for i, entity := range m.entities {
 		if entity.ID == entityID {
 			if state, ok := updates["state"].(string); ok {
@@ -127,8 +616,10 @@ func (m *MockHomeAssistantClient) UpdateMockEntity(entityID string, updates map[
 }
 
 // createMockEntities creates a set of mock entities for testing
+// createMockEntities creates one device per type, with capabilities read from
+// their attributes like the real client does
 func createMockEntities() []models.Device {
-	return []models.Device{
+	entities := []models.Device{
 		{
 			ID:       "light.living_room",
 			Name:     "Living Room Light",
@@ -137,9 +628,10 @@ func createMockEntities() []models.Device {
 			Domain:   "light",
 			EntityID: "light.living_room",
 			Attributes: map[string]any{
-				"friendly_name": "Living Room Light",
-				"brightness":    0,
-				"color_mode":    "brightness",
+				"friendly_name":         "Living Room Light",
+				"brightness":            0,
+				"color_mode":            "brightness",
+				"supported_color_modes": []string{"brightness"},
 			},
 			LastUpdated: time.Now(),
 		},
@@ -151,10 +643,13 @@ func createMockEntities() []models.Device {
 			Domain:   "light",
 			EntityID: "light.bedroom",
 			Attributes: map[string]any{
-				"friendly_name": "Bedroom Light",
-				"brightness":    255,
-				"color_mode":    "rgb",
-				"rgb_color":     []int{255, 255, 255},
+				"friendly_name":         "Bedroom Light",
+				"brightness":            255,
+				"color_mode":            "rgb",
+				"rgb_color":             []int{255, 255, 255},
+				"supported_color_modes": []string{"color_temp", "rgb"},
+				"min_color_temp_kelvin": 2000,
+				"max_color_temp_kelvin": 6500,
 			},
 			LastUpdated: time.Now(),
 		},
@@ -185,6 +680,9 @@ func createMockEntities() []models.Device {
 				"current_temperature": 21.5,
 				"hvac_mode":           "heat",
 				"hvac_modes":          []string{"off", "heat", "cool", "auto"},
+				"min_temp":            7.0,
+				"max_temp":            30.0,
+				"supported_features":  models.ClimateSupportTargetTemperature,
 			},
 			LastUpdated: time.Now(),
 		},
@@ -210,9 +708,10 @@ func createMockEntities() []models.Device {
 			Domain:   "cover",
 			EntityID: "cover.garage_door",
 			Attributes: map[string]any{
-				"friendly_name":    "Garage Door",
-				"current_position": 0,
-				"device_class":     "garage",
+				"friendly_name":      "Garage Door",
+				"current_position":   0,
+				"device_class":       "garage",
+				"supported_features": models.CoverSupportOpen | models.CoverSupportClose,
 			},
 			LastUpdated: time.Now(),
 		},
@@ -224,9 +723,10 @@ func createMockEntities() []models.Device {
 			Domain:   "fan",
 			EntityID: "fan.ceiling",
 			Attributes: map[string]any{
-				"friendly_name": "Ceiling Fan",
-				"percentage":    0,
-				"preset_modes":  []string{"low", "medium", "high"},
+				"friendly_name":      "Ceiling Fan",
+				"percentage":         0,
+				"preset_modes":       []string{"low", "medium", "high"},
+				"supported_features": models.FanSupportSetSpeed | models.FanSupportPresetMode,
 			},
 			LastUpdated: time.Now(),
 		},
@@ -247,4 +747,37 @@ func createMockEntities() []models.Device {
 			LastUpdated: time.Now(),
 		},
 	}
+
+	for i := range entities {
+		entities[i].Capabilities = homeassistant.ParseCapabilities(entities[i].Domain, entities[i].Attributes)
+	}
+	return entities
+}
+
+// createMockHistory creates recorded states for the garage door and thermostat relative to now
+func createMockHistory(now time.Time) map[string][]models.StateChange {
+	return map[string][]models.StateChange{
+		"cover.garage_door": {
+			{EntityID: "cover.garage_door", State: "closed", LastChanged: now.Add(-30 * time.Hour)},
+			{EntityID: "cover.garage_door", State: "open", LastChanged: now.Add(-3 * time.Hour)},
+			{EntityID: "cover.garage_door", State: "closed", LastChanged: now.Add(-3*time.Hour + 10*time.Minute)},
+		},
+		"climate.main": {
+			{EntityID: "climate.main", State: "off", LastChanged: now.Add(-20 * time.Hour)},
+			{EntityID: "climate.main", State: "heat", LastChanged: now.Add(-5 * time.Hour)},
+			{EntityID: "climate.main", State: "off", LastChanged: now.Add(-3 * time.Hour)},
+			{EntityID: "climate.main", State: "heat", LastChanged: now.Add(-1 * time.Hour)},
+		},
+	}
+}
+
+// createMockLogbook creates logbook entries matching the mock history
+func createMockLogbook(now time.Time) []models.LogbookEntry {
+	return []models.LogbookEntry{
+		{When: now.Add(-5 * time.Hour), Name: "Main Thermostat", Message: "changed to heat", EntityID: "climate.main", State: "heat", Domain: "climate"},
+		{When: now.Add(-3 * time.Hour), Name: "Garage Door", Message: "was opened", EntityID: "cover.garage_door", State: "open", Domain: "cover"},
+		{When: now.Add(-3 * time.Hour), Name: "Main Thermostat", Message: "changed to off", EntityID: "climate.main", State: "off", Domain: "climate"},
+		{When: now.Add(-3*time.Hour + 10*time.Minute), Name: "Garage Door", Message: "was closed", EntityID: "cover.garage_door", State: "closed", Domain: "cover"},
+		{When: now.Add(-1 * time.Hour), Name: "Main Thermostat", Message: "changed to heat", EntityID: "climate.main", State: "heat", Domain: "climate"},
+	}
 }
@@ -1,9 +1,12 @@
 package mocks
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tienpdinh/gpt-home/pkg/models"
 )
 
@@ -40,17 +43,30 @@ func TestSetServiceError(t *testing.T) {
 	assert.False(t, client.serviceError)
 }
 
+func TestCancelledContext(t *testing.T) {
+	client := NewMockHomeAssistantClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.GetEntities(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = client.CallService(ctx, "light", "turn_on", "light.living_room", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, client.ServiceCalls())
+}
+
 func TestGetEntities(t *testing.T) {
 	client := NewMockHomeAssistantClient()
 
 	// Test normal operation
-	entities, err := client.GetEntities()
+	entities, err := client.GetEntities(context.Background())
 	assert.NoError(t, err)
 	assert.NotEmpty(t, entities)
 
 	// Test with connection error
 	client.SetConnectionError(true)
-	entities, err = client.GetEntities()
+	entities, err = client.GetEntities(context.Background())
 	assert.Error(t, err)
 	assert.Nil(t, entities)
 }
@@ -59,12 +75,12 @@ func TestTestConnection(t *testing.T) {
 	client := NewMockHomeAssistantClient()
 
 	// Test normal operation
-	err := client.TestConnection()
+	err := client.TestConnection(context.Background())
 	assert.NoError(t, err)
 
 	// Test with connection error
 	client.SetConnectionError(true)
-	err = client.TestConnection()
+	err = client.TestConnection(context.Background())
 	assert.Error(t, err)
 }
 
@@ -72,20 +88,20 @@ func TestGetEntity(t *testing.T) {
 	client := NewMockHomeAssistantClient()
 
 	// Test getting existing entity
-	entity, err := client.GetEntity("light.living_room")
+	entity, err := client.GetEntity(context.Background(), "light.living_room")
 	assert.NoError(t, err)
 	assert.NotNil(t, entity)
 	assert.Equal(t, "light.living_room", entity.ID)
 
 	// Test getting non-existent entity
-	entity, err = client.GetEntity("nonexistent.entity")
+	entity, err = client.GetEntity(context.Background(), "nonexistent.entity")
 	assert.Error(t, err)
 	assert.Nil(t, entity)
 	assert.Contains(t, err.Error(), "entity not found")
 
 	// Test with connection error
 	client.SetConnectionError(true)
-	entity, err = client.GetEntity("light.living_room")
+	entity, err = client.GetEntity(context.Background(), "light.living_room")
 	assert.Error(t, err)
 	assert.Nil(t, entity)
 }
@@ -94,18 +110,18 @@ func TestCallService(t *testing.T) {
 	client := NewMockHomeAssistantClient()
 
 	// Test normal service call
-	err := client.CallService("light", "turn_on", "light.living_room", nil)
+	err := client.CallService(context.Background(), "light", "turn_on", "light.living_room", nil)
 	assert.NoError(t, err)
 
 	// Test with connection error
 	client.SetConnectionError(true)
-	err = client.CallService("light", "turn_on", "light.living_room", nil)
+	err = client.CallService(context.Background(), "light", "turn_on", "light.living_room", nil)
 	assert.Error(t, err)
 
 	// Reset and test with service error
 	client.SetConnectionError(false)
 	client.SetServiceError(true)
-	err = client.CallService("light", "turn_on", "light.living_room", nil)
+	err = client.CallService(context.Background(), "light", "turn_on", "light.living_room", nil)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "service error")
 }
@@ -125,7 +141,87 @@ func TestAddMockEntity(t *testing.T) {
 	assert.Len(t, client.entities, initialCount+1)
 
 	// Verify entity was added
-	entity, err := client.GetEntity("switch.new_switch")
+	entity, err := client.GetEntity(context.Background(), "switch.new_switch")
 	assert.NoError(t, err)
 	assert.Equal(t, "New Switch", entity.Name)
 }
+
+func TestGetHistory(t *testing.T) {
+	client := NewMockHomeAssistantClient()
+	now := time.Now()
+
+	// The state in effect at start comes first, followed by changes in the window
+	history, err := client.GetHistory(context.Background(), []string{"cover.garage_door"}, now.Add(-24*time.Hour), now)
+	assert.NoError(t, err)
+	changes := history["cover.garage_door"]
+	assert.Len(t, changes, 3)
+	assert.Equal(t, "closed", changes[0].State)
+	assert.Equal(t, "open", changes[1].State)
+	assert.Equal(t, "closed", changes[2].State)
+
+	// Service calls that change state are recorded
+	err = client.CallService(context.Background(), "light", "turn_on", "light.living_room", nil)
+	assert.NoError(t, err)
+	history, err = client.GetHistory(context.Background(), []string{"light.living_room"}, now.Add(-time.Minute), time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, history["light.living_room"], 1)
+	assert.Equal(t, "on", history["light.living_room"][0].State)
+
+	// Test with connection error
+	client.SetConnectionError(true)
+	history, err = client.GetHistory(context.Background(), []string{"cover.garage_door"}, now.Add(-time.Hour), now)
+	assert.Error(t, err)
+	assert.Nil(t, history)
+}
+
+func TestGetLogbook(t *testing.T) {
+	client := NewMockHomeAssistantClient()
+	now := time.Now()
+
+	entries, err := client.GetLogbook(context.Background(), "cover.garage_door", now.Add(-24*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "was opened", entries[0].Message)
+
+	// Empty entity ID returns all entries in the window
+	entries, err = client.GetLogbook(context.Background(), "", now.Add(-2*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "climate.main", entries[0].EntityID)
+}
+
+func TestCallService_StateTransitions(t *testing.T) {
+	client := NewMockHomeAssistantClient()
+	ctx := context.Background()
+
+	var changes []MockStateChange
+	stop := client.OnStateChange(func(change MockStateChange) { changes = append(changes, change) })
+
+	require.NoError(t, client.CallService(ctx, "fan", "set_percentage", "fan.ceiling", map[string]any{"percentage": 40}))
+	fan, err := client.GetEntity(ctx, "fan.ceiling")
+	require.NoError(t, err)
+	assert.Equal(t, "on", fan.State)
+	assert.Equal(t, 40, fan.Attributes["percentage"])
+
+	require.NoError(t, client.CallService(ctx, "media_player", "media_play", "media_player.living_room", nil))
+	speaker, err := client.GetEntity(ctx, "media_player.living_room")
+	require.NoError(t, err)
+	assert.Equal(t, "playing", speaker.State)
+
+	require.Len(t, changes, 2)
+	assert.Equal(t, "off", changes[0].Old.State)
+	assert.Equal(t, "on", changes[0].New.State)
+	stop()
+
+	// A stopped cover stays partly open
+	client.SetTransitionTime(time.Hour)
+	require.NoError(t, client.CallService(ctx, "cover", "open_cover", "cover.garage_door", nil))
+	door, err := client.GetEntity(ctx, "cover.garage_door")
+	require.NoError(t, err)
+	assert.Equal(t, "opening", door.State)
+	require.NoError(t, client.CallService(ctx, "cover", "stop_cover", "cover.garage_door", nil))
+	door, err = client.GetEntity(ctx, "cover.garage_door")
+	require.NoError(t, err)
+	assert.Equal(t, "open", door.State)
+	assert.Len(t, changes, 2)
+}
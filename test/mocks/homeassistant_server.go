@@ -0,0 +1,676 @@
+package mocks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/pkg/homeassistant"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// mockHAVersion is the HomeAssistant version the mock server claims to be
+const mockHAVersion = "2024.6.0"
+
+// Faults make the mock server slow or unreliable, to see how clients cope
+type Faults struct {
+	Latency     time.Duration // added before every answer
+	Jitter      time.Duration // up to this much more latency, at random
+	ErrorRate   float64       // share of requests failed with ErrorStatus, from 0 to 1
+	ErrorStatus int           // status for failed requests; 500 when unset
+	Offline     bool          // drop connections without answering
+}
+
+// faultsJSON is Faults as the /fake/faults endpoint takes it, with durations
+// such as "250ms"
+type faultsJSON struct {
+	Latency     string  `json:"latency,omitempty"`
+	Jitter      string  `json:"jitter,omitempty"`
+	ErrorRate   float64 `json:"error_rate"`
+	ErrorStatus int     `json:"error_status,omitempty"`
+	Offline     bool    `json:"offline"`
+}
+
+// MockHomeAssistantServer serves a mock client's entities over HomeAssistant's
+// REST and WebSocket APIs, so the real client, and GPT-Home as a whole, can run
+// without HomeAssistant. Service calls change state as they would there, and
+// GET or PUT /fake/faults reads or sets the faults injected into API requests.
+type MockHomeAssistantServer struct {
+	client   *MockHomeAssistantClient
+	token    string
+	name     string
+	upgrader websocket.Upgrader
+
+	mutex  sync.Mutex
+	faults Faults
+}
+
+// NewMockHomeAssistantServer creates a server for client's entities. Requests
+// must carry token, unless it is empty.
+func NewMockHomeAssistantServer(client *MockHomeAssistantClient, token string) *MockHomeAssistantServer {
+	return &MockHomeAssistantServer{client: client, token: token, name: "Home"}
+}
+
+// NewMockHomeAssistantServerForHouse creates a server for a house's entities
+func NewMockHomeAssistantServerForHouse(house *House, token string) *MockHomeAssistantServer {
+	server := NewMockHomeAssistantServer(NewMockHomeAssistantClientForHouse(house), token)
+	if house.Name != "" {
+		server.name = house.Name
+	}
+	return server
+}
+
+// Client is the mock client holding the server's entities
+func (s *MockHomeAssistantServer) Client() *MockHomeAssistantClient {
+	return s.client
+}
+
+// SetFaults replaces the faults injected into API requests
+func (s *MockHomeAssistantServer) SetFaults(faults Faults) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = faults
+}
+
+// Faults returns the faults injected into API requests
+func (s *MockHomeAssistantServer) Faults() Faults {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.faults
+}
+
+func (s *MockHomeAssistantServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/fake/faults" {
+		s.serveFaults(w, r)
+		return
+	}
+	if !s.injectFaults(w, r) {
+		return
+	}
+
+	// The WebSocket API authenticates in its first message
+	if r.URL.Path == "/api/websocket" {
+		s.serveWebSocket(w, r)
+		return
+	}
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeHAError(w, http.StatusUnauthorized, "401: Unauthorized")
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/api/" && r.Method == http.MethodGet:
+		writeHAJSON(w, http.StatusOK, map[string]string{"message": "API running."})
+	case path == "/api/states" && r.Method == http.MethodGet:
+		s.serveStates(w, r)
+	case strings.HasPrefix(path, "/api/states/") && r.Method == http.MethodGet:
+		s.serveState(w, r, strings.TrimPrefix(path, "/api/states/"))
+	case strings.HasPrefix(path, "/api/states/") && r.Method == http.MethodPost:
+		s.serveSetState(w, r, strings.TrimPrefix(path, "/api/states/"))
+	case strings.HasPrefix(path, "/api/services/") && r.Method == http.MethodPost:
+		s.serveService(w, r, strings.TrimPrefix(path, "/api/services/"))
+	case strings.HasPrefix(path, "/api/history/period/") && r.Method == http.MethodGet:
+		s.serveHistory(w, r, strings.TrimPrefix(path, "/api/history/period/"))
+	case strings.HasPrefix(path, "/api/logbook/") && r.Method == http.MethodGet:
+		s.serveLogbook(w, r, strings.TrimPrefix(path, "/api/logbook/"))
+	case strings.HasPrefix(path, "/api/"):
+		writeHAError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		writeHAError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// injectFaults delays the request and may fail it, reporting whether it should
+// still be answered
+func (s *MockHomeAssistantServer) injectFaults(w http.ResponseWriter, r *http.Request) bool {
+	faults := s.Faults()
+	if faults.Offline {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return false
+			}
+		}
+		writeHAError(w, http.StatusServiceUnavailable, "Offline")
+		return false
+	}
+
+	if !s.delay(r.Context()) {
+		return false
+	}
+
+	if faults.ErrorRate > 0 && rand.Float64() < faults.ErrorRate {
+		status := faults.ErrorStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		writeHAError(w, status, "Injected fault")
+		return false
+	}
+	return true
+}
+
+func (s *MockHomeAssistantServer) serveFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var body faultsJSON
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeHAError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+			return
+		}
+		faults, err := body.faults()
+		if err != nil {
+			writeHAError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.SetFaults(faults)
+	default:
+		writeHAError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	faults := s.Faults()
+	writeHAJSON(w, http.StatusOK, faultsJSON{
+		Latency:     faults.Latency.String(),
+		Jitter:      faults.Jitter.String(),
+		ErrorRate:   faults.ErrorRate,
+		ErrorStatus: faults.ErrorStatus,
+		Offline:     faults.Offline,
+	})
+}
+
+func (f faultsJSON) faults() (Faults, error) {
+	faults := Faults{ErrorRate: f.ErrorRate, ErrorStatus: f.ErrorStatus, Offline: f.Offline}
+	for _, field := range []struct {
+		value string
+		out   *time.Duration
+	}{{f.Latency, &faults.Latency}, {f.Jitter, &faults.Jitter}} {
+		if field.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.value)
+		if err != nil || d < 0 {
+			return Faults{}, fmt.Errorf("invalid duration %q", field.value)
+		}
+		*field.out = d
+	}
+	if faults.ErrorRate < 0 || faults.ErrorRate > 1 {
+		return Faults{}, fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if faults.ErrorStatus != 0 && (faults.ErrorStatus < 400 || faults.ErrorStatus > 599) {
+		return Faults{}, fmt.Errorf("error_status must be a 4xx or 5xx status")
+	}
+	return faults, nil
+}
+
+func (s *MockHomeAssistantServer) serveStates(w http.ResponseWriter, r *http.Request) {
+	devices, err := s.client.GetEntities(r.Context())
+	if err != nil {
+		writeHAError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	states := make([]homeassistant.HAEntity, 0, len(devices))
+	for _, device := range devices {
+		states = append(states, entityState(device))
+	}
+	writeHAJSON(w, http.StatusOK, states)
+}
+
+func (s *MockHomeAssistantServer) serveState(w http.ResponseWriter, r *http.Request, entityID string) {
+	device, err := s.client.GetEntity(r.Context(), entityID)
+	if errors.Is(err, ErrEntityNotFound) {
+		writeHAError(w, http.StatusNotFound, "Entity not found.")
+		return
+	}
+	if err != nil {
+		writeHAError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeHAJSON(w, http.StatusOK, entityState(*device))
+}
+
+// serveSetState sets an entity's state, as integrations and scripts do, which
+// is handy for making sensors change while developing
+func (s *MockHomeAssistantServer) serveSetState(w http.ResponseWriter, r *http.Request, entityID string) {
+	var body struct {
+		State      *string        `json:"state"`
+		Attributes map[string]any `json:"attributes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.State == nil {
+		writeHAError(w, http.StatusBadRequest, "No state specified.")
+		return
+	}
+	if domain, object, ok := strings.Cut(entityID, "."); !ok || domain == "" || object == "" {
+		writeHAError(w, http.StatusBadRequest, "Invalid entity ID specified.")
+		return
+	}
+
+	status := http.StatusOK
+	if _, err := s.client.GetEntity(r.Context(), entityID); errors.Is(err, ErrEntityNotFound) {
+		status = http.StatusCreated
+	}
+	device := s.client.SetState(entityID, *body.State, body.Attributes)
+	writeHAJSON(w, status, entityState(device))
+}
+
+// serveService calls a service. It takes the client's body, with target and
+// service_data, as well as HomeAssistant's own flat one, where entity_id sits
+// among the service data.
+func (s *MockHomeAssistantServer) serveService(w http.ResponseWriter, r *http.Request, name string) {
+	domain, service, ok := strings.Cut(name, "/")
+	if !ok || domain == "" || service == "" {
+		writeHAError(w, http.StatusBadRequest, "Invalid service")
+		return
+	}
+
+	body := map[string]any{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeHAError(w, http.StatusBadRequest, "Data should be valid JSON.")
+			return
+		}
+	}
+	entityIDs, serviceData := serviceTarget(body)
+
+	changes, err := s.client.CallServiceForChanges(r.Context(), domain, service, entityIDs, serviceData)
+	if err != nil {
+		writeHAError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	states := make([]homeassistant.HAEntity, 0, len(changes))
+	for _, change := range changes {
+		states = append(states, entityState(*change.New))
+	}
+	writeHAJSON(w, http.StatusOK, states)
+}
+
+// serviceTarget splits a service call's body into the entities it targets and
+// its service data
+func serviceTarget(body map[string]any) ([]string, map[string]any) {
+	serviceData := map[string]any{}
+	if nested, ok := body["service_data"].(map[string]any); ok {
+		for key, value := range nested {
+			serviceData[key] = value
+		}
+	}
+	for key, value := range body {
+		switch key {
+		case "domain", "service", "service_data", "target":
+		default:
+			serviceData[key] = value
+		}
+	}
+
+	var entityIDs []string
+	if target, ok := body["target"].(map[string]any); ok {
+		entityIDs = append(entityIDs, stringList(target["entity_id"])...)
+	}
+	entityIDs = append(entityIDs, stringList(serviceData["entity_id"])...)
+	delete(serviceData, "entity_id")
+	return entityIDs, serviceData
+}
+
+// stringList reads an entity_id, which may be one ID, a comma-separated list
+// or a JSON list
+func stringList(value any) []string {
+	var values []string
+	switch v := value.(type) {
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func (s *MockHomeAssistantServer) serveHistory(w http.ResponseWriter, r *http.Request, startParam string) {
+	start, end, ok := period(w, r, startParam)
+	if !ok {
+		return
+	}
+
+	entityIDs := stringList(r.URL.Query().Get("filter_entity_id"))
+	if len(entityIDs) == 0 {
+		devices, err := s.client.GetEntities(r.Context())
+		if err != nil {
+			writeHAError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, device := range devices {
+			entityIDs = append(entityIDs, device.ID)
+		}
+	}
+
+	history, err := s.client.GetHistory(r.Context(), entityIDs, start, end)
+	if err != nil {
+		writeHAError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	periods := [][]homeassistant.HAEntity{}
+	for _, entityID := range entityIDs {
+		changes, ok := history[entityID]
+		if !ok {
+			continue
+		}
+		states := make([]homeassistant.HAEntity, 0, len(changes))
+		for _, change := range changes {
+			states = append(states, homeassistant.HAEntity{
+				EntityID:    entityID,
+				State:       change.State,
+				Attributes:  change.Attributes,
+				LastChanged: formatHATime(change.LastChanged),
+				LastUpdated: formatHATime(change.LastChanged),
+			})
+		}
+		periods = append(periods, states)
+	}
+	writeHAJSON(w, http.StatusOK, periods)
+}
+
+func (s *MockHomeAssistantServer) serveLogbook(w http.ResponseWriter, r *http.Request, startParam string) {
+	start, end, ok := period(w, r, startParam)
+	if !ok {
+		return
+	}
+
+	logbook, err := s.client.GetLogbook(r.Context(), r.URL.Query().Get("entity"), start, end)
+	if err != nil {
+		writeHAError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	entries := make([]homeassistant.HALogbookEntry, 0, len(logbook))
+	for _, entry := range logbook {
+		entries = append(entries, homeassistant.HALogbookEntry{
+			When:     formatHATime(entry.When),
+			Name:     entry.Name,
+			Message:  entry.Message,
+			EntityID: entry.EntityID,
+			State:    entry.State,
+			Domain:   entry.Domain,
+		})
+	}
+	writeHAJSON(w, http.StatusOK, entries)
+}
+
+// period reads a history or logbook window: its start from the path and its
+// end from end_time, a day later by default as in HomeAssistant
+func period(w http.ResponseWriter, r *http.Request, startParam string) (time.Time, time.Time, bool) {
+	start, err := time.Parse(time.RFC3339, startParam)
+	if err != nil {
+		writeHAError(w, http.StatusBadRequest, "Invalid datetime")
+		return time.Time{}, time.Time{}, false
+	}
+	end := start.Add(24 * time.Hour)
+	if endParam := r.URL.Query().Get("end_time"); endParam != "" {
+		if end, err = time.Parse(time.RFC3339, endParam); err != nil {
+			writeHAError(w, http.StatusBadRequest, "Invalid end_time")
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	return start, end, true
+}
+
+// entityState is a device as HomeAssistant reports its state
+func entityState(device models.Device) homeassistant.HAEntity {
+	attributes := make(map[string]any, len(device.Attributes)+2)
+	for key, value := range device.Attributes {
+		attributes[key] = value
+	}
+	if _, ok := attributes["friendly_name"]; !ok && device.Name != "" {
+		attributes["friendly_name"] = device.Name
+	}
+	if _, ok := attributes["area_id"]; !ok && device.Area != "" {
+		attributes["area_id"] = device.Area
+	}
+
+	entityID := device.EntityID
+	if entityID == "" {
+		entityID = device.ID
+	}
+	lastUpdated := device.LastUpdated
+	if lastUpdated.IsZero() {
+		lastUpdated = time.Now()
+	}
+	lastChanged := device.LastChanged
+	if lastChanged.IsZero() {
+		lastChanged = lastUpdated
+	}
+
+	return homeassistant.HAEntity{
+		EntityID:    entityID,
+		State:       device.State,
+		Attributes:  attributes,
+		LastChanged: formatHATime(lastChanged),
+		LastUpdated: formatHATime(lastUpdated),
+		Context:     homeassistant.HAContext{ID: uuid.NewString()},
+	}
+}
+
+func formatHATime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func writeHAJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeHAError(w http.ResponseWriter, status int, message string) {
+	writeHAJSON(w, status, map[string]string{"message": message})
+}
+
+// haMessage is a WebSocket API message, in either direction
+type haMessage struct {
+	ID          int            `json:"id,omitempty"`
+	Type        string         `json:"type"`
+	AccessToken string         `json:"access_token,omitempty"`
+	HAVersion   string         `json:"ha_version,omitempty"`
+	Message     string         `json:"message,omitempty"`
+	Success     *bool          `json:"success,omitempty"`
+	Result      any            `json:"result,omitempty"`
+	Error       *haError       `json:"error,omitempty"`
+	Event       *haEvent       `json:"event,omitempty"`
+	EventType   string         `json:"event_type,omitempty"`
+	Domain      string         `json:"domain,omitempty"`
+	Service     string         `json:"service,omitempty"`
+	ServiceData map[string]any `json:"service_data,omitempty"`
+	Target      map[string]any `json:"target,omitempty"`
+	Sub         int            `json:"subscription,omitempty"`
+}
+
+type haError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type haEvent struct {
+	EventType string         `json:"event_type"`
+	Data      haStateChanged `json:"data"`
+	Origin    string         `json:"origin"`
+	TimeFired string         `json:"time_fired"`
+}
+
+type haStateChanged struct {
+	EntityID string                  `json:"entity_id"`
+	OldState *homeassistant.HAEntity `json:"old_state"`
+	NewState *homeassistant.HAEntity `json:"new_state"`
+}
+
+// haSession is one WebSocket API connection
+type haSession struct {
+	conn  *websocket.Conn
+	write sync.Mutex
+}
+
+func (c *haSession) send(message haMessage) error {
+	c.write.Lock()
+	defer c.write.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteJSON(message)
+}
+
+func (c *haSession) result(id int, result any) error {
+	success := true
+	return c.send(haMessage{ID: id, Type: "result", Success: &success, Result: result})
+}
+
+func (c *haSession) fail(id int, code, message string) error {
+	success := false
+	return c.send(haMessage{ID: id, Type: "result", Success: &success, Error: &haError{Code: code, Message: message}})
+}
+
+// serveWebSocket speaks HomeAssistant's WebSocket API: after authenticating,
+// clients can ping, get states and config, call services and subscribe to
+// state_changed events
+func (s *MockHomeAssistantServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	session := &haSession{conn: conn}
+
+	if session.send(haMessage{Type: "auth_required", HAVersion: mockHAVersion}) != nil {
+		return
+	}
+	var auth haMessage
+	if err := conn.ReadJSON(&auth); err != nil {
+		return
+	}
+	if auth.Type != "auth" || (s.token != "" && auth.AccessToken != s.token) {
+		session.send(haMessage{Type: "auth_invalid", Message: "Invalid access token or password"})
+		return
+	}
+	if session.send(haMessage{Type: "auth_ok", HAVersion: mockHAVersion}) != nil {
+		return
+	}
+
+	subscriptions := make(map[int]func())
+	defer func() {
+		for _, unsubscribe := range subscriptions {
+			unsubscribe()
+		}
+	}()
+
+	for {
+		var message haMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		if !s.delay(r.Context()) {
+			return
+		}
+
+		var err error
+		switch message.Type {
+		case "ping":
+			err = session.send(haMessage{ID: message.ID, Type: "pong"})
+		case "get_states":
+			devices, getErr := s.client.GetEntities(r.Context())
+			if getErr != nil {
+				err = session.fail(message.ID, "home_assistant_error", getErr.Error())
+				break
+			}
+			states := make([]homeassistant.HAEntity, 0, len(devices))
+			for _, device := range devices {
+				states = append(states, entityState(device))
+			}
+			err = session.result(message.ID, states)
+		case "get_config":
+			err = session.result(message.ID, map[string]any{"location_name": s.name, "version": mockHAVersion, "state": "RUNNING"})
+		case "subscribe_events":
+			if message.EventType != "" && message.EventType != "state_changed" {
+				// Nothing else ever happens here
+				subscriptions[message.ID] = func() {}
+				err = session.result(message.ID, nil)
+				break
+			}
+			id := message.ID
+			subscriptions[id] = s.client.OnStateChange(func(change MockStateChange) {
+				session.send(haMessage{ID: id, Type: "event", Event: stateChangedEvent(change)})
+			})
+			err = session.result(message.ID, nil)
+		case "unsubscribe_events":
+			unsubscribe, ok := subscriptions[message.Sub]
+			if !ok {
+				err = session.fail(message.ID, "not_found", "Subscription not found.")
+				break
+			}
+			unsubscribe()
+			delete(subscriptions, message.Sub)
+			err = session.result(message.ID, nil)
+		case "call_service":
+			body := map[string]any{"service_data": message.ServiceData}
+			if message.Target != nil {
+				body["target"] = message.Target
+			}
+			entityIDs, serviceData := serviceTarget(body)
+			if _, callErr := s.client.CallServiceForChanges(r.Context(), message.Domain, message.Service, entityIDs, serviceData); callErr != nil {
+				err = session.fail(message.ID, "home_assistant_error", callErr.Error())
+				break
+			}
+			err = session.result(message.ID, map[string]any{"context": homeassistant.HAContext{ID: uuid.NewString()}})
+		default:
+			err = session.fail(message.ID, "unknown_command", "Unknown command.")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// delay waits out the injected latency, reporting false if ctx ends first
+func (s *MockHomeAssistantServer) delay(ctx context.Context) bool {
+	faults := s.Faults()
+	delay := faults.Latency
+	if faults.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(faults.Jitter)))
+	}
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func stateChangedEvent(change MockStateChange) *haEvent {
+	event := &haEvent{
+		EventType: "state_changed",
+		Data:      haStateChanged{EntityID: change.EntityID},
+		Origin:    "LOCAL",
+		TimeFired: formatHATime(time.Now()),
+	}
+	if change.Old != nil {
+		old := entityState(*change.Old)
+		event.Data.OldState = &old
+	}
+	if change.New != nil {
+		updated := entityState(*change.New)
+		event.Data.NewState = &updated
+	}
+	return event
+}
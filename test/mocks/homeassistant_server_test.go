@@ -0,0 +1,236 @@
+package mocks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/homeassistant"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+	"github.com/tienpdinh/gpt-home/pkg/resilience"
+)
+
+// newTestServer serves the built-in house, returning a real client for it
+func newTestServer(t *testing.T) (*MockHomeAssistantServer, *httptest.Server, *homeassistant.Client) {
+	fake := NewMockHomeAssistantServer(NewMockHomeAssistantClient(), "secret")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	opts := homeassistant.DefaultOptions()
+	opts.Retry = resilience.NoRetry
+	return fake, server, homeassistant.NewClientWithOptions(server.URL, "secret", opts)
+}
+
+func TestMockHomeAssistantServer_States(t *testing.T) {
+	_, _, client := newTestServer(t)
+	ctx := context.Background()
+
+	require.NoError(t, client.TestConnection(ctx))
+
+	devices, err := client.GetEntities(ctx)
+	require.NoError(t, err)
+	assert.Len(t, devices, 8)
+
+	device, err := client.GetEntity(ctx, "climate.main")
+	require.NoError(t, err)
+	assert.Equal(t, "Main Thermostat", device.Name)
+	assert.Equal(t, models.DeviceTypeClimate, device.Type)
+	assert.Equal(t, 22.0, device.Attributes["temperature"])
+	require.NotNil(t, device.Capabilities)
+	assert.Equal(t, []string{"off", "heat", "cool", "auto"}, device.Capabilities.HVACModes)
+
+	_, err = client.GetEntity(ctx, "light.attic")
+	assert.ErrorContains(t, err, "entity not found")
+}
+
+func TestMockHomeAssistantServer_RequiresToken(t *testing.T) {
+	_, server, _ := newTestServer(t)
+
+	client := homeassistant.NewClientWithOptions(server.URL, "wrong", homeassistant.Options{Retry: resilience.NoRetry})
+	err := client.TestConnection(context.Background())
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestMockHomeAssistantServer_CallService(t *testing.T) {
+	_, _, client := newTestServer(t)
+	ctx := context.Background()
+
+	err := client.CallServiceForEntities(ctx, "light", "turn_on", []string{"light.living_room", "light.bedroom"}, map[string]any{"brightness_pct": 50})
+	require.NoError(t, err)
+
+	device, err := client.GetEntity(ctx, "light.living_room")
+	require.NoError(t, err)
+	assert.Equal(t, "on", device.State)
+	assert.Equal(t, 127.0, device.Attributes["brightness"])
+
+	require.NoError(t, client.CallService(ctx, "climate", "set_hvac_mode", "climate.main", map[string]any{"hvac_mode": "cool"}))
+	device, err = client.GetEntity(ctx, "climate.main")
+	require.NoError(t, err)
+	assert.Equal(t, "cool", device.State)
+
+	// The change shows up in history and the logbook
+	now := time.Now()
+	history, err := client.GetHistory(ctx, []string{"climate.main"}, now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	changes := history["climate.main"]
+	require.NotEmpty(t, changes)
+	assert.Equal(t, "cool", changes[len(changes)-1].State)
+
+	logbook, err := client.GetLogbook(ctx, "climate.main", now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, logbook, 1)
+	assert.Equal(t, "changed to cool", logbook[0].Message)
+}
+
+func TestMockHomeAssistantServer_FlatServiceBody(t *testing.T) {
+	fake, server, _ := newTestServer(t)
+
+	// HomeAssistant's own REST body puts entity_id among the service data
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/api/services/media_player/volume_set", strings.NewReader(`{"entity_id": "media_player.living_room", "volume_level": 0.8}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var changed []homeassistant.HAEntity
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&changed))
+	require.Len(t, changed, 1)
+	assert.Equal(t, 0.8, changed[0].Attributes["volume_level"])
+
+	calls := fake.Client().ServiceCalls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, []string{"media_player.living_room"}, calls[0].EntityIDs)
+	assert.NotContains(t, calls[0].ServiceData, "entity_id")
+}
+
+func TestMockHomeAssistantServer_SetState(t *testing.T) {
+	_, server, client := newTestServer(t)
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/api/states/sensor.humidity", strings.NewReader(`{"state": "48", "attributes": {"friendly_name": "Humidity", "unit_of_measurement": "%"}}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	device, err := client.GetEntity(context.Background(), "sensor.humidity")
+	require.NoError(t, err)
+	assert.Equal(t, "48", device.State)
+	assert.Equal(t, "Humidity", device.Name)
+}
+
+func TestMockHomeAssistantServer_CoverTransition(t *testing.T) {
+	fake, _, client := newTestServer(t)
+	fake.Client().SetTransitionTime(50 * time.Millisecond)
+	ctx := context.Background()
+
+	require.NoError(t, client.CallService(ctx, "cover", "open_cover", "cover.garage_door", nil))
+	device, err := client.GetEntity(ctx, "cover.garage_door")
+	require.NoError(t, err)
+	assert.Equal(t, "opening", device.State)
+
+	assert.Eventually(t, func() bool {
+		device, err := client.GetEntity(ctx, "cover.garage_door")
+		return err == nil && device.State == "open" && device.Attributes["current_position"] == 100.0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestMockHomeAssistantServer_Faults(t *testing.T) {
+	fake, server, client := newTestServer(t)
+	ctx := context.Background()
+
+	fake.SetFaults(Faults{ErrorRate: 1, ErrorStatus: http.StatusServiceUnavailable})
+	_, err := client.GetEntities(ctx)
+	assert.ErrorContains(t, err, "503")
+
+	fake.SetFaults(Faults{Offline: true})
+	assert.Error(t, client.TestConnection(ctx))
+
+	fake.SetFaults(Faults{Latency: 200 * time.Millisecond})
+	slow := homeassistant.NewClientWithOptions(server.URL, "secret", homeassistant.Options{Timeout: 50 * time.Millisecond, Retry: resilience.NoRetry})
+	assert.Error(t, slow.TestConnection(ctx))
+
+	// Faults can be changed over HTTP too, and the control endpoint is never faulty
+	resp, err := http.Post(server.URL+"/fake/faults", "application/json", strings.NewReader(`{"latency": "5ms", "error_rate": 0}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, Faults{Latency: 5 * time.Millisecond}, fake.Faults())
+	assert.NoError(t, client.TestConnection(ctx))
+
+	resp, err = http.Post(server.URL+"/fake/faults", "application/json", strings.NewReader(`{"error_rate": 2}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestMockHomeAssistantServer_WebSocket(t *testing.T) {
+	fake, server, _ := newTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/websocket", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	read := func() map[string]any {
+		var message map[string]any
+		require.NoError(t, conn.ReadJSON(&message))
+		return message
+	}
+	assert.Equal(t, "auth_required", read()["type"])
+	require.NoError(t, conn.WriteJSON(map[string]any{"type": "auth", "access_token": "secret"}))
+	assert.Equal(t, "auth_ok", read()["type"])
+
+	require.NoError(t, conn.WriteJSON(map[string]any{"id": 1, "type": "get_states"}))
+	states := read()
+	assert.Equal(t, true, states["success"])
+	assert.Len(t, states["result"], 8)
+
+	require.NoError(t, conn.WriteJSON(map[string]any{"id": 2, "type": "subscribe_events", "event_type": "state_changed"}))
+	assert.Equal(t, true, read()["success"])
+
+	// Changes made over REST are pushed to subscribers
+	require.NoError(t, fake.Client().CallService(context.Background(), "switch", "turn_on", "switch.porch", nil))
+	event := read()
+	assert.Equal(t, "event", event["type"])
+	assert.Equal(t, 2.0, event["id"])
+	data := event["event"].(map[string]any)["data"].(map[string]any)
+	assert.Equal(t, "switch.porch", data["entity_id"])
+	assert.Equal(t, "off", data["old_state"].(map[string]any)["state"])
+	assert.Equal(t, "on", data["new_state"].(map[string]any)["state"])
+
+	require.NoError(t, conn.WriteJSON(map[string]any{"id": 3, "type": "call_service", "domain": "switch", "service": "turn_off", "target": map[string]any{"entity_id": "switch.porch"}}))
+	// The event and the result may come in either order
+	types := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		types[read()["type"].(string)] = true
+	}
+	assert.Equal(t, map[string]bool{"event": true, "result": true}, types)
+
+	require.NoError(t, conn.WriteJSON(map[string]any{"id": 4, "type": "frobnicate"}))
+	failed := read()
+	assert.Equal(t, false, failed["success"])
+	assert.Equal(t, "unknown_command", failed["error"].(map[string]any)["code"])
+}
+
+func TestMockHomeAssistantServer_WebSocketAuth(t *testing.T) {
+	_, server, _ := newTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/websocket", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var message map[string]any
+	require.NoError(t, conn.ReadJSON(&message))
+	require.NoError(t, conn.WriteJSON(map[string]any{"type": "auth", "access_token": "wrong"}))
+	require.NoError(t, conn.ReadJSON(&message))
+	assert.Equal(t, "auth_invalid", message["type"])
+}
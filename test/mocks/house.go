@@ -0,0 +1,112 @@
+package mocks
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tienpdinh/gpt-home/pkg/homeassistant"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// House is a home for the mock HomeAssistant to serve, as defined in YAML:
+//
+//	name: Demo House
+//	transition: 3s # how long covers take to open and close
+//	entities:
+//	  - entity_id: light.kitchen
+//	    state: "off"
+//	    attributes:
+//	      friendly_name: Kitchen Light
+//	      area_id: kitchen
+//
+// Entities are written as HomeAssistant reports their state, so they can be
+// copied from its developer tools.
+type House struct {
+	Name       string
+	Transition time.Duration
+	Entities   []homeassistant.HAEntity
+}
+
+type houseFile struct {
+	Name       string `yaml:"name"`
+	Transition string `yaml:"transition"`
+	Entities   []struct {
+		EntityID    string         `yaml:"entity_id"`
+		State       string         `yaml:"state"`
+		Attributes  map[string]any `yaml:"attributes"`
+		LastChanged string         `yaml:"last_changed"`
+	} `yaml:"entities"`
+}
+
+// LoadHouse reads a house from a YAML file
+func LoadHouse(path string) (*House, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read house: %w", err)
+	}
+	return ParseHouse(data)
+}
+
+// ParseHouse reads a house from YAML
+func ParseHouse(data []byte) (*House, error) {
+	var file houseFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse house: %w", err)
+	}
+
+	house := &House{Name: file.Name}
+	if file.Transition != "" {
+		transition, err := time.ParseDuration(file.Transition)
+		if err != nil || transition < 0 {
+			return nil, fmt.Errorf("invalid transition %q", file.Transition)
+		}
+		house.Transition = transition
+	}
+
+	seen := make(map[string]bool)
+	for _, entity := range file.Entities {
+		domain, object, ok := strings.Cut(entity.EntityID, ".")
+		if !ok || domain == "" || object == "" {
+			return nil, fmt.Errorf("invalid entity ID %q, expected domain.name", entity.EntityID)
+		}
+		if seen[entity.EntityID] {
+			return nil, fmt.Errorf("duplicate entity %s", entity.EntityID)
+		}
+		seen[entity.EntityID] = true
+
+		state := entity.State
+		if state == "" {
+			state = "unknown"
+		}
+		attributes := entity.Attributes
+		if attributes == nil {
+			attributes = make(map[string]any)
+		}
+		house.Entities = append(house.Entities, homeassistant.HAEntity{
+			EntityID:    entity.EntityID,
+			State:       state,
+			Attributes:  attributes,
+			LastChanged: entity.LastChanged,
+			LastUpdated: entity.LastChanged,
+		})
+	}
+	if len(house.Entities) == 0 {
+		return nil, fmt.Errorf("house has no entities")
+	}
+	return house, nil
+}
+
+// Devices converts the house's entities the way the real client does
+func (h *House) Devices() []models.Device {
+	devices := make([]models.Device, 0, len(h.Entities))
+	for _, entity := range h.Entities {
+		device := homeassistant.EntityToDevice(entity)
+		device.Attributes = copyEntity(device).Attributes
+		devices = append(devices, device)
+	}
+	return devices
+}
@@ -0,0 +1,64 @@
+package mocks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tienpdinh/gpt-home/pkg/models"
+)
+
+func TestLoadHouse_Demo(t *testing.T) {
+	house, err := LoadHouse("../houses/demo.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "Demo House", house.Name)
+	assert.Equal(t, 3*time.Second, house.Transition)
+
+	devices := make(map[string]models.Device)
+	for _, device := range house.Devices() {
+		devices[device.ID] = device
+	}
+	kitchen := devices["light.kitchen"]
+	assert.Equal(t, "Kitchen Light", kitchen.Name)
+	assert.Equal(t, models.DeviceTypeLight, kitchen.Type)
+	assert.Equal(t, "kitchen", kitchen.Area)
+	require.NotNil(t, kitchen.Capabilities)
+	assert.Equal(t, []string{"color_temp", "rgb"}, kitchen.Capabilities.ColorModes)
+	assert.Equal(t, []string{"off", "heat", "cool", "auto"}, devices["climate.thermostat"].Capabilities.HVACModes)
+	assert.Equal(t, models.DeviceTypeSensor, devices["binary_sensor.front_door"].Type)
+}
+
+func TestParseHouse_Invalid(t *testing.T) {
+	testCases := map[string]string{
+		"no entities":      "name: Empty\n",
+		"bad entity ID":    "entities:\n  - entity_id: kitchen\n",
+		"duplicate entity": "entities:\n  - entity_id: light.a\n  - entity_id: light.a\n",
+		"bad transition":   "transition: soon\nentities:\n  - entity_id: light.a\n",
+		"not a house":      "- light.a\n",
+	}
+	for name, data := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseHouse([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewMockHomeAssistantClientForHouse(t *testing.T) {
+	house, err := ParseHouse([]byte("entities:\n  - entity_id: switch.porch\n    state: \"on\"\n  - entity_id: sensor.mystery\n"))
+	require.NoError(t, err)
+	client := NewMockHomeAssistantClientForHouse(house)
+
+	entity, err := client.GetEntity(context.Background(), "sensor.mystery")
+	require.NoError(t, err)
+	assert.Equal(t, "unknown", entity.State)
+
+	// Each entity's state at load is its history so far
+	now := time.Now()
+	history, err := client.GetHistory(context.Background(), []string{"switch.porch"}, now.Add(-time.Hour), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, history["switch.porch"], 1)
+	assert.Equal(t, "on", history["switch.porch"][0].State)
+}
@@ -0,0 +1,229 @@
+package mocks
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// MQTTMessage is a message published to the mock broker
+type MQTTMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// MockMQTTBroker is a minimal MQTT 3.1.1 broker for tests. It accepts QoS 0 and
+// 1 publishes, delivers them to matching subscribers at QoS 0 and records them.
+// Retained messages and sessions aren't supported.
+type MockMQTTBroker struct {
+	listener net.Listener
+	username string
+	password string
+
+	mutex    sync.Mutex
+	sessions map[*brokerSession]struct{}
+	messages []MQTTMessage
+	notify   chan struct{}
+}
+
+type brokerSession struct {
+	conn    net.Conn
+	write   sync.Mutex
+	filters []string
+}
+
+// NewMockMQTTBroker starts a broker on a free local port
+func NewMockMQTTBroker() (*MockMQTTBroker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	broker := &MockMQTTBroker{
+		listener: listener,
+		sessions: make(map[*brokerSession]struct{}),
+		notify:   make(chan struct{}),
+	}
+	go broker.accept()
+	return broker, nil
+}
+
+// RequireCredentials makes the broker refuse clients without this username and password
+func (b *MockMQTTBroker) RequireCredentials(username, password string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.username, b.password = username, password
+}
+
+// URL is the address clients connect to
+func (b *MockMQTTBroker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Messages returns every message published so far
+func (b *MockMQTTBroker) Messages() []MQTTMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]MQTTMessage(nil), b.messages...)
+}
+
+// WaitForMessage waits for a message on topic and returns the first one
+func (b *MockMQTTBroker) WaitForMessage(topic string, timeout time.Duration) (MQTTMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		b.mutex.Lock()
+		for _, message := range b.messages {
+			if message.Topic == topic {
+				b.mutex.Unlock()
+				return message, nil
+			}
+		}
+		notify := b.notify
+		b.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return MQTTMessage{}, fmt.Errorf("no message on %s within %s", topic, timeout)
+		}
+	}
+}
+
+// Close stops the broker and disconnects every client
+func (b *MockMQTTBroker) Close() {
+	b.listener.Close()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for session := range b.sessions {
+		session.conn.Close()
+	}
+}
+
+func (b *MockMQTTBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.serve(&brokerSession{conn: conn})
+	}
+}
+
+func (b *MockMQTTBroker) serve(session *brokerSession) {
+	defer func() {
+		b.mutex.Lock()
+		delete(b.sessions, session)
+		b.mutex.Unlock()
+		session.conn.Close()
+	}()
+
+	for {
+		packet, err := packets.ReadPacket(session.conn)
+		if err != nil {
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			b.mutex.Lock()
+			if b.username != "" && (p.Username != b.username || string(p.Password) != b.password) {
+				connack.ReturnCode = packets.ErrRefusedNotAuthorised
+			} else {
+				b.sessions[session] = struct{}{}
+			}
+			b.mutex.Unlock()
+			session.send(connack)
+			if connack.ReturnCode != packets.Accepted {
+				return
+			}
+		case *packets.SubscribePacket:
+			b.mutex.Lock()
+			session.filters = append(session.filters, p.Topics...)
+			b.mutex.Unlock()
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = make([]byte, len(p.Topics))
+			session.send(suback)
+		case *packets.UnsubscribePacket:
+			b.mutex.Lock()
+			session.filters = removeFilters(session.filters, p.Topics)
+			b.mutex.Unlock()
+			unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			unsuback.MessageID = p.MessageID
+			session.send(unsuback)
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				session.send(puback)
+			}
+			b.publish(MQTTMessage{Topic: p.TopicName, Payload: p.Payload})
+		case *packets.PingreqPacket:
+			session.send(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// publish records a message and delivers it to the sessions subscribed to its topic
+func (b *MockMQTTBroker) publish(message MQTTMessage) {
+	b.mutex.Lock()
+	b.messages = append(b.messages, message)
+	close(b.notify)
+	b.notify = make(chan struct{})
+	var receivers []*brokerSession
+	for session := range b.sessions {
+		for _, filter := range session.filters {
+			if topicMatches(filter, message.Topic) {
+				receivers = append(receivers, session)
+				break
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, session := range receivers {
+		publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		publish.TopicName = message.Topic
+		publish.Payload = message.Payload
+		session.send(publish)
+	}
+}
+
+func (s *brokerSession) send(packet packets.ControlPacket) {
+	s.write.Lock()
+	defer s.write.Unlock()
+	packet.Write(s.conn)
+}
+
+func removeFilters(filters, removed []string) []string {
+	kept := filters[:0]
+	for _, filter := range filters {
+		if !slices.Contains(removed, filter) {
+			kept = append(kept, filter)
+		}
+	}
+	return kept
+}
+
+// topicMatches reports whether a topic matches a subscription filter with + and # wildcards
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
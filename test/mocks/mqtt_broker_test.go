@@ -0,0 +1,59 @@
+package mocks
+
+import (
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockMQTTBroker_Delivers(t *testing.T) {
+	broker, err := NewMockMQTTBroker()
+	require.NoError(t, err)
+	defer broker.Close()
+
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(broker.URL()).SetClientID("test"))
+	require.True(t, client.Connect().WaitTimeout(5*time.Second))
+	defer client.Disconnect(100)
+
+	received := make(chan string, 1)
+	token := client.Subscribe("home/+/state", 1, func(_ paho.Client, message paho.Message) {
+		received <- string(message.Payload())
+	})
+	require.True(t, token.WaitTimeout(5*time.Second))
+
+	require.True(t, client.Publish("home/porch/state", 1, false, "on").WaitTimeout(5*time.Second))
+	select {
+	case payload := <-received:
+		assert.Equal(t, "on", payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	message, err := broker.WaitForMessage("home/porch/state", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("on"), message.Payload)
+	assert.Len(t, broker.Messages(), 1)
+}
+
+func TestMockMQTTBroker_RequireCredentials(t *testing.T) {
+	broker, err := NewMockMQTTBroker()
+	require.NoError(t, err)
+	defer broker.Close()
+	broker.RequireCredentials("luna", "secret")
+
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(broker.URL()).SetClientID("test").SetUsername("luna").SetPassword("wrong"))
+	token := client.Connect()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	assert.Error(t, token.Error())
+}
+
+func TestTopicMatches(t *testing.T) {
+	assert.True(t, topicMatches("gpt-home/command", "gpt-home/command"))
+	assert.True(t, topicMatches("gpt-home/+", "gpt-home/command"))
+	assert.True(t, topicMatches("gpt-home/#", "gpt-home/events/device_state"))
+	assert.False(t, topicMatches("gpt-home/+", "gpt-home/events/device_state"))
+	assert.False(t, topicMatches("gpt-home/command", "gpt-home"))
+}